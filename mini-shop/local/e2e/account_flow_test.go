@@ -0,0 +1,63 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetThroughMailedLink(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	status := call(t, http.MethodPost, s.userURL+"/api/v1/password/forgot", "", map[string]string{"email": acc.Email}, nil)
+	if status != http.StatusAccepted {
+		t.Fatalf("forgot password: status %d, want %d", status, http.StatusAccepted)
+	}
+
+	email := s.mail.waitFor(t, acc.Email, "Сброс пароля в MiniShop")
+	link := mailedLink(t, email, s.userURL+"/reset-password?token=")
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("parse reset link: %v", err)
+	}
+	token := parsed.Query().Get("token")
+
+	reset := map[string]string{"token": token, "password": "new-e2e-password"}
+	if status := call(t, http.MethodPost, s.userURL+"/api/v1/password/reset", "", reset, nil); status != http.StatusOK {
+		t.Fatalf("reset password: status %d, want %d", status, http.StatusOK)
+	}
+	if status := call(t, http.MethodPost, s.userURL+"/api/v1/password/reset", "", reset, nil); status != http.StatusBadRequest {
+		t.Errorf("reusing the reset link: status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	s.login(t, acc.Email, "new-e2e-password")
+}
+
+func TestEmailChangeReachesOrdersAndPayments(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 100)
+	o := s.placeOrder(t, acc, 30)
+	s.waitForOrderStatus(t, o.ID, "paid")
+
+	email := "changed-" + acc.Email
+	if status := call(t, http.MethodPatch, s.userURL+"/api/v1/me", acc.Token, map[string]string{"email": email}, nil); status != http.StatusOK {
+		t.Fatalf("update profile: status %d, want %d", status, http.StatusOK)
+	}
+	s.mail.waitFor(t, email, "Подтвердите адрес почты")
+
+	eventually(t, 15*time.Second, func() bool {
+		var got struct {
+			Email string `json:"email"`
+		}
+		call(t, http.MethodGet, fmt.Sprintf("%s/api/v1/orders/%d", s.orderURL, o.ID), "", nil, &got)
+		return got.Email == email
+	}, "order %d to carry the new email", o.ID)
+	eventually(t, 15*time.Second, func() bool {
+		return s.paymentFor(t, acc, o.ID).Email == email
+	}, "payment of order %d to carry the new email", o.ID)
+}
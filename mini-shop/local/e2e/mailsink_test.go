@@ -0,0 +1,221 @@
+package e2e
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// capturedEmail is a message accepted by the mail sink.
+type capturedEmail struct {
+	From    string
+	To      []string
+	Subject string
+	Header  mail.Header
+	// Body is the decoded plain-text part; HTML is the HTML alternative, if any.
+	Body string
+	HTML string
+}
+
+// mailSink is a minimal SMTP server that accepts every message and keeps it in
+// memory. It advertises AUTH PLAIN so the notification service can log in, and
+// nothing else, so the client never attempts STARTTLS.
+type mailSink struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	emails []capturedEmail
+}
+
+func startMailSink() (*mailSink, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &mailSink{ln: ln}
+	go s.serve()
+	return s, nil
+}
+
+func (s *mailSink) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(s.ln.Addr().String())
+	return host, port
+}
+
+func (s *mailSink) Close() {
+	s.ln.Close()
+}
+
+// waitFor returns the first email sent to recipient whose subject contains
+// subject, failing the test if none arrives in time.
+func (s *mailSink) waitFor(t *testing.T, recipient, subject string) capturedEmail {
+	t.Helper()
+
+	var found capturedEmail
+	eventually(t, 15*time.Second, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for _, email := range s.emails {
+			for _, to := range email.To {
+				if to == recipient && strings.Contains(email.Subject, subject) {
+					found = email
+					return true
+				}
+			}
+		}
+		return false
+	}, "email to %s with subject %q", recipient, subject)
+
+	return found
+}
+
+func (s *mailSink) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *mailSink) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 localhost mailsink")
+
+	var email capturedEmail
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH"):
+			reply("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			email = capturedEmail{From: addressArg(line)}
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			email.To = append(email.To, addressArg(line))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			if err := readData(r, &email); err != nil {
+				reply("554 " + err.Error())
+				continue
+			}
+			s.mu.Lock()
+			s.emails = append(s.emails, email)
+			s.mu.Unlock()
+			reply("250 OK")
+		case cmd == "RSET", cmd == "NOOP":
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+func readData(r *bufio.Reader, email *capturedEmail) error {
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if line == ".\r\n" {
+			break
+		}
+		data.WriteString(strings.TrimPrefix(line, "."))
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(data.String()))
+	if err != nil {
+		return err
+	}
+	if err := readBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body, email); err != nil {
+		return err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return err
+	}
+
+	email.Header = msg.Header
+	email.Subject = subject
+	return nil
+}
+
+// readBody decodes a text/plain or text/html body, descending into multipart
+// alternatives.
+func readBody(contentType, encoding string, r io.Reader, email *capturedEmail) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		parts := multipart.NewReader(r, params["boundary"])
+		for {
+			part, err := parts.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := readBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part, email); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strings.EqualFold(encoding, "quoted-printable") {
+		r = quotedprintable.NewReader(r)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	switch mediaType {
+	case "text/plain":
+		email.Body = string(body)
+	case "text/html":
+		email.HTML = string(body)
+	}
+	return nil
+}
+
+func addressArg(line string) string {
+	_, arg, _ := strings.Cut(line, ":")
+	arg, _, _ = strings.Cut(strings.TrimSpace(arg), " ")
+	return strings.Trim(arg, "<>")
+}
@@ -0,0 +1,324 @@
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type account struct {
+	ID    string
+	Email string
+	Token string
+}
+
+type order struct {
+	ID     int     `json:"id"`
+	Amount float64 `json:"amount"`
+	Status string  `json:"status"`
+}
+
+type payment struct {
+	ID             int
+	OrderID        int
+	Email          string
+	Amount         float64
+	Status         string
+	Method         string
+	BalanceAmount  float64
+	ProviderAmount float64
+}
+
+func TestOrderIsPaidWhenBalanceCoversIt(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 100)
+
+	o := s.placeOrder(t, acc, 30)
+	s.waitForOrderStatus(t, o.ID, "paid")
+
+	if p := s.paymentFor(t, acc, o.ID); p.Status != "completed" || p.Amount != 30 {
+		t.Errorf("payment = %+v, want completed payment of 30", p)
+	}
+	if balance := s.balance(t, acc); balance != 70 {
+		t.Errorf("balance = %v, want 70", balance)
+	}
+
+	email := s.mail.waitFor(t, acc.Email, fmt.Sprintf("Оплата заказа %d успешна", o.ID))
+	if !strings.Contains(email.Body, "30.00") {
+		t.Errorf("email body %q does not mention the charged amount", email.Body)
+	}
+}
+
+func TestOrderFailsWhenBalanceIsInsufficient(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 50)
+
+	o := s.placeOrder(t, acc, 80)
+	s.waitForOrderStatus(t, o.ID, "failed")
+
+	if p := s.paymentFor(t, acc, o.ID); p.Status != "failed" {
+		t.Errorf("payment = %+v, want failed", p)
+	}
+	if balance := s.balance(t, acc); balance != 50 {
+		t.Errorf("balance = %v, want 50 (unchanged)", balance)
+	}
+
+	s.mail.waitFor(t, acc.Email, fmt.Sprintf("Оплата заказа %d не удалась", o.ID))
+}
+
+func TestCancelledOrderIsRefunded(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 100)
+
+	o := s.placeOrder(t, acc, 30)
+	s.waitForOrderStatus(t, o.ID, "paid")
+
+	statusURL := fmt.Sprintf("%s/api/v1/orders/%d/status", s.orderURL, o.ID)
+	cancel := map[string]string{"status": "cancelled"}
+	if status := call(t, http.MethodPut, statusURL, acc.Token, cancel, nil); status != http.StatusForbidden {
+		t.Fatalf("cancel order as its customer: status %d, want %d", status, http.StatusForbidden)
+	}
+	if status := call(t, http.MethodPut, statusURL, s.registerAdmin(t).Token, cancel, nil); status != http.StatusOK {
+		t.Fatalf("cancel order: status %d, want %d", status, http.StatusOK)
+	}
+	s.waitForOrderStatus(t, o.ID, "refunded")
+
+	if p := s.paymentFor(t, acc, o.ID); p.Status != "refunded" {
+		t.Errorf("payment = %+v, want refunded", p)
+	}
+	if balance := s.balance(t, acc); balance != 100 {
+		t.Errorf("balance = %v, want 100 after the refund", balance)
+	}
+
+	s.mail.waitFor(t, acc.Email, fmt.Sprintf("Заказ %d отменён", o.ID))
+	s.mail.waitFor(t, acc.Email, fmt.Sprintf("Возврат по заказу %d", o.ID))
+}
+
+func TestOrderPaidThroughProvider(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 20)
+
+	tests := []struct {
+		name    string
+		order   map[string]any
+		status  string
+		balance float64
+	}{
+		{"card", map[string]any{"amount": 30, "paymentMethod": "provider", "providerMethod": "fake_success"}, "paid", 20},
+		{"declined card", map[string]any{"amount": 30, "paymentMethod": "provider", "providerMethod": "fake_decline"}, "failed", 20},
+		// Отказ по карте возвращает на баланс уже списанную часть
+		{"declined split", map[string]any{"amount": 30, "paymentMethod": "split", "balanceAmount": 5, "providerMethod": "fake_decline"}, "failed", 20},
+		{"split", map[string]any{"amount": 30, "paymentMethod": "split", "balanceAmount": 5, "providerMethod": "fake_success"}, "paid", 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := s.checkout(t, acc, tt.order)
+			s.waitForOrderStatus(t, o.ID, tt.status)
+
+			if p := s.paymentFor(t, acc, o.ID); p.Method != tt.order["paymentMethod"] {
+				t.Errorf("payment = %+v, want paid by %s", p, tt.order["paymentMethod"])
+			}
+			eventually(t, 5*time.Second, func() bool {
+				return s.balance(t, acc) == tt.balance
+			}, "balance to be %v", tt.balance)
+		})
+	}
+}
+
+func (s *stack) register(t *testing.T) account {
+	t.Helper()
+	return s.registerAs(t, fmt.Sprintf("e2e-%d@minishop.test", time.Now().UnixNano()))
+}
+
+// registerAdmin returns the admin account, registering it on first use.
+func (s *stack) registerAdmin(t *testing.T) account {
+	t.Helper()
+
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	if s.admin.Token == "" {
+		s.admin = s.registerAs(t, adminEmail)
+	}
+	return s.admin
+}
+
+func (s *stack) registerAs(t *testing.T, email string) account {
+	t.Helper()
+
+	var tokens struct {
+		AccessToken string `json:"accessToken"`
+	}
+	status := call(t, http.MethodPost, s.userURL+"/api/v1/register", "", map[string]string{
+		"firstName": "End",
+		"lastName":  "ToEnd",
+		"email":     email,
+		"password":  "e2e-password",
+	}, &tokens)
+	if status != http.StatusCreated {
+		t.Fatalf("register: status %d, want %d", status, http.StatusCreated)
+	}
+
+	id, err := userIDFromToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("read access token: %v", err)
+	}
+
+	// Заказы доступны только с подтверждённым адресом
+	welcome := s.mail.waitFor(t, email, "Добро пожаловать в MiniShop")
+	link := mailedLink(t, welcome, s.userURL+"/api/v1/email/verify?token=")
+	if status := call(t, http.MethodGet, link, "", nil, nil); status != http.StatusOK {
+		t.Fatalf("verify email: status %d, want %d", status, http.StatusOK)
+	}
+	return account{ID: id, Email: email, Token: s.login(t, email, "e2e-password")}
+}
+
+func (s *stack) login(t *testing.T, email, password string) string {
+	t.Helper()
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	status := call(t, http.MethodPost, s.userURL+"/api/v1/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	}, &resp)
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, want %d", status, http.StatusOK)
+	}
+	return resp.Token
+}
+
+// mailedLink returns the link starting with prefix from the plain-text body.
+func mailedLink(t *testing.T, email capturedEmail, prefix string) string {
+	t.Helper()
+
+	i := strings.Index(email.Body, prefix)
+	if i < 0 {
+		t.Fatalf("email %q has no link to %s", email.Subject, prefix)
+	}
+	return strings.Fields(email.Body[i:])[0]
+}
+
+// topUp pays amount through the fake provider of payment-service and waits
+// until it is on the balance.
+func (s *stack) topUp(t *testing.T, acc account, amount float64) {
+	t.Helper()
+
+	if status := s.payTopUp(t, acc, amount, "fake_success"); status != "credited" {
+		t.Fatalf("top-up status %q, want credited", status)
+	}
+}
+
+// payTopUp creates a top-up, confirms it with paymentMethod and returns the
+// status it settles in.
+func (s *stack) payTopUp(t *testing.T, acc account, amount float64, paymentMethod string) string {
+	t.Helper()
+
+	var topUp struct {
+		ID     int    `json:"id"`
+		Status string `json:"status"`
+	}
+	status := call(t, http.MethodPost, s.paymentURL+"/api/v1/topups", acc.Token, map[string]float64{"amount": amount}, &topUp)
+	if status != http.StatusCreated {
+		t.Fatalf("create top-up: status %d, want %d", status, http.StatusCreated)
+	}
+
+	endpoint := fmt.Sprintf("%s/api/v1/topups/%d", s.paymentURL, topUp.ID)
+	status = call(t, http.MethodPost, endpoint+"/confirm", acc.Token, map[string]string{"paymentMethod": paymentMethod}, nil)
+	if status != http.StatusOK {
+		t.Fatalf("confirm top-up: status %d, want %d", status, http.StatusOK)
+	}
+
+	eventually(t, 10*time.Second, func() bool {
+		call(t, http.MethodGet, endpoint, acc.Token, nil, &topUp)
+		return topUp.Status == "credited" || topUp.Status == "failed"
+	}, "top-up %d never settled", topUp.ID)
+	return topUp.Status
+}
+
+func TestDeclinedTopUpLeavesBalance(t *testing.T) {
+	s := requireStack(t)
+
+	acc := s.register(t)
+	s.topUp(t, acc, 40)
+
+	if status := s.payTopUp(t, acc, 25, "fake_decline"); status != "failed" {
+		t.Errorf("declined top-up status %q, want failed", status)
+	}
+	if balance := s.balance(t, acc); balance != 40 {
+		t.Errorf("balance = %v, want 40 (declined top-up not credited)", balance)
+	}
+	// Без токена сервиса баланс не пополнить
+	status := call(t, http.MethodPost, s.userURL+"/api/v1/balance/"+acc.ID+"/add", acc.Token, map[string]float64{"amount": 100}, nil)
+	if status != http.StatusForbidden {
+		t.Errorf("direct top-up: status %d, want %d", status, http.StatusForbidden)
+	}
+}
+
+func (s *stack) balance(t *testing.T, acc account) float64 {
+	t.Helper()
+
+	var resp struct {
+		Balance float64 `json:"balance"`
+	}
+	if status := call(t, http.MethodGet, s.userURL+"/api/v1/balance/"+acc.ID, acc.Token, nil, &resp); status != http.StatusOK {
+		t.Fatalf("get balance: status %d, want %d", status, http.StatusOK)
+	}
+	return resp.Balance
+}
+
+func (s *stack) placeOrder(t *testing.T, acc account, amount float64) order {
+	t.Helper()
+	return s.checkout(t, acc, map[string]any{"amount": amount})
+}
+
+// checkout places an order with the payment fields of body.
+func (s *stack) checkout(t *testing.T, acc account, body map[string]any) order {
+	t.Helper()
+
+	var o order
+	status := call(t, http.MethodPost, s.orderURL+"/api/v1/orders", acc.Token, body, &o)
+	if status != http.StatusCreated {
+		t.Fatalf("create order: status %d, want %d", status, http.StatusCreated)
+	}
+	return o
+}
+
+func (s *stack) waitForOrderStatus(t *testing.T, id int, want string) {
+	t.Helper()
+
+	eventually(t, 15*time.Second, func() bool {
+		var o order
+		call(t, http.MethodGet, fmt.Sprintf("%s/api/v1/orders/%d", s.orderURL, id), "", nil, &o)
+		return o.Status == want
+	}, "order %d to be %s", id, want)
+}
+
+func (s *stack) paymentFor(t *testing.T, acc account, orderID int) payment {
+	t.Helper()
+
+	var page struct {
+		Items []payment `json:"items"`
+	}
+	if status := call(t, http.MethodGet, s.paymentURL+"/api/v1/payments/user", acc.Token, nil, &page); status != http.StatusOK {
+		t.Fatalf("list payments: status %d, want %d", status, http.StatusOK)
+	}
+
+	for _, p := range page.Items {
+		if p.OrderID == orderID {
+			return p
+		}
+	}
+	t.Fatalf("no payment recorded for order %d", orderID)
+	return payment{}
+}
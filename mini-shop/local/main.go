@@ -0,0 +1,143 @@
+// Command local runs every MiniShop service in a single process. The services
+// share an in-memory message broker, so only the Postgres databases have to be
+// running (for example `docker-compose up -d user-db order-db payment-db notification-db`).
+// Email goes to localhost:1025, where `docker-compose up -d mailhog` captures it.
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	orderservice "github.com/Viltsev/minishop/order-service"
+	paymentservice "github.com/Viltsev/minishop/payment-service"
+	notificationservice "github.com/Viltsev/notification-service"
+	"golang.org/x/sync/errgroup"
+	userservice "mini-shop/user-service"
+)
+
+func main() {
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	password := os.Getenv("DB_PASSWORD")
+	if password == "" {
+		slog.Error("required environment variable is not set", "key", "DB_PASSWORD")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	broker := orderservice.NewMemoryBroker()
+	defer broker.Close()
+
+	host := getEnv("DB_HOST", "localhost")
+	user := getEnv("DB_USER", "user")
+	sslMode := getEnv("DB_SSL", "disable")
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return userservice.Run(ctx, userservice.Options{
+			Addr:                ":8080",
+			DBHost:              host,
+			DBPort:              getEnv("USER_DB_PORT", "5432"),
+			DBUser:              user,
+			DBPassword:          password,
+			DBName:              getEnv("USER_DB_NAME", "user-service-db"),
+			DBSSLMode:           sslMode,
+			Broker:              broker,
+			LowBalanceThreshold: 100,
+			PublicURL:           getEnv("USER_PUBLIC_URL", "http://localhost:8080"),
+			AdminEmails:         getEnvAsList("ADMIN_EMAILS"),
+			// Выгрузка данных собирает разделы остальных сервисов
+			OrderServiceURL:        "http://localhost:8081",
+			PaymentServiceURL:      "http://localhost:8082",
+			NotificationServiceURL: "http://localhost:8083",
+		})
+	})
+	g.Go(func() error {
+		return orderservice.Run(ctx, orderservice.Options{
+			Addr:       ":8081",
+			DBHost:     host,
+			DBPort:     getEnv("ORDER_DB_PORT", "5433"),
+			DBUser:     user,
+			DBPassword: password,
+			DBName:     getEnv("ORDER_DB_NAME", "order-service-db"),
+			DBSSLMode:  sslMode,
+			Broker:     broker,
+		})
+	})
+	g.Go(func() error {
+		return paymentservice.Run(ctx, paymentservice.Options{
+			Addr:           ":8082",
+			DBHost:         host,
+			DBPort:         getEnv("PAYMENT_DB_PORT", "5434"),
+			DBUser:         user,
+			DBPassword:     password,
+			DBName:         getEnv("PAYMENT_DB_NAME", "payment-service-db"),
+			DBSSLMode:      sslMode,
+			Broker:         broker,
+			UserServiceURL: "http://localhost:8080",
+			// Пополнения проходят через фейкового провайдера, без настоящих денег
+			Provider:      "fake",
+			WebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", "local-webhook-secret"),
+			PublicURL:     "http://localhost:8082",
+			FakeDelay:     2 * time.Second,
+		})
+	})
+	g.Go(func() error {
+		return notificationservice.Run(ctx, notificationservice.Options{
+			Addr:          ":8083",
+			DBHost:        host,
+			DBPort:        getEnv("NOTIFICATION_DB_PORT", "5435"),
+			DBUser:        user,
+			DBPassword:    password,
+			DBName:        getEnv("NOTIFICATION_DB_NAME", "notification-service-db"),
+			DBSSLMode:     sslMode,
+			Broker:        broker,
+			Channels:      getEnv("NOTIFY_CHANNELS", "smtp"),
+			Routes:        os.Getenv("NOTIFY_ROUTES"),
+			SMTPHost:      getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:      getEnv("SMTP_PORT", "1025"),
+			SMTPUsername:  os.Getenv("SMTP_USERNAME"),
+			SMTPPassword:  os.Getenv("SMTP_PASSWORD"),
+			SMTPFrom:      getEnv("SMTP_FROM", "noreply@minishop.local"),
+			WebhookURL:    os.Getenv("WEBHOOK_URL"),
+			WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+			NotifyFile:    getEnv("NOTIFY_FILE", "notifications.jsonl"),
+			Locale:        getEnv("NOTIFY_LOCALE", "ru"),
+			// Локальный запуск обходится без секретов, в проде секрет обязателен
+			UnsubscribeSecret: getEnv("UNSUBSCRIBE_SECRET", "local-unsubscribe-secret"),
+			PublicURL:         getEnv("PUBLIC_URL", "http://localhost:8083"),
+		})
+	})
+
+	slog.Info("all services started", "broker", "memory")
+	if err := g.Wait(); err != nil {
+		slog.Error("service failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("all services stopped")
+}
+
+func getEnv(key, defaultVal string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultVal
+}
+
+// getEnvAsList reads a comma-separated list, skipping empty items.
+func getEnvAsList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
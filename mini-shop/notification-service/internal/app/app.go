@@ -1,50 +1,278 @@
 package app
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
 
+	"github.com/Viltsev/notification-service/internal/handler"
 	"github.com/Viltsev/notification-service/internal/messaging"
+	"github.com/Viltsev/notification-service/internal/metrics"
+	"github.com/Viltsev/notification-service/internal/notifier"
+	"github.com/Viltsev/notification-service/internal/repository"
 	"github.com/Viltsev/notification-service/internal/service"
+	"github.com/Viltsev/notification-service/internal/templates"
+	"github.com/Viltsev/notification-service/internal/tracing"
+	"github.com/Viltsev/notification-service/migrations"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"mini-shop/platform/database"
+	"mini-shop/platform/health"
+	"mini-shop/platform/logger"
 )
 
+// Options configures one instance of the notification service.
+type Options struct {
+	Addr string
+
+	DBHost     string
+	DBPort     string
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DBSSLMode  string
+
+	// Broker delivers payment events. It is owned by the caller.
+	Broker messaging.Broker
+
+	// Channels lists the default delivery channels, comma-separated. Routes
+	// overrides them per notification type, e.g.
+	// "payment.failed=smtp,webhook;payment.completed=log".
+	Channels string
+	Routes   string
+
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	WebhookURL    string
+	WebhookSecret string
+
+	// NotifyFile is where the file channel appends notifications.
+	NotifyFile string
+
+	// Locale is used for recipients whose language has no templates. It
+	// defaults to "ru".
+	Locale string
+
+	// RetryAttempts, RetryBaseDelay and RetryMaxDelay shape the exponential
+	// backoff of failed deliveries. Zero values take service.DefaultRetryPolicy.
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// UnsubscribeSecret signs the one-click unsubscribe links in notifications.
+	// PublicURL is where recipients reach the service to follow them.
+	UnsubscribeSecret string
+	PublicURL         string
+
+	// Notifiers adds channels built by the caller, such as an in-memory one in
+	// tests. They replace built-in channels of the same name.
+	Notifiers map[string]notifier.Notifier
+}
+
+// Run connects to the database, applies migrations, consumes payment events
+// and serves the API until ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	if opts.UnsubscribeSecret == "" {
+		return fmt.Errorf("an unsubscribe secret is required")
+	}
+	n, err := newNotifier(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Locale == "" {
+		opts.Locale = "ru"
+	}
+	renderer, err := templates.New(opts.Locale)
+	if err != nil {
+		return fmt.Errorf("failed to load notification templates: %w", err)
+	}
+
+	cfg := database.Config{
+		Host:     opts.DBHost,
+		Port:     opts.DBPort,
+		User:     opts.DBUser,
+		Password: opts.DBPassword,
+		DBName:   opts.DBName,
+		SSLMode:  opts.DBSSLMode,
+	}
+
+	slog.Info("connecting to database", "host", cfg.Host, "port", cfg.Port, "db", cfg.DBName)
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+	defer db.Close()
+	slog.Info("database connection established")
+
+	slog.Info("running migrations")
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	slog.Info("migrations applied")
+
+	links := service.NewUnsubscribeLinks(opts.UnsubscribeSecret, opts.PublicURL)
+	return NewAPIServer(opts.Addr, db, opts.Broker, n, renderer, retryPolicy(opts), links).Run(ctx)
+}
+
+func retryPolicy(opts Options) service.RetryPolicy {
+	policy := service.DefaultRetryPolicy
+	if opts.RetryAttempts > 0 {
+		policy.MaxAttempts = opts.RetryAttempts
+	}
+	if opts.RetryBaseDelay > 0 {
+		policy.BaseDelay = opts.RetryBaseDelay
+	}
+	if opts.RetryMaxDelay > 0 {
+		policy.MaxDelay = opts.RetryMaxDelay
+	}
+	return policy
+}
+
+// newNotifier builds every channel referenced by the configuration and routes
+// notifications between them.
+func newNotifier(opts Options) (*notifier.Router, error) {
+	if opts.Channels == "" {
+		opts.Channels = notifier.ChannelSMTP
+	}
+	defaults, err := notifier.ParseChannels(opts.Channels)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification channels: %w", err)
+	}
+	routes, err := notifier.ParseRoutes(opts.Routes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification routes: %w", err)
+	}
+
+	cfg := notifier.Config{
+		SMTP: notifier.SMTPConfig{
+			Host:     opts.SMTPHost,
+			Port:     opts.SMTPPort,
+			Username: opts.SMTPUsername,
+			Password: opts.SMTPPassword,
+			From:     opts.SMTPFrom,
+		},
+		WebhookURL:    opts.WebhookURL,
+		WebhookSecret: opts.WebhookSecret,
+		FilePath:      opts.NotifyFile,
+	}
+
+	channels := map[string]notifier.Notifier{}
+	for _, names := range append([][]string{defaults}, slices.Collect(maps.Values(routes))...) {
+		for _, name := range names {
+			if _, ok := channels[name]; ok {
+				continue
+			}
+			if n, ok := opts.Notifiers[name]; ok {
+				channels[name] = n
+				continue
+			}
+			if channels[name], err = notifier.New(name, cfg); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return notifier.NewRouter(channels, defaults, routes)
+}
+
 type APIServer struct {
-	addr     string
-	rabbitMQ *messaging.RabbitMQ
+	addr      string
+	db        *sql.DB
+	broker    messaging.Broker
+	router    *notifier.Router
+	templates *templates.Renderer
+	retry     service.RetryPolicy
+	links     *service.UnsubscribeLinks
 }
 
-func NewAPIServer(addr string, rabbitMQ *messaging.RabbitMQ) *APIServer {
+func NewAPIServer(addr string, db *sql.DB, broker messaging.Broker, router *notifier.Router, renderer *templates.Renderer, retry service.RetryPolicy, links *service.UnsubscribeLinks) *APIServer {
 	return &APIServer{
-		addr:     addr,
-		rabbitMQ: rabbitMQ,
+		addr:      addr,
+		db:        db,
+		broker:    broker,
+		router:    router,
+		templates: renderer,
+		retry:     retry,
+		links:     links,
 	}
 }
 
-func (s *APIServer) Run() error {
-	notificationService := service.NewNotificationService(s.rabbitMQ)
+func (s *APIServer) Run(ctx context.Context) error {
+	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(logger.Middleware)
+	router.Use(metrics.Common.Middleware)
 
-	go func() {
-		if err := s.startPaymentEventListener(notificationService); err != nil {
-			log.Fatalf("failed to start notification service listener: %v", err)
+	metrics.Common.RegisterDB(s.db, "notifications")
+	metrics.Common.RegisterRoutes(router)
+
+	checker := health.NewChecker()
+	checker.Register("database", s.db.PingContext)
+	checker.Register("migrations", func(ctx context.Context) error {
+		return database.CheckMigrations(ctx, s.db, migrations.FS)
+	})
+	checker.Register("broker", s.broker.Check)
+	checker.Register("consumer", func(ctx context.Context) error {
+		var errs []error
+		for _, bindingKey := range eventBindingKeys {
+			errs = append(errs, s.broker.CheckConsumer(bindingKey))
 		}
-	}()
+		return errors.Join(errs...)
+	})
+	checker.RegisterRoutes(router)
 
-	log.Println("Notification server starts http://localhost:8083")
-	return nil
-}
+	subrouter := router.PathPrefix("/api/v1").Subrouter()
+
+	notificationStore := repository.NewStore(s.db)
 
-func (s *APIServer) startPaymentEventListener(notificationService *service.NotificationService) error {
-	log.Println("[Listener-NS] Initializing payment.* consumer...")
+	dispatcher := service.NewDispatcher(notificationStore, s.router, s.retry)
+	go dispatcher.Run(ctx)
 
-	return s.rabbitMQ.Consume("payment.*", func(body []byte) {
-		log.Println("[Listener-NS] Received payment event:", string(body))
+	notificationService := service.NewNotificationService(s.broker, notificationStore, dispatcher, s.templates, service.DefaultRegistry(), s.links)
+	notificationHandler := handler.NewNotificationHandler(notificationStore, notificationService)
+	notificationHandler.RegisterRoutes(subrouter)
 
-		var event map[string]interface{}
-		if err := json.Unmarshal(body, &event); err != nil {
-			log.Printf("[Listener-NS] Failed to unmarshal payment event: %v", err)
-			return
+	for _, bindingKey := range eventBindingKeys {
+		if err := s.startEventListener(notificationService, bindingKey); err != nil {
+			return fmt.Errorf("failed to start %s listener: %w", bindingKey, err)
 		}
+	}
 
-		notificationService.HandlePaymentEvent(body)
-	})
+	slog.Info("server listening", "addr", s.addr)
+	return serve(ctx, s.addr, router)
+}
+
+// serve runs the HTTP server until ctx is cancelled, then shuts it down gracefully.
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// eventBindingKeys are the events the service turns into notifications.
+var eventBindingKeys = []string{"order.*", "payment.*", "user.*"}
+
+func (s *APIServer) startEventListener(notificationService *service.NotificationService, bindingKey string) error {
+	slog.Info("initializing consumer", "binding_key", bindingKey)
+	return s.broker.Consume(bindingKey, notificationService.HandleEvent)
 }
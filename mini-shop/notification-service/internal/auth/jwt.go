@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Viltsev/notification-service/internal/config"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/utils"
+	"github.com/golang-jwt/jwt"
+	"mini-shop/platform/problem"
+)
+
+type contextKey string
+
+const UserKey contextKey = "userID"
+
+func WithJWTAuth(handlerFunc http.HandlerFunc, store model.NotificationStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tokenString := utils.GetTokenFromRequest(r)
+
+		userID, err := GetUserIDFromToken(tokenString)
+		if err != nil {
+			slog.WarnContext(r.Context(), "failed to validate token", "error", err)
+			permissionDenied(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		ctx = context.WithValue(ctx, UserKey, userID)
+		r = r.WithContext(ctx)
+
+		handlerFunc(w, r)
+	}
+}
+
+func GetUserIDFromToken(tokenString string) (int, error) {
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	token, err := validateJWT(tokenString)
+	if err != nil {
+		return 0, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if !token.Valid {
+		return 0, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, fmt.Errorf("invalid token claims")
+	}
+
+	userIDStr, ok := claims["userID"].(string)
+	if !ok {
+		return 0, fmt.Errorf("userID not found in token claims")
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert userID to int: %w", err)
+	}
+
+	return userID, nil
+}
+
+func validateJWT(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(config.Envs.JWTSecret), nil
+	})
+}
+
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	problem.WriteError(w, r, model.ErrPermissionDenied)
+}
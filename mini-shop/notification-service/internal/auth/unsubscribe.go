@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// UnsubscribeToken signs the opt-out of userID from a notification category.
+// The token does not expire: the link in an old email must keep working.
+func UnsubscribeToken(secret []byte, userID int, category string) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(userID) + ":" + category))
+	return payload + "." + sign(secret, payload)
+}
+
+// ParseUnsubscribeToken verifies a token made by UnsubscribeToken and returns
+// the user and category it names.
+func ParseUnsubscribeToken(secret []byte, token string) (int, string, error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", fmt.Errorf("malformed unsubscribe token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, payload))) {
+		return 0, "", fmt.Errorf("invalid unsubscribe token signature")
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed unsubscribe token: %w", err)
+	}
+	id, category, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return 0, "", fmt.Errorf("malformed unsubscribe token")
+	}
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, "", fmt.Errorf("malformed unsubscribe token: %w", err)
+	}
+	return userID, category, nil
+}
+
+func sign(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
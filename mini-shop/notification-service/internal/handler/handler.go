@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Viltsev/notification-service/internal/auth"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/service"
+	"github.com/Viltsev/notification-service/internal/utils"
+	"github.com/gorilla/mux"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
+)
+
+type Handler struct {
+	store   model.NotificationStore
+	service *service.NotificationService
+}
+
+func NewNotificationHandler(store model.NotificationStore, service *service.NotificationService) *Handler {
+	return &Handler{store: store, service: service}
+}
+
+func (h *Handler) RegisterRoutes(router *mux.Router) {
+	// Пользователь видит только свои уведомления
+	router.HandleFunc("/notifications", auth.WithJWTAuth(h.ListNotifications, h.store)).Methods("GET")
+	router.HandleFunc("/notifications/{id:[0-9]+}/resend", auth.WithJWTAuth(h.ResendNotification, h.store)).Methods("POST")
+	router.HandleFunc("/preferences", auth.WithJWTAuth(h.GetPreferences, h.store)).Methods("GET")
+	router.HandleFunc("/preferences", auth.WithJWTAuth(h.UpdatePreferences, h.store)).Methods("PUT")
+	// Часть выгрузки данных пользователя, её собирает user-service
+	router.HandleFunc("/me/export", auth.WithJWTAuth(h.ExportMe, h.store)).Methods("GET")
+
+	// Ссылку из письма открывают без входа в аккаунт, её подлинность
+	// подтверждает подпись токена
+	router.HandleFunc("/unsubscribe", h.ConfirmUnsubscribe).Methods("GET")
+	router.HandleFunc("/unsubscribe", h.Unsubscribe).Methods("POST")
+}
+
+func (h *Handler) ListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	params, err := pagination.Parse(r.URL.Query(), model.NotificationSorts, "-createdAt")
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	filter := model.NotificationFilter{
+		Status:  r.URL.Query().Get("status"),
+		Channel: r.URL.Query().Get("channel"),
+	}
+
+	page, err := h.service.ListNotificationsByUser(r.Context(), userID, filter, params)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) ResendNotification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid notification ID"))
+		return
+	}
+
+	notification, err := h.service.Resend(r.Context(), userID, id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	// Письмо уйдёт асинхронно, статус можно отслеживать в списке уведомлений
+	utils.WriteJSON(w, http.StatusAccepted, notification)
+}
+
+func (h *Handler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	prefs, err := h.service.GetPreferences(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, prefs)
+}
+
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var prefs model.Preferences
+	if err := utils.ParseJSON(r, &prefs); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	saved, err := h.service.UpdatePreferences(r.Context(), userID, prefs)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, saved)
+}
+
+func (h *Handler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	data, err := h.service.ExportData(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/config"
+	"github.com/Viltsev/notification-service/internal/messaging"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/notifier"
+	"github.com/Viltsev/notification-service/internal/repository"
+	"github.com/Viltsev/notification-service/internal/service"
+	"github.com/Viltsev/notification-service/internal/templates"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
+)
+
+var testLinks = service.NewUnsubscribeLinks("test-secret", "https://shop.test")
+
+// newTestRouter serves the handler over a store holding notifications, which
+// are stored with the given statuses.
+func newTestRouter(t *testing.T, notifications ...model.Notification) *mux.Router {
+	t.Helper()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	for _, n := range notifications {
+		created, err := store.CreateNotification(ctx, n)
+		if err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+		created.Status = n.Status
+		if err := store.UpdateDelivery(ctx, *created); err != nil {
+			t.Fatalf("UpdateDelivery: %v", err)
+		}
+	}
+
+	renderer, err := templates.New("ru")
+	if err != nil {
+		t.Fatalf("templates.New: %v", err)
+	}
+	channels, err := notifier.NewRouter(map[string]notifier.Notifier{"memory": notifier.NewMemoryNotifier()}, []string{"memory"}, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	dispatcher := service.NewDispatcher(store, channels, service.DefaultRetryPolicy)
+	router := mux.NewRouter()
+	svc := service.NewNotificationService(broker, store, dispatcher, renderer, service.DefaultRegistry(), testLinks)
+	NewNotificationHandler(store, svc).RegisterRoutes(router)
+	return router
+}
+
+func testToken(t *testing.T, userID string) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID":    userID,
+		"email":     "ann@example.com",
+		"expiredAt": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(config.Envs.JWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func serve(router http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	return serveBody(router, method, path, token, "")
+}
+
+func serveBody(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return body.Code
+}
+
+func notification(eventID string, userID int, status string) model.Notification {
+	return model.Notification{
+		EventID:   eventID,
+		UserID:    userID,
+		Type:      "payment.completed",
+		Channel:   "memory",
+		Recipient: "ann@example.com",
+		Status:    status,
+	}
+}
+
+func TestListNotifications(t *testing.T) {
+	router := newTestRouter(t,
+		notification("evt-1", 7, model.StatusSent),
+		notification("evt-2", 8, model.StatusSent),
+		notification("evt-3", 7, model.StatusFailed),
+	)
+
+	rec := serve(router, http.MethodGet, "/notifications", testToken(t, "7"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var page pagination.Page[model.Notification]
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].EventID != "evt-3" || page.Items[1].EventID != "evt-1" {
+		t.Errorf("items = %+v, want user 7's notifications, newest first", page.Items)
+	}
+
+	rec = serve(router, http.MethodGet, "/notifications?status=failed", testToken(t, "7"))
+	page = pagination.Page[model.Notification]{}
+	json.NewDecoder(rec.Body).Decode(&page)
+	if len(page.Items) != 1 || page.Items[0].EventID != "evt-3" {
+		t.Errorf("failed notifications = %+v, want only evt-3", page.Items)
+	}
+}
+
+func TestListNotificationsRequiresToken(t *testing.T) {
+	rec := serve(newTestRouter(t), http.MethodGet, "/notifications", "")
+	if rec.Code != http.StatusForbidden || problemCode(t, rec) != "permission_denied" {
+		t.Errorf("status = %d, want 403 permission_denied", rec.Code)
+	}
+}
+
+func TestResendNotification(t *testing.T) {
+	router := newTestRouter(t,
+		notification("evt-1", 7, model.StatusFailed),
+		notification("evt-2", 7, model.StatusSent),
+	)
+
+	tests := []struct {
+		name   string
+		path   string
+		userID string
+		status int
+		code   string
+	}{
+		{"failed notification", "/notifications/1/resend", "7", http.StatusAccepted, ""},
+		{"already pending again", "/notifications/1/resend", "7", http.StatusConflict, "notification_not_failed"},
+		{"sent notification", "/notifications/2/resend", "7", http.StatusConflict, "notification_not_failed"},
+		{"another user's notification", "/notifications/2/resend", "8", http.StatusNotFound, "notification_not_found"},
+		{"missing notification", "/notifications/42/resend", "7", http.StatusNotFound, "notification_not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(router, http.MethodPost, tt.path, testToken(t, tt.userID))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code != "" {
+				if code := problemCode(t, rec); code != tt.code {
+					t.Errorf("code = %q, want %q", code, tt.code)
+				}
+				return
+			}
+
+			var n model.Notification
+			if err := json.NewDecoder(rec.Body).Decode(&n); err != nil {
+				t.Fatalf("decode notification: %v", err)
+			}
+			if n.Status != model.StatusPending || n.Attempts != 0 {
+				t.Errorf("notification = %+v, want pending with no attempts", n)
+			}
+		})
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(router, http.MethodGet, "/preferences", testToken(t, "7"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var prefs model.Preferences
+	if err := json.NewDecoder(rec.Body).Decode(&prefs); err != nil {
+		t.Fatalf("decode preferences: %v", err)
+	}
+	if prefs.UserID != 7 || !prefs.Allows(model.CategoryOrders, "memory") {
+		t.Errorf("default preferences = %+v, want everything allowed", prefs)
+	}
+
+	body := `{"locale":"en","channels":{"orders":{"memory":false}},"quietHours":{"start":"22:00","end":"08:00","timeZone":"Europe/Moscow"}}`
+	rec = serveBody(router, http.MethodPut, "/preferences", testToken(t, "7"), body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(router, http.MethodGet, "/preferences", testToken(t, "7"))
+	prefs = model.Preferences{}
+	json.NewDecoder(rec.Body).Decode(&prefs)
+	if prefs.Locale != "en" || prefs.Allows(model.CategoryOrders, "memory") || prefs.QuietHours == nil {
+		t.Errorf("preferences = %+v, want the saved ones", prefs)
+	}
+
+	rec = serveBody(router, http.MethodPut, "/preferences", testToken(t, "7"), `{"quietHours":{"start":"late","end":"08:00"}}`)
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != "validation_failed" {
+		t.Errorf("status = %d, want 400 validation_failed", rec.Code)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	router := newTestRouter(t)
+	link := testLinks.URL(7, model.CategoryOrders)
+	path := strings.TrimPrefix(link, "https://shop.test/api/v1")
+
+	// Открытие ссылки только спрашивает подтверждение
+	rec := serve(router, http.MethodGet, path, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `method="post"`) {
+		t.Fatalf("GET %s = %d %s, want a confirmation form", path, rec.Code, rec.Body)
+	}
+	rec = serve(router, http.MethodGet, "/preferences", testToken(t, "7"))
+	var prefs model.Preferences
+	json.NewDecoder(rec.Body).Decode(&prefs)
+	if !prefs.Allows(model.CategoryOrders, "memory") {
+		t.Fatal("opening the link unsubscribed the user")
+	}
+
+	rec = serveBody(router, http.MethodPost, path, "", "List-Unsubscribe=One-Click")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST %s = %d %s, want 200", path, rec.Code, rec.Body)
+	}
+	rec = serve(router, http.MethodGet, "/preferences", testToken(t, "7"))
+	prefs = model.Preferences{}
+	json.NewDecoder(rec.Body).Decode(&prefs)
+	if prefs.Allows(model.CategoryOrders, "memory") || !prefs.Allows(model.CategoryPayments, "memory") {
+		t.Errorf("preferences = %+v, want orders opted out", prefs)
+	}
+
+	rec = serve(router, http.MethodPost, "/unsubscribe?token=forged", "")
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != "invalid_unsubscribe_link" {
+		t.Errorf("forged token status = %d, want 400 invalid_unsubscribe_link", rec.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"mini-shop/platform/problem"
+)
+
+// Страницы отписки открываются в браузере, поэтому отвечаем HTML, а не JSON
+var (
+	confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<body>
+<p>Отписаться от этих уведомлений?</p>
+<form method="post" action="?token={{.}}">
+<button type="submit">Отписаться</button>
+</form>
+</body>
+</html>
+`))
+	unsubscribedPage = template.Must(template.New("unsubscribed").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<body>
+<p>Вы отписались от этих уведомлений. Вернуть их можно в настройках профиля.</p>
+</body>
+</html>
+`))
+)
+
+// ConfirmUnsubscribe asks before opting out, since mail scanners open every
+// link in a message and must not unsubscribe anybody.
+func (h *Handler) ConfirmUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.WriteError(w, r, model.ErrInvalidUnsubscribe)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	confirmPage.Execute(w, token)
+}
+
+// Unsubscribe serves both the confirmation form and RFC 8058 one-click
+// requests, which mail clients POST to the List-Unsubscribe URL.
+func (h *Handler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if _, err := h.service.Unsubscribe(r.Context(), r.URL.Query().Get("token")); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	unsubscribedPage.Execute(w, nil)
+}
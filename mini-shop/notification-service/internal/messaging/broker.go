@@ -0,0 +1,29 @@
+package messaging
+
+import (
+	"github.com/Viltsev/notification-service/internal/metrics"
+	"github.com/Viltsev/notification-service/internal/tracing"
+	"mini-shop/platform/messaging"
+)
+
+const (
+	BrokerRabbitMQ = messaging.BrokerRabbitMQ
+	BrokerMemory   = messaging.BrokerMemory
+)
+
+type (
+	Publisher    = messaging.Publisher
+	Subscriber   = messaging.Subscriber
+	Broker       = messaging.Broker
+	MemoryBroker = messaging.MemoryBroker
+)
+
+// New returns the broker selected by kind, counting messages in the service metrics.
+func New(kind, url string) (Broker, error) {
+	return messaging.New(kind, url, tracing.ServiceName, metrics.Common)
+}
+
+// NewMemoryBroker returns an in-process broker for tests and local runs.
+func NewMemoryBroker() *MemoryBroker {
+	return messaging.NewMemoryBroker(metrics.Common)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	platform "mini-shop/platform/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Common are the HTTP and broker metrics of notification-service. The metrics
+// below share its "service" label.
+var Common = platform.New("notification-service")
+
+var (
+	EmailsSent = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "emails_sent_total",
+		Help:      "Notification emails sent.",
+	})
+
+	EmailsFailed = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "emails_failed_total",
+		Help:      "Notification emails that could not be sent.",
+	})
+
+	NotificationsSent = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "notifications_sent_total",
+		Help:      "Notifications delivered, by channel and notification type.",
+	}, []string{"channel", "type"})
+
+	NotificationsFailed = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "notifications_failed_total",
+		Help:      "Notifications that could not be delivered, by channel and notification type.",
+	}, []string{"channel", "type"})
+
+	NotificationsAbandoned = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "notifications_abandoned_total",
+		Help:      "Notifications given up on after the last delivery attempt, by channel and notification type.",
+	}, []string{"channel", "type"})
+)
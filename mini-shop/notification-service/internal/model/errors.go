@@ -0,0 +1,36 @@
+package model
+
+import "mini-shop/platform/problem"
+
+// Domain errors are the shared problem errors, so every service reports them
+// the same way.
+type (
+	ErrorKind  = problem.ErrorKind
+	Error      = problem.Error
+	FieldError = problem.FieldError
+)
+
+const (
+	KindInternal     = problem.KindInternal
+	KindInvalid      = problem.KindInvalid
+	KindUnauthorized = problem.KindUnauthorized
+	KindForbidden    = problem.KindForbidden
+	KindNotFound     = problem.KindNotFound
+	KindConflict     = problem.KindConflict
+)
+
+var (
+	ErrNotificationNotFound  = &Error{Kind: KindNotFound, Code: "notification_not_found", Message: "notification not found"}
+	ErrNotificationExists    = &Error{Kind: KindConflict, Code: "notification_exists", Message: "event was already delivered over this channel"}
+	ErrNotificationNotFailed = &Error{Kind: KindConflict, Code: "notification_not_failed", Message: "only failed notifications can be resent"}
+	ErrPermissionDenied      = &Error{Kind: KindForbidden, Code: "permission_denied", Message: "permission denied"}
+	ErrInvalidUnsubscribe    = &Error{Kind: KindInvalid, Code: "invalid_unsubscribe_link", Message: "unsubscribe link is invalid"}
+)
+
+func InvalidInput(format string, args ...any) error {
+	return problem.InvalidInput(format, args...)
+}
+
+func ValidationFailed(fields []FieldError) error {
+	return problem.ValidationFailed(fields)
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"context"
+	"time"
+
+	"mini-shop/platform/pagination"
+)
+
+// Статусы доставки уведомления
+const (
+	StatusPending = "pending"
+	// StatusSending marks a notification a dispatcher has claimed; its
+	// NextAttemptAt is when the claim runs out.
+	StatusSending = "sending"
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+)
+
+// RedactedURL stands in for the SecretURL of a notification in its stored
+// subject and bodies.
+const RedactedURL = "[link hidden]"
+
+// ErasedRecipient is the LastError of notifications that were still pending
+// when their recipient's account was erased.
+const ErasedRecipient = "recipient erased"
+
+type NotificationStore interface {
+	// CreateNotification returns ErrNotificationExists when the event already
+	// has a notification on the same channel.
+	CreateNotification(ctx context.Context, notification Notification) (*Notification, error)
+	GetNotificationByID(ctx context.Context, id int) (*Notification, error)
+	ListNotificationsByUser(ctx context.Context, userID int, filter NotificationFilter, params pagination.Params) ([]Notification, error)
+	// ClaimDueNotifications marks up to limit pending notifications whose next
+	// attempt is not later than now as sending until now plus lease. A sending
+	// notification whose lease ran out is claimed again, so one left behind by
+	// a stopped dispatcher is not lost.
+	ClaimDueNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Notification, error)
+	// UpdateDelivery stores the status, attempts, error and schedule of
+	// notification and forgets its SecretURL once it is sent.
+	UpdateDelivery(ctx context.Context, notification Notification) error
+	// GetPreferences returns nil when the user has not saved any preferences.
+	GetPreferences(ctx context.Context, userID int) (*Preferences, error)
+	SavePreferences(ctx context.Context, preferences Preferences) (*Preferences, error)
+	// EraseUser blanks the addresses and contents of the user's notifications,
+	// gives up the pending and sending ones and deletes the user's preferences.
+	EraseUser(ctx context.Context, userID int) error
+	// InTx runs fn inside a single database transaction.
+	InTx(ctx context.Context, fn func(tx NotificationStore) error) error
+}
+
+// Notification is one message for one channel, together with the state of its
+// delivery.
+type Notification struct {
+	ID int `json:"id"`
+	// EventID identifies the event that caused the notification; together with
+	// Channel it is unique.
+	EventID   string `json:"eventID"`
+	UserID    int    `json:"userID"`
+	Type      string `json:"type"`
+	Channel   string `json:"channel"`
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Body      string `json:"body"`
+	HTML      string `json:"-"`
+	// UnsubscribeURL is the one-click link sent in the List-Unsubscribe header.
+	UnsubscribeURL string `json:"-"`
+	// SecretURL is the single-use link the message carries, such as a password
+	// reset link. Subject, Body and HTML hold RedactedURL in its place, so
+	// listing or exporting notifications does not reveal it; it is put back
+	// only for delivery.
+	SecretURL string `json:"-"`
+
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"lastError,omitempty"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+// NotificationSorts lists the fields a user's notifications can be sorted by.
+var NotificationSorts = []string{"createdAt"}
+
+type NotificationFilter struct {
+	Status  string
+	Channel string
+}
+
+// NotificationCursor builds the keyset cursor pointing at notification.
+func NotificationCursor(notification Notification, sort string) pagination.Cursor {
+	return pagination.Cursor{Value: notification.CreatedAt.Format(time.RFC3339Nano), ID: notification.ID}
+}
@@ -0,0 +1,161 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Категории уведомлений, на которые пользователь подписывается по отдельности
+const (
+	CategoryOrders   = "orders"
+	CategoryPayments = "payments"
+	CategoryAccount  = "account"
+)
+
+var Categories = []string{CategoryOrders, CategoryPayments, CategoryAccount}
+
+// AllChannels stands for every channel of a category that is not listed by name.
+const AllChannels = "*"
+
+// essential notifications answer an action of the user, such as a password
+// reset, and go out regardless of preferences and quiet hours.
+var essential = map[string]bool{
+	"user.registered":     true,
+	"user.verify_email":   true,
+	"user.password_reset": true,
+}
+
+// CategoryOf returns the category of a notification type such as
+// "order.created", or "" for essential types and types outside every category.
+func CategoryOf(notificationType string) string {
+	if essential[notificationType] {
+		return ""
+	}
+	prefix, _, _ := strings.Cut(notificationType, ".")
+	switch prefix {
+	case "order":
+		return CategoryOrders
+	case "payment":
+		return CategoryPayments
+	case "user":
+		return CategoryAccount
+	default:
+		return ""
+	}
+}
+
+// Preferences decide which notifications a user gets, where and when.
+type Preferences struct {
+	UserID int `json:"userID"`
+	// Locale overrides the locale sent with events when set.
+	Locale string `json:"locale"`
+	// Channels opts channels in (true) or out (false) per category. Channels
+	// that are not listed follow AllChannels and otherwise stay opted in.
+	Channels   map[string]map[string]bool `json:"channels"`
+	QuietHours *QuietHours                `json:"quietHours"`
+	UpdatedAt  time.Time                  `json:"updatedAt"`
+}
+
+// Allows reports whether notifications of category may go out over channel.
+func (p Preferences) Allows(category, channel string) bool {
+	channels := p.Channels[category]
+	if enabled, ok := channels[channel]; ok {
+		return enabled
+	}
+	enabled, ok := channels[AllChannels]
+	return !ok || enabled
+}
+
+// SetChannel opts channel in or out of category.
+func (p *Preferences) SetChannel(category, channel string, enabled bool) {
+	if p.Channels == nil {
+		p.Channels = map[string]map[string]bool{}
+	}
+	if p.Channels[category] == nil {
+		p.Channels[category] = map[string]bool{}
+	}
+	p.Channels[category][channel] = enabled
+}
+
+// OptOut turns every channel of category off, including those opted in by name.
+func (p *Preferences) OptOut(category string) {
+	if p.Channels == nil {
+		p.Channels = map[string]map[string]bool{}
+	}
+	p.Channels[category] = map[string]bool{AllChannels: false}
+}
+
+// NotBefore is the earliest time a notification created at t may be sent.
+func (p Preferences) NotBefore(t time.Time) time.Time {
+	if p.QuietHours == nil {
+		return t
+	}
+	return p.QuietHours.Until(t)
+}
+
+// QuietHours is a daily window, such as 22:00–08:00, during which
+// notifications are held back until the window ends.
+type QuietHours struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// TimeZone is an IANA name such as "Europe/Moscow"; UTC when empty.
+	TimeZone string `json:"timeZone"`
+}
+
+// Validate reports every malformed field.
+func (q QuietHours) Validate() []FieldError {
+	var fields []FieldError
+	if _, err := parseClock(q.Start); err != nil {
+		fields = append(fields, FieldError{Field: "quietHours.start", Message: err.Error()})
+	}
+	if _, err := parseClock(q.End); err != nil {
+		fields = append(fields, FieldError{Field: "quietHours.end", Message: err.Error()})
+	}
+	if _, err := time.LoadLocation(q.TimeZone); err != nil {
+		fields = append(fields, FieldError{Field: "quietHours.timeZone", Message: "unknown time zone"})
+	}
+	return fields
+}
+
+// Until returns the end of the window when t falls inside it, and t otherwise.
+// Windows that cross midnight are supported; an empty window never holds
+// anything back.
+func (q QuietHours) Until(t time.Time) time.Time {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return t
+	}
+	end, err := parseClock(q.End)
+	if err != nil || start == end {
+		return t
+	}
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return t
+	}
+
+	local := t.In(loc)
+	now := local.Hour()*60 + local.Minute()
+	endOfWindow := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+
+	switch {
+	case start < end && now >= start && now < end:
+		return endOfWindow
+	case start > end && now >= start:
+		return endOfWindow.AddDate(0, 0, 1)
+	case start > end && now < end:
+		return endOfWindow
+	default:
+		return t
+	}
+}
+
+// parseClock reads "HH:MM" into minutes since midnight.
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("want a time of day such as 22:00")
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
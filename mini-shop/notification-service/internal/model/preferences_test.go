@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHoursUntil(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, moscow)
+	}
+
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		t     time.Time
+		want  time.Time
+	}{
+		{"before a daytime window", QuietHours{Start: "13:00", End: "15:00", TimeZone: "Europe/Moscow"}, at(19, 12, 59), at(19, 12, 59)},
+		{"inside a daytime window", QuietHours{Start: "13:00", End: "15:00", TimeZone: "Europe/Moscow"}, at(19, 13, 0), at(19, 15, 0)},
+		{"window end is not quiet", QuietHours{Start: "13:00", End: "15:00", TimeZone: "Europe/Moscow"}, at(19, 15, 0), at(19, 15, 0)},
+		{"evening of an overnight window", QuietHours{Start: "22:00", End: "08:00", TimeZone: "Europe/Moscow"}, at(19, 23, 10), at(20, 8, 0)},
+		{"morning of an overnight window", QuietHours{Start: "22:00", End: "08:00", TimeZone: "Europe/Moscow"}, at(20, 7, 59), at(20, 8, 0)},
+		{"day outside an overnight window", QuietHours{Start: "22:00", End: "08:00", TimeZone: "Europe/Moscow"}, at(19, 12, 0), at(19, 12, 0)},
+		{"empty window", QuietHours{Start: "08:00", End: "08:00", TimeZone: "Europe/Moscow"}, at(19, 8, 0), at(19, 8, 0)},
+		{"time zone of the window", QuietHours{Start: "22:00", End: "08:00"}, at(20, 0, 30), at(20, 0, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quiet.Until(tt.t); !got.Equal(tt.want) {
+				t.Errorf("Until(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPreferencesAllows(t *testing.T) {
+	var p Preferences
+	if !p.Allows(CategoryOrders, "smtp") {
+		t.Error("default preferences opt out of orders over smtp")
+	}
+
+	p.OptOut(CategoryOrders)
+	p.SetChannel(CategoryOrders, "webhook", true)
+	if p.Allows(CategoryOrders, "smtp") || !p.Allows(CategoryOrders, "webhook") || !p.Allows(CategoryPayments, "smtp") {
+		t.Errorf("channels = %v, want only orders over smtp opted out", p.Channels)
+	}
+}
@@ -0,0 +1,106 @@
+// Package notifier delivers rendered notifications over pluggable channels.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Каналы доставки, которые можно указать в конфигурации
+const (
+	ChannelSMTP    = "smtp"
+	ChannelWebhook = "webhook"
+	ChannelFile    = "file"
+	ChannelLog     = "log"
+	ChannelMemory  = "memory"
+)
+
+// Message is a notification ready to be delivered.
+type Message struct {
+	// Type names the notification, e.g. "payment.completed". Routes are keyed by it.
+	Type    string `json:"type"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// HTML is an optional rich alternative to Body for channels that can show it.
+	HTML string `json:"html,omitempty"`
+	// Unsubscribe is the one-click opt-out link for this kind of message.
+	Unsubscribe string `json:"unsubscribe,omitempty"`
+}
+
+// Notifier delivers a message over one channel.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Config holds the settings of every built-in channel.
+type Config struct {
+	SMTP SMTPConfig
+
+	WebhookURL    string
+	WebhookSecret string
+
+	FilePath string
+}
+
+// New builds the channel called name.
+func New(name string, cfg Config) (Notifier, error) {
+	switch name {
+	case ChannelSMTP:
+		return NewSMTPNotifier(cfg.SMTP), nil
+	case ChannelWebhook:
+		if cfg.WebhookURL == "" || cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("webhook channel needs a URL and a secret")
+		}
+		return NewWebhookNotifier(cfg.WebhookURL, cfg.WebhookSecret), nil
+	case ChannelFile:
+		return NewFileNotifier(cfg.FilePath), nil
+	case ChannelLog:
+		return NewLogNotifier(), nil
+	case ChannelMemory:
+		return NewMemoryNotifier(), nil
+	default:
+		return nil, fmt.Errorf("unknown notification channel %q", name)
+	}
+}
+
+// ParseRoutes reads a route spec such as
+//
+//	payment.completed=smtp,webhook;payment.failed=smtp
+//
+// into channel names per notification type.
+func ParseRoutes(spec string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, route := range strings.Split(spec, ";") {
+		route = strings.TrimSpace(route)
+		if route == "" {
+			continue
+		}
+
+		notificationType, channels, ok := strings.Cut(route, "=")
+		if !ok {
+			return nil, fmt.Errorf("route %q must look like type=channel[,channel]", route)
+		}
+		names, err := ParseChannels(channels)
+		if err != nil {
+			return nil, fmt.Errorf("route %q: %w", route, err)
+		}
+		routes[strings.TrimSpace(notificationType)] = names
+	}
+	return routes, nil
+}
+
+// ParseChannels splits a comma-separated list of channel names.
+func ParseChannels(spec string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(spec, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no channels given")
+	}
+	return names, nil
+}
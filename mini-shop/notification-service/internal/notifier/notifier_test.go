@@ -0,0 +1,179 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes(" payment.completed = smtp, webhook ;payment.failed=log;")
+	if err != nil {
+		t.Fatalf("ParseRoutes: %v", err)
+	}
+	want := map[string][]string{
+		"payment.completed": {"smtp", "webhook"},
+		"payment.failed":    {"log"},
+	}
+	if !reflect.DeepEqual(routes, want) {
+		t.Errorf("routes = %v, want %v", routes, want)
+	}
+
+	for _, spec := range []string{"payment.completed", "payment.completed=", "payment.completed= , "} {
+		if _, err := ParseRoutes(spec); err == nil {
+			t.Errorf("ParseRoutes(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+type failingNotifier struct{}
+
+func (failingNotifier) Notify(context.Context, Message) error {
+	return errors.New("unavailable")
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	email, hook := NewMemoryNotifier(), NewMemoryNotifier()
+	channels := map[string]Notifier{"email": email, "hook": hook, "broken": failingNotifier{}}
+
+	router, err := NewRouter(channels, []string{"email"}, map[string][]string{
+		"payment.failed": {"broken", "hook"},
+	})
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+
+	if err := router.Notify(ctx, Message{Type: "payment.completed", To: "ann@example.com"}); err != nil {
+		t.Fatalf("Notify default route: %v", err)
+	}
+	if err := router.Notify(ctx, Message{Type: "payment.failed", To: "ann@example.com"}); err == nil {
+		t.Fatal("Notify with a broken channel succeeded, want an error")
+	}
+
+	if got := email.Messages(); len(got) != 1 || got[0].Type != "payment.completed" {
+		t.Errorf("default channel got %+v, want only payment.completed", got)
+	}
+	if got := hook.Messages(); len(got) != 1 || got[0].Type != "payment.failed" {
+		t.Errorf("routed channel got %+v, want payment.failed despite the broken channel", got)
+	}
+
+	if _, err := NewRouter(channels, []string{"sms"}, nil); err == nil {
+		t.Error("NewRouter with an unknown channel succeeded, want an error")
+	}
+}
+
+func TestWebhookNotifierSignsRequests(t *testing.T) {
+	const secret = "webhook-secret"
+	msg := Message{Type: "payment.completed", To: "ann@example.com", Subject: "Paid", Body: "Order 1 paid"}
+
+	received := make(chan Message, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + Sign([]byte(secret), r.Header.Get(TimestampHeader), body)
+		if r.Header.Get(SignatureHeader) != want {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var got Message
+		json.Unmarshal(body, &got)
+		received <- got
+	}))
+	defer srv.Close()
+
+	if err := NewWebhookNotifier(srv.URL, secret).Notify(context.Background(), msg); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if got := <-received; got != msg {
+		t.Errorf("webhook received %+v, want %+v", got, msg)
+	}
+
+	if err := NewWebhookNotifier(srv.URL, "wrong-secret").Notify(context.Background(), msg); err == nil {
+		t.Error("Notify with a wrong secret succeeded, want the receiver's rejection")
+	}
+}
+
+func TestFileNotifierAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	n := NewFileNotifier(path)
+
+	for _, to := range []string{"ann@example.com", "bob@example.com"} {
+		if err := n.Notify(context.Background(), Message{Type: "payment.completed", To: to}); err != nil {
+			t.Fatalf("Notify: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	var last Message
+	if err := json.Unmarshal([]byte(lines[1]), &last); err != nil || last.To != "bob@example.com" {
+		t.Errorf("last line = %s, want the second message", lines[1])
+	}
+}
+
+func TestBuildMessageMatchesGoldenFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{
+			name: "plain",
+			msg:  Message{To: "ann@example.com", Subject: "Оплата заказа 7 успешна", Body: "Списано 30.00 ₽.\n"},
+		},
+		{
+			name: "multipart",
+			msg: Message{
+				To:      "ann@example.com",
+				Subject: "Оплата заказа 7 успешна",
+				Body:    "Списано 30.00 ₽.\n",
+				HTML:    "<p>Списано <strong>30.00 ₽</strong>.</p>\n",
+			},
+		},
+		{
+			name: "unsubscribe",
+			msg: Message{
+				To:          "ann@example.com",
+				Subject:     "Оплата заказа 7 успешна",
+				Body:        "Списано 30.00 ₽.\n",
+				Unsubscribe: "https://shop.example.com/api/v1/unsubscribe?token=abc",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildMessage("noreply@minishop.local", tt.msg, "minishop-boundary")
+
+			golden := filepath.Join("testdata", "smtp_"+tt.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("%s mismatch:\n--- got ---\n%s\n--- want ---\n%s", golden, got, want)
+			}
+		})
+	}
+}
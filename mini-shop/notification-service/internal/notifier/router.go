@@ -0,0 +1,71 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+
+	"github.com/Viltsev/notification-service/internal/metrics"
+)
+
+// Router sends each message to the channels configured for its type, or to the
+// default channels when the type has no route of its own.
+type Router struct {
+	channels map[string]Notifier
+	routes   map[string][]string
+	defaults []string
+}
+
+// NewRouter checks that every route refers to a known channel.
+func NewRouter(channels map[string]Notifier, defaults []string, routes map[string][]string) (*Router, error) {
+	for _, names := range append([][]string{defaults}, slices.Collect(maps.Values(routes))...) {
+		for _, name := range names {
+			if _, ok := channels[name]; !ok {
+				return nil, fmt.Errorf("notification channel %q is not configured", name)
+			}
+		}
+	}
+
+	return &Router{channels: channels, routes: routes, defaults: defaults}, nil
+}
+
+// Channels returns the names of the channels msgType is routed to.
+func (r *Router) Channels(msgType string) []string {
+	if names, ok := r.routes[msgType]; ok {
+		return names
+	}
+	return r.defaults
+}
+
+// Notify delivers msg over every routed channel. A failing channel does not
+// stop the others; the joined error reports all failures.
+func (r *Router) Notify(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, name := range r.Channels(msg.Type) {
+		if err := r.Send(ctx, name, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Send delivers msg over the named channel only.
+func (r *Router) Send(ctx context.Context, channel string, msg Message) error {
+	n, ok := r.channels[channel]
+	if !ok {
+		return fmt.Errorf("notification channel %q is not configured", channel)
+	}
+
+	if err := n.Notify(ctx, msg); err != nil {
+		metrics.NotificationsFailed.WithLabelValues(channel, msg.Type).Inc()
+		return err
+	}
+
+	metrics.NotificationsSent.WithLabelValues(channel, msg.Type).Inc()
+	slog.InfoContext(ctx, "notification delivered", "channel", channel, "type", msg.Type)
+	return nil
+}
@@ -0,0 +1,112 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/smtp"
+	"net/textproto"
+
+	"github.com/Viltsev/notification-service/internal/metrics"
+	"github.com/Viltsev/notification-service/internal/tracing"
+)
+
+// SMTPConfig describes the mail server notifications are sent through.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPNotifier sends messages as email: plain text, or multipart/alternative
+// with an HTML part when the message has one.
+type SMTPNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	n := &SMTPNotifier{
+		addr: fmt.Sprintf("%s:%s", cfg.Host, cfg.Port),
+		from: cfg.From,
+	}
+	// Локальные перехватчики почты (MailHog и т.п.) работают без авторизации
+	if cfg.Username != "" {
+		n.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	return n
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	_, span := tracing.Tracer().Start(ctx, "smtp.send")
+	defer span.End()
+
+	data := buildMessage(n.from, msg, "")
+
+	if err := smtp.SendMail(n.addr, n.auth, n.from, []string{msg.To}, data); err != nil {
+		metrics.EmailsFailed.Inc()
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	metrics.EmailsSent.Inc()
+	slog.InfoContext(ctx, "email sent", "email", msg.To)
+	return nil
+}
+
+// buildMessage formats msg as an RFC 5322 message. Bodies are quoted-printable
+// so that non-ASCII text survives servers without 8BITMIME. An empty boundary
+// means a random one.
+func buildMessage(from string, msg Message, boundary string) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.Unsubscribe != "" {
+		// RFC 8058: почтовый клиент отписывает одним POST без перехода по ссылке
+		b.WriteString("List-Unsubscribe: <" + msg.Unsubscribe + ">\r\n")
+		b.WriteString("List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=\"UTF-8\"\r\n")
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		writeQuotedPrintable(&b, msg.Body)
+		return b.Bytes()
+	}
+
+	w := multipart.NewWriter(&b)
+	if boundary != "" {
+		w.SetBoundary(boundary)
+	}
+	b.WriteString("Content-Type: multipart/alternative; boundary=\"" + w.Boundary() + "\"\r\n\r\n")
+
+	// Клиенты показывают последнюю понятную им часть, поэтому HTML идёт после текста
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", msg.Body},
+		{"text/html", msg.HTML},
+	} {
+		pw, _ := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=\"UTF-8\""},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		writeQuotedPrintable(pw, part.body)
+	}
+	w.Close()
+	return b.Bytes()
+}
+
+func writeQuotedPrintable(w io.Writer, body string) {
+	qp := quotedprintable.NewWriter(w)
+	qp.Write([]byte(body))
+	qp.Close()
+}
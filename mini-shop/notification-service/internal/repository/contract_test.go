@@ -0,0 +1,372 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// testNotificationStore is the contract every model.NotificationStore
+// implementation has to satisfy. newStore must return an empty store.
+func testNotificationStore(t *testing.T, newStore func(t *testing.T) model.NotificationStore) {
+	ctx := context.Background()
+
+	t.Run("lookups return nil, nil for a missing notification", func(t *testing.T) {
+		store := newStore(t)
+
+		if n, err := store.GetNotificationByID(ctx, 42); n != nil || err != nil {
+			t.Errorf("GetNotificationByID = %v, %v; want nil, nil", n, err)
+		}
+		if err := store.UpdateDelivery(ctx, model.Notification{ID: 42, Status: model.StatusSent}); !errors.Is(err, model.ErrNotificationNotFound) {
+			t.Errorf("UpdateDelivery error = %v, want %v", err, model.ErrNotificationNotFound)
+		}
+	})
+
+	t.Run("CreateNotification stores every field as pending", func(t *testing.T) {
+		store := newStore(t)
+
+		created := mustCreateNotification(t, store, "evt-1", "smtp", 7)
+		if created.ID == 0 || created.CreatedAt.IsZero() || created.Status != model.StatusPending || created.Attempts != 0 {
+			t.Fatalf("created notification = %+v, want a pending notification with an ID", created)
+		}
+
+		got, err := store.GetNotificationByID(ctx, created.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetNotificationByID = %v, %v; want the notification", got, err)
+		}
+		if got.EventID != "evt-1" || got.UserID != 7 || got.Type != "payment.completed" || got.Channel != "smtp" ||
+			got.Recipient != "ann@example.com" || got.Subject != "Paid" || got.Body != "text" || got.HTML != "<p>html</p>" {
+			t.Errorf("GetNotificationByID = %+v, want the created fields", got)
+		}
+		if !got.NextAttemptAt.Equal(got.CreatedAt) || got.SentAt != nil {
+			t.Errorf("schedule = next %v, sent %v; want due immediately and not sent", got.NextAttemptAt, got.SentAt)
+		}
+	})
+
+	t.Run("an event has at most one notification per channel", func(t *testing.T) {
+		store := newStore(t)
+
+		mustCreateNotification(t, store, "evt-1", "smtp", 7)
+		mustCreateNotification(t, store, "evt-1", "webhook", 7)
+
+		err := store.InTx(ctx, func(tx model.NotificationStore) error {
+			if _, err := tx.CreateNotification(ctx, model.Notification{EventID: "evt-1", Channel: "smtp", UserID: 7, Type: "payment.completed", Recipient: "ann@example.com"}); !errors.Is(err, model.ErrNotificationExists) {
+				t.Errorf("duplicate CreateNotification error = %v, want %v", err, model.ErrNotificationExists)
+			}
+			// Конфликт не должен ломать остальную транзакцию
+			_, err := tx.CreateNotification(ctx, model.Notification{EventID: "evt-2", Channel: "smtp", UserID: 7, Type: "payment.completed", Recipient: "ann@example.com"})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("InTx: %v", err)
+		}
+
+		page := listAll(t, store, 7)
+		if len(page) != 3 {
+			t.Errorf("got %d notifications, want 3", len(page))
+		}
+	})
+
+	t.Run("ClaimDueNotifications leases pending notifications whose attempt is due", func(t *testing.T) {
+		store := newStore(t)
+
+		due := mustCreateNotification(t, store, "evt-1", "smtp", 7)
+		later := mustCreateNotification(t, store, "evt-2", "smtp", 7)
+		sent := mustCreateNotification(t, store, "evt-3", "smtp", 7)
+
+		now := time.Now()
+		later.Attempts, later.NextAttemptAt = 1, now.Add(time.Hour)
+		sent.Status, sent.SentAt = model.StatusSent, &now
+		for _, n := range []*model.Notification{later, sent} {
+			if err := store.UpdateDelivery(ctx, *n); err != nil {
+				t.Fatalf("UpdateDelivery: %v", err)
+			}
+		}
+
+		got, err := store.ClaimDueNotifications(ctx, now.Add(time.Minute), time.Minute, 10)
+		if err != nil {
+			t.Fatalf("ClaimDueNotifications: %v", err)
+		}
+		if len(got) != 1 || got[0].ID != due.ID {
+			t.Fatalf("ClaimDueNotifications = %v, want only notification %d", ids(got), due.ID)
+		}
+		leased := now.Add(2 * time.Minute).Truncate(time.Microsecond)
+		if got[0].Status != model.StatusSending || !got[0].NextAttemptAt.Equal(leased) {
+			t.Errorf("claimed notification = %+v, want sending until %v", got[0], leased)
+		}
+		if stored, _ := store.GetNotificationByID(ctx, due.ID); stored.Status != model.StatusSending {
+			t.Errorf("stored status = %s, want %s", stored.Status, model.StatusSending)
+		}
+
+		// Захваченное уведомление не достаётся другому диспетчеру, пока не истекла аренда
+		got, err = store.ClaimDueNotifications(ctx, now.Add(time.Minute), time.Minute, 10)
+		if err != nil || len(got) != 0 {
+			t.Errorf("ClaimDueNotifications again = %v, %v; want nothing", ids(got), err)
+		}
+
+		if got, _ := store.ClaimDueNotifications(ctx, now.Add(2*time.Hour), time.Hour, 1); len(got) != 1 || got[0].ID != due.ID {
+			t.Errorf("ClaimDueNotifications with limit 1 after the lease = %v, want the expired claim %d", ids(got), due.ID)
+		}
+		got, err = store.ClaimDueNotifications(ctx, now.Add(2*time.Hour), time.Hour, 10)
+		if err != nil {
+			t.Fatalf("ClaimDueNotifications: %v", err)
+		}
+		if !slices.Equal(ids(got), []int{later.ID}) {
+			t.Errorf("ClaimDueNotifications after the retry time = %v, want [%d]", ids(got), later.ID)
+		}
+	})
+
+	t.Run("UpdateDelivery records the outcome of an attempt", func(t *testing.T) {
+		store := newStore(t)
+
+		n := mustCreateNotification(t, store, "evt-1", "smtp", 7)
+		sentAt := time.Now().Add(time.Minute).Truncate(time.Microsecond)
+		n.Status, n.Attempts, n.LastError, n.SentAt = model.StatusSent, 2, "", &sentAt
+		if err := store.UpdateDelivery(ctx, *n); err != nil {
+			t.Fatalf("UpdateDelivery: %v", err)
+		}
+
+		got, err := store.GetNotificationByID(ctx, n.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetNotificationByID = %v, %v", got, err)
+		}
+		if got.Status != model.StatusSent || got.Attempts != 2 || got.SentAt == nil || !got.SentAt.Equal(sentAt) {
+			t.Errorf("stored = %+v, want sent after 2 attempts at %v", got, sentAt)
+		}
+	})
+
+	t.Run("the secret URL is kept until the notification is sent", func(t *testing.T) {
+		store := newStore(t)
+
+		const link = "https://shop.test/reset-password?token=t"
+		n, err := store.CreateNotification(ctx, model.Notification{
+			EventID: "evt-1", UserID: 7, Type: "user.password_reset", Channel: "smtp", Recipient: "ann@example.com",
+			Body: "Reset: " + model.RedactedURL, SecretURL: link,
+		})
+		if err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+
+		n.Status, n.Attempts, n.LastError = model.StatusPending, 1, "timeout"
+		if err := store.UpdateDelivery(ctx, *n); err != nil {
+			t.Fatalf("UpdateDelivery: %v", err)
+		}
+		if got, _ := store.GetNotificationByID(ctx, n.ID); got.SecretURL != link {
+			t.Fatalf("secret URL after a failed attempt = %q, want %q", got.SecretURL, link)
+		}
+
+		n.Status, n.Attempts, n.LastError = model.StatusSent, 2, ""
+		if err := store.UpdateDelivery(ctx, *n); err != nil {
+			t.Fatalf("UpdateDelivery: %v", err)
+		}
+		if got, _ := store.GetNotificationByID(ctx, n.ID); got.SecretURL != "" || got.Body != "Reset: "+model.RedactedURL {
+			t.Errorf("sent notification = %+v, want the secret URL forgotten", got)
+		}
+	})
+
+	t.Run("ListNotificationsByUser filters and pages", func(t *testing.T) {
+		store := newStore(t)
+
+		for i, channel := range []string{"smtp", "webhook", "smtp"} {
+			mustCreateNotification(t, store, "evt-"+string(rune('a'+i)), channel, 7)
+		}
+		mustCreateNotification(t, store, "evt-other", "smtp", 8)
+
+		if got := listAll(t, store, 7); len(got) != 3 {
+			t.Errorf("user 7 has %d notifications, want 3", len(got))
+		}
+
+		smtp, err := store.ListNotificationsByUser(ctx, 7, model.NotificationFilter{Channel: "smtp", Status: model.StatusPending}, pagination.Params{Limit: 10, Sort: "createdAt"})
+		if err != nil || len(smtp) != 2 {
+			t.Errorf("smtp notifications = %d, %v; want 2", len(smtp), err)
+		}
+
+		first, err := store.ListNotificationsByUser(ctx, 7, model.NotificationFilter{}, pagination.Params{Limit: 1, Sort: "createdAt", Desc: true})
+		if err != nil {
+			t.Fatalf("ListNotificationsByUser: %v", err)
+		}
+		// Запрашивается на одну строку больше, чтобы понять, есть ли следующая страница
+		if len(first) != 2 {
+			t.Fatalf("first page = %d rows, want limit+1", len(first))
+		}
+		cursor := model.NotificationCursor(first[0], "createdAt")
+		rest, err := store.ListNotificationsByUser(ctx, 7, model.NotificationFilter{}, pagination.Params{Limit: 10, Sort: "createdAt", Desc: true, Cursor: &cursor})
+		if err != nil {
+			t.Fatalf("ListNotificationsByUser after cursor: %v", err)
+		}
+		if len(rest) != 2 || slices.Contains(ids(rest), first[0].ID) {
+			t.Errorf("second page = %v, want the 2 notifications after %d", ids(rest), first[0].ID)
+		}
+	})
+
+	t.Run("CreateNotification keeps a later first attempt", func(t *testing.T) {
+		store := newStore(t)
+
+		later := time.Now().Add(8 * time.Hour).Truncate(time.Microsecond)
+		created, err := store.CreateNotification(ctx, model.Notification{
+			EventID: "evt-1", UserID: 7, Type: "order.shipped", Channel: "smtp", Recipient: "ann@example.com",
+			UnsubscribeURL: "https://shop.test/unsubscribe?token=t",
+			NextAttemptAt:  later,
+		})
+		if err != nil {
+			t.Fatalf("CreateNotification: %v", err)
+		}
+		if !created.NextAttemptAt.Equal(later) {
+			t.Errorf("next attempt = %v, want %v", created.NextAttemptAt, later)
+		}
+
+		if due, err := store.ClaimDueNotifications(ctx, time.Now(), time.Minute, 10); err != nil || len(due) != 0 {
+			t.Errorf("ClaimDueNotifications now = %v, %v; want nothing due before quiet hours end", ids(due), err)
+		}
+		due, err := store.ClaimDueNotifications(ctx, later, time.Minute, 10)
+		if err != nil || len(due) != 1 {
+			t.Fatalf("ClaimDueNotifications at the deferred time = %v, %v; want the notification", ids(due), err)
+		}
+		if due[0].UnsubscribeURL != "https://shop.test/unsubscribe?token=t" {
+			t.Errorf("unsubscribe URL = %q, want the stored link", due[0].UnsubscribeURL)
+		}
+	})
+
+	t.Run("preferences are saved and replaced per user", func(t *testing.T) {
+		store := newStore(t)
+
+		if p, err := store.GetPreferences(ctx, 7); p != nil || err != nil {
+			t.Fatalf("GetPreferences before saving = %v, %v; want nil, nil", p, err)
+		}
+
+		want := model.Preferences{
+			UserID:     7,
+			Locale:     "en",
+			QuietHours: &model.QuietHours{Start: "22:00", End: "08:00", TimeZone: "Europe/Moscow"},
+		}
+		want.SetChannel(model.CategoryOrders, "smtp", false)
+		saved, err := store.SavePreferences(ctx, want)
+		if err != nil {
+			t.Fatalf("SavePreferences: %v", err)
+		}
+		if saved.UpdatedAt.IsZero() {
+			t.Errorf("saved preferences = %+v, want an update time", saved)
+		}
+
+		got, err := store.GetPreferences(ctx, 7)
+		if err != nil || got == nil {
+			t.Fatalf("GetPreferences = %v, %v; want the saved preferences", got, err)
+		}
+		if got.Locale != "en" || got.Allows(model.CategoryOrders, "smtp") || !got.Allows(model.CategoryPayments, "smtp") ||
+			got.QuietHours == nil || *got.QuietHours != *want.QuietHours {
+			t.Errorf("GetPreferences = %+v, want the saved fields", got)
+		}
+
+		if _, err := store.SavePreferences(ctx, model.Preferences{UserID: 7}); err != nil {
+			t.Fatalf("SavePreferences: %v", err)
+		}
+		got, err = store.GetPreferences(ctx, 7)
+		if err != nil || got.Locale != "" || got.QuietHours != nil || !got.Allows(model.CategoryOrders, "smtp") {
+			t.Errorf("GetPreferences after replacing = %+v, %v; want defaults", got, err)
+		}
+		if other, err := store.GetPreferences(ctx, 8); other != nil || err != nil {
+			t.Errorf("GetPreferences of another user = %v, %v; want nil, nil", other, err)
+		}
+	})
+
+	t.Run("EraseUser blanks notifications and drops preferences", func(t *testing.T) {
+		store := newStore(t)
+
+		sent := mustCreateNotification(t, store, "evt-1", "smtp", 7)
+		sent.Status = model.StatusSent
+		if err := store.UpdateDelivery(ctx, *sent); err != nil {
+			t.Fatalf("UpdateDelivery: %v", err)
+		}
+		mustCreateNotification(t, store, "evt-2", "smtp", 7)
+		other := mustCreateNotification(t, store, "evt-3", "smtp", 8)
+		if _, err := store.SavePreferences(ctx, model.Preferences{UserID: 7, Locale: "en"}); err != nil {
+			t.Fatalf("SavePreferences: %v", err)
+		}
+
+		if err := store.EraseUser(ctx, 7); err != nil {
+			t.Fatalf("EraseUser: %v", err)
+		}
+
+		got := listAll(t, store, 7)
+		if len(got) != 2 {
+			t.Fatalf("%d notifications after erasing, want 2", len(got))
+		}
+		for _, n := range got {
+			if n.Recipient != "" || n.Subject != "" || n.Body != "" {
+				t.Errorf("erased notification = %+v, want no address or contents", n)
+			}
+		}
+		if got[0].Status != model.StatusSent || got[1].Status != model.StatusFailed || got[1].LastError != model.ErasedRecipient {
+			t.Errorf("statuses = %s and %s (%q), want sent and a failed pending one", got[0].Status, got[1].Status, got[1].LastError)
+		}
+		if due, _ := store.ClaimDueNotifications(ctx, time.Now().Add(time.Hour), time.Minute, 10); len(due) != 1 || due[0].ID != other.ID {
+			t.Errorf("due notifications = %v, want only the other user's", ids(due))
+		}
+		if p, err := store.GetPreferences(ctx, 7); p != nil || err != nil {
+			t.Errorf("GetPreferences after erasing = %v, %v; want nil, nil", p, err)
+		}
+		if n, _ := store.GetNotificationByID(ctx, other.ID); n.Recipient != "ann@example.com" {
+			t.Errorf("other user's recipient = %q, want it kept", n.Recipient)
+		}
+	})
+
+	t.Run("InTx rolls back on error", func(t *testing.T) {
+		store := newStore(t)
+
+		boom := errors.New("boom")
+		err := store.InTx(ctx, func(tx model.NotificationStore) error {
+			if _, err := tx.CreateNotification(ctx, model.Notification{EventID: "evt-1", Channel: "smtp", UserID: 7, Type: "payment.completed", Recipient: "ann@example.com"}); err != nil {
+				return err
+			}
+			return boom
+		})
+		if !errors.Is(err, boom) {
+			t.Fatalf("InTx error = %v, want %v", err, boom)
+		}
+		if got := listAll(t, store, 7); len(got) != 0 {
+			t.Errorf("%d notifications survived the rollback", len(got))
+		}
+	})
+}
+
+func mustCreateNotification(t *testing.T, store model.NotificationStore, eventID, channel string, userID int) *model.Notification {
+	t.Helper()
+
+	n, err := store.CreateNotification(context.Background(), model.Notification{
+		EventID:   eventID,
+		UserID:    userID,
+		Type:      "payment.completed",
+		Channel:   channel,
+		Recipient: "ann@example.com",
+		Subject:   "Paid",
+		Body:      "text",
+		HTML:      "<p>html</p>",
+	})
+	if err != nil {
+		t.Fatalf("CreateNotification: %v", err)
+	}
+	return n
+}
+
+func listAll(t *testing.T, store model.NotificationStore, userID int) []model.Notification {
+	t.Helper()
+
+	notifications, err := store.ListNotificationsByUser(context.Background(), userID, model.NotificationFilter{}, pagination.Params{Limit: 100, Sort: "createdAt"})
+	if err != nil {
+		t.Fatalf("ListNotificationsByUser: %v", err)
+	}
+	return notifications
+}
+
+func ids(notifications []model.Notification) []int {
+	ids := make([]int, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.ID
+	}
+	return ids
+}
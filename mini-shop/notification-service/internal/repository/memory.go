@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// MemoryStore is an in-memory model.NotificationStore with the same semantics
+// as the Postgres Store. It is meant for tests and has no persistence.
+type MemoryStore struct {
+	state *notificationState
+	inTx  bool
+}
+
+type notificationState struct {
+	// tx serializes transactions, which is all the isolation the fake offers.
+	tx sync.Mutex
+
+	mu            sync.Mutex
+	notifications []model.Notification
+	preferences   map[int]model.Preferences
+	last          int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: &notificationState{}}
+}
+
+func (s *MemoryStore) CreateNotification(ctx context.Context, n model.Notification) (*model.Notification, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if s.state.index(func(x model.Notification) bool { return x.EventID == n.EventID && x.Channel == n.Channel }) >= 0 {
+		return nil, model.ErrNotificationExists
+	}
+
+	s.state.last++
+	n.ID = s.state.last
+	n.Status = model.StatusPending
+	n.Attempts = 0
+	n.LastError = ""
+	n.CreatedAt = now()
+	// Тихие часы откладывают первую попытку
+	if n.NextAttemptAt.Before(n.CreatedAt) {
+		n.NextAttemptAt = n.CreatedAt
+	}
+	n.NextAttemptAt = n.NextAttemptAt.UTC().Truncate(time.Microsecond)
+	n.SentAt = nil
+	s.state.notifications = append(s.state.notifications, n)
+	return &n, nil
+}
+
+func (s *MemoryStore) GetNotificationByID(ctx context.Context, id int) (*model.Notification, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.index(func(n model.Notification) bool { return n.ID == id })
+	if i < 0 {
+		return nil, nil
+	}
+	n := s.state.notifications[i]
+	return &n, nil
+}
+
+func (s *MemoryStore) ListNotificationsByUser(ctx context.Context, userID int, filter model.NotificationFilter, params pagination.Params) ([]model.Notification, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	notifications := []model.Notification{}
+	for _, n := range s.state.notifications {
+		if n.UserID != userID {
+			continue
+		}
+		if filter.Status != "" && n.Status != filter.Status {
+			continue
+		}
+		if filter.Channel != "" && n.Channel != filter.Channel {
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+
+	return keysetPage(notifications, params, model.NotificationCursor), nil
+}
+
+func (s *MemoryStore) ClaimDueNotifications(ctx context.Context, at time.Time, lease time.Duration, limit int) ([]model.Notification, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	var due []int
+	for i, n := range s.state.notifications {
+		if (n.Status == model.StatusPending || n.Status == model.StatusSending) && !n.NextAttemptAt.After(at) {
+			due = append(due, i)
+		}
+	}
+	slices.SortStableFunc(due, func(a, b int) int {
+		x, y := s.state.notifications[a], s.state.notifications[b]
+		return cmp.Or(x.NextAttemptAt.Compare(y.NextAttemptAt), cmp.Compare(x.ID, y.ID))
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	slices.Sort(due)
+
+	until := at.Add(lease).UTC().Truncate(time.Microsecond)
+	claimed := make([]model.Notification, 0, len(due))
+	for _, i := range due {
+		n := &s.state.notifications[i]
+		n.Status, n.NextAttemptAt = model.StatusSending, until
+		claimed = append(claimed, *n)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) UpdateDelivery(ctx context.Context, n model.Notification) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.index(func(x model.Notification) bool { return x.ID == n.ID })
+	if i < 0 {
+		return model.ErrNotificationNotFound
+	}
+
+	stored := &s.state.notifications[i]
+	stored.Status = n.Status
+	stored.Attempts = n.Attempts
+	stored.LastError = n.LastError
+	stored.NextAttemptAt = n.NextAttemptAt.Truncate(time.Microsecond)
+	if n.Status == model.StatusSent {
+		stored.SecretURL = ""
+	}
+	stored.SentAt = nil
+	if n.SentAt != nil {
+		sentAt := n.SentAt.Truncate(time.Microsecond)
+		stored.SentAt = &sentAt
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetPreferences(ctx context.Context, userID int) (*model.Preferences, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	p, ok := s.state.preferences[userID]
+	if !ok {
+		return nil, nil
+	}
+	return clonePreferences(p), nil
+}
+
+func (s *MemoryStore) SavePreferences(ctx context.Context, p model.Preferences) (*model.Preferences, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	p.UpdatedAt = now()
+	if p.Channels == nil {
+		p.Channels = map[string]map[string]bool{}
+	}
+	if s.state.preferences == nil {
+		s.state.preferences = map[int]model.Preferences{}
+	}
+	s.state.preferences[p.UserID] = *clonePreferences(p)
+	return clonePreferences(p), nil
+}
+
+func (s *MemoryStore) EraseUser(ctx context.Context, userID int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.notifications {
+		n := &s.state.notifications[i]
+		if n.UserID != userID {
+			continue
+		}
+		n.Recipient, n.Subject, n.Body, n.HTML, n.UnsubscribeURL, n.SecretURL = "", "", "", "", "", ""
+		if n.Status == model.StatusPending || n.Status == model.StatusSending {
+			n.Status, n.LastError = model.StatusFailed, model.ErasedRecipient
+		}
+	}
+	delete(s.state.preferences, userID)
+	return nil
+}
+
+// clonePreferences copies the nested maps so callers cannot change the stored
+// preferences in place.
+func clonePreferences(p model.Preferences) *model.Preferences {
+	channels := make(map[string]map[string]bool, len(p.Channels))
+	for category, enabled := range p.Channels {
+		channels[category] = maps.Clone(enabled)
+	}
+	p.Channels = channels
+	if p.QuietHours != nil {
+		quietHours := *p.QuietHours
+		p.QuietHours = &quietHours
+	}
+	return &p
+}
+
+// InTx runs fn with transactions serialized. If fn fails, every change made
+// since the transaction began is discarded.
+func (s *MemoryStore) InTx(ctx context.Context, fn func(tx model.NotificationStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.state.tx.Lock()
+	defer s.state.tx.Unlock()
+
+	s.state.mu.Lock()
+	notifications, preferences := slices.Clone(s.state.notifications), maps.Clone(s.state.preferences)
+	s.state.mu.Unlock()
+
+	if err := fn(&MemoryStore{state: s.state, inTx: true}); err != nil {
+		s.state.mu.Lock()
+		s.state.notifications, s.state.preferences = notifications, preferences
+		s.state.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (st *notificationState) index(match func(model.Notification) bool) int {
+	return slices.IndexFunc(st.notifications, match)
+}
+
+// now matches the microsecond precision of Postgres timestamps.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// keysetPage applies the keyset condition, ordering and limit that pagination.Query
+// builds for Postgres.
+func keysetPage[T any](items []T, params pagination.Params, cursor func(item T, sort string) pagination.Cursor) []T {
+	compare := func(a, b pagination.Cursor) int {
+		x, _ := time.Parse(time.RFC3339Nano, a.Value)
+		y, _ := time.Parse(time.RFC3339Nano, b.Value)
+		c := cmp.Or(x.Compare(y), cmp.Compare(a.ID, b.ID))
+		if params.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	if params.Cursor != nil {
+		items = slices.DeleteFunc(items, func(item T) bool {
+			return compare(cursor(item, params.Sort), *params.Cursor) <= 0
+		})
+	}
+
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(cursor(a, params.Sort), cursor(b, params.Sort))
+	})
+
+	if len(items) > params.Limit+1 {
+		items = items[:params.Limit+1]
+	}
+	return items
+}
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/Viltsev/notification-service/internal/model"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testNotificationStore(t, func(t *testing.T) model.NotificationStore {
+		return NewMemoryStore()
+	})
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"mini-shop/platform/database"
+	"mini-shop/platform/pagination"
+)
+
+const notificationColumns = `id, eventID, userID, type, channel, recipient, subject, body, html, unsubscribeURL, secretURL,
+	status, attempts, lastError, nextAttemptAt, createdAt, sentAt`
+
+var notificationSortColumns = map[string]pagination.Column{
+	"createdAt": {Name: "createdAt", Cast: "timestamp"},
+}
+
+type Store struct {
+	db *sql.DB
+	q  database.DBTX
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db, q: db}
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanNotification(row rowScanner) (*model.Notification, error) {
+	n := new(model.Notification)
+
+	err := row.Scan(
+		&n.ID,
+		&n.EventID,
+		&n.UserID,
+		&n.Type,
+		&n.Channel,
+		&n.Recipient,
+		&n.Subject,
+		&n.Body,
+		&n.HTML,
+		&n.UnsubscribeURL,
+		&n.SecretURL,
+		&n.Status,
+		&n.Attempts,
+		&n.LastError,
+		&n.NextAttemptAt,
+		&n.CreatedAt,
+		&n.SentAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func (s *Store) CreateNotification(ctx context.Context, n model.Notification) (*model.Notification, error) {
+	query := `INSERT INTO notifications (eventID, userID, type, channel, recipient, subject, body, html, unsubscribeURL, secretURL, status, nextAttemptAt, createdAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (eventID, channel) DO NOTHING RETURNING id`
+
+	// Время хранится без часового пояса в UTC
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	n.Status = model.StatusPending
+	n.Attempts = 0
+	n.LastError = ""
+	// Тихие часы откладывают первую попытку
+	if n.NextAttemptAt.Before(now) {
+		n.NextAttemptAt = now
+	}
+	n.NextAttemptAt = n.NextAttemptAt.UTC().Truncate(time.Microsecond)
+	n.CreatedAt = now
+	n.SentAt = nil
+
+	// ON CONFLICT вместо перехвата ошибки: иначе конфликт прервал бы всю транзакцию
+	err := s.q.QueryRowContext(ctx, query, n.EventID, n.UserID, n.Type, n.Channel, n.Recipient, n.Subject, n.Body, n.HTML, n.UnsubscribeURL, n.SecretURL,
+		n.Status, n.NextAttemptAt, now).Scan(&n.ID)
+	if err == sql.ErrNoRows {
+		return nil, model.ErrNotificationExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+func (s *Store) GetNotificationByID(ctx context.Context, id int) (*model.Notification, error) {
+	row := s.q.QueryRowContext(ctx, `SELECT `+notificationColumns+` FROM notifications WHERE id = $1`, id)
+
+	n, err := scanNotification(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return n, nil
+}
+
+func (s *Store) ListNotificationsByUser(ctx context.Context, userID int, filter model.NotificationFilter, params pagination.Params) ([]model.Notification, error) {
+	q := pagination.NewQuery()
+	q.Where("userID = ?", userID)
+	if filter.Status != "" {
+		q.Where("status = ?", filter.Status)
+	}
+	if filter.Channel != "" {
+		q.Where("channel = ?", filter.Channel)
+	}
+	query := q.Build(`SELECT `+notificationColumns+` FROM notifications`, notificationSortColumns[params.Sort], params)
+
+	rows, err := s.q.QueryContext(ctx, query, q.Args()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []model.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// ClaimDueNotifications claims in a single statement, so the rows are locked
+// only for as long as it runs. Rows locked by another dispatcher are skipped,
+// so several replicas can deliver concurrently.
+func (s *Store) ClaimDueNotifications(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]model.Notification, error) {
+	query := `WITH claimed AS (
+			UPDATE notifications SET status = 'sending', nextAttemptAt = $2
+			WHERE id IN (SELECT id FROM notifications WHERE status IN ('pending', 'sending') AND nextAttemptAt <= $1
+				ORDER BY nextAttemptAt, id LIMIT $3 FOR UPDATE SKIP LOCKED)
+			RETURNING ` + notificationColumns + `
+		)
+		SELECT ` + notificationColumns + ` FROM claimed ORDER BY id`
+
+	until := now.Add(lease).UTC().Truncate(time.Microsecond)
+	rows, err := s.q.QueryContext(ctx, query, now.UTC(), until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []model.Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (s *Store) UpdateDelivery(ctx context.Context, n model.Notification) error {
+	query := `UPDATE notifications SET status = $1, attempts = $2, lastError = $3, nextAttemptAt = $4, sentAt = $5,
+		secretURL = CASE WHEN $1 = 'sent' THEN '' ELSE secretURL END
+		WHERE id = $6`
+
+	var sentAt *time.Time
+	if n.SentAt != nil {
+		t := n.SentAt.UTC()
+		sentAt = &t
+	}
+	result, err := s.q.ExecContext(ctx, query, n.Status, n.Attempts, n.LastError, n.NextAttemptAt.UTC(), sentAt, n.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return model.ErrNotificationNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) GetPreferences(ctx context.Context, userID int) (*model.Preferences, error) {
+	query := `SELECT userID, locale, channels, quietStart, quietEnd, timeZone, updatedAt FROM notification_preferences WHERE userID = $1`
+
+	var (
+		p                    model.Preferences
+		channels             []byte
+		quietStart, quietEnd sql.NullString
+		timeZone             string
+	)
+	err := s.q.QueryRowContext(ctx, query, userID).Scan(&p.UserID, &p.Locale, &channels, &quietStart, &quietEnd, &timeZone, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(channels, &p.Channels); err != nil {
+		return nil, err
+	}
+	if quietStart.Valid {
+		p.QuietHours = &model.QuietHours{Start: quietStart.String, End: quietEnd.String, TimeZone: timeZone}
+	}
+	return &p, nil
+}
+
+func (s *Store) SavePreferences(ctx context.Context, p model.Preferences) (*model.Preferences, error) {
+	query := `INSERT INTO notification_preferences (userID, locale, channels, quietStart, quietEnd, timeZone, updatedAt)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (userID) DO UPDATE SET locale = $2, channels = $3, quietStart = $4, quietEnd = $5, timeZone = $6, updatedAt = $7`
+
+	if p.Channels == nil {
+		p.Channels = map[string]map[string]bool{}
+	}
+	channels, err := json.Marshal(p.Channels)
+	if err != nil {
+		return nil, err
+	}
+
+	var quietStart, quietEnd sql.NullString
+	var timeZone string
+	if p.QuietHours != nil {
+		quietStart = sql.NullString{String: p.QuietHours.Start, Valid: true}
+		quietEnd = sql.NullString{String: p.QuietHours.End, Valid: true}
+		timeZone = p.QuietHours.TimeZone
+	}
+
+	p.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if _, err := s.q.ExecContext(ctx, query, p.UserID, p.Locale, string(channels), quietStart, quietEnd, timeZone, p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *Store) EraseUser(ctx context.Context, userID int) error {
+	query := `UPDATE notifications SET recipient = '', subject = '', body = '', html = '', unsubscribeURL = '', secretURL = '',
+		lastError = CASE WHEN status IN ('pending', 'sending') THEN $2 ELSE lastError END,
+		status = CASE WHEN status IN ('pending', 'sending') THEN 'failed' ELSE status END
+		WHERE userID = $1`
+
+	if _, err := s.q.ExecContext(ctx, query, userID, model.ErasedRecipient); err != nil {
+		return err
+	}
+	_, err := s.q.ExecContext(ctx, `DELETE FROM notification_preferences WHERE userID = $1`, userID)
+	return err
+}
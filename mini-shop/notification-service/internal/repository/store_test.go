@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/migrations"
+	"mini-shop/platform/database"
+)
+
+func TestStore(t *testing.T) {
+	db := openTestDB(t)
+
+	testNotificationStore(t, func(t *testing.T) model.NotificationStore {
+		if _, err := db.Exec("TRUNCATE notifications, notification_preferences RESTART IDENTITY CASCADE"); err != nil {
+			t.Fatalf("truncate tables: %v", err)
+		}
+		return NewStore(db)
+	})
+}
+
+// openTestDB creates a migrated scratch database on the server named by
+// TEST_POSTGRES_DSN and drops it when the test ends. The test is skipped when
+// the variable is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse TEST_POSTGRES_DSN: %v", err)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open admin connection: %v", err)
+	}
+	name := fmt.Sprintf("notification_service_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		admin.Close()
+		t.Fatalf("create database: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name))
+		admin.Close()
+	})
+
+	password, _ := u.User.Password()
+	sslMode := u.Query().Get("sslmode")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	cfg := database.Config{
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		User:     u.User.Username(),
+		Password: password,
+		DBName:   name,
+		SSLMode:  sslMode,
+	}
+
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/metrics"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/notifier"
+)
+
+const (
+	dispatchBatchSize    = 50
+	dispatchPollInterval = time.Second
+	// dispatchLease is how long a claimed batch may take to send before another
+	// dispatcher takes it over.
+	dispatchLease = 10 * time.Minute
+)
+
+// RetryPolicy decides how many times and how soon a failed delivery is retried.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: time.Hour}
+
+// Delay is how long to wait after the given failed attempt: BaseDelay, doubled
+// for every earlier failure and capped at MaxDelay.
+func (p RetryPolicy) Delay(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < attempt && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// Dispatcher delivers the notifications stored in the delivery log. It drains
+// the log as soon as new notifications are enqueued and polls for retries that
+// have become due.
+type Dispatcher struct {
+	store  model.NotificationStore
+	router *notifier.Router
+	policy RetryPolicy
+	wake   chan struct{}
+}
+
+func NewDispatcher(store model.NotificationStore, router *notifier.Router, policy RetryPolicy) *Dispatcher {
+	return &Dispatcher{
+		store:  store,
+		router: router,
+		policy: policy,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Enqueue records msg once for every channel its type is routed to and the
+// recipient has not opted out of. Channels that already have a notification
+// for eventID are skipped, so a redelivered event is not sent twice. Quiet
+// hours hold the first attempt back. secretURL, when set, is a single-use link
+// in msg that is kept out of the stored text. It reports how many
+// notifications were created.
+func (d *Dispatcher) Enqueue(ctx context.Context, eventID string, userID int, msg notifier.Message, secretURL string, prefs model.Preferences) (int, error) {
+	notBefore := prefs.NotBefore(time.Now())
+	msg = redact(msg, secretURL)
+	created := 0
+	err := d.store.InTx(ctx, func(tx model.NotificationStore) error {
+		for _, channel := range d.Channels(msg.Type, prefs) {
+			_, err := tx.CreateNotification(ctx, model.Notification{
+				EventID:        eventID,
+				UserID:         userID,
+				Type:           msg.Type,
+				Channel:        channel,
+				Recipient:      msg.To,
+				Subject:        msg.Subject,
+				Body:           msg.Body,
+				HTML:           msg.HTML,
+				UnsubscribeURL: msg.Unsubscribe,
+				SecretURL:      secretURL,
+				NextAttemptAt:  notBefore,
+			})
+			if errors.Is(err, model.ErrNotificationExists) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			created++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if created > 0 {
+		d.Notify()
+	}
+	return created, nil
+}
+
+// Channels returns the channels msgType is routed to that prefs allow.
+func (d *Dispatcher) Channels(msgType string, prefs model.Preferences) []string {
+	category := model.CategoryOf(msgType)
+	var channels []string
+	for _, channel := range d.router.Channels(msgType) {
+		if prefs.Allows(category, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// Notify asks the dispatcher to deliver due notifications without waiting for
+// the next poll.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatchPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.flush(ctx, time.Now()); err != nil {
+			slog.ErrorContext(ctx, "failed to dispatch notifications", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// flush attempts every notification due at the given time. The batch is
+// claimed first and sent outside any transaction, so a slow channel holds no
+// locks; every outcome is stored as soon as it is known.
+func (d *Dispatcher) flush(ctx context.Context, at time.Time) error {
+	due, err := d.store.ClaimDueNotifications(ctx, at, dispatchLease, dispatchBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, n := range due {
+		d.deliver(ctx, &n, at)
+		if err := d.store.UpdateDelivery(ctx, n); err != nil {
+			errs = append(errs, fmt.Errorf("record delivery of notification %d: %w", n.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver makes one attempt and records its outcome in n.
+func (d *Dispatcher) deliver(ctx context.Context, n *model.Notification, at time.Time) {
+	msg := unredact(notifier.Message{Type: n.Type, To: n.Recipient, Subject: n.Subject, Body: n.Body, HTML: n.HTML, Unsubscribe: n.UnsubscribeURL}, n.SecretURL)
+	err := d.router.Send(ctx, n.Channel, msg)
+	n.Attempts++
+
+	if err == nil {
+		n.Status = model.StatusSent
+		n.LastError = ""
+		n.SentAt = &at
+		return
+	}
+
+	n.LastError = err.Error()
+	if n.Attempts >= d.policy.MaxAttempts {
+		n.Status = model.StatusFailed
+		metrics.NotificationsAbandoned.WithLabelValues(n.Channel, n.Type).Inc()
+		slog.ErrorContext(ctx, "notification delivery abandoned", "notification_id", n.ID, "channel", n.Channel, "attempts", n.Attempts, "error", err)
+		return
+	}
+
+	n.Status = model.StatusPending
+	n.NextAttemptAt = at.Add(d.policy.Delay(n.Attempts))
+	slog.WarnContext(ctx, "notification delivery failed, will retry", "notification_id", n.ID, "channel", n.Channel, "attempts", n.Attempts, "retry_at", n.NextAttemptAt, "error", err)
+}
+
+// redact replaces secretURL in msg, as written in text and in HTML, with
+// model.RedactedURL.
+func redact(msg notifier.Message, secretURL string) notifier.Message {
+	if secretURL == "" {
+		return msg
+	}
+	r := strings.NewReplacer(secretURL, model.RedactedURL, html.EscapeString(secretURL), model.RedactedURL)
+	msg.Subject, msg.Body, msg.HTML = r.Replace(msg.Subject), r.Replace(msg.Body), r.Replace(msg.HTML)
+	return msg
+}
+
+// unredact puts secretURL back into a message made by redact.
+func unredact(msg notifier.Message, secretURL string) notifier.Message {
+	if secretURL == "" {
+		return msg
+	}
+	msg.Subject = strings.ReplaceAll(msg.Subject, model.RedactedURL, secretURL)
+	msg.Body = strings.ReplaceAll(msg.Body, model.RedactedURL, secretURL)
+	msg.HTML = strings.ReplaceAll(msg.HTML, model.RedactedURL, html.EscapeString(secretURL))
+	return msg
+}
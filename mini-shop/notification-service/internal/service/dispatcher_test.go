@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/notifier"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute}
+
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		if got := policy.Delay(i + 1); got != w {
+			t.Errorf("Delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestDispatcherRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	ch := &flakyNotifier{MemoryNotifier: notifier.NewMemoryNotifier(), failures: 2}
+	s := newTestService(t, ch)
+
+	msg := notifier.Message{Type: "payment.completed", To: "ann@example.com", Subject: "Paid"}
+	if _, err := s.dispatcher.Enqueue(ctx, "evt-1", 7, msg, "", model.Preferences{}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	start := time.Now()
+	if err := s.dispatcher.flush(ctx, start); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	n := userNotifications(t, s, 7)[0]
+	if n.Status != model.StatusPending || n.Attempts != 1 || n.LastError == "" {
+		t.Fatalf("after the first failure: %+v, want pending with 1 attempt and the error", n)
+	}
+	if want := start.Add(time.Minute); !n.NextAttemptAt.Equal(want.Truncate(time.Microsecond)) {
+		t.Errorf("next attempt at %v, want %v", n.NextAttemptAt, want)
+	}
+
+	// До назначенного времени повтор не выполняется
+	if err := s.dispatcher.flush(ctx, start.Add(30*time.Second)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if ch.calls != 1 {
+		t.Fatalf("%d delivery attempts before the retry was due, want 1", ch.calls)
+	}
+
+	second := start.Add(time.Minute)
+	if err := s.dispatcher.flush(ctx, second); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	n = userNotifications(t, s, 7)[0]
+	if want := second.Add(2 * time.Minute); n.Attempts != 2 || !n.NextAttemptAt.Equal(want.Truncate(time.Microsecond)) {
+		t.Errorf("after the second failure: %+v, want 2 attempts and a retry at %v", n, want)
+	}
+
+	if err := s.dispatcher.flush(ctx, second.Add(2*time.Minute)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	n = userNotifications(t, s, 7)[0]
+	if n.Status != model.StatusSent || n.Attempts != 3 || n.SentAt == nil || n.LastError != "" {
+		t.Errorf("after the third attempt: %+v, want sent", n)
+	}
+	if len(ch.Messages()) != 1 {
+		t.Errorf("%d messages delivered, want 1", len(ch.Messages()))
+	}
+}
+
+func TestDispatcherGivesUpAndResendRetries(t *testing.T) {
+	ctx := context.Background()
+	ch := &flakyNotifier{MemoryNotifier: notifier.NewMemoryNotifier(), failures: testRetryPolicy.MaxAttempts}
+	s := newTestService(t, ch)
+
+	msg := notifier.Message{Type: "payment.failed", To: "ann@example.com", Subject: "Failed"}
+	if _, err := s.dispatcher.Enqueue(ctx, "evt-1", 7, msg, "", model.Preferences{}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	at := time.Now()
+	for range testRetryPolicy.MaxAttempts {
+		if err := s.dispatcher.flush(ctx, at); err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		at = at.Add(testRetryPolicy.MaxDelay)
+	}
+	n := userNotifications(t, s, 7)[0]
+	if n.Status != model.StatusFailed || n.Attempts != testRetryPolicy.MaxAttempts {
+		t.Fatalf("after every attempt failed: %+v, want failed", n)
+	}
+
+	if _, err := s.Resend(ctx, 8, n.ID); !errors.Is(err, model.ErrNotificationNotFound) {
+		t.Errorf("Resend by another user: error = %v, want %v", err, model.ErrNotificationNotFound)
+	}
+
+	resent, err := s.Resend(ctx, 7, n.ID)
+	if err != nil {
+		t.Fatalf("Resend: %v", err)
+	}
+	if resent.Status != model.StatusPending || resent.Attempts != 0 {
+		t.Errorf("resent = %+v, want pending with a fresh retry budget", resent)
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	n = userNotifications(t, s, 7)[0]
+	if n.Status != model.StatusSent || len(ch.Messages()) != 1 {
+		t.Errorf("after resend: %+v and %d messages, want it sent once", n, len(ch.Messages()))
+	}
+
+	if _, err := s.Resend(ctx, 7, n.ID); !errors.Is(err, model.ErrNotificationNotFailed) {
+		t.Errorf("Resend of a sent notification: error = %v, want %v", err, model.ErrNotificationNotFailed)
+	}
+}
+
+// hookNotifier calls onNotify before recording each message.
+type hookNotifier struct {
+	*notifier.MemoryNotifier
+	onNotify func(ctx context.Context)
+}
+
+func (n *hookNotifier) Notify(ctx context.Context, msg notifier.Message) error {
+	if n.onNotify != nil {
+		n.onNotify(ctx)
+	}
+	return n.MemoryNotifier.Notify(ctx, msg)
+}
+
+func TestDispatcherSendsOutsideTransaction(t *testing.T) {
+	ctx := context.Background()
+	ch := &hookNotifier{MemoryNotifier: notifier.NewMemoryNotifier()}
+	s := newTestService(t, ch)
+
+	msg := notifier.Message{Type: "payment.completed", To: "ann@example.com", Subject: "Paid"}
+	if _, err := s.dispatcher.Enqueue(ctx, "evt-1", 7, msg, "", model.Preferences{}); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	var sending model.Notification
+	ch.onNotify = func(ctx context.Context) {
+		ch.onNotify = nil
+
+		// Пока идёт отправка, новые события записываются без ожидания
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.dispatcher.Enqueue(ctx, "evt-2", 7, msg, "", model.Preferences{})
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Enqueue during a send: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Enqueue blocked while a notification was being sent")
+		}
+		sending = userNotifications(t, s, 7)[0]
+	}
+
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if sending.Status != model.StatusSending {
+		t.Errorf("status during the send = %s, want %s", sending.Status, model.StatusSending)
+	}
+
+	got := userNotifications(t, s, 7)
+	if len(got) != 2 || got[0].Status != model.StatusSent || got[1].Status != model.StatusPending {
+		t.Fatalf("after the flush: %+v, want the first sent and the second pending", got)
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(ch.Messages()) != 2 {
+		t.Errorf("%d messages delivered, want 2", len(ch.Messages()))
+	}
+}
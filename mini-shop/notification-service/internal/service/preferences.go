@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/Viltsev/notification-service/internal/auth"
+	"github.com/Viltsev/notification-service/internal/model"
+)
+
+// UnsubscribeLinks builds the signed one-click links put into notifications.
+type UnsubscribeLinks struct {
+	secret  []byte
+	baseURL string
+}
+
+// NewUnsubscribeLinks signs links with secret. baseURL is where the service is
+// reachable from the recipient's mail client, e.g. "https://shop.example.com".
+func NewUnsubscribeLinks(secret, baseURL string) *UnsubscribeLinks {
+	return &UnsubscribeLinks{secret: []byte(secret), baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// URL opts userID out of category when opened.
+func (l *UnsubscribeLinks) URL(userID int, category string) string {
+	token := auth.UnsubscribeToken(l.secret, userID, category)
+	return l.baseURL + "/api/v1/unsubscribe?token=" + url.QueryEscape(token)
+}
+
+// Parse returns the user and category of a link token.
+func (l *UnsubscribeLinks) Parse(token string) (int, string, error) {
+	return auth.ParseUnsubscribeToken(l.secret, token)
+}
+
+// preferences returns the stored preferences of userID, or the defaults that
+// allow everything.
+func preferences(ctx context.Context, store model.NotificationStore, userID int) (model.Preferences, error) {
+	prefs, err := store.GetPreferences(ctx, userID)
+	if err != nil {
+		return model.Preferences{}, err
+	}
+	if prefs == nil {
+		return model.Preferences{UserID: userID, Channels: map[string]map[string]bool{}}, nil
+	}
+	return *prefs, nil
+}
+
+func (s *NotificationService) GetPreferences(ctx context.Context, userID int) (*model.Preferences, error) {
+	prefs, err := preferences(ctx, s.store, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &prefs, nil
+}
+
+// UpdatePreferences replaces the preferences of userID.
+func (s *NotificationService) UpdatePreferences(ctx context.Context, userID int, prefs model.Preferences) (*model.Preferences, error) {
+	var fields []model.FieldError
+	if prefs.Locale != "" && !slices.Contains(s.templates.Locales(), prefs.Locale) {
+		fields = append(fields, model.FieldError{Field: "locale", Message: "no templates for this locale"})
+	}
+	for category := range prefs.Channels {
+		if !slices.Contains(model.Categories, category) {
+			fields = append(fields, model.FieldError{Field: "channels." + category, Message: "unknown category"})
+		}
+	}
+	if prefs.QuietHours != nil {
+		fields = append(fields, prefs.QuietHours.Validate()...)
+	}
+	if len(fields) > 0 {
+		return nil, model.ValidationFailed(fields)
+	}
+
+	prefs.UserID = userID
+	if prefs.Channels == nil {
+		prefs.Channels = map[string]map[string]bool{}
+	}
+	saved, err := s.store.SavePreferences(ctx, prefs)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "notification preferences updated", "user_id", userID)
+	return saved, nil
+}
+
+// Unsubscribe opts the user named by an unsubscribe link token out of its
+// category on every channel. It returns the category.
+func (s *NotificationService) Unsubscribe(ctx context.Context, token string) (string, error) {
+	userID, category, err := s.links.Parse(token)
+	if err != nil {
+		slog.WarnContext(ctx, "rejected unsubscribe link", "error", err)
+		return "", model.ErrInvalidUnsubscribe
+	}
+	if !slices.Contains(model.Categories, category) {
+		return "", model.ErrInvalidUnsubscribe
+	}
+
+	err = s.store.InTx(ctx, func(tx model.NotificationStore) error {
+		prefs, err := preferences(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		prefs.OptOut(category)
+		_, err = tx.SavePreferences(ctx, prefs)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to unsubscribe: %w", err)
+	}
+
+	slog.InfoContext(ctx, "user unsubscribed", "user_id", userID, "category", category)
+	return category, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/Viltsev/notification-service/internal/templates"
+)
+
+// Event is the envelope shared by the events of order-, payment- and
+// user-service. Each event type fills only the fields it needs.
+type Event struct {
+	// EventID is unique per event and is used to drop redeliveries.
+	EventID string `json:"eventID"`
+	Type    string `json:"type"`
+	UserID  int    `json:"userID"`
+	Email   string `json:"email"`
+	// Locale is the recipient's preferred language; the default locale is
+	// used when it is empty or has no templates.
+	Locale string `json:"locale"`
+
+	OrderID  *int     `json:"orderID"`
+	Amount   *float64 `json:"amount"`
+	Currency string   `json:"currency"`
+	// Reason is set on PaymentFailed, e.g. "insufficient_funds".
+	Reason string `json:"reason"`
+
+	FirstName string   `json:"firstName"`
+	Balance   *float64 `json:"balance"`
+	Threshold *float64 `json:"threshold"`
+
+	// VerificationURL and ResetURL are the single-use links of the user
+	// account events.
+	VerificationURL string `json:"verificationURL"`
+	ResetURL        string `json:"resetURL"`
+}
+
+// EventHandler extracts the template data from an event and rejects events
+// missing a field their template needs.
+type EventHandler func(event Event) (templates.Data, error)
+
+type eventRoute struct {
+	notificationType string
+	handle           EventHandler
+}
+
+// Registry maps event types to the notification they produce.
+type Registry struct {
+	routes map[string]eventRoute
+}
+
+func NewRegistry() *Registry {
+	return &Registry{routes: map[string]eventRoute{}}
+}
+
+// Register makes events of eventType send a notificationType notification
+// rendered from the data returned by handle.
+func (r *Registry) Register(eventType, notificationType string, handle EventHandler) {
+	r.routes[eventType] = eventRoute{notificationType: notificationType, handle: handle}
+}
+
+func (r *Registry) lookup(eventType string) (eventRoute, bool) {
+	route, ok := r.routes[eventType]
+	return route, ok
+}
+
+// DefaultRegistry knows every event MiniShop services publish that the user
+// should hear about.
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	r.Register("OrderCreated", "order.created", orderData)
+	r.Register("OrderCancelled", "order.cancelled", orderData)
+	r.Register("OrderShipped", "order.shipped", orderData)
+	r.Register("OrderDelivered", "order.delivered", orderData)
+	r.Register("PaymentCompleted", "payment.completed", orderData)
+	r.Register("PaymentFailed", "payment.failed", orderData)
+	r.Register("PaymentRefunded", "payment.refunded", orderData)
+	r.Register("UserRegistered", "user.registered", userData)
+	r.Register("VerificationRequested", "user.verify_email", verificationData)
+	r.Register("PasswordResetRequested", "user.password_reset", resetData)
+	r.Register("BalanceLow", "user.balance_low", balanceData)
+	return r
+}
+
+func orderData(event Event) (templates.Data, error) {
+	if event.OrderID == nil {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'orderID' in event")
+	}
+	if event.Amount == nil {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'amount' in event")
+	}
+
+	data := recipientData(event)
+	data.OrderID = *event.OrderID
+	data.Amount = *event.Amount
+	data.Reason = event.Reason
+	return data, nil
+}
+
+func balanceData(event Event) (templates.Data, error) {
+	if event.Balance == nil {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'balance' in event")
+	}
+	if event.Threshold == nil {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'threshold' in event")
+	}
+
+	data := recipientData(event)
+	data.Balance = *event.Balance
+	data.Threshold = *event.Threshold
+	return data, nil
+}
+
+func userData(event Event) (templates.Data, error) {
+	data := recipientData(event)
+	data.ActionURL = event.VerificationURL
+	return data, nil
+}
+
+func verificationData(event Event) (templates.Data, error) {
+	if event.VerificationURL == "" {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'verificationURL' in event")
+	}
+	return userData(event)
+}
+
+func resetData(event Event) (templates.Data, error) {
+	if event.ResetURL == "" {
+		return templates.Data{}, fmt.Errorf("missing or invalid 'resetURL' in event")
+	}
+
+	data := recipientData(event)
+	data.ActionURL = event.ResetURL
+	return data, nil
+}
+
+// recipientData holds the fields every template may greet the user with.
+func recipientData(event Event) templates.Data {
+	return templates.Data{
+		Currency:  event.Currency,
+		FirstName: event.FirstName,
+		Email:     event.Email,
+	}
+}
@@ -1,108 +1,209 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"net/smtp"
+	"log/slog"
+	"time"
 
 	"github.com/Viltsev/notification-service/internal/messaging"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/notifier"
+	"github.com/Viltsev/notification-service/internal/templates"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/pagination"
 )
 
 type NotificationService struct {
-	rabbitMQ *messaging.RabbitMQ
-	smtpHost string
-	smtpPort string
-	auth     smtp.Auth
-	from     string
+	publisher  messaging.Publisher
+	store      model.NotificationStore
+	dispatcher *Dispatcher
+	templates  *templates.Renderer
+	events     *Registry
+	links      *UnsubscribeLinks
 }
 
-func NewNotificationService(rabbitMQ *messaging.RabbitMQ) *NotificationService {
-	smtpHost := "smtp.mail.ru"
-	smtpPort := "587"
-	from := "pm_csit2025@mail.ru"
-	auth := smtp.PlainAuth("", "pm_csit2025@mail.ru", "T7bHKeHHP8Afg2cCQwXS", smtpHost)
-
+func NewNotificationService(publisher messaging.Publisher, store model.NotificationStore, dispatcher *Dispatcher, renderer *templates.Renderer, events *Registry, links *UnsubscribeLinks) *NotificationService {
 	return &NotificationService{
-		rabbitMQ: rabbitMQ,
-		smtpHost: smtpHost,
-		smtpPort: smtpPort,
-		auth:     auth,
-		from:     from,
+		publisher:  publisher,
+		store:      store,
+		dispatcher: dispatcher,
+		templates:  renderer,
+		events:     events,
+		links:      links,
 	}
 }
 
-func (s *NotificationService) SendEmail(to, subject, body string) error {
-	msg := "From: " + s.from + "\r\n" +
-		"To: " + to + "\r\n" +
-		"Subject: " + subject + "\r\n" +
-		"MIME-Version: 1.0\r\n" +
-		"Content-Type: text/plain; charset=\"UTF-8\"\r\n\r\n" +
-		body
+// HandleEvent renders the notification registered for the event type and
+// enqueues it for delivery according to the recipient's preferences. Events
+// nobody is notified about are skipped, and UserErased erases what is kept
+// about the user.
+func (s *NotificationService) HandleEvent(ctx context.Context, body []byte) error {
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal event: %w", err)
+	}
 
-	addr := fmt.Sprintf("%s:%s", s.smtpHost, s.smtpPort)
-	log.Println("About to call smtp.SendMail")
-	err := smtp.SendMail(addr, s.auth, s.from, []string{to}, []byte(msg))
-	log.Printf("smtp.SendMail returned: %v", err)
+	if event.Type == "" {
+		return fmt.Errorf("missing or invalid 'type' in event")
+	}
+	if event.Type == "UserErased" {
+		return s.eraseUser(ctx, event.UserID)
+	}
+	route, ok := s.events.lookup(event.Type)
+	if !ok {
+		// Подписка по шаблону приносит и события, о которых не уведомляем
+		slog.DebugContext(ctx, "no notification for event type", "event_type", event.Type)
+		return nil
+	}
+	if event.Email == "" {
+		return fmt.Errorf("missing or invalid 'email' in event")
+	}
 
+	data, err := route.handle(event)
 	if err != nil {
-		log.Printf("Failed to send email to %s: %v", to, err)
 		return err
 	}
-	log.Printf("Email sent to %s", to)
+
+	prefs, err := preferences(ctx, s.store, event.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to load notification preferences: %w", err)
+	}
+	if len(s.dispatcher.Channels(route.notificationType, prefs)) == 0 {
+		slog.InfoContext(ctx, "recipient opted out of notification", "event_type", event.Type, "user_id", event.UserID)
+		return nil
+	}
+	locale := event.Locale
+	if prefs.Locale != "" {
+		locale = prefs.Locale
+	}
+	if category := model.CategoryOf(route.notificationType); category != "" {
+		data.UnsubscribeURL = s.links.URL(event.UserID, category)
+	} else {
+		// Письма вне категорий пользователь ждёт прямо сейчас
+		prefs.QuietHours = nil
+	}
+
+	content, err := s.templates.Render(route.notificationType, locale, data)
+	if err != nil {
+		return fmt.Errorf("failed to render %s notification: %w", route.notificationType, err)
+	}
+
+	msg := notifier.Message{
+		Type:        route.notificationType,
+		To:          event.Email,
+		Subject:     content.Subject,
+		Body:        content.Text,
+		HTML:        content.HTML,
+		Unsubscribe: data.UnsubscribeURL,
+	}
+
+	eventID := event.EventID
+	switch {
+	case eventID != "":
+	case event.OrderID != nil:
+		// У заказа каждое событие случается один раз, так что тип и заказ
+		// однозначно задают событие от отправителей без eventID
+		eventID = fmt.Sprintf("%s:%d", event.Type, *event.OrderID)
+	default:
+		// Без идентификатора дубликаты не отбросить, но уведомление не теряем
+		eventID = logger.NewID()
+	}
+
+	// Ссылка с одноразовым токеном не хранится в тексте уведомления
+	created, err := s.dispatcher.Enqueue(ctx, eventID, event.UserID, msg, data.ActionURL, prefs)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue notification: %w", err)
+	}
+	if created == 0 {
+		slog.InfoContext(ctx, "duplicate event ignored", "event_id", eventID, "event_type", event.Type, "user_id", event.UserID)
+		return nil
+	}
+
+	slog.InfoContext(ctx, "notification enqueued", "event_id", eventID, "event_type", event.Type, "user_id", event.UserID, "channels", created)
 	return nil
 }
 
-func (s *NotificationService) HandlePaymentEvent(body []byte) {
-	var event map[string]interface{}
-	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Failed to unmarshal payment event: %v", err)
-		return
+func (s *NotificationService) ListNotificationsByUser(ctx context.Context, userID int, filter model.NotificationFilter, params pagination.Params) (pagination.Page[model.Notification], error) {
+	notifications, err := s.store.ListNotificationsByUser(ctx, userID, filter, params)
+	if err != nil {
+		return pagination.Page[model.Notification]{}, err
 	}
+	return pagination.NewPage(notifications, params, model.NotificationCursor), nil
+}
 
-	eventType, ok := event["type"].(string)
-	if !ok {
-		log.Println("Missing or invalid 'type' in event")
-		return
+// ExportData returns every notification and the preferences of userID for the
+// data export of user-service. Preferences are nil when never saved.
+func (s *NotificationService) ExportData(ctx context.Context, userID int) (map[string]any, error) {
+	params := pagination.Params{Limit: pagination.MaxLimit, Sort: "createdAt"}
+	notifications := []model.Notification{}
+	for {
+		page, err := s.store.ListNotificationsByUser(ctx, userID, model.NotificationFilter{}, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) <= params.Limit {
+			notifications = append(notifications, page...)
+			break
+		}
+		notifications = append(notifications, page[:params.Limit]...)
+		cursor := model.NotificationCursor(page[params.Limit-1], params.Sort)
+		params.Cursor = &cursor
 	}
 
-	orderIDFloat, ok := event["orderID"].(float64)
-	if !ok {
-		log.Println("Missing or invalid 'orderID' in event")
-		return
+	prefs, err := s.store.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
 	}
-	orderID := int(orderIDFloat)
+	return map[string]any{"notifications": notifications, "preferences": prefs}, nil
+}
 
-	amountFloat, ok := event["amount"].(float64)
-	if !ok {
-		log.Println("Missing or invalid 'amount' in event")
-		return
+// eraseUser keeps the delivery history of an erased account without its
+// addresses and contents. Nothing more is sent to it.
+func (s *NotificationService) eraseUser(ctx context.Context, userID int) error {
+	if userID <= 0 {
+		return fmt.Errorf("missing or invalid 'userID' in event")
 	}
-	amount := amountFloat
-
-	email, ok := event["email"].(string)
-	if !ok {
-		log.Println("Missing or invalid 'email' in event")
-		return
-	}
-
-	var subject, bodyMessage string
-	switch eventType {
-	case "PaymentCompleted":
-		subject = fmt.Sprintf("Оплата заказа %d успешна", orderID)
-		bodyMessage = fmt.Sprintf("Заказ %d успешно оплачен! C Вашего счета списано %.2f рублей", orderID, amount)
-	case "PaymentFailed":
-		subject = fmt.Sprintf("Оплата заказа %d не удалась", orderID)
-		bodyMessage = fmt.Sprintf("Не удалось оплатить заказ %d! Недостаточно средств", orderID)
-	default:
-		log.Printf("Unknown event type: %s", eventType)
-		return
+	if err := s.store.EraseUser(ctx, userID); err != nil {
+		return fmt.Errorf("failed to erase notifications: %w", err)
 	}
 
-	log.Printf("Ready to send message!")
-	if err := s.SendEmail(email, subject, bodyMessage); err != nil {
-		log.Printf("Failed to send email to %s: %v", email, err)
-	} else {
-		log.Printf("Notification sent to %s", email)
+	slog.InfoContext(ctx, "notifications erased", "user_id", userID)
+	return nil
+}
+
+// Resend schedules a failed notification of userID for immediate delivery with
+// a fresh retry budget.
+func (s *NotificationService) Resend(ctx context.Context, userID, id int) (*model.Notification, error) {
+	var resent *model.Notification
+	err := s.store.InTx(ctx, func(tx model.NotificationStore) error {
+		n, err := tx.GetNotificationByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Чужие уведомления не раскрываем, для пользователя их просто нет
+		if n == nil || n.UserID != userID {
+			return model.ErrNotificationNotFound
+		}
+		if n.Status != model.StatusFailed {
+			return model.ErrNotificationNotFailed
+		}
+
+		n.Status = model.StatusPending
+		n.Attempts = 0
+		n.NextAttemptAt = time.Now()
+		if err := tx.UpdateDelivery(ctx, *n); err != nil {
+			return err
+		}
+		resent = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+
+	s.dispatcher.Notify()
+	slog.InfoContext(ctx, "notification resend requested", "notification_id", id)
+	return resent, nil
 }
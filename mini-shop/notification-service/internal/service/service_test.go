@@ -0,0 +1,464 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/notification-service/internal/messaging"
+	"github.com/Viltsev/notification-service/internal/model"
+	"github.com/Viltsev/notification-service/internal/notifier"
+	"github.com/Viltsev/notification-service/internal/repository"
+	"github.com/Viltsev/notification-service/internal/templates"
+	"mini-shop/platform/pagination"
+)
+
+var testRetryPolicy = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}
+
+type testService struct {
+	*NotificationService
+	store      *repository.MemoryStore
+	dispatcher *Dispatcher
+}
+
+// newTestService wires the service to an in-memory store and routes every
+// notification to ch.
+func newTestService(t *testing.T, ch notifier.Notifier) testService {
+	t.Helper()
+
+	renderer, err := templates.New("ru")
+	if err != nil {
+		t.Fatalf("templates.New: %v", err)
+	}
+	router, err := notifier.NewRouter(map[string]notifier.Notifier{"memory": ch}, []string{"memory"}, nil)
+	if err != nil {
+		t.Fatalf("NewRouter: %v", err)
+	}
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	store := repository.NewMemoryStore()
+	dispatcher := NewDispatcher(store, router, testRetryPolicy)
+	return testService{
+		NotificationService: NewNotificationService(broker, store, dispatcher, renderer, DefaultRegistry(), NewUnsubscribeLinks("test-secret", "https://shop.test")),
+		store:               store,
+		dispatcher:          dispatcher,
+	}
+}
+
+// flakyNotifier fails the first failures calls and records the rest.
+type flakyNotifier struct {
+	*notifier.MemoryNotifier
+	failures int
+	calls    int
+}
+
+func (n *flakyNotifier) Notify(ctx context.Context, msg notifier.Message) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("smtp: connection refused")
+	}
+	return n.MemoryNotifier.Notify(ctx, msg)
+}
+
+func userNotifications(t *testing.T, s testService, userID int) []model.Notification {
+	t.Helper()
+
+	page, err := s.ListNotificationsByUser(context.Background(), userID, model.NotificationFilter{}, pagination.Params{Limit: 10, Sort: "createdAt"})
+	if err != nil {
+		t.Fatalf("ListNotificationsByUser: %v", err)
+	}
+	return page.Items
+}
+
+func TestHandleEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		wantType string
+		subject  string
+		text     string
+		// essential notifications carry no unsubscribe link
+		essential bool
+	}{
+		{
+			name:     "completed",
+			event:    `{"type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`,
+			wantType: "payment.completed",
+			subject:  "Оплата заказа 5 успешна",
+			text:     "30.00 ₽",
+		},
+		{
+			name:     "failed",
+			event:    `{"type":"PaymentFailed","orderID":6,"userID":7,"email":"ann@example.com","amount":80,"reason":"insufficient_funds"}`,
+			wantType: "payment.failed",
+			subject:  "Оплата заказа 6 не удалась",
+			text:     "недостаточно средств",
+		},
+		{
+			name:     "recipient locale",
+			event:    `{"type":"PaymentFailed","orderID":6,"userID":7,"email":"ann@example.com","amount":80,"currency":"USD","reason":"user_service_unavailable","locale":"en-GB"}`,
+			wantType: "payment.failed",
+			subject:  "Payment for order 6 failed",
+			text:     "$",
+		},
+		{
+			name:     "order cancelled",
+			event:    `{"eventID":"evt-2","type":"OrderCancelled","orderID":6,"userID":7,"email":"ann@example.com","amount":80}`,
+			wantType: "order.cancelled",
+			subject:  "Заказ 6 отменён",
+			text:     "80.00 ₽",
+		},
+		{
+			name:     "refund",
+			event:    `{"eventID":"evt-3","type":"PaymentRefunded","orderID":6,"userID":7,"email":"ann@example.com","amount":80}`,
+			wantType: "payment.refunded",
+			subject:  "Возврат по заказу 6",
+			text:     "80.00 ₽ возвращено",
+		},
+		{
+			name:      "welcome",
+			event:     `{"eventID":"evt-4","type":"UserRegistered","userID":7,"email":"ann@example.com","firstName":"Анна","verificationURL":"https://shop.test/api/v1/email/verify?token=abc"}`,
+			wantType:  "user.registered",
+			subject:   "Добро пожаловать",
+			text:      "Подтвердите адрес, чтобы оформлять заказы: https://shop.test/api/v1/email/verify?token=abc",
+			essential: true,
+		},
+		{
+			name:      "verification",
+			event:     `{"eventID":"evt-6","type":"VerificationRequested","userID":7,"email":"ann@example.com","verificationURL":"https://shop.test/api/v1/email/verify?token=abc"}`,
+			wantType:  "user.verify_email",
+			subject:   "Подтвердите адрес почты",
+			text:      "https://shop.test/api/v1/email/verify?token=abc",
+			essential: true,
+		},
+		{
+			name:      "password reset",
+			event:     `{"eventID":"evt-7","type":"PasswordResetRequested","userID":7,"email":"ann@example.com","resetURL":"https://shop.test/reset-password?token=abc"}`,
+			wantType:  "user.password_reset",
+			subject:   "Сброс пароля",
+			text:      "https://shop.test/reset-password?token=abc",
+			essential: true,
+		},
+		{
+			name:     "low balance",
+			event:    `{"eventID":"evt-5","type":"BalanceLow","userID":7,"email":"ann@example.com","balance":20,"threshold":100}`,
+			wantType: "user.balance_low",
+			subject:  "На балансе осталось 20.00 ₽",
+			text:     "меньше 100.00 ₽",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := notifier.NewMemoryNotifier()
+			s := newTestService(t, sink)
+
+			if err := s.HandleEvent(context.Background(), []byte(tt.event)); err != nil {
+				t.Fatalf("HandleEvent: %v", err)
+			}
+			if err := s.dispatcher.flush(context.Background(), time.Now()); err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+
+			got := sink.Messages()
+			if len(got) != 1 {
+				t.Fatalf("got %d messages, want 1", len(got))
+			}
+			if got[0].Type != tt.wantType || got[0].To != "ann@example.com" || !strings.Contains(got[0].Subject, tt.subject) {
+				t.Errorf("message = %+v, want %s to ann@example.com about %q", got[0], tt.wantType, tt.subject)
+			}
+			if !strings.Contains(got[0].Body, tt.text) {
+				t.Errorf("body %q does not contain %q", got[0].Body, tt.text)
+			}
+			if !strings.Contains(got[0].HTML, "<html") {
+				t.Errorf("message has no HTML alternative: %q", got[0].HTML)
+			}
+			// Одноразовая ссылка уходит в письме, но не остаётся в журнале
+			if strings.Contains(tt.text, "token=abc") && !strings.Contains(got[0].HTML, `href="https://shop.test/`) {
+				t.Errorf("HTML %q does not link to the shop", got[0].HTML)
+			}
+			stored := userNotifications(t, s, 7)[0]
+			if strings.Contains(stored.Subject+stored.Body+stored.HTML, "token=abc") {
+				t.Errorf("stored notification keeps the single-use link: %q", stored.Body)
+			}
+			if tt.essential {
+				if got[0].Unsubscribe != "" {
+					t.Errorf("message unsubscribe link = %q, want none", got[0].Unsubscribe)
+				}
+			} else if !strings.HasPrefix(got[0].Unsubscribe, "https://shop.test/api/v1/unsubscribe?token=") || !strings.Contains(got[0].Body, got[0].Unsubscribe) {
+				t.Errorf("message unsubscribe link = %q, want it in the header and the body", got[0].Unsubscribe)
+			}
+		})
+	}
+}
+
+func TestHandleEventSkipsUnknownType(t *testing.T) {
+	s := newTestService(t, notifier.NewMemoryNotifier())
+
+	err := s.HandleEvent(context.Background(), []byte(`{"type":"UserUpdated","userID":7,"email":"ann@example.com"}`))
+	if err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if n := len(userNotifications(t, s, 7)); n != 0 {
+		t.Errorf("%d notifications recorded, want none", n)
+	}
+}
+
+func TestHandleEventRejectsIncompleteEvent(t *testing.T) {
+	s := newTestService(t, notifier.NewMemoryNotifier())
+
+	events := []string{
+		`{"type":"OrderShipped","userID":7,"email":"ann@example.com","amount":30}`,
+		`{"type":"BalanceLow","userID":7,"email":"ann@example.com","balance":20}`,
+		`{"type":"PaymentCompleted","orderID":5,"userID":7,"amount":30}`,
+		`{"type":"PasswordResetRequested","userID":7,"email":"ann@example.com"}`,
+	}
+	for _, event := range events {
+		if err := s.HandleEvent(context.Background(), []byte(event)); err == nil {
+			t.Errorf("HandleEvent(%s) succeeded, want an error", event)
+		}
+	}
+	if n := len(userNotifications(t, s, 7)); n != 0 {
+		t.Errorf("%d notifications recorded, want none", n)
+	}
+}
+
+func TestHandleEventDropsDuplicates(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	events := []string{
+		`{"eventID":"evt-1","type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`,
+		`{"eventID":"evt-1","type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`,
+		// Без eventID событие опознаётся по типу и заказу
+		`{"type":"PaymentFailed","orderID":6,"userID":7,"email":"ann@example.com","amount":80}`,
+		`{"type":"PaymentFailed","orderID":6,"userID":7,"email":"ann@example.com","amount":80}`,
+	}
+	for _, event := range events {
+		if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if n := len(sink.Messages()); n != 2 {
+		t.Errorf("%d messages sent, want 2", n)
+	}
+	if n := len(userNotifications(t, s, 7)); n != 2 {
+		t.Errorf("%d notifications recorded, want 2", n)
+	}
+}
+
+func TestHandleEventFollowsPreferences(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	prefs := model.Preferences{Locale: "en"}
+	prefs.SetChannel(model.CategoryOrders, "memory", false)
+	if _, err := s.UpdatePreferences(ctx, 7, prefs); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+
+	events := []string{
+		`{"eventID":"evt-1","type":"OrderCancelled","orderID":6,"userID":7,"email":"ann@example.com","amount":80}`,
+		`{"eventID":"evt-2","type":"PaymentCompleted","orderID":6,"userID":7,"email":"ann@example.com","amount":80,"locale":"ru"}`,
+	}
+	for _, event := range events {
+		if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	got := sink.Messages()
+	if len(got) != 1 || got[0].Type != "payment.completed" {
+		t.Fatalf("messages = %+v, want only payment.completed", got)
+	}
+	if got[0].Subject != "Payment for order 6 succeeded" {
+		t.Errorf("subject = %q, want the preferred English one", got[0].Subject)
+	}
+}
+
+func TestHandleEventHoldsBackDuringQuietHours(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	now := time.Now().UTC()
+	quiet := &model.QuietHours{Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04"), TimeZone: "UTC"}
+	if _, err := s.UpdatePreferences(ctx, 7, model.Preferences{QuietHours: quiet}); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+
+	event := `{"eventID":"evt-1","type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`
+	if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if err := s.dispatcher.flush(ctx, now); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if n := len(sink.Messages()); n != 0 {
+		t.Fatalf("%d messages sent during quiet hours, want none", n)
+	}
+
+	if err := s.dispatcher.flush(ctx, now.Add(time.Hour)); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if n := len(sink.Messages()); n != 1 {
+		t.Errorf("%d messages sent after quiet hours, want 1", n)
+	}
+}
+
+func TestHandleEventSendsEssentialNotificationsRegardlessOfPreferences(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	now := time.Now().UTC()
+	prefs := model.Preferences{
+		QuietHours: &model.QuietHours{Start: now.Add(-time.Hour).Format("15:04"), End: now.Add(time.Hour).Format("15:04"), TimeZone: "UTC"},
+	}
+	prefs.OptOut(model.CategoryAccount)
+	if _, err := s.UpdatePreferences(ctx, 7, prefs); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+
+	events := []string{
+		`{"eventID":"evt-1","type":"PasswordResetRequested","userID":7,"email":"ann@example.com","resetURL":"https://shop.test/reset-password?token=abc"}`,
+		`{"eventID":"evt-2","type":"BalanceLow","userID":7,"email":"ann@example.com","balance":20,"threshold":100}`,
+	}
+	for _, event := range events {
+		if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	got := sink.Messages()
+	if len(got) != 1 || got[0].Type != "user.password_reset" {
+		t.Errorf("messages = %+v, want only the password reset right away", got)
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	event := `{"eventID":"evt-1","type":"OrderShipped","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`
+	if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	link, err := url.Parse(sink.Messages()[0].Unsubscribe)
+	if err != nil {
+		t.Fatalf("parse unsubscribe link: %v", err)
+	}
+	token := link.Query().Get("token")
+
+	if _, err := s.Unsubscribe(ctx, token+"x"); !errors.Is(err, model.ErrInvalidUnsubscribe) {
+		t.Errorf("Unsubscribe with a tampered token = %v, want ErrInvalidUnsubscribe", err)
+	}
+	category, err := s.Unsubscribe(ctx, token)
+	if err != nil || category != model.CategoryOrders {
+		t.Fatalf("Unsubscribe = %q, %v; want orders", category, err)
+	}
+
+	events := []string{
+		`{"eventID":"evt-2","type":"OrderDelivered","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`,
+		`{"eventID":"evt-3","type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`,
+	}
+	for _, event := range events {
+		if err := s.HandleEvent(ctx, []byte(event)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	got := sink.Messages()
+	if len(got) != 2 || got[1].Type != "payment.completed" {
+		t.Errorf("messages = %+v, want no more order notifications", got)
+	}
+}
+
+func TestUpdatePreferencesValidates(t *testing.T) {
+	s := newTestService(t, notifier.NewMemoryNotifier())
+
+	prefs := model.Preferences{
+		Locale:     "fr",
+		QuietHours: &model.QuietHours{Start: "25:00", End: "08:00", TimeZone: "Mars/Olympus"},
+	}
+	prefs.SetChannel("marketing", "memory", false)
+
+	_, err := s.UpdatePreferences(context.Background(), 7, prefs)
+	var domainErr *model.Error
+	if !errors.As(err, &domainErr) || domainErr.Code != "validation_failed" {
+		t.Fatalf("UpdatePreferences = %v, want validation_failed", err)
+	}
+	var fields []string
+	for _, f := range domainErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	want := []string{"locale", "channels.marketing", "quietHours.start", "quietHours.timeZone"}
+	if !slices.Equal(fields, want) {
+		t.Errorf("fields = %v, want %v", fields, want)
+	}
+}
+
+func TestHandleEventErasesUser(t *testing.T) {
+	ctx := context.Background()
+	sink := notifier.NewMemoryNotifier()
+	s := newTestService(t, sink)
+
+	if _, err := s.UpdatePreferences(ctx, 7, model.Preferences{Locale: "en"}); err != nil {
+		t.Fatalf("UpdatePreferences: %v", err)
+	}
+	err := s.HandleEvent(ctx, []byte(`{"eventID":"evt-1","type":"PaymentCompleted","orderID":5,"userID":7,"email":"ann@example.com","amount":30}`))
+	if err != nil {
+		t.Fatalf("HandleEvent: %v", err)
+	}
+
+	data, err := s.ExportData(ctx, 7)
+	if err != nil {
+		t.Fatalf("ExportData: %v", err)
+	}
+	if n := data["notifications"].([]model.Notification); len(n) != 1 || n[0].Recipient != "ann@example.com" {
+		t.Errorf("exported notifications = %+v, want the payment notification", n)
+	}
+	if p := data["preferences"].(*model.Preferences); p == nil || p.Locale != "en" {
+		t.Errorf("exported preferences = %+v, want the saved ones", p)
+	}
+
+	if err := s.HandleEvent(ctx, []byte(`{"type":"UserErased","userID":7}`)); err != nil {
+		t.Fatalf("HandleEvent(UserErased): %v", err)
+	}
+	if err := s.dispatcher.flush(ctx, time.Now()); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if n := len(sink.Messages()); n != 0 {
+		t.Errorf("%d messages sent to an erased user, want none", n)
+	}
+	got := userNotifications(t, s, 7)
+	if len(got) != 1 || got[0].Recipient != "" || got[0].Status != model.StatusFailed {
+		t.Errorf("notifications after erasing = %+v, want one failed without a recipient", got)
+	}
+	if prefs, _ := s.store.GetPreferences(ctx, 7); prefs != nil {
+		t.Errorf("preferences after erasing = %+v, want none", prefs)
+	}
+}
@@ -0,0 +1,202 @@
+// Package templates renders notification content from template files embedded
+// in the binary. Every locale has a directory with, per notification type,
+// <type>.txt defining "subject" and the plain-text "body", and <type>.html
+// defining the HTML "body". The *.tmpl partials are shared by every type:
+// reasons.tmpl explains payment failure codes and footer.tmpl adds the
+// unsubscribe link.
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed */*.txt */*.html */*.tmpl
+var files embed.FS
+
+// DefaultCurrency is assumed when an event does not name its currency.
+const DefaultCurrency = "RUB"
+
+var currencySymbols = map[string]string{
+	"RUB": "₽",
+	"USD": "$",
+	"EUR": "€",
+}
+
+var funcs = map[string]any{
+	"money": money,
+}
+
+// Data is what the templates can refer to.
+type Data struct {
+	OrderID  int
+	Amount   float64
+	Currency string
+	// Reason is the machine-readable failure code sent by payment-service.
+	Reason string
+
+	FirstName string
+	Email     string
+	// Balance and Threshold describe a user.balance_low notification.
+	Balance   float64
+	Threshold float64
+
+	// ActionURL is the link the notification asks the user to follow, such as
+	// email verification or password reset.
+	ActionURL string
+
+	// UnsubscribeURL opts the recipient out of this kind of notification; the
+	// footer is left out when it is empty.
+	UnsubscribeURL string
+}
+
+// Content is a rendered notification.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// Renderer picks the templates for a notification type and locale.
+type Renderer struct {
+	sets          map[string]templateSet
+	defaultLocale string
+}
+
+// New parses every embedded template. Locales without a template for some
+// type fall back to defaultLocale, which therefore must exist.
+func New(defaultLocale string) (*Renderer, error) {
+	r := &Renderer{sets: map[string]templateSet{}, defaultLocale: defaultLocale}
+
+	locales, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+	for _, locale := range locales {
+		textFiles, err := fs.Glob(files, path.Join(locale.Name(), "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+
+		partials := path.Join(locale.Name(), "*.tmpl")
+		for _, textFile := range textFiles {
+			notificationType := strings.TrimSuffix(path.Base(textFile), ".txt")
+
+			text, err := texttemplate.New(notificationType).Funcs(funcs).ParseFS(files, partials, textFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", textFile, err)
+			}
+			htmlFile := strings.TrimSuffix(textFile, ".txt") + ".html"
+			html, err := htmltemplate.New(notificationType).Funcs(funcs).ParseFS(files, partials, htmlFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse %s: %w", htmlFile, err)
+			}
+
+			r.sets[key(locale.Name(), notificationType)] = templateSet{text: text, html: html}
+		}
+	}
+
+	if !r.hasLocale(defaultLocale) {
+		return nil, fmt.Errorf("no templates for default locale %q", defaultLocale)
+	}
+	return r, nil
+}
+
+// Render fills the templates for notificationType in the best match for
+// locale, which may be a language tag such as "en-US" or an Accept-Language
+// value.
+func (r *Renderer) Render(notificationType, locale string, data Data) (Content, error) {
+	set, ok := r.lookup(notificationType, locale)
+	if !ok {
+		return Content{}, fmt.Errorf("no template for notification type %q", notificationType)
+	}
+	if data.Currency == "" {
+		data.Currency = DefaultCurrency
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := set.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Content{}, err
+	}
+	if err := set.text.ExecuteTemplate(&text, "body", data); err != nil {
+		return Content{}, err
+	}
+	if err := set.html.ExecuteTemplate(&html, "body", data); err != nil {
+		return Content{}, err
+	}
+
+	return Content{Subject: subject.String(), Text: text.String(), HTML: html.String()}, nil
+}
+
+// Types lists the notification types that have templates in the default
+// locale.
+func (r *Renderer) Types() []string {
+	var types []string
+	for k := range r.sets {
+		locale, notificationType, _ := strings.Cut(k, "/")
+		if locale == r.defaultLocale {
+			types = append(types, notificationType)
+		}
+	}
+	return types
+}
+
+// Locales lists the locales that have at least one template.
+func (r *Renderer) Locales() []string {
+	seen := map[string]bool{}
+	var locales []string
+	for k := range r.sets {
+		locale, _, _ := strings.Cut(k, "/")
+		if !seen[locale] {
+			seen[locale] = true
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+func (r *Renderer) lookup(notificationType, locale string) (templateSet, bool) {
+	tag := strings.ToLower(strings.TrimSpace(locale))
+	tag, _, _ = strings.Cut(tag, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	tag = strings.ReplaceAll(tag, "_", "-")
+	language, _, _ := strings.Cut(tag, "-")
+
+	for _, candidate := range []string{tag, language, r.defaultLocale} {
+		if set, ok := r.sets[key(candidate, notificationType)]; ok {
+			return set, true
+		}
+	}
+	return templateSet{}, false
+}
+
+func (r *Renderer) hasLocale(locale string) bool {
+	for k := range r.sets {
+		if strings.HasPrefix(k, locale+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func key(locale, notificationType string) string {
+	return locale + "/" + notificationType
+}
+
+func money(amount float64, currency string) string {
+	if symbol, ok := currencySymbols[currency]; ok {
+		return fmt.Sprintf("%.2f %s", amount, symbol)
+	}
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
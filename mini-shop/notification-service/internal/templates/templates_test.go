@@ -0,0 +1,160 @@
+package templates
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const (
+	unsubscribeURL = "https://shop.example.com/api/v1/unsubscribe?token=abc"
+	verifyURL      = "https://shop.example.com/api/v1/email/verify?token=abc"
+)
+
+// goldenData is rendered by every template.
+var goldenData = map[string]Data{
+	"order.created":       {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"order.cancelled":     {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"order.shipped":       {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"order.delivered":     {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"payment.completed":   {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"payment.failed":      {OrderID: 42, Amount: 1234.5, Currency: "RUB", Reason: "insufficient_funds", UnsubscribeURL: unsubscribeURL},
+	"payment.refunded":    {OrderID: 42, Amount: 1234.5, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+	"user.registered":     {FirstName: "Анна", Email: "ann@example.com", ActionURL: verifyURL},
+	"user.verify_email":   {FirstName: "Анна", Email: "ann@example.com", ActionURL: verifyURL},
+	"user.password_reset": {FirstName: "Анна", Email: "ann@example.com", ActionURL: "https://shop.example.com/reset-password?token=abc"},
+	"user.balance_low":    {FirstName: "Анна", Balance: 80, Threshold: 100, Currency: "RUB", UnsubscribeURL: unsubscribeURL},
+}
+
+func TestEveryTemplateHasGoldenData(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, notificationType := range r.Types() {
+		if _, ok := goldenData[notificationType]; !ok {
+			t.Errorf("template %s has no golden data", notificationType)
+		}
+	}
+}
+
+func TestTemplatesMatchGoldenFiles(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	locales := r.Locales()
+	slices.Sort(locales)
+	for _, locale := range locales {
+		for notificationType, data := range goldenData {
+			t.Run(locale+"/"+notificationType, func(t *testing.T) {
+				if _, ok := r.sets[key(locale, notificationType)]; !ok {
+					t.Fatalf("locale %s has no %s template", locale, notificationType)
+				}
+
+				content, err := r.Render(notificationType, locale, data)
+				if err != nil {
+					t.Fatalf("Render: %v", err)
+				}
+				got := fmt.Sprintf("Subject: %s\n\n--- text ---\n%s\n--- html ---\n%s", content.Subject, content.Text, content.HTML)
+
+				golden := filepath.Join("testdata", locale+"_"+notificationType+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if got != string(want) {
+					t.Errorf("%s mismatch:\n--- got ---\n%s\n--- want ---\n%s", golden, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRenderOmitsFooterWithoutUnsubscribeURL(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	content, err := r.Render("order.created", "ru", Data{OrderID: 1, Amount: 10})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if strings.Contains(content.Text, "--") || strings.Contains(content.HTML, "<a ") {
+		t.Errorf("content has an unsubscribe footer:\n%s\n%s", content.Text, content.HTML)
+	}
+}
+
+func TestRenderPicksLocale(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{"en", "Payment for order 1 succeeded"},
+		{"en-US", "Payment for order 1 succeeded"},
+		{"EN_gb", "Payment for order 1 succeeded"},
+		{"en-US,en;q=0.9,ru;q=0.8", "Payment for order 1 succeeded"},
+		{"ru", "Оплата заказа 1 успешна"},
+		{"de", "Оплата заказа 1 успешна"},
+		{"", "Оплата заказа 1 успешна"},
+	}
+	for _, tt := range tests {
+		content, err := r.Render("payment.completed", tt.locale, Data{OrderID: 1, Amount: 10})
+		if err != nil {
+			t.Fatalf("Render(%q): %v", tt.locale, err)
+		}
+		if content.Subject != tt.subject {
+			t.Errorf("Render(%q) subject = %q, want %q", tt.locale, content.Subject, tt.subject)
+		}
+	}
+}
+
+func TestRenderEscapesHTML(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	content, err := r.Render("payment.completed", "en", Data{OrderID: 1, Amount: 10, Currency: "<b>"})
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if strings.Contains(content.HTML, "<b>") {
+		t.Errorf("HTML contains unescaped currency:\n%s", content.HTML)
+	}
+}
+
+func TestRenderRejectsUnknownType(t *testing.T) {
+	r, err := New("ru")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := r.Render("order.returned", "ru", Data{}); err == nil {
+		t.Error("Render succeeded for an unknown type")
+	}
+}
+
+func TestNewRequiresDefaultLocale(t *testing.T) {
+	if _, err := New("fr"); err == nil {
+		t.Error("New succeeded without templates for the default locale")
+	}
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    eventID VARCHAR(100) NOT NULL,
+    userID INTEGER NOT NULL,
+    type VARCHAR(100) NOT NULL,
+    channel VARCHAR(50) NOT NULL,
+    recipient VARCHAR(255) NOT NULL,
+    subject TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    html TEXT NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    lastError TEXT NOT NULL DEFAULT '',
+    nextAttemptAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    createdAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sentAt TIMESTAMP
+);
+
+-- Повторно доставленное событие не должно породить второе уведомление
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_event_channel ON notifications (eventID, channel);
+CREATE INDEX IF NOT EXISTS idx_notifications_user_created ON notifications (userID, createdAt, id);
+CREATE INDEX IF NOT EXISTS idx_notifications_due ON notifications (nextAttemptAt) WHERE status = 'pending';
@@ -0,0 +1,3 @@
+ALTER TABLE notifications DROP COLUMN IF EXISTS unsubscribeURL;
+
+DROP TABLE IF EXISTS notification_preferences;
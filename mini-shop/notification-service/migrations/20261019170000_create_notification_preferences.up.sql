@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    userID INTEGER PRIMARY KEY,
+    locale VARCHAR(35) NOT NULL DEFAULT '',
+    channels JSONB NOT NULL DEFAULT '{}',
+    -- Тихие часы не заданы, если quietStart пуст
+    quietStart VARCHAR(5),
+    quietEnd VARCHAR(5),
+    timeZone VARCHAR(64) NOT NULL DEFAULT '',
+    updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS unsubscribeURL TEXT NOT NULL DEFAULT '';
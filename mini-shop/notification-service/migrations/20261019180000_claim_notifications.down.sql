@@ -0,0 +1,3 @@
+UPDATE notifications SET status = 'pending' WHERE status = 'sending';
+DROP INDEX IF EXISTS idx_notifications_due;
+CREATE INDEX IF NOT EXISTS idx_notifications_due ON notifications (nextAttemptAt) WHERE status = 'pending';
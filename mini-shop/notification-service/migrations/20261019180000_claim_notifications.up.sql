@@ -0,0 +1,3 @@
+-- Захваченные диспетчером уведомления тоже ищутся по времени: по истечении аренды их забирают снова
+DROP INDEX IF EXISTS idx_notifications_due;
+CREATE INDEX IF NOT EXISTS idx_notifications_due ON notifications (nextAttemptAt) WHERE status IN ('pending', 'sending');
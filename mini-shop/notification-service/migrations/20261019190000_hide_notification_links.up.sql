@@ -0,0 +1,6 @@
+-- Одноразовые ссылки хранятся отдельно от текста, который видит пользователь
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS secretURL TEXT NOT NULL DEFAULT '';
+
+-- Уже отправленные письма со ссылками из тел не восстановить, их тела удаляются
+UPDATE notifications SET body = '', html = ''
+    WHERE type IN ('user.registered', 'user.verify_email', 'user.password_reset') AND status IN ('sent', 'failed');
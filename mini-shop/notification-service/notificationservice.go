@@ -0,0 +1,39 @@
+// Package notificationservice embeds the notification service into another process, such as
+// the all-in-one local runner.
+package notificationservice
+
+import (
+	"context"
+
+	"github.com/Viltsev/notification-service/internal/app"
+	"github.com/Viltsev/notification-service/internal/messaging"
+	"github.com/Viltsev/notification-service/internal/notifier"
+)
+
+// Broker is the message bus the service publishes to and consumes from.
+type Broker = messaging.Broker
+
+// Options configures the service.
+type Options = app.Options
+
+// Notifier delivers a notification over one channel; see Options.Notifiers.
+type Notifier = notifier.Notifier
+
+// Message is a notification handed to a Notifier.
+type Message = notifier.Message
+
+// NewMemoryNotifier returns a channel that keeps every message in memory.
+func NewMemoryNotifier() *notifier.MemoryNotifier {
+	return notifier.NewMemoryNotifier()
+}
+
+// NewMemoryBroker returns an in-process topic broker. Its method set uses only
+// built-in types, so one instance can be shared by every service in a process.
+func NewMemoryBroker() Broker {
+	return messaging.NewMemoryBroker()
+}
+
+// Run serves the notification service until ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	return app.Run(ctx, opts)
+}
@@ -1,93 +1,187 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/Viltsev/minishop/order-service/internal/handler"
 	"github.com/Viltsev/minishop/order-service/internal/messaging"
+	"github.com/Viltsev/minishop/order-service/internal/metrics"
 	"github.com/Viltsev/minishop/order-service/internal/model"
 	"github.com/Viltsev/minishop/order-service/internal/repository"
 	"github.com/Viltsev/minishop/order-service/internal/service"
+	"github.com/Viltsev/minishop/order-service/internal/tracing"
+	"github.com/Viltsev/minishop/order-service/migrations"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"mini-shop/platform/database"
+	"mini-shop/platform/health"
+	"mini-shop/platform/logger"
 )
 
+// Options configures one instance of the order service.
+type Options struct {
+	Addr string
+
+	DBHost     string
+	DBPort     string
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DBSSLMode  string
+
+	// Broker carries order and payment events. It is owned by the caller.
+	Broker messaging.Broker
+}
+
+// Run connects to the database, applies migrations and serves the API until
+// ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	cfg := database.Config{
+		Host:     opts.DBHost,
+		Port:     opts.DBPort,
+		User:     opts.DBUser,
+		Password: opts.DBPassword,
+		DBName:   opts.DBName,
+		SSLMode:  opts.DBSSLMode,
+	}
+
+	slog.Info("connecting to database", "host", cfg.Host, "port", cfg.Port, "db", cfg.DBName)
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+	defer db.Close()
+	slog.Info("database connection established")
+
+	slog.Info("running migrations")
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	slog.Info("migrations applied")
+
+	return NewAPIServer(opts.Addr, db, opts.Broker).Run(ctx)
+}
+
 type APIServer struct {
-	addr     string
-	db       *sql.DB
-	rabbitMQ *messaging.RabbitMQ
+	addr   string
+	db     *sql.DB
+	broker messaging.Broker
 }
 
-func NewAPIServer(addr string, db *sql.DB, rabbitMQ *messaging.RabbitMQ) *APIServer {
+func NewAPIServer(addr string, db *sql.DB, broker messaging.Broker) *APIServer {
 	return &APIServer{
-		addr:     addr,
-		db:       db,
-		rabbitMQ: rabbitMQ,
+		addr:   addr,
+		db:     db,
+		broker: broker,
 	}
 }
 
-func (s *APIServer) Run() error {
+func (s *APIServer) Run(ctx context.Context) error {
 	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(logger.Middleware)
+	router.Use(metrics.Common.Middleware)
+
+	metrics.Common.RegisterDB(s.db, "orders")
+	metrics.Common.RegisterRoutes(router)
+
+	checker := health.NewChecker()
+	checker.Register("database", s.db.PingContext)
+	checker.Register("migrations", func(ctx context.Context) error {
+		return database.CheckMigrations(ctx, s.db, migrations.FS)
+	})
+	checker.Register("broker", s.broker.Check)
+	checker.Register("consumer", func(ctx context.Context) error {
+		errs := []error{s.broker.CheckConsumer("payment.*")}
+		for _, key := range userEventKeys {
+			errs = append(errs, s.broker.CheckConsumer(key))
+		}
+		return errors.Join(errs...)
+	})
+	checker.RegisterRoutes(router)
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
 	orderStore := repository.NewStore(s.db)
-	orderService := service.NewOrderService(orderStore, s.rabbitMQ)
+	outbox := service.NewOutboxRelay(orderStore, s.broker)
+	go outbox.Run(ctx)
+
+	orderService := service.NewOrderService(orderStore, outbox)
 	orderHandler := handler.NewOrderHandler(orderStore, *orderService)
 	orderHandler.RegisterRoutes(subrouter)
 
+	if err := s.startPaymentEventListener(orderService); err != nil {
+		return fmt.Errorf("failed to start payment event listener: %w", err)
+	}
+	if err := s.startUserEventListener(orderService); err != nil {
+		return fmt.Errorf("failed to start user event listener: %w", err)
+	}
+
+	slog.Info("server listening", "addr", s.addr)
+	return serve(ctx, s.addr, router)
+}
+
+// serve runs the HTTP server until ctx is cancelled, then shuts it down gracefully.
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
 	go func() {
-		if err := s.startPaymentEventListener(orderStore); err != nil {
-			log.Fatalf("failed to start order service listener: %v", err)
-		}
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
 	}()
 
-	log.Println("Server starts on http://localhost:8081")
-	return http.ListenAndServe(s.addr, router)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-func (s *APIServer) startPaymentEventListener(orderStore model.OrderStore) error {
-	log.Println("[Listener] Initializing payment.* consumer...")
+// startPaymentEventListener moves orders along as payment-service authorizes,
+// captures, fails, voids or refunds their payments.
+func (s *APIServer) startPaymentEventListener(orderService *service.OrderService) error {
+	slog.Info("initializing consumer", "binding_key", "payment.*")
 
-	return s.rabbitMQ.Consume("payment.*", func(body []byte) {
-		log.Println("[Listener] Received payment event:", string(body))
-
-		var event map[string]interface{}
+	return s.broker.Consume("payment.*", func(ctx context.Context, body []byte) error {
+		var event model.PaymentEvent
 		if err := json.Unmarshal(body, &event); err != nil {
-			log.Printf("[Listener] Failed to unmarshal payment event: %v", err)
-			return
+			return fmt.Errorf("failed to unmarshal payment event: %w", err)
 		}
-
-		eventType, ok := event["type"].(string)
-		if !ok {
-			log.Println("[Listener] Missing or invalid 'type' in event")
-			return
+		if event.Type == "" {
+			return fmt.Errorf("missing or invalid 'type' in event")
 		}
-
-		orderIDFloat, ok := event["orderID"].(float64)
-		if !ok {
-			log.Println("[Listener] Missing or invalid 'orderID' in event")
-			return
-		}
-		orderID := int(orderIDFloat)
-
-		var newStatus string
-		switch eventType {
-		case "PaymentCompleted":
-			newStatus = "completed"
-		case "PaymentFailed":
-			newStatus = "failed"
-		default:
-			log.Printf("[Listener] Unknown event type: %s", eventType)
-			return
+		if event.OrderID == 0 {
+			return fmt.Errorf("missing or invalid 'orderID' in event")
 		}
+		return orderService.ApplyPaymentEvent(ctx, event)
+	})
+}
+
+// userEventKeys are the user-service events that change the email copied onto
+// orders.
+var userEventKeys = []string{"user.updated", "user.erased"}
 
-		err := orderStore.UpdateStatus(orderID, newStatus)
+func (s *APIServer) startUserEventListener(orderService *service.OrderService) error {
+	for _, key := range userEventKeys {
+		slog.Info("initializing consumer", "binding_key", key)
+		err := s.broker.Consume(key, func(ctx context.Context, body []byte) error {
+			var event model.UserEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal user event: %w", err)
+			}
+			return orderService.ApplyUserEvent(ctx, event)
+		})
 		if err != nil {
-			log.Printf("[Listener] Failed to update order status: %v", err)
-		} else {
-			log.Printf("[Listener] Order %d status updated to '%s'", orderID, newStatus)
+			return err
 		}
-	})
+	}
+	return nil
 }
@@ -1,10 +1,9 @@
 package handler
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/Viltsev/minishop/order-service/internal/auth"
@@ -12,6 +11,8 @@ import (
 	"github.com/Viltsev/minishop/order-service/internal/service"
 	"github.com/Viltsev/minishop/order-service/internal/utils"
 	"github.com/gorilla/mux"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
 )
 
 type Handler struct {
@@ -26,73 +27,97 @@ func NewOrderHandler(store model.OrderStore, service service.OrderService) *Hand
 func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/orders", auth.WithJWTAuth(h.CreateOrder, h.store)).Methods("POST")
 	router.HandleFunc("/orders/{id:[0-9]+}", h.GetOrder).Methods("GET")
-	router.HandleFunc("/orders/{id:[0-9]+}/status", h.UpdateStatus).Methods("PUT")
+	// Статус вручную меняют только администраторы и другие сервисы
+	router.HandleFunc("/orders/{id:[0-9]+}/status", auth.WithAdminOrServiceAuth(h.UpdateStatus)).Methods("PUT")
 	router.HandleFunc("/orders/user/{userID}", h.ListOrdersByUser).Methods("GET")
 	router.HandleFunc("/orders/{id:[0-9]+}", h.DeleteOrder).Methods("DELETE")
+	// Часть выгрузки данных пользователя, её собирает user-service
+	router.HandleFunc("/me/export", auth.WithJWTAuth(h.ExportMe, h.store)).Methods("GET")
 }
 
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
-	log.Println("Received request to create order")
 	userID := r.Context().Value(auth.UserKey).(int)
 	email := r.Context().Value(auth.EmailKey).(string)
 
-	log.Println("user id ", userID)
-	log.Println("user email ", email)
+	if verified, _ := r.Context().Value(auth.EmailVerifiedKey).(bool); !verified {
+		problem.WriteError(w, r, model.ErrEmailNotVerified)
+		return
+	}
 
 	var orderRequest model.OrderRequest
 	if err := utils.ParseJSON(r, &orderRequest); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
 		return
 	}
 
-	log.Println("Decoded order request:", orderRequest)
+	if err := utils.Validate.Struct(orderRequest); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+	if fields := orderRequest.PaymentFieldErrors(); len(fields) > 0 {
+		problem.WriteError(w, r, model.ValidationFailed(fields))
+		return
+	}
 
 	order := model.Order{
 		UserID: userID,
 		Email:  email,
 		Amount: orderRequest.Amount,
 		Status: "created",
+
+		PaymentMethod:  orderRequest.PaymentMethod,
+		BalanceAmount:  orderRequest.BalanceAmount,
+		ProviderMethod: orderRequest.ProviderMethod,
 	}
 
-	createdOrder, err := h.service.CreateOrder(order)
+	createdOrder, err := h.service.CreateOrder(r.Context(), order)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to create order: %v", err))
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	log.Println("Order created successfully:", createdOrder)
+	slog.InfoContext(r.Context(), "order created", "order_id", createdOrder.ID, "user_id", userID)
 
 	// Отправляем успешный ответ с созданным заказом
 	utils.WriteJSON(w, http.StatusCreated, createdOrder)
 }
 
 func (h *Handler) GetOrder(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
+	id, err := orderID(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
 
-	order, err := h.service.GetOrderByID(id)
-	if err != nil || order == nil {
-		http.Error(w, "order not found", http.StatusNotFound)
+	order, err := h.service.GetOrderByID(r.Context(), id)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(order)
+	utils.WriteJSON(w, http.StatusOK, order)
 }
 
 func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
+	id, err := orderID(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
 
-	var payload struct {
-		Status string `json:"status"`
+	var payload model.UpdateStatusRequest
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
 		return
 	}
 
-	if err := h.service.UpdateStatus(id, payload.Status); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.service.UpdateStatus(r.Context(), id, payload.Status); err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
@@ -102,23 +127,78 @@ func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListOrdersByUser(w http.ResponseWriter, r *http.Request) {
 	userID := mux.Vars(r)["userID"]
 
-	orders, err := h.service.ListOrdersByUser(userID)
+	params, err := pagination.Parse(r.URL.Query(), model.OrderSorts, "-createdAt")
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
 		return
 	}
 
-	json.NewEncoder(w).Encode(orders)
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	page, err := h.service.ListOrdersByUser(r.Context(), userID, filter, params)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func (h *Handler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	orders, err := h.service.ExportOrders(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"orders": orders})
 }
 
 func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
+	id, err := orderID(r)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
 
-	if err := h.service.DeleteOrder(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.service.DeleteOrder(r.Context(), id); err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+func parseOrderFilter(q url.Values) (model.OrderFilter, error) {
+	var (
+		filter = model.OrderFilter{Status: q.Get("status")}
+		err    error
+	)
+	if filter.CreatedFrom, err = pagination.TimeParam(q, "createdFrom"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = pagination.TimeParam(q, "createdTo"); err != nil {
+		return filter, err
+	}
+	if filter.MinAmount, err = pagination.FloatParam(q, "minAmount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = pagination.FloatParam(q, "maxAmount"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func orderID(r *http.Request) (int, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		return 0, model.InvalidInput("invalid order ID")
+	}
+	return id, nil
+}
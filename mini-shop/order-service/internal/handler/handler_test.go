@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/minishop/order-service/internal/config"
+	"github.com/Viltsev/minishop/order-service/internal/messaging"
+	"github.com/Viltsev/minishop/order-service/internal/model"
+	"github.com/Viltsev/minishop/order-service/internal/repository"
+	"github.com/Viltsev/minishop/order-service/internal/service"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"mini-shop/platform/problem"
+)
+
+func newTestRouter(t *testing.T) *mux.Router {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	router := mux.NewRouter()
+	NewOrderHandler(store, *service.NewOrderService(store, service.NewOutboxRelay(store, broker))).RegisterRoutes(router)
+	return router
+}
+
+func testToken(t *testing.T, userID, email string) string {
+	t.Helper()
+
+	return signClaims(t, jwt.MapClaims{
+		"userID":    userID,
+		"email":     email,
+		"expiredAt": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// adminToken is a token of a user-service admin.
+func adminToken(t *testing.T) string {
+	t.Helper()
+
+	return signClaims(t, jwt.MapClaims{
+		"userID":    "1",
+		"email":     "admin@example.com",
+		"roles":     []string{"admin"},
+		"expiredAt": time.Now().Add(time.Hour).Unix(),
+	})
+}
+
+// serviceToken is a token another service calls order-service with.
+func serviceToken(t *testing.T) string {
+	t.Helper()
+
+	return signClaims(t, jwt.MapClaims{"service": "payment-service", "exp": time.Now().Add(time.Minute).Unix()})
+}
+
+func signClaims(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(config.Envs.JWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func serve(t *testing.T, router http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return body.Code
+}
+
+func TestCreateAndGetOrder(t *testing.T) {
+	router := newTestRouter(t)
+	token := testToken(t, "7", "ann@example.com")
+
+	rec := serve(t, router, http.MethodPost, "/orders", token, map[string]float64{"amount": 25})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var created model.Order
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("decode order: %v", err)
+	}
+	if created.UserID != 7 || created.Email != "ann@example.com" || created.Status != "created" {
+		t.Errorf("created order = %+v, want it to belong to the token's user", created)
+	}
+
+	rec = serve(t, router, http.MethodGet, "/orders/1", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = serve(t, router, http.MethodGet, "/orders/user/7?sort=amount", "", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var page struct {
+		Items []model.Order `json:"items"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || len(page.Items) != 1 {
+		t.Errorf("list = %+v, %v; want the created order", page, err)
+	}
+}
+
+func TestCreateOrderRequiresToken(t *testing.T) {
+	rec := serve(t, newTestRouter(t), http.MethodPost, "/orders", "", map[string]float64{"amount": 25})
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if code := problemCode(t, rec); code != "permission_denied" {
+		t.Errorf("code %q, want permission_denied", code)
+	}
+}
+
+func TestCreateOrderRequiresVerifiedEmail(t *testing.T) {
+	router := newTestRouter(t)
+
+	for _, verified := range []bool{false, true} {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"userID":        "7",
+			"email":         "ann@example.com",
+			"emailVerified": verified,
+			"expiredAt":     time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte(config.Envs.JWTSecret))
+		if err != nil {
+			t.Fatalf("sign token: %v", err)
+		}
+
+		rec := serve(t, router, http.MethodPost, "/orders", token, map[string]float64{"amount": 25})
+		if !verified {
+			if rec.Code != http.StatusForbidden || problemCode(t, rec) != "email_not_verified" {
+				t.Errorf("unverified: status %d, want %d email_not_verified", rec.Code, http.StatusForbidden)
+			}
+			continue
+		}
+		if rec.Code != http.StatusCreated {
+			t.Errorf("verified: status %d, want %d", rec.Code, http.StatusCreated)
+		}
+	}
+}
+
+func TestCreateOrderValidation(t *testing.T) {
+	token := testToken(t, "7", "ann@example.com")
+	router := newTestRouter(t)
+
+	for name, body := range map[string]map[string]any{
+		"negative amount":                {"amount": -1},
+		"unknown payment method":         {"amount": 25, "paymentMethod": "cash"},
+		"provider without a method":      {"amount": 25, "paymentMethod": "provider"},
+		"split of the whole amount":      {"amount": 25, "paymentMethod": "split", "balanceAmount": 25, "providerMethod": "fake_success"},
+		"balance part of a card payment": {"amount": 25, "paymentMethod": "provider", "balanceAmount": 5, "providerMethod": "fake_success"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, router, http.MethodPost, "/orders", token, body)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if code := problemCode(t, rec); code != "validation_failed" {
+				t.Errorf("code %q, want validation_failed", code)
+			}
+		})
+	}
+}
+
+func TestCreateOrderWithPaymentMethod(t *testing.T) {
+	router := newTestRouter(t)
+	token := testToken(t, "7", "ann@example.com")
+
+	tests := []struct {
+		body map[string]any
+		want model.Order
+	}{
+		{map[string]any{"amount": 25}, model.Order{PaymentMethod: "balance"}},
+		{map[string]any{"amount": 25, "paymentMethod": "split", "balanceAmount": 10, "providerMethod": "fake_success"},
+			model.Order{PaymentMethod: "split", BalanceAmount: 10, ProviderMethod: "fake_success"}},
+	}
+	for _, tt := range tests {
+		rec := serve(t, router, http.MethodPost, "/orders", token, tt.body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create %v: status %d, want %d", tt.body, rec.Code, http.StatusCreated)
+		}
+		var created model.Order
+		if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+			t.Fatalf("decode order: %v", err)
+		}
+		if created.PaymentMethod != tt.want.PaymentMethod || created.BalanceAmount != tt.want.BalanceAmount || created.ProviderMethod != tt.want.ProviderMethod {
+			t.Errorf("created order = %+v, want payment %s", created, tt.want.PaymentMethod)
+		}
+	}
+}
+
+func TestMissingOrder(t *testing.T) {
+	router := newTestRouter(t)
+	admin := adminToken(t)
+
+	for _, tt := range []struct {
+		method, path string
+		body         any
+	}{
+		{http.MethodGet, "/orders/42", nil},
+		{http.MethodPut, "/orders/42/status", map[string]string{"status": "cancelled"}},
+		{http.MethodDelete, "/orders/42", nil},
+	} {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := serve(t, router, tt.method, tt.path, admin, tt.body)
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusNotFound)
+			}
+			if code := problemCode(t, rec); code != "order_not_found" {
+				t.Errorf("code %q, want order_not_found", code)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	router := newTestRouter(t)
+	rec := serve(t, router, http.MethodPost, "/orders", testToken(t, "7", "ann@example.com"), map[string]float64{"amount": 25})
+	var order model.Order
+	if err := json.NewDecoder(rec.Body).Decode(&order); err != nil {
+		t.Fatalf("decode order: %v", err)
+	}
+	path := "/orders/" + strconv.Itoa(order.ID) + "/status"
+
+	// Покупатель не может сам отметить заказ отправленным или отменить его
+	for name, token := range map[string]string{"without a token": "", "with a user token": testToken(t, "7", "ann@example.com")} {
+		rec = serve(t, router, http.MethodPut, path, token, map[string]string{"status": "cancelled"})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("update %s: status %d, want %d", name, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	tests := []struct {
+		name, token, status string
+		want                int
+	}{
+		{"status set by payments", adminToken(t), "paid", http.StatusBadRequest},
+		{"shipping an unpaid order", adminToken(t), "shipped", http.StatusConflict},
+		{"cancelling by a service", serviceToken(t), "cancelled", http.StatusOK},
+		{"repeated cancellation", adminToken(t), "cancelled", http.StatusOK},
+		{"delivering a cancelled order", adminToken(t), "delivered", http.StatusConflict},
+	}
+	for _, tt := range tests {
+		rec = serve(t, router, http.MethodPut, path, tt.token, map[string]string{"status": tt.status})
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if code := problemCode(t, rec); code != "invalid_status_transition" {
+		t.Errorf("code %q, want invalid_status_transition", code)
+	}
+}
+
+func TestListOrdersRejectsUnknownSort(t *testing.T) {
+	rec := serve(t, newTestRouter(t), http.MethodGet, "/orders/user/7?sort=email", "", nil)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
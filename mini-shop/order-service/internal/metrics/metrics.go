@@ -0,0 +1,20 @@
+package metrics
+
+import (
+	platform "mini-shop/platform/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Common are the HTTP and broker metrics of order-service. The metrics below
+// share its "service" label.
+var Common = platform.New("order-service")
+
+var (
+	OrdersCreated = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "orders_created_total",
+		Help:      "Orders created.",
+	})
+)
@@ -0,0 +1,36 @@
+package model
+
+import "mini-shop/platform/problem"
+
+// Domain errors are the shared problem errors, so every service reports them
+// the same way.
+type (
+	ErrorKind  = problem.ErrorKind
+	Error      = problem.Error
+	FieldError = problem.FieldError
+)
+
+const (
+	KindInternal          = problem.KindInternal
+	KindInvalid           = problem.KindInvalid
+	KindUnauthorized      = problem.KindUnauthorized
+	KindForbidden         = problem.KindForbidden
+	KindNotFound          = problem.KindNotFound
+	KindConflict          = problem.KindConflict
+	KindInsufficientFunds = problem.KindInsufficientFunds
+)
+
+var (
+	ErrOrderNotFound    = &Error{Kind: KindNotFound, Code: "order_not_found", Message: "order not found"}
+	ErrPermissionDenied = &Error{Kind: KindForbidden, Code: "permission_denied", Message: "permission denied"}
+	ErrEmailNotVerified = &Error{Kind: KindForbidden, Code: "email_not_verified", Message: "confirm your email before placing orders"}
+	ErrStatusTransition = &Error{Kind: KindConflict, Code: "invalid_status_transition", Message: "the order cannot move to this status from its current one"}
+)
+
+func InvalidInput(format string, args ...any) error {
+	return problem.InvalidInput(format, args...)
+}
+
+func ValidationFailed(fields []FieldError) error {
+	return problem.ValidationFailed(fields)
+}
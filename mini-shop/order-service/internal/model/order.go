@@ -1,15 +1,36 @@
 package model
 
-import "time"
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
+)
 
 type OrderStore interface {
-	CreateOrder(order Order) (*Order, error)
-	GetOrderByID(id int) (*Order, error)
-	UpdateStatus(id int, status string) error
-	ListOrdersByUser(userID string) ([]Order, error)
-	DeleteOrder(id int) error
+	CreateOrder(ctx context.Context, order Order) (*Order, error)
+	GetOrderByID(ctx context.Context, id int) (*Order, error)
+	UpdateStatus(ctx context.Context, id int, status string) error
+	ListOrdersByUser(ctx context.Context, userID string, filter OrderFilter, params pagination.Params) ([]Order, error)
+	DeleteOrder(ctx context.Context, id int) error
+	// UpdateUserEmail replaces the email copy on every order of userID.
+	UpdateUserEmail(ctx context.Context, userID int, email string) error
+	AddOutboxEvent(ctx context.Context, event OutboxEvent) error
+	PendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkOutboxEventSent(ctx context.Context, id int) error
+	// InTx runs fn inside a single database transaction.
+	InTx(ctx context.Context, fn func(tx OrderStore) error) error
 }
 
+// Способы оплаты заказа
+const (
+	PaymentBalance  = "balance"
+	PaymentProvider = "provider"
+	PaymentSplit    = "split"
+)
+
 type Order struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"userID"`
@@ -17,8 +38,75 @@ type Order struct {
 	Amount    float64   `json:"amount"`
 	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
+
+	// PaymentMethod is how the order is paid: one of the Payment constants.
+	PaymentMethod string `json:"paymentMethod"`
+	// BalanceAmount is the part of a split payment taken from the balance.
+	BalanceAmount float64 `json:"balanceAmount,omitempty"`
+	// ProviderMethod is the payment method the provider charges.
+	ProviderMethod string `json:"providerMethod,omitempty"`
+}
+
+// OrderSorts lists the fields a user's orders can be sorted by.
+var OrderSorts = []string{"createdAt", "amount"}
+
+type OrderFilter struct {
+	Status      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinAmount   *float64
+	MaxAmount   *float64
+}
+
+// OrderCursor builds the keyset cursor pointing at order for the given sort.
+func OrderCursor(order Order, sort string) pagination.Cursor {
+	if sort == "amount" {
+		return pagination.Cursor{Value: strconv.FormatFloat(order.Amount, 'f', -1, 64), ID: order.ID}
+	}
+	return pagination.Cursor{Value: order.CreatedAt.Format(time.RFC3339Nano), ID: order.ID}
+}
+
+// OutboxEvent is an event waiting in the outbox table to be published.
+type OutboxEvent = outbox.Event
+
+// PaymentEvent is a payment.* event of payment-service.
+type PaymentEvent struct {
+	Type    string `json:"type"`
+	OrderID int    `json:"orderID"`
+}
+
+// UserEvent is a user.updated or user.erased event of user-service.
+type UserEvent struct {
+	Type   string `json:"type"`
+	UserID int    `json:"userID"`
+	Email  string `json:"email"`
 }
 
 type OrderRequest struct {
-	Amount float64 `json:"amount" validate:"required"`
+	Amount float64 `json:"amount" validate:"required,gt=0"`
+	// PaymentMethod defaults to paying from the balance.
+	PaymentMethod  string  `json:"paymentMethod" validate:"omitempty,oneof=balance provider split"`
+	BalanceAmount  float64 `json:"balanceAmount" validate:"gte=0"`
+	ProviderMethod string  `json:"providerMethod" validate:"max=50"`
+}
+
+// PaymentFieldErrors checks the payment fields that depend on each other:
+// provider and split payments name the provider's payment method, and only a
+// split takes a part of the amount from the balance.
+func (r OrderRequest) PaymentFieldErrors() []FieldError {
+	var fields []FieldError
+	if (r.PaymentMethod == PaymentProvider || r.PaymentMethod == PaymentSplit) && r.ProviderMethod == "" {
+		fields = append(fields, FieldError{Field: "providerMethod", Message: "is required"})
+	}
+	switch {
+	case r.PaymentMethod == PaymentSplit && (r.BalanceAmount <= 0 || r.BalanceAmount >= r.Amount):
+		fields = append(fields, FieldError{Field: "balanceAmount", Message: "must be greater than 0 and less than amount"})
+	case r.PaymentMethod != PaymentSplit && r.BalanceAmount != 0:
+		fields = append(fields, FieldError{Field: "balanceAmount", Message: "is only allowed for split payments"})
+	}
+	return fields
+}
+
+type UpdateStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=cancelled shipped delivered"`
 }
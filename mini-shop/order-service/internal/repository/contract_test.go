@@ -0,0 +1,259 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/Viltsev/minishop/order-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// testOrderStore is the contract every model.OrderStore implementation has to
+// satisfy. newStore must return an empty store.
+func testOrderStore(t *testing.T, newStore func(t *testing.T) model.OrderStore) {
+	ctx := context.Background()
+
+	t.Run("GetOrderByID returns nil, nil for a missing order", func(t *testing.T) {
+		store := newStore(t)
+
+		order, err := store.GetOrderByID(ctx, 42)
+		if order != nil || err != nil {
+			t.Fatalf("GetOrderByID = %v, %v; want nil, nil", order, err)
+		}
+	})
+
+	t.Run("UpdateStatus and DeleteOrder report a missing order", func(t *testing.T) {
+		store := newStore(t)
+
+		if err := store.UpdateStatus(ctx, 42, "completed"); !errors.Is(err, model.ErrOrderNotFound) {
+			t.Errorf("UpdateStatus error = %v, want %v", err, model.ErrOrderNotFound)
+		}
+		if err := store.DeleteOrder(ctx, 42); !errors.Is(err, model.ErrOrderNotFound) {
+			t.Errorf("DeleteOrder error = %v, want %v", err, model.ErrOrderNotFound)
+		}
+	})
+
+	t.Run("CreateOrder assigns unique IDs", func(t *testing.T) {
+		store := newStore(t)
+
+		first := mustCreateOrder(t, store, 7, 10)
+		second := mustCreateOrder(t, store, 7, 10)
+		if first.ID == 0 || first.ID == second.ID {
+			t.Fatalf("order IDs = %d and %d, want distinct non-zero IDs", first.ID, second.ID)
+		}
+
+		got, err := store.GetOrderByID(ctx, first.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetOrderByID = %v, %v; want the order", got, err)
+		}
+		if got.UserID != 7 || got.Email != "ann@example.com" || got.Amount != 10 || got.Status != "created" {
+			t.Errorf("stored order = %+v, want the created fields", got)
+		}
+		if !got.CreatedAt.Equal(first.CreatedAt) {
+			t.Errorf("stored createdAt = %v, want %v", got.CreatedAt, first.CreatedAt)
+		}
+	})
+
+	t.Run("CreateOrder keeps the payment method", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 25, Status: "created",
+			PaymentMethod: model.PaymentSplit, BalanceAmount: 10, ProviderMethod: "fake_success"})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		got, err := store.GetOrderByID(ctx, created.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetOrderByID = %v, %v; want the order", got, err)
+		}
+		if got.PaymentMethod != model.PaymentSplit || got.BalanceAmount != 10 || got.ProviderMethod != "fake_success" {
+			t.Errorf("stored order = %+v, want the split payment", got)
+		}
+	})
+
+	t.Run("UpdateStatus and DeleteOrder change the order", func(t *testing.T) {
+		store := newStore(t)
+		order := mustCreateOrder(t, store, 7, 10)
+
+		if err := store.UpdateStatus(ctx, order.ID, "completed"); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+		if got, _ := store.GetOrderByID(ctx, order.ID); got == nil || got.Status != "completed" {
+			t.Errorf("order after UpdateStatus = %+v, want status completed", got)
+		}
+
+		if err := store.DeleteOrder(ctx, order.ID); err != nil {
+			t.Fatalf("DeleteOrder: %v", err)
+		}
+		if got, err := store.GetOrderByID(ctx, order.ID); got != nil || err != nil {
+			t.Errorf("GetOrderByID after delete = %v, %v; want nil, nil", got, err)
+		}
+	})
+
+	t.Run("UpdateUserEmail rewrites only the orders of the user", func(t *testing.T) {
+		store := newStore(t)
+
+		first := mustCreateOrder(t, store, 7, 10)
+		second := mustCreateOrder(t, store, 7, 20)
+		other := mustCreateOrder(t, store, 8, 30)
+		if err := store.UpdateUserEmail(ctx, 7, "anna@example.com"); err != nil {
+			t.Fatalf("UpdateUserEmail: %v", err)
+		}
+
+		for id, want := range map[int]string{first.ID: "anna@example.com", second.ID: "anna@example.com", other.ID: "ann@example.com"} {
+			got, err := store.GetOrderByID(ctx, id)
+			if err != nil || got == nil {
+				t.Fatalf("GetOrderByID(%d) = %v, %v; want the order", id, got, err)
+			}
+			if got.Email != want {
+				t.Errorf("order %d email = %q, want %q", id, got.Email, want)
+			}
+		}
+	})
+
+	t.Run("ListOrdersByUser orders by the sort field and then by ID", func(t *testing.T) {
+		store := newStore(t)
+
+		var ids []int
+		for _, amount := range []float64{30, 10, 30, 20} {
+			ids = append(ids, mustCreateOrder(t, store, 7, amount).ID)
+		}
+		mustCreateOrder(t, store, 8, 15)
+		if err := store.UpdateStatus(ctx, ids[1], "failed"); err != nil {
+			t.Fatalf("UpdateStatus: %v", err)
+		}
+
+		minAmount := 15.0
+		tests := []struct {
+			name   string
+			filter model.OrderFilter
+			params pagination.Params
+			want   []int
+		}{
+			{"createdAt", model.OrderFilter{}, pagination.Params{Limit: 10, Sort: "createdAt"}, ids},
+			{"-createdAt", model.OrderFilter{}, pagination.Params{Limit: 10, Sort: "createdAt", Desc: true}, []int{ids[3], ids[2], ids[1], ids[0]}},
+			{"amount", model.OrderFilter{}, pagination.Params{Limit: 10, Sort: "amount"}, []int{ids[1], ids[3], ids[0], ids[2]}},
+			{"-amount", model.OrderFilter{}, pagination.Params{Limit: 10, Sort: "amount", Desc: true}, []int{ids[2], ids[0], ids[3], ids[1]}},
+			{"limit fetches one extra row", model.OrderFilter{}, pagination.Params{Limit: 2, Sort: "amount"}, []int{ids[1], ids[3], ids[0]}},
+			{"status filter", model.OrderFilter{Status: "created"}, pagination.Params{Limit: 10, Sort: "createdAt"}, []int{ids[0], ids[2], ids[3]}},
+			{"amount filter", model.OrderFilter{MinAmount: &minAmount}, pagination.Params{Limit: 10, Sort: "amount"}, []int{ids[3], ids[0], ids[2]}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				orders, err := store.ListOrdersByUser(ctx, "7", tt.filter, tt.params)
+				if err != nil {
+					t.Fatalf("ListOrdersByUser: %v", err)
+				}
+				if got := orderIDs(orders); !slices.Equal(got, tt.want) {
+					t.Errorf("ListOrdersByUser IDs = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		t.Run("cursor continues after the last row", func(t *testing.T) {
+			cursor := model.OrderCursor(model.Order{ID: ids[3], Amount: 20}, "amount")
+			rest, err := store.ListOrdersByUser(ctx, "7", model.OrderFilter{}, pagination.Params{Limit: 2, Sort: "amount", Cursor: &cursor})
+			if err != nil {
+				t.Fatalf("ListOrdersByUser: %v", err)
+			}
+			if got, want := orderIDs(rest), []int{ids[0], ids[2]}; !slices.Equal(got, want) {
+				t.Errorf("second page IDs = %v, want %v", got, want)
+			}
+		})
+	})
+
+	t.Run("outbox returns pending events in insertion order", func(t *testing.T) {
+		store := newStore(t)
+
+		for i := range 3 {
+			event := model.OutboxEvent{
+				EventType: "order.created",
+				Payload:   []byte(`{"orderID": ` + strconv.Itoa(i) + `}`),
+				Headers:   map[string]string{"traceparent": strconv.Itoa(i)},
+			}
+			if err := store.AddOutboxEvent(ctx, event); err != nil {
+				t.Fatalf("AddOutboxEvent: %v", err)
+			}
+		}
+
+		events, err := store.PendingOutboxEvents(ctx, 2)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("got %d events, want 2", len(events))
+		}
+		first := events[0]
+		if first.EventType != "order.created" || first.Status != "pending" || first.Headers["traceparent"] != "0" {
+			t.Errorf("first event = %+v, want the first pending order.created", first)
+		}
+		var payload map[string]int
+		if err := json.Unmarshal(first.Payload, &payload); err != nil || !reflect.DeepEqual(payload, map[string]int{"orderID": 0}) {
+			t.Errorf("first payload = %s, want orderID 0", first.Payload)
+		}
+
+		if err := store.MarkOutboxEventSent(ctx, first.ID); err != nil {
+			t.Fatalf("MarkOutboxEventSent: %v", err)
+		}
+		events, err = store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 2 || events[0].ID <= first.ID || events[0].ID >= events[1].ID {
+			t.Errorf("pending after send = %+v, want the remaining two in order", events)
+		}
+	})
+
+	t.Run("InTx rolls back on error", func(t *testing.T) {
+		store := newStore(t)
+
+		errRollback := errors.New("rollback")
+		err := store.InTx(ctx, func(tx model.OrderStore) error {
+			if _, err := tx.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 10, Status: "created"}); err != nil {
+				return err
+			}
+			if err := tx.AddOutboxEvent(ctx, model.OutboxEvent{EventType: "order.created", Payload: []byte(`{}`)}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("InTx error = %v, want %v", err, errRollback)
+		}
+
+		orders, err := store.ListOrdersByUser(ctx, "7", model.OrderFilter{}, pagination.Params{Limit: 10, Sort: "createdAt"})
+		if err != nil {
+			t.Fatalf("ListOrdersByUser: %v", err)
+		}
+		events, err := store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(orders) != 0 || len(events) != 0 {
+			t.Errorf("after rollback: %d orders and %d events, want none", len(orders), len(events))
+		}
+	})
+}
+
+func mustCreateOrder(t *testing.T, store model.OrderStore, userID int, amount float64) *model.Order {
+	t.Helper()
+
+	order, err := store.CreateOrder(context.Background(), model.Order{UserID: userID, Email: "ann@example.com", Amount: amount, Status: "created"})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	return order
+}
+
+func orderIDs(orders []model.Order) []int {
+	ids := make([]int, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
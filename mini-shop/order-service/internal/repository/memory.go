@@ -0,0 +1,251 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Viltsev/minishop/order-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// MemoryStore is an in-memory model.OrderStore with the same semantics as the
+// Postgres Store. It is meant for tests and has no persistence.
+type MemoryStore struct {
+	state *orderState
+	inTx  bool
+}
+
+type orderState struct {
+	// tx serializes transactions, which is all the isolation the fake offers.
+	tx sync.Mutex
+
+	mu         sync.Mutex
+	orders     []model.Order
+	outbox     []model.OutboxEvent
+	lastOrder  int
+	lastOutbox int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: &orderState{}}
+}
+
+func (s *MemoryStore) CreateOrder(ctx context.Context, order model.Order) (*model.Order, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.lastOrder++
+	order.ID = s.state.lastOrder
+	order.CreatedAt = now()
+	s.state.orders = append(s.state.orders, order)
+	return &order, nil
+}
+
+func (s *MemoryStore) GetOrderByID(ctx context.Context, id int) (*model.Order, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.orderIndex(id)
+	if i < 0 {
+		return nil, nil
+	}
+	order := s.state.orders[i]
+	return &order, nil
+}
+
+func (s *MemoryStore) UpdateStatus(ctx context.Context, id int, status string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.orderIndex(id)
+	if i < 0 {
+		return model.ErrOrderNotFound
+	}
+	s.state.orders[i].Status = status
+	return nil
+}
+
+func (s *MemoryStore) ListOrdersByUser(ctx context.Context, userID string, filter model.OrderFilter, params pagination.Params) ([]model.Order, error) {
+	id, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	orders := []model.Order{}
+	for _, order := range s.state.orders {
+		if order.UserID != id {
+			continue
+		}
+		if filter.Status != "" && order.Status != filter.Status {
+			continue
+		}
+		if filter.CreatedFrom != nil && order.CreatedAt.Before(*filter.CreatedFrom) {
+			continue
+		}
+		if filter.CreatedTo != nil && !order.CreatedAt.Before(*filter.CreatedTo) {
+			continue
+		}
+		if filter.MinAmount != nil && order.Amount < *filter.MinAmount {
+			continue
+		}
+		if filter.MaxAmount != nil && order.Amount > *filter.MaxAmount {
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	return keysetPage(orders, params, model.OrderCursor), nil
+}
+
+func (s *MemoryStore) DeleteOrder(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.orderIndex(id)
+	if i < 0 {
+		return model.ErrOrderNotFound
+	}
+	s.state.orders = slices.Delete(s.state.orders, i, i+1)
+	return nil
+}
+
+func (s *MemoryStore) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.orders {
+		if s.state.orders[i].UserID == userID {
+			s.state.orders[i].Email = email
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.lastOutbox++
+	event.ID = s.state.lastOutbox
+	event.Payload = slices.Clone(event.Payload)
+	event.Headers = maps.Clone(event.Headers)
+	event.Status = "pending"
+	event.CreatedAt = now()
+	event.SentAt = nil
+	s.state.outbox = append(s.state.outbox, event)
+	return nil
+}
+
+func (s *MemoryStore) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	var events []model.OutboxEvent
+	for _, event := range s.state.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.Status == "pending" {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkOutboxEventSent(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.outbox {
+		if s.state.outbox[i].ID == id {
+			sentAt := now()
+			s.state.outbox[i].Status = "sent"
+			s.state.outbox[i].SentAt = &sentAt
+		}
+	}
+	return nil
+}
+
+// InTx runs fn with transactions serialized. If fn fails, every change made
+// since the transaction began is discarded.
+func (s *MemoryStore) InTx(ctx context.Context, fn func(tx model.OrderStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.state.tx.Lock()
+	defer s.state.tx.Unlock()
+
+	s.state.mu.Lock()
+	orders, outbox := slices.Clone(s.state.orders), slices.Clone(s.state.outbox)
+	s.state.mu.Unlock()
+
+	if err := fn(&MemoryStore{state: s.state, inTx: true}); err != nil {
+		s.state.mu.Lock()
+		s.state.orders, s.state.outbox = orders, outbox
+		s.state.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (st *orderState) orderIndex(id int) int {
+	return slices.IndexFunc(st.orders, func(order model.Order) bool { return order.ID == id })
+}
+
+// now matches the microsecond precision of Postgres timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// keysetPage applies the keyset condition, ordering and limit that pagination.Query
+// builds for Postgres.
+func keysetPage[T any](items []T, params pagination.Params, cursor func(item T, sort string) pagination.Cursor) []T {
+	compare := func(a, b pagination.Cursor) int {
+		c := compareSortValues(params.Sort, a.Value, b.Value)
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if params.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	if params.Cursor != nil {
+		items = slices.DeleteFunc(items, func(item T) bool {
+			return compare(cursor(item, params.Sort), *params.Cursor) <= 0
+		})
+	}
+
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(cursor(a, params.Sort), cursor(b, params.Sort))
+	})
+
+	if len(items) > params.Limit+1 {
+		items = items[:params.Limit+1]
+	}
+	return items
+}
+
+// compareSortValues compares two cursor values of the given sort field.
+func compareSortValues(sort, a, b string) int {
+	if sort == "createdAt" {
+		x, _ := time.Parse(time.RFC3339Nano, a)
+		y, _ := time.Parse(time.RFC3339Nano, b)
+		return x.Compare(y)
+	}
+
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	return cmp.Compare(x, y)
+}
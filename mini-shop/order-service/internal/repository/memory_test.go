@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/Viltsev/minishop/order-service/internal/model"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testOrderStore(t, func(t *testing.T) model.OrderStore {
+		return NewMemoryStore()
+	})
+}
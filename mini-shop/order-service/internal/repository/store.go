@@ -1,19 +1,28 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
 	"time"
 
 	"github.com/Viltsev/minishop/order-service/internal/model"
+	"mini-shop/platform/database"
+	"mini-shop/platform/pagination"
 )
 
+var orderSortColumns = map[string]pagination.Column{
+	"createdAt": {Name: "createdAt", Cast: "timestamptz"},
+	"amount":    {Name: "amount", Cast: "double precision"},
+}
+
 type Store struct {
 	db *sql.DB
+	q  database.DBTX
 }
 
 func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, q: db}
 }
 
 func scanRowsIntoOrder(rows *sql.Rows) (*model.Order, error) {
@@ -26,6 +35,9 @@ func scanRowsIntoOrder(rows *sql.Rows) (*model.Order, error) {
 		&user.Amount,
 		&user.Status,
 		&user.CreatedAt,
+		&user.PaymentMethod,
+		&user.BalanceAmount,
+		&user.ProviderMethod,
 	)
 	if err != nil {
 		return nil, err
@@ -34,23 +46,27 @@ func scanRowsIntoOrder(rows *sql.Rows) (*model.Order, error) {
 	return user, nil
 }
 
-func (s *Store) CreateOrder(order model.Order) (*model.Order, error) {
-	query := `INSERT INTO orders (userID, email, amount, status, createdAt) VALUES ($1, $2, $3, $4, $5) RETURNING id`
-	err := s.db.QueryRow(query, order.UserID, order.Email, order.Amount, order.Status, time.Now()).Scan(&order.ID)
+func (s *Store) CreateOrder(ctx context.Context, order model.Order) (*model.Order, error) {
+	query := `INSERT INTO orders (userID, email, amount, status, createdAt, paymentMethod, balanceAmount, providerMethod)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	now := time.Now()
+	err := s.q.QueryRowContext(ctx, query, order.UserID, order.Email, order.Amount, order.Status, now,
+		order.PaymentMethod, order.BalanceAmount, order.ProviderMethod).Scan(&order.ID)
 	if err != nil {
 		return nil, err
 	}
-	order.CreatedAt = time.Now()
+	order.CreatedAt = now
 	return &order, nil
 }
 
-func (s *Store) GetOrderByID(id int) (*model.Order, error) {
-	query := `SELECT id, userID, email, amount, status, createdAt FROM orders WHERE id = $1`
+func (s *Store) GetOrderByID(ctx context.Context, id int) (*model.Order, error) {
+	query := `SELECT id, userID, email, amount, status, createdAt, paymentMethod, balanceAmount, providerMethod FROM orders WHERE id = $1`
 
-	row := s.db.QueryRow(query, id)
+	row := s.q.QueryRowContext(ctx, query, id)
 
 	order := &model.Order{}
-	err := row.Scan(&order.ID, &order.UserID, &order.Email, &order.Amount, &order.Status, &order.CreatedAt)
+	err := row.Scan(&order.ID, &order.UserID, &order.Email, &order.Amount, &order.Status, &order.CreatedAt,
+		&order.PaymentMethod, &order.BalanceAmount, &order.ProviderMethod)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -61,10 +77,10 @@ func (s *Store) GetOrderByID(id int) (*model.Order, error) {
 	return order, nil
 }
 
-func (s *Store) UpdateStatus(id int, status string) error {
+func (s *Store) UpdateStatus(ctx context.Context, id int, status string) error {
 	query := `UPDATE orders SET status = $1 WHERE id = $2`
 
-	result, err := s.db.Exec(query, status, id)
+	result, err := s.q.ExecContext(ctx, query, status, id)
 	if err != nil {
 		return err
 	}
@@ -74,22 +90,39 @@ func (s *Store) UpdateStatus(id int, status string) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no order found with ID %d", id)
+		return model.ErrOrderNotFound
 	}
 
 	return nil
 }
 
-func (s *Store) ListOrdersByUser(userID string) ([]model.Order, error) {
-	query := `SELECT id, userID, email, amount, status, createdAt FROM orders WHERE userID = $1`
+func (s *Store) ListOrdersByUser(ctx context.Context, userID string, filter model.OrderFilter, params pagination.Params) ([]model.Order, error) {
+	q := pagination.NewQuery()
+	q.Where("userID = ?", userID)
+	if filter.Status != "" {
+		q.Where("status = ?", filter.Status)
+	}
+	if filter.CreatedFrom != nil {
+		q.Where("createdAt >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		q.Where("createdAt < ?", *filter.CreatedTo)
+	}
+	if filter.MinAmount != nil {
+		q.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		q.Where("amount <= ?", *filter.MaxAmount)
+	}
+	query := q.Build(`SELECT id, userID, email, amount, status, createdAt, paymentMethod, balanceAmount, providerMethod FROM orders`, orderSortColumns[params.Sort], params)
 
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.q.QueryContext(ctx, query, q.Args()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var orders []model.Order
+	orders := []model.Order{}
 	for rows.Next() {
 		order, err := scanRowsIntoOrder(rows)
 		if err != nil {
@@ -98,13 +131,13 @@ func (s *Store) ListOrdersByUser(userID string) ([]model.Order, error) {
 		orders = append(orders, *order)
 	}
 
-	return orders, nil
+	return orders, rows.Err()
 }
 
-func (s *Store) DeleteOrder(id int) error {
+func (s *Store) DeleteOrder(ctx context.Context, id int) error {
 	query := `DELETE FROM orders WHERE id = $1`
 
-	result, err := s.db.Exec(query, id)
+	result, err := s.q.ExecContext(ctx, query, id)
 	if err != nil {
 		return err
 	}
@@ -114,8 +147,59 @@ func (s *Store) DeleteOrder(id int) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no order found with ID %d", id)
+		return model.ErrOrderNotFound
 	}
 
 	return nil
 }
+
+func (s *Store) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE orders SET email = $1 WHERE userID = $2`, email, userID)
+	return err
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	headers, err := json.Marshal(event.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox_events (eventType, payload, headers) VALUES ($1, $2, $3)`
+	_, err = s.q.ExecContext(ctx, query, event.EventType, string(event.Payload), string(headers))
+	return err
+}
+
+// PendingOutboxEvents locks up to limit unsent events. Rows locked by another
+// relay are skipped, so several replicas can drain the outbox concurrently.
+func (s *Store) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	query := `SELECT id, eventType, payload, headers, status, createdAt FROM outbox_events
+		WHERE status = 'pending' ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`
+
+	rows, err := s.q.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var (
+			event   model.OutboxEvent
+			headers []byte
+		)
+		if err := rows.Scan(&event.ID, &event.EventType, &event.Payload, &headers, &event.Status, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(headers, &event.Headers); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Store) MarkOutboxEventSent(ctx context.Context, id int) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE outbox_events SET status = 'sent', sentAt = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
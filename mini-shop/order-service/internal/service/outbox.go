@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Viltsev/minishop/order-service/internal/messaging"
+	"github.com/Viltsev/minishop/order-service/internal/model"
+	"mini-shop/platform/outbox"
+)
+
+// NewOutboxRelay publishes the events written to the outbox of store.
+func NewOutboxRelay(store model.OrderStore, publisher messaging.Publisher) *outbox.Relay {
+	return outbox.NewRelay(func(ctx context.Context, fn func(tx outbox.Store) error) error {
+		return store.InTx(ctx, func(tx model.OrderStore) error {
+			return fn(tx)
+		})
+	}, publisher)
+}
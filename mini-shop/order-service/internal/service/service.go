@@ -1,71 +1,259 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
+	"strconv"
 
-	"github.com/Viltsev/minishop/order-service/internal/messaging"
+	"github.com/Viltsev/minishop/order-service/internal/metrics"
 	"github.com/Viltsev/minishop/order-service/internal/model"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
 )
 
 type OrderService struct {
-	store    model.OrderStore
-	rabbitMQ *messaging.RabbitMQ
+	store  model.OrderStore
+	outbox *outbox.Relay
 }
 
-func NewOrderService(store model.OrderStore, rabbitMQ *messaging.RabbitMQ) *OrderService {
+func NewOrderService(store model.OrderStore, outbox *outbox.Relay) *OrderService {
 	return &OrderService{
-		store:    store,
-		rabbitMQ: rabbitMQ,
+		store:  store,
+		outbox: outbox,
 	}
 }
 
-func (s *OrderService) CreateOrder(order model.Order) (*model.Order, error) {
+// CreateOrder stores the order and its OrderCreated event in one transaction.
+// The event is published by the outbox relay once the transaction commits.
+// Orders without a payment method are paid from the balance.
+func (s *OrderService) CreateOrder(ctx context.Context, order model.Order) (*model.Order, error) {
 	order.Status = "created"
-	createdOrder, err := s.store.CreateOrder(order)
+	if order.PaymentMethod == "" {
+		order.PaymentMethod = model.PaymentBalance
+	}
+
+	var createdOrder *model.Order
+	err := s.store.InTx(ctx, func(tx model.OrderStore) error {
+		var err error
+		createdOrder, err = tx.CreateOrder(ctx, order)
+		if err != nil {
+			return err
+		}
+
+		return addOrderEvent(ctx, tx, "OrderCreated", createdOrder)
+	})
 	if err != nil {
 		return nil, err
 	}
+	s.outbox.Notify()
 
-	log.Printf("Create order with amount %f and id %d", createdOrder.Amount, createdOrder.ID)
+	metrics.OrdersCreated.Inc()
+	slog.InfoContext(ctx, "order stored", "order_id", createdOrder.ID, "amount", createdOrder.Amount)
 
-	// Публикуем событие OrderCreated
-	event := map[string]interface{}{
-		"type":    "OrderCreated",
-		"orderID": createdOrder.ID,
-		"userID":  createdOrder.UserID,
-		"email":   createdOrder.Email,
-		"amount":  createdOrder.Amount,
+	return createdOrder, nil
+}
+
+func (s *OrderService) GetOrderByID(ctx context.Context, id int) (*model.Order, error) {
+	order, err := s.store.GetOrderByID(ctx, id)
+	if err != nil {
+		return nil, err
 	}
+	if order == nil {
+		return nil, model.ErrOrderNotFound
+	}
+	return order, nil
+}
 
-	log.Printf("Create event %s", event)
+// statusTransitions maps the statuses set by hand to the events announcing
+// them, keyed by the routing key suffix, and the statuses the order may move
+// to them from. Every other status follows the payment.
+var statusTransitions = map[string]struct {
+	event string
+	from  []string
+}{
+	// Отменить можно до отправки; оплаченный заказ вернёт деньги
+	"cancelled": {"OrderCancelled", []string{"created", "authorized", "paid"}},
+	"shipped":   {"OrderShipped", []string{"paid"}},
+	"delivered": {"OrderDelivered", []string{"shipped"}},
+}
 
-	body, err := json.Marshal(event)
+// UpdateStatus moves the order to cancelled, shipped or delivered and
+// publishes order.<status> from the same transaction. Setting the status the
+// order already has changes nothing; any other move not in
+// statusTransitions fails with ErrStatusTransition.
+func (s *OrderService) UpdateStatus(ctx context.Context, id int, status string) error {
+	transition, ok := statusTransitions[status]
+	if !ok {
+		return model.InvalidInput("status %q cannot be set by hand", status)
+	}
+
+	var published bool
+	err := s.store.InTx(ctx, func(tx model.OrderStore) error {
+		order, err := tx.GetOrderByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if order == nil {
+			return model.ErrOrderNotFound
+		}
+
+		// Повторный запрос не дублирует событие
+		if order.Status == status {
+			return nil
+		}
+		if !slices.Contains(transition.from, order.Status) {
+			return model.ErrStatusTransition
+		}
+
+		if err := tx.UpdateStatus(ctx, id, status); err != nil {
+			return err
+		}
+		order.Status = status
+		published = true
+		return addOrderEvent(ctx, tx, transition.event, order)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal event: %w", err)
+		return err
+	}
+	if published {
+		s.outbox.Notify()
 	}
 
-	err = s.rabbitMQ.Publish("order.created", body)
+	slog.InfoContext(ctx, "order status updated", "order_id", id, "status", status)
+	return nil
+}
+
+func (s *OrderService) ListOrdersByUser(ctx context.Context, userID string, filter model.OrderFilter, params pagination.Params) (pagination.Page[model.Order], error) {
+	orders, err := s.store.ListOrdersByUser(ctx, userID, filter, params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to publish event: %w", err)
+		return pagination.Page[model.Order]{}, err
+	}
+	return pagination.NewPage(orders, params, model.OrderCursor), nil
+}
+
+// ExportOrders returns every order of userID, oldest first, for the data
+// export of user-service.
+func (s *OrderService) ExportOrders(ctx context.Context, userID int) ([]model.Order, error) {
+	params := pagination.Params{Limit: pagination.MaxLimit, Sort: "createdAt"}
+	orders := []model.Order{}
+	for {
+		page, err := s.store.ListOrdersByUser(ctx, strconv.Itoa(userID), model.OrderFilter{}, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) <= params.Limit {
+			return append(orders, page...), nil
+		}
+		orders = append(orders, page[:params.Limit]...)
+		cursor := model.OrderCursor(page[params.Limit-1], params.Sort)
+		params.Cursor = &cursor
 	}
+}
 
-	return createdOrder, nil
+func (s *OrderService) DeleteOrder(ctx context.Context, id int) error {
+	return s.store.DeleteOrder(ctx, id)
 }
 
-func (s *OrderService) GetOrderByID(id int) (*model.Order, error) {
-	return s.store.GetOrderByID(id)
+// paymentTransitions maps payment events to the order status they set and
+// the statuses the order may have for it. Events reaching an order that has
+// moved on, e.g. a late payment.completed of a cancelled order, change nothing.
+var paymentTransitions = map[string]struct {
+	status string
+	from   []string
+}{
+	// Авторизация может дойти уже после захвата или отказа
+	"PaymentAuthorized": {"authorized", []string{"created"}},
+	// Заказ оплачен, только когда деньги захвачены
+	"PaymentCompleted": {"paid", []string{"created", "authorized"}},
+	"PaymentFailed":    {"failed", []string{"created", "authorized"}},
+	"PaymentRefunded":  {"refunded", []string{"cancelled"}},
+	// Деньги отменённого заказа отпущены, заказ остаётся отменённым
+	"PaymentVoided": {},
 }
 
-func (s *OrderService) UpdateStatus(id int, status string) error {
-	return s.store.UpdateStatus(id, status)
+// ApplyPaymentEvent moves the order to the status the payment event sets,
+// unless the order has moved on.
+func (s *OrderService) ApplyPaymentEvent(ctx context.Context, event model.PaymentEvent) error {
+	transition, ok := paymentTransitions[event.Type]
+	if !ok {
+		return fmt.Errorf("unknown event type: %s", event.Type)
+	}
+
+	var applied bool
+	err := s.store.InTx(ctx, func(tx model.OrderStore) error {
+		order, err := tx.GetOrderByID(ctx, event.OrderID)
+		if err != nil {
+			return err
+		}
+		if order == nil || !slices.Contains(transition.from, order.Status) {
+			return nil
+		}
+		applied = true
+		return tx.UpdateStatus(ctx, order.ID, transition.status)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	if applied {
+		slog.InfoContext(ctx, "order status updated", "order_id", event.OrderID, "status", transition.status)
+	} else {
+		slog.InfoContext(ctx, "payment event left order status alone", "order_id", event.OrderID, "event_type", event.Type)
+	}
+	return nil
 }
 
-func (s *OrderService) ListOrdersByUser(userID string) ([]model.Order, error) {
-	return s.store.ListOrdersByUser(userID)
+// ApplyUserEvent keeps the email copied onto the user's orders in sync with
+// user-service. Orders of an erased account keep no address; the orders
+// themselves stay for the books.
+func (s *OrderService) ApplyUserEvent(ctx context.Context, event model.UserEvent) error {
+	var email string
+	switch event.Type {
+	case "UserUpdated":
+		if event.Email == "" {
+			return fmt.Errorf("missing or invalid 'email' in event")
+		}
+		email = event.Email
+	case "UserErased":
+	default:
+		return fmt.Errorf("unknown event type: %s", event.Type)
+	}
+
+	if err := s.store.UpdateUserEmail(ctx, event.UserID, email); err != nil {
+		return fmt.Errorf("failed to update order emails: %w", err)
+	}
+	slog.InfoContext(ctx, "order emails synced", "user_id", event.UserID, "event_type", event.Type)
+	return nil
 }
 
-func (s *OrderService) DeleteOrder(id int) error {
-	return s.store.DeleteOrder(id)
+// addOrderEvent writes an event of eventType about order to the outbox of tx.
+// The routing key is derived from the order status, e.g. "order.created".
+func addOrderEvent(ctx context.Context, tx model.OrderStore, eventType string, order *model.Order) error {
+	event := map[string]interface{}{
+		// Идентификатор хранится в outbox вместе с событием, по нему получатели
+		// отбрасывают дубликаты
+		"eventID": logger.NewID(),
+		"type":    eventType,
+		"orderID": order.ID,
+		"userID":  order.UserID,
+		"email":   order.Email,
+		"amount":  order.Amount,
+	}
+	// Способ оплаты нужен payment-service, чтобы принять оплату заказа
+	if eventType == "OrderCreated" {
+		event["paymentMethod"] = order.PaymentMethod
+		event["balanceAmount"] = order.BalanceAmount
+		event["providerMethod"] = order.ProviderMethod
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	return tx.AddOutboxEvent(ctx, outbox.NewEvent(ctx, "order."+order.Status, body))
 }
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/minishop/order-service/internal/messaging"
+	"github.com/Viltsev/minishop/order-service/internal/model"
+	"github.com/Viltsev/minishop/order-service/internal/repository"
+)
+
+func TestCreateOrderStoresOutboxEvent(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+	order, err := s.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 25})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if order.Status != "created" {
+		t.Errorf("status = %q, want created", order.Status)
+	}
+
+	events, err := store.PendingOutboxEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingOutboxEvents: %v", err)
+	}
+	if len(events) != 1 || events[0].EventType != "order.created" {
+		t.Fatalf("pending events = %+v, want one order.created", events)
+	}
+
+	var payload struct {
+		Type    string  `json:"type"`
+		OrderID int     `json:"orderID"`
+		UserID  int     `json:"userID"`
+		Amount  float64 `json:"amount"`
+	}
+	if err := json.Unmarshal(events[0].Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.Type != "OrderCreated" || payload.OrderID != order.ID || payload.UserID != 7 || payload.Amount != 25 {
+		t.Errorf("payload = %+v, want OrderCreated for order %d", payload, order.ID)
+	}
+}
+
+func TestOrderCreatedCarriesPaymentMethod(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+	order := model.Order{UserID: 7, Email: "ann@example.com", Amount: 25, PaymentMethod: model.PaymentSplit, BalanceAmount: 10, ProviderMethod: "fake_success"}
+	if _, err := s.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	events, err := store.PendingOutboxEvents(ctx, 10)
+	if err != nil || len(events) != 1 {
+		t.Fatalf("PendingOutboxEvents = %+v, %v; want one event", events, err)
+	}
+	var payload struct {
+		PaymentMethod  string  `json:"paymentMethod"`
+		BalanceAmount  float64 `json:"balanceAmount"`
+		ProviderMethod string  `json:"providerMethod"`
+	}
+	if err := json.Unmarshal(events[0].Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.PaymentMethod != model.PaymentSplit || payload.BalanceAmount != 10 || payload.ProviderMethod != "fake_success" {
+		t.Errorf("payload = %+v, want the split payment of the order", payload)
+	}
+}
+
+func TestOutboxRelayPublishesPendingEvents(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	relay := NewOutboxRelay(store, broker)
+
+	received := make(chan []byte, 1)
+	err := broker.Consume("order.*", func(ctx context.Context, body []byte) error {
+		received <- body
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	if _, err := NewOrderService(store, relay).CreateOrder(ctx, model.Order{UserID: 7, Amount: 25}); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := relay.Flush(ctx); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("order.created was not published")
+	}
+
+	if events, _ := store.PendingOutboxEvents(ctx, 10); len(events) != 0 {
+		t.Errorf("%d events still pending after flush", len(events))
+	}
+}
+
+func TestGetOrderByIDNotFound(t *testing.T) {
+	store := repository.NewMemoryStore()
+	s := NewOrderService(store, NewOutboxRelay(store, messaging.NewMemoryBroker()))
+
+	if _, err := s.GetOrderByID(context.Background(), 42); !errors.Is(err, model.ErrOrderNotFound) {
+		t.Errorf("GetOrderByID error = %v, want %v", err, model.ErrOrderNotFound)
+	}
+}
+
+func TestUpdateStatusPublishesLifecycleEvents(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+	order, err := s.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 25})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	// Отправить можно только оплаченный заказ
+	if err := s.UpdateStatus(ctx, order.ID, "shipped"); !errors.Is(err, model.ErrStatusTransition) {
+		t.Fatalf("UpdateStatus(shipped) of an unpaid order: error = %v, want %v", err, model.ErrStatusTransition)
+	}
+	if err := s.ApplyPaymentEvent(ctx, model.PaymentEvent{Type: "PaymentCompleted", OrderID: order.ID}); err != nil {
+		t.Fatalf("ApplyPaymentEvent: %v", err)
+	}
+	// Повторный shipped не дублирует уведомление
+	for _, status := range []string{"shipped", "shipped", "delivered"} {
+		if err := s.UpdateStatus(ctx, order.ID, status); err != nil {
+			t.Fatalf("UpdateStatus(%s): %v", status, err)
+		}
+	}
+	if err := s.UpdateStatus(ctx, order.ID, "cancelled"); !errors.Is(err, model.ErrStatusTransition) {
+		t.Errorf("UpdateStatus(cancelled) of a delivered order: error = %v, want %v", err, model.ErrStatusTransition)
+	}
+
+	events, err := store.PendingOutboxEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingOutboxEvents: %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.EventType)
+	}
+	want := []string{"order.created", "order.shipped", "order.delivered"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("outbox = %v, want %v", got, want)
+	}
+
+	var payload struct {
+		EventID string `json:"eventID"`
+		Type    string `json:"type"`
+		OrderID int    `json:"orderID"`
+	}
+	if err := json.Unmarshal(events[2].Payload, &payload); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	if payload.EventID == "" || payload.Type != "OrderDelivered" || payload.OrderID != order.ID {
+		t.Errorf("payload = %+v, want OrderDelivered with an event ID for order %d", payload, order.ID)
+	}
+
+	if err := s.UpdateStatus(ctx, order.ID+1, "cancelled"); !errors.Is(err, model.ErrOrderNotFound) {
+		t.Errorf("UpdateStatus of a missing order: error = %v, want %v", err, model.ErrOrderNotFound)
+	}
+}
+
+func TestApplyPaymentEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		cancel bool
+		events []string
+		want   string
+	}{
+		{"authorized and captured", false, []string{"PaymentAuthorized", "PaymentCompleted"}, "paid"},
+		{"authorization after the capture", false, []string{"PaymentCompleted", "PaymentAuthorized"}, "paid"},
+		{"declined", false, []string{"PaymentFailed"}, "failed"},
+		{"captured after the cancellation", true, []string{"PaymentCompleted"}, "cancelled"},
+		{"failed after the cancellation", true, []string{"PaymentAuthorized", "PaymentFailed"}, "cancelled"},
+		{"voided after the cancellation", true, []string{"PaymentVoided"}, "cancelled"},
+		{"refunded after the cancellation", true, []string{"PaymentRefunded"}, "refunded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := repository.NewMemoryStore()
+			broker := messaging.NewMemoryBroker()
+			defer broker.Close()
+			s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+			order, err := s.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 25})
+			if err != nil {
+				t.Fatalf("CreateOrder: %v", err)
+			}
+			if tt.cancel {
+				if err := s.UpdateStatus(ctx, order.ID, "cancelled"); err != nil {
+					t.Fatalf("UpdateStatus: %v", err)
+				}
+			}
+			for _, eventType := range tt.events {
+				if err := s.ApplyPaymentEvent(ctx, model.PaymentEvent{Type: eventType, OrderID: order.ID}); err != nil {
+					t.Fatalf("ApplyPaymentEvent(%s): %v", eventType, err)
+				}
+			}
+
+			if got, _ := s.GetOrderByID(ctx, order.ID); got.Status != tt.want {
+				t.Errorf("status = %q, want %q", got.Status, tt.want)
+			}
+		})
+	}
+
+	s := NewOrderService(repository.NewMemoryStore(), nil)
+	if err := s.ApplyPaymentEvent(context.Background(), model.PaymentEvent{Type: "PaymentLost", OrderID: 1}); err == nil {
+		t.Error("ApplyPaymentEvent of an unknown type succeeded, want an error")
+	}
+}
+
+func TestApplyUserEvent(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+	order, err := s.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: 25})
+	if err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	events := []struct {
+		event model.UserEvent
+		want  string
+	}{
+		{model.UserEvent{Type: "UserUpdated", UserID: 7, Email: "anna@example.com"}, "anna@example.com"},
+		{model.UserEvent{Type: "UserErased", UserID: 7}, ""},
+	}
+	for _, tt := range events {
+		if err := s.ApplyUserEvent(ctx, tt.event); err != nil {
+			t.Fatalf("ApplyUserEvent(%s): %v", tt.event.Type, err)
+		}
+		got, err := s.GetOrderByID(ctx, order.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if got.Email != tt.want {
+			t.Errorf("email after %s = %q, want %q", tt.event.Type, got.Email, tt.want)
+		}
+	}
+
+	if err := s.ApplyUserEvent(ctx, model.UserEvent{Type: "UserUpdated", UserID: 7}); err == nil {
+		t.Error("ApplyUserEvent without an email succeeded, want an error")
+	}
+}
+
+func TestExportOrders(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	defer broker.Close()
+	s := NewOrderService(store, NewOutboxRelay(store, broker))
+
+	// Больше одной страницы, чтобы выгрузка прошла по курсору
+	var want []int
+	for i := range 102 {
+		order, err := s.CreateOrder(ctx, model.Order{UserID: 7, Email: "ann@example.com", Amount: float64(i + 1)})
+		if err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		want = append(want, order.ID)
+	}
+	if _, err := s.CreateOrder(ctx, model.Order{UserID: 8, Email: "bob@example.com", Amount: 5}); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	orders, err := s.ExportOrders(ctx, 7)
+	if err != nil {
+		t.Fatalf("ExportOrders: %v", err)
+	}
+	var got []int
+	for _, order := range orders {
+		got = append(got, order.ID)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("exported order IDs = %v, want %v", got, want)
+	}
+}
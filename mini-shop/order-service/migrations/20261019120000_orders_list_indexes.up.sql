@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_orders_user_created_at ON orders (userID, createdAt, id);
+CREATE INDEX IF NOT EXISTS idx_orders_user_amount ON orders (userID, amount, id);
+CREATE INDEX IF NOT EXISTS idx_orders_user_status ON orders (userID, status);
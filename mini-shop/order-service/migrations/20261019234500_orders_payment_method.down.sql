@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN IF EXISTS providerMethod;
+ALTER TABLE orders DROP COLUMN IF EXISTS balanceAmount;
+ALTER TABLE orders DROP COLUMN IF EXISTS paymentMethod;
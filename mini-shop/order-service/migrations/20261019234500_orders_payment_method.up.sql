@@ -0,0 +1,3 @@
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paymentMethod VARCHAR(20) NOT NULL DEFAULT 'balance';
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS balanceAmount DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS providerMethod VARCHAR(50) NOT NULL DEFAULT '';
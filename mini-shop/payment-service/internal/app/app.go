@@ -1,67 +1,194 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/Viltsev/minishop/payment-service/internal/handler"
 	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/metrics"
 	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
 	"github.com/Viltsev/minishop/payment-service/internal/repository"
 	"github.com/Viltsev/minishop/payment-service/internal/service"
+	"github.com/Viltsev/minishop/payment-service/internal/tracing"
+	"github.com/Viltsev/minishop/payment-service/migrations"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"mini-shop/platform/database"
+	"mini-shop/platform/health"
+	"mini-shop/platform/logger"
 )
 
+// Options configures one instance of the payment service.
+type Options struct {
+	Addr string
+
+	DBHost     string
+	DBPort     string
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DBSSLMode  string
+
+	// Broker carries order and payment events. It is owned by the caller.
+	Broker messaging.Broker
+	// UserServiceURL is the base URL of the user service, used to withdraw funds.
+	UserServiceURL string
+
+	// Provider names the payment provider that collects balance top-ups and
+	// checkout payments.
+	Provider string
+	// WebhookSecret signs the webhooks of the provider.
+	WebhookSecret string
+	// PublicURL is the base URL the provider reaches the service at.
+	PublicURL string
+	// FakeDelay is how long the fake provider takes for delayed payments.
+	FakeDelay time.Duration
+}
+
+// Run connects to the database, applies migrations and serves the API until
+// ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	cfg := database.Config{
+		Host:     opts.DBHost,
+		Port:     opts.DBPort,
+		User:     opts.DBUser,
+		Password: opts.DBPassword,
+		DBName:   opts.DBName,
+		SSLMode:  opts.DBSSLMode,
+	}
+
+	slog.Info("connecting to database", "host", cfg.Host, "port", cfg.Port, "db", cfg.DBName)
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+	defer db.Close()
+	slog.Info("database connection established")
+
+	slog.Info("running migrations")
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	slog.Info("migrations applied")
+
+	payments, err := provider.New(opts.Provider, provider.Config{
+		WebhookURL:    opts.PublicURL + "/api/v1/topups/webhook/" + opts.Provider,
+		WebhookSecret: opts.WebhookSecret,
+		FakeDelay:     opts.FakeDelay,
+	})
+	if err != nil {
+		return fmt.Errorf("payment provider: %w", err)
+	}
+
+	return NewAPIServer(opts.Addr, db, opts.Broker, opts.UserServiceURL, payments).Run(ctx)
+}
+
 type APIServer struct {
-	addr     string
-	db       *sql.DB
-	rabbitMQ *messaging.RabbitMQ
+	addr           string
+	db             *sql.DB
+	broker         messaging.Broker
+	userServiceURL string
+	provider       provider.PaymentProvider
 }
 
-func NewAPIServer(addr string, db *sql.DB, rabbitMQ *messaging.RabbitMQ) *APIServer {
+func NewAPIServer(addr string, db *sql.DB, broker messaging.Broker, userServiceURL string, provider provider.PaymentProvider) *APIServer {
 	return &APIServer{
-		addr:     addr,
-		db:       db,
-		rabbitMQ: rabbitMQ,
+		addr:           addr,
+		db:             db,
+		broker:         broker,
+		userServiceURL: userServiceURL,
+		provider:       provider,
 	}
 }
 
-func (s *APIServer) Run() error {
+func (s *APIServer) Run(ctx context.Context) error {
 	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(logger.Middleware)
+	router.Use(metrics.Common.Middleware)
+
+	metrics.Common.RegisterDB(s.db, "payments")
+	metrics.Common.RegisterRoutes(router)
+
+	checker := health.NewChecker()
+	checker.Register("database", s.db.PingContext)
+	checker.Register("migrations", func(ctx context.Context) error {
+		return database.CheckMigrations(ctx, s.db, migrations.FS)
+	})
+	checker.Register("broker", s.broker.Check)
+	checker.Register("consumer", func(ctx context.Context) error {
+		errs := []error{s.broker.CheckConsumer("order.created"), s.broker.CheckConsumer("order.cancelled")}
+		for _, key := range userEventKeys {
+			errs = append(errs, s.broker.CheckConsumer(key))
+		}
+		return errors.Join(errs...)
+	})
+	checker.RegisterRoutes(router)
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
 	paymentStore := repository.NewStore(s.db)
 
-	paymentService := service.NewPaymentService(paymentStore, s.rabbitMQ)
+	outbox := service.NewOutboxRelay(paymentStore, s.broker)
+	go outbox.Run(ctx)
+
+	paymentService := service.NewPaymentService(paymentStore, outbox, s.provider)
+	userServiceClient := service.NewUserServiceClient(s.userServiceURL)
 	paymentHandler := handler.NewPaymentHandler(paymentStore, paymentService)
 	paymentHandler.RegisterRoutes(subrouter)
 
+	topUpService := service.NewTopUpService(paymentStore, s.provider, s.userServiceURL)
+	handler.NewTopUpHandler(paymentStore, topUpService).RegisterRoutes(subrouter)
+
+	if err := s.startOrderCreatedListener(paymentService, userServiceClient); err != nil {
+		return fmt.Errorf("failed to start order.created listener: %w", err)
+	}
+	if err := s.startOrderCancelledListener(paymentService, userServiceClient); err != nil {
+		return fmt.Errorf("failed to start order.cancelled listener: %w", err)
+	}
+	if err := s.startUserEventListener(paymentService); err != nil {
+		return fmt.Errorf("failed to start user event listener: %w", err)
+	}
+
+	slog.Info("server listening", "addr", s.addr)
+	return serve(ctx, s.addr, router)
+}
+
+// serve runs the HTTP server until ctx is cancelled, then shuts it down gracefully.
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
 	go func() {
-		if err := s.startOrderCreatedListener(paymentService); err != nil {
-			log.Fatalf("failed to start order.created listener: %v", err)
-		}
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
 	}()
 
-	log.Println("Server starts on http://localhost:8082")
-	return http.ListenAndServe(s.addr, router)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 // startOrderCreatedListener подписывается на очередь "order.created" и обрабатывает события создания заказа
-func (s *APIServer) startOrderCreatedListener(paymentService *service.PaymentService) error {
-	log.Println("[Listener] Initializing order.created consumer...")
-	return s.rabbitMQ.Consume("order.created", func(body []byte) {
-		log.Println("[Listener] Received raw order.created event:", string(body))
-
+func (s *APIServer) startOrderCreatedListener(paymentService *service.PaymentService, userServiceClient *service.UserServiceClient) error {
+	slog.Info("initializing consumer", "binding_key", "order.created")
+	return s.broker.Consume("order.created", func(ctx context.Context, body []byte) error {
 		var orderEvent model.OrderCreatedEvent
 		if err := json.Unmarshal(body, &orderEvent); err != nil {
-			log.Printf("[Listener] Failed to unmarshal order event: %v", err)
-			return
+			return fmt.Errorf("failed to unmarshal order event: %w", err)
 		}
 
-		log.Printf("[Listener] Parsed order event: %+v", orderEvent)
+		slog.InfoContext(ctx, "order created event received", "order_id", orderEvent.OrderID, "user_id", orderEvent.UserID)
 
 		payment := model.Payment{
 			OrderID: orderEvent.OrderID,
@@ -69,16 +196,58 @@ func (s *APIServer) startOrderCreatedListener(paymentService *service.PaymentSer
 			Email:   orderEvent.Email,
 			Amount:  orderEvent.Amount,
 			Status:  "pending",
-		}
 
-		url := fmt.Sprintf("http://user-service:8080/api/v1/balance/%d", orderEvent.UserID)
-		userServiceClient := service.NewUserServiceClient(url)
+			Method:         orderEvent.PaymentMethod,
+			BalanceAmount:  orderEvent.BalanceAmount,
+			ProviderMethod: orderEvent.ProviderMethod,
+		}
 
-		_, err := paymentService.ProcessPayment(payment, userServiceClient)
+		_, err := paymentService.ProcessPayment(ctx, payment, userServiceClient)
 		if err != nil {
-			log.Printf("[Listener] Failed to process payment: %v", err)
-		} else {
-			log.Println("[Listener] Payment processed successfully for order:", orderEvent.OrderID)
+			return fmt.Errorf("failed to process payment: %w", err)
 		}
+
+		slog.InfoContext(ctx, "payment processed", "order_id", orderEvent.OrderID)
+		return nil
 	})
 }
+
+// startOrderCancelledListener возвращает или отпускает деньги за отменённые заказы
+func (s *APIServer) startOrderCancelledListener(paymentService *service.PaymentService, userServiceClient *service.UserServiceClient) error {
+	slog.Info("initializing consumer", "binding_key", "order.cancelled")
+	return s.broker.Consume("order.cancelled", func(ctx context.Context, body []byte) error {
+		var orderEvent model.OrderCancelledEvent
+		if err := json.Unmarshal(body, &orderEvent); err != nil {
+			return fmt.Errorf("failed to unmarshal order event: %w", err)
+		}
+
+		slog.InfoContext(ctx, "order cancelled event received", "order_id", orderEvent.OrderID, "user_id", orderEvent.UserID)
+
+		if _, err := paymentService.RefundPayment(ctx, orderEvent.OrderID, orderEvent.UserID, userServiceClient); err != nil {
+			return fmt.Errorf("failed to refund payment: %w", err)
+		}
+		return nil
+	})
+}
+
+// userEventKeys are the user-service events that change the email copied onto
+// payments.
+var userEventKeys = []string{"user.updated", "user.erased"}
+
+// startUserEventListener обновляет копию почты в платежах при изменении или стирании пользователя
+func (s *APIServer) startUserEventListener(paymentService *service.PaymentService) error {
+	for _, key := range userEventKeys {
+		slog.Info("initializing consumer", "binding_key", key)
+		err := s.broker.Consume(key, func(ctx context.Context, body []byte) error {
+			var event model.UserEvent
+			if err := json.Unmarshal(body, &event); err != nil {
+				return fmt.Errorf("failed to unmarshal user event: %w", err)
+			}
+			return paymentService.ApplyUserEvent(ctx, event)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -3,15 +3,17 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Viltsev/minishop/payment-service/internal/config"
 	"github.com/Viltsev/minishop/payment-service/internal/model"
 	"github.com/Viltsev/minishop/payment-service/internal/utils"
 	"github.com/golang-jwt/jwt"
+	"mini-shop/platform/problem"
 )
 
 type contextKey string
@@ -24,8 +26,8 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store model.PaymentStore) http.Ha
 
 		userID, err := GetUserIDFromToken(tokenString)
 		if err != nil {
-			log.Printf("failed to validate token: %v", err)
-			permissionDenied(w)
+			slog.WarnContext(r.Context(), "failed to validate token", "error", err)
+			permissionDenied(w, r)
 			return
 		}
 
@@ -67,6 +69,16 @@ func GetUserIDFromToken(tokenString string) (int, error) {
 	return userID, nil
 }
 
+// CreateServiceJWT issues a token for the named service; user-service only
+// moves money for requests carrying one. It expires after ttl.
+func CreateServiceJWT(secret []byte, service string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"service": service,
+		"exp":     time.Now().Add(ttl).Unix(),
+	})
+	return token.SignedString(secret)
+}
+
 func validateJWT(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -76,6 +88,6 @@ func validateJWT(tokenString string) (*jwt.Token, error) {
 	})
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	problem.WriteError(w, r, model.ErrPermissionDenied)
 }
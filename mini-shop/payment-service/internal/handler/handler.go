@@ -2,6 +2,7 @@ package handler
 
 import (
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/Viltsev/minishop/payment-service/internal/auth"
@@ -9,6 +10,8 @@ import (
 	"github.com/Viltsev/minishop/payment-service/internal/service"
 	"github.com/Viltsev/minishop/payment-service/internal/utils"
 	"github.com/gorilla/mux"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
 )
 
 type Handler struct {
@@ -25,31 +28,76 @@ func (h *Handler) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/payments/user", auth.WithJWTAuth(h.ListPaymentsByUser, h.store)).Methods("GET")
 	// Получение конкретного платежа (можно защитить или оставить открытым, по желанию)
 	router.HandleFunc("/payments/{id:[0-9]+}", h.GetPaymentByID).Methods("GET")
+	// Часть выгрузки данных пользователя, её собирает user-service
+	router.HandleFunc("/me/export", auth.WithJWTAuth(h.ExportMe, h.store)).Methods("GET")
 }
 
 func (h *Handler) ListPaymentsByUser(w http.ResponseWriter, r *http.Request) {
 	userID := r.Context().Value(auth.UserKey).(int)
 
-	payments, err := h.service.ListPaymentsByUser(userID)
+	params, err := pagination.Parse(r.URL.Query(), model.PaymentSorts, "-createdAt")
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, payments)
+	filter, err := parsePaymentFilter(r.URL.Query())
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	page, err := h.service.ListPaymentsByUser(r.Context(), userID, filter, params)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
 }
 
-func (h *Handler) GetPaymentByID(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id, _ := strconv.Atoi(idStr)
+func parsePaymentFilter(q url.Values) (model.PaymentFilter, error) {
+	var (
+		filter = model.PaymentFilter{Status: q.Get("status")}
+		err    error
+	)
+	if filter.CreatedFrom, err = pagination.TimeParam(q, "createdFrom"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = pagination.TimeParam(q, "createdTo"); err != nil {
+		return filter, err
+	}
+	if filter.MinAmount, err = pagination.FloatParam(q, "minAmount"); err != nil {
+		return filter, err
+	}
+	if filter.MaxAmount, err = pagination.FloatParam(q, "maxAmount"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func (h *Handler) ExportMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	payments, err := h.service.ExportPayments(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"payments": payments})
+}
 
-	payment, err := h.service.GetPaymentByID(id)
+func (h *Handler) GetPaymentByID(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, model.InvalidInput("invalid payment ID"))
 		return
 	}
-	if payment == nil {
-		http.NotFound(w, r)
+
+	payment, err := h.service.GetPaymentByID(r.Context(), id)
+	if err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
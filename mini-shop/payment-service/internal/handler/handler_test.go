@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/minishop/payment-service/internal/config"
+	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/repository"
+	"github.com/Viltsev/minishop/payment-service/internal/service"
+	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/mux"
+	"mini-shop/platform/problem"
+)
+
+func newTestRouter(t *testing.T, payments ...model.Payment) *mux.Router {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	for _, payment := range payments {
+		if _, err := store.CreatePayment(context.Background(), payment); err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+	}
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	router := mux.NewRouter()
+	NewPaymentHandler(store, service.NewPaymentService(store, service.NewOutboxRelay(store, broker), nil)).RegisterRoutes(router)
+	return router
+}
+
+func testToken(t *testing.T, userID string) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"userID":    userID,
+		"email":     "ann@example.com",
+		"expiredAt": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(config.Envs.JWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func serve(router http.Handler, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return body.Code
+}
+
+func TestListPaymentsByUser(t *testing.T) {
+	router := newTestRouter(t,
+		model.Payment{OrderID: 1, UserID: 7, Amount: 10, Status: "completed"},
+		model.Payment{OrderID: 2, UserID: 8, Amount: 20, Status: "completed"},
+		model.Payment{OrderID: 3, UserID: 7, Amount: 30, Status: "failed"},
+	)
+
+	rec := serve(router, "/payments/user?sort=amount", testToken(t, "7"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	var page struct {
+		Items []model.Payment `json:"items"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("decode page: %v", err)
+	}
+	if len(page.Items) != 2 || page.Items[0].OrderID != 1 || page.Items[1].OrderID != 3 {
+		t.Errorf("items = %+v, want orders 1 and 3 of user 7", page.Items)
+	}
+}
+
+func TestListPaymentsRequiresToken(t *testing.T) {
+	rec := serve(newTestRouter(t), "/payments/user", "")
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if code := problemCode(t, rec); code != "permission_denied" {
+		t.Errorf("code %q, want permission_denied", code)
+	}
+}
+
+func TestGetPaymentByID(t *testing.T) {
+	router := newTestRouter(t, model.Payment{OrderID: 1, UserID: 7, Amount: 10, Status: "completed"})
+
+	if rec := serve(router, "/payments/1", ""); rec.Code != http.StatusOK {
+		t.Errorf("existing payment: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec := serve(router, "/payments/42", "")
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("missing payment: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if code := problemCode(t, rec); code != "payment_not_found" {
+		t.Errorf("missing payment: code %q, want payment_not_found", code)
+	}
+}
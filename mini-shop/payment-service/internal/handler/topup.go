@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/Viltsev/minishop/payment-service/internal/auth"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/service"
+	"github.com/Viltsev/minishop/payment-service/internal/utils"
+	"github.com/gorilla/mux"
+	"mini-shop/platform/problem"
+)
+
+// maxWebhookBody limits the webhook bodies read for signature checks.
+const maxWebhookBody = 64 << 10
+
+type TopUpHandler struct {
+	store   model.PaymentStore
+	service *service.TopUpService
+}
+
+func NewTopUpHandler(store model.PaymentStore, service *service.TopUpService) *TopUpHandler {
+	return &TopUpHandler{store: store, service: service}
+}
+
+func (h *TopUpHandler) RegisterRoutes(router *mux.Router) {
+	// Пополнение баланса через платёжного провайдера
+	router.HandleFunc("/topups", auth.WithJWTAuth(h.CreateTopUp, h.store)).Methods("POST")
+	router.HandleFunc("/topups/{id:[0-9]+}", auth.WithJWTAuth(h.GetTopUp, h.store)).Methods("GET")
+	router.HandleFunc("/topups/{id:[0-9]+}/confirm", auth.WithJWTAuth(h.ConfirmTopUp, h.store)).Methods("POST")
+	// Провайдер сообщает об исходе платежа; запрос подписан, токена нет
+	router.HandleFunc("/topups/webhook/{provider}", h.Webhook).Methods("POST")
+}
+
+func (h *TopUpHandler) CreateTopUp(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.CreateTopUpPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	topUp, err := h.service.Create(r.Context(), userID, payload.Amount)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, topUp)
+}
+
+func (h *TopUpHandler) GetTopUp(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid top-up ID"))
+		return
+	}
+
+	topUp, err := h.service.Get(r.Context(), userID, id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, topUp)
+}
+
+func (h *TopUpHandler) ConfirmTopUp(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid top-up ID"))
+		return
+	}
+
+	var payload model.ConfirmTopUpPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	topUp, err := h.service.Confirm(r.Context(), userID, id, payload.PaymentMethod)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, topUp)
+}
+
+func (h *TopUpHandler) Webhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("failed to read body: %v", err))
+		return
+	}
+
+	if err := h.service.HandleWebhook(r.Context(), mux.Vars(r)["provider"], r.Header, body); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
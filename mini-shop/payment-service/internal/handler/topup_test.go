@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+	"github.com/Viltsev/minishop/payment-service/internal/repository"
+	"github.com/Viltsev/minishop/payment-service/internal/service"
+	"github.com/gorilla/mux"
+)
+
+// newTopUpServer serves the top-up routes with the fake provider, which sends
+// its webhooks back to the server, and a user service that takes every
+// credit.
+func newTopUpServer(t *testing.T) (*httptest.Server, *provider.FakeProvider) {
+	t.Helper()
+
+	users := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(users.Close)
+
+	router := mux.NewRouter()
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	store := repository.NewMemoryStore()
+	payments := provider.NewFakeProvider(srv.URL+"/topups/webhook/fake", "secret", 0)
+	NewTopUpHandler(store, service.NewTopUpService(store, payments, users.URL)).RegisterRoutes(router)
+	return srv, payments
+}
+
+func send(t *testing.T, method, url, token string, body any) *http.Response {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("build request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestTopUpThroughFakeProvider(t *testing.T) {
+	srv, payments := newTopUpServer(t)
+	token := testToken(t, "7")
+
+	resp := send(t, http.MethodPost, srv.URL+"/topups", token, map[string]float64{"amount": 25})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	var topUp model.TopUp
+	if err := json.NewDecoder(resp.Body).Decode(&topUp); err != nil {
+		t.Fatalf("decode top-up: %v", err)
+	}
+
+	url := srv.URL + "/topups/" + strconv.Itoa(topUp.ID)
+	if resp := send(t, http.MethodGet, url, testToken(t, "8"), nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("top-up of another user: status %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	if resp := send(t, http.MethodPost, url+"/confirm", token, map[string]string{"paymentMethod": provider.FakeSuccess}); resp.StatusCode != http.StatusOK {
+		t.Fatalf("confirm: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	payments.Wait()
+
+	resp = send(t, http.MethodGet, url, token, nil)
+	if err := json.NewDecoder(resp.Body).Decode(&topUp); err != nil {
+		t.Fatalf("decode top-up: %v", err)
+	}
+	if topUp.Status != model.TopUpCredited {
+		t.Errorf("status = %q, want %q", topUp.Status, model.TopUpCredited)
+	}
+}
+
+func TestTopUpRequests(t *testing.T) {
+	srv, _ := newTopUpServer(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		body   any
+		status int
+	}{
+		{"create without a token", "/topups", "", map[string]float64{"amount": 25}, http.StatusForbidden},
+		{"create without an amount", "/topups", testToken(t, "7"), map[string]float64{}, http.StatusBadRequest},
+		{"unsigned webhook", "/topups/webhook/fake", "", map[string]string{"intentID": "pi_fake_1", "status": "succeeded"}, http.StatusUnauthorized},
+		{"webhook of an unknown provider", "/topups/webhook/acquirer", "", map[string]string{}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := send(t, http.MethodPost, srv.URL+tt.path, tt.token, tt.body); resp.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
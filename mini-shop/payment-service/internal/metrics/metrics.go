@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	platform "mini-shop/platform/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Common are the HTTP and broker metrics of payment-service. The metrics below
+// share its "service" label.
+var Common = platform.New("payment-service")
+
+var (
+	PaymentsCompleted = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "payments_completed_total",
+		Help:      "Payments completed.",
+	})
+
+	PaymentsRefunded = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "payments_refunded_total",
+		Help:      "Payments refunded after the order was cancelled.",
+	})
+
+	PaymentsFailed = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "payments_failed_total",
+		Help:      "Payments failed, by reason.",
+	}, []string{"reason"})
+
+	TopUps = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "top_ups_total",
+		Help:      "Balance top-ups that were credited or failed, by status.",
+	}, []string{"status"})
+)
@@ -0,0 +1,42 @@
+package model
+
+import "mini-shop/platform/problem"
+
+// Domain errors are the shared problem errors, so every service reports them
+// the same way.
+type (
+	ErrorKind  = problem.ErrorKind
+	Error      = problem.Error
+	FieldError = problem.FieldError
+)
+
+const (
+	KindInternal          = problem.KindInternal
+	KindInvalid           = problem.KindInvalid
+	KindUnauthorized      = problem.KindUnauthorized
+	KindForbidden         = problem.KindForbidden
+	KindNotFound          = problem.KindNotFound
+	KindConflict          = problem.KindConflict
+	KindInsufficientFunds = problem.KindInsufficientFunds
+	KindUnavailable       = problem.KindUnavailable
+)
+
+var (
+	ErrPaymentNotFound  = &Error{Kind: KindNotFound, Code: "payment_not_found", Message: "payment not found"}
+	ErrPaymentExists    = &Error{Kind: KindConflict, Code: "payment_exists", Message: "order already has a payment"}
+	ErrPermissionDenied = &Error{Kind: KindForbidden, Code: "permission_denied", Message: "permission denied"}
+
+	ErrTopUpNotFound     = &Error{Kind: KindNotFound, Code: "top_up_not_found", Message: "top-up not found"}
+	ErrTopUpConfirmed    = &Error{Kind: KindConflict, Code: "top_up_already_confirmed", Message: "top-up is already confirmed"}
+	ErrInvalidSignature  = &Error{Kind: KindUnauthorized, Code: "invalid_signature", Message: "webhook signature is invalid"}
+	ErrProviderFailed    = &Error{Kind: KindUnavailable, Code: "provider_unavailable", Message: "payment provider is unavailable, try again later"}
+	ErrCreditUnavailable = &Error{Kind: KindUnavailable, Code: "credit_unavailable", Message: "balance cannot be credited right now"}
+)
+
+func InvalidInput(format string, args ...any) error {
+	return problem.InvalidInput(format, args...)
+}
+
+func ValidationFailed(fields []FieldError) error {
+	return problem.ValidationFailed(fields)
+}
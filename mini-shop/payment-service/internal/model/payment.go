@@ -1,14 +1,43 @@
 package model
 
-import "time"
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
+)
 
 type PaymentStore interface {
-	CreatePayment(payment Payment) (*Payment, error)
-	GetPaymentByID(id int) (*Payment, error)
-	UpdatePaymentStatus(id int, status string) error
-	ListPaymentsByUser(userID int) ([]Payment, error)
+	CreatePayment(ctx context.Context, payment Payment) (*Payment, error)
+	GetPaymentByID(ctx context.Context, id int) (*Payment, error)
+	GetPaymentByOrderID(ctx context.Context, orderID int) (*Payment, error)
+	// LockPaymentByOrderID returns the payment of orderID and locks it until
+	// the transaction ends, or nil, nil when there is none.
+	LockPaymentByOrderID(ctx context.Context, orderID int) (*Payment, error)
+	UpdatePaymentStatus(ctx context.Context, id int, status string) error
+	// UpdatePaymentAuthorization stores the provider's authorization of a
+	// payment stored before the money was authorized.
+	UpdatePaymentAuthorization(ctx context.Context, id int, authorizationID string) error
+	ListPaymentsByUser(ctx context.Context, userID int, filter PaymentFilter, params pagination.Params) ([]Payment, error)
+	// UpdateUserEmail replaces the email copy on every payment of userID.
+	UpdateUserEmail(ctx context.Context, userID int, email string) error
+	AddOutboxEvent(ctx context.Context, event OutboxEvent) error
+	PendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkOutboxEventSent(ctx context.Context, id int) error
+	TopUpStore
+	// InTx runs fn inside a single database transaction.
+	InTx(ctx context.Context, fn func(tx PaymentStore) error) error
 }
 
+// Способы оплаты заказа
+const (
+	MethodBalance  = "balance"
+	MethodProvider = "provider"
+	MethodSplit    = "split"
+)
+
 type Payment struct {
 	ID        int       `db:"id"`
 	OrderID   int       `db:"order_id"`
@@ -17,20 +46,61 @@ type Payment struct {
 	Amount    float64   `db:"amount"`
 	Status    string    `db:"status"`
 	CreatedAt time.Time `db:"created_at"`
+
+	// Method is how the order is paid: one of the Method constants.
+	Method string `db:"method"`
+	// BalanceAmount and ProviderAmount are the parts of Amount taken from
+	// the balance and through the provider.
+	BalanceAmount  float64 `db:"balance_amount"`
+	ProviderAmount float64 `db:"provider_amount"`
+	// ProviderMethod is the payment method the provider charges.
+	ProviderMethod string `db:"provider_method"`
+	// AuthorizationID is the provider's authorization of ProviderAmount.
+	AuthorizationID string `db:"authorization_id"`
+}
+
+// PaymentSorts lists the fields a user's payments can be sorted by.
+var PaymentSorts = []string{"createdAt", "amount"}
+
+type PaymentFilter struct {
+	Status      string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinAmount   *float64
+	MaxAmount   *float64
 }
 
-type OutboxEvent struct {
-	ID        int        `db:"id"`
-	EventType string     `db:"event_type"`
-	Payload   []byte     `db:"payload"`
-	Status    string     `db:"status"`
-	CreatedAt time.Time  `db:"created_at"`
-	SentAt    *time.Time `db:"sent_at"`
+// PaymentCursor builds the keyset cursor pointing at payment for the given sort.
+func PaymentCursor(payment Payment, sort string) pagination.Cursor {
+	if sort == "amount" {
+		return pagination.Cursor{Value: strconv.FormatFloat(payment.Amount, 'f', -1, 64), ID: payment.ID}
+	}
+	return pagination.Cursor{Value: payment.CreatedAt.Format(time.RFC3339Nano), ID: payment.ID}
 }
 
+// OutboxEvent is an event waiting in the outbox table to be published.
+type OutboxEvent = outbox.Event
+
 type OrderCreatedEvent struct {
 	OrderID int     `json:"orderID"`
 	UserID  int     `json:"userID"`
 	Email   string  `json:"email"`
 	Amount  float64 `json:"amount"`
+	// PaymentMethod is empty in events of orders placed before checkout
+	// had a choice; those are paid from the balance.
+	PaymentMethod  string  `json:"paymentMethod"`
+	BalanceAmount  float64 `json:"balanceAmount"`
+	ProviderMethod string  `json:"providerMethod"`
+}
+
+type OrderCancelledEvent struct {
+	OrderID int `json:"orderID"`
+	UserID  int `json:"userID"`
+}
+
+// UserEvent is a user.updated or user.erased event of user-service.
+type UserEvent struct {
+	Type   string `json:"type"`
+	UserID int    `json:"userID"`
+	Email  string `json:"email"`
 }
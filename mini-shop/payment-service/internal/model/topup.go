@@ -0,0 +1,61 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Статусы пополнения баланса
+const (
+	// TopUpPending waits for the user to confirm the payment.
+	TopUpPending = "pending"
+	// TopUpProcessing waits for the provider to collect the money.
+	TopUpProcessing = "processing"
+	// TopUpSucceeded has the money collected but not yet on the balance.
+	TopUpSucceeded = "succeeded"
+	// TopUpCredited has the money on the user's balance.
+	TopUpCredited = "credited"
+	TopUpFailed   = "failed"
+)
+
+// TopUpStore keeps balance top-ups paid through a payment provider.
+type TopUpStore interface {
+	CreateTopUp(ctx context.Context, topUp *TopUp) error
+	// GetTopUp returns nil, nil when there is no top-up with id.
+	GetTopUp(ctx context.Context, id int) (*TopUp, error)
+	// LockTopUpByIntent returns the top-up paid by the intent of provider and
+	// locks it until the transaction ends, or nil, nil when there is none.
+	LockTopUpByIntent(ctx context.Context, provider, intentID string) (*TopUp, error)
+	// UpdateTopUp saves the intent, status and failure reason of topUp.
+	UpdateTopUp(ctx context.Context, topUp *TopUp) error
+}
+
+// TopUp is money a user pays through a provider to be credited to their
+// balance in user-service.
+type TopUp struct {
+	ID       int     `json:"id"`
+	UserID   int     `json:"userID"`
+	Amount   float64 `json:"amount"`
+	Provider string  `json:"provider"`
+	IntentID string  `json:"intentID,omitempty"`
+	Status   string  `json:"status"`
+	// FailureReason says why a failed top-up did not go through.
+	FailureReason string    `json:"failureReason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// Reference names the top-up to the provider and to user-service, which
+// credits each reference once.
+func (t *TopUp) Reference() string {
+	return "topup:" + strconv.Itoa(t.ID)
+}
+
+type CreateTopUpPayload struct {
+	Amount float64 `json:"amount" validate:"gt=0,lte=100000"`
+}
+
+type ConfirmTopUpPayload struct {
+	PaymentMethod string `json:"paymentMethod" validate:"required,max=50"`
+}
@@ -0,0 +1,308 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"mini-shop/platform/logger"
+)
+
+// Способы оплаты, которые понимает FakeProvider
+const (
+	// FakeSuccess collects the money right away.
+	FakeSuccess = "fake_success"
+	// FakeDecline fails as a declined card would.
+	FakeDecline = "fake_decline"
+	// FakeDelay collects the money after the configured delay.
+	FakeDelay = "fake_delay"
+)
+
+// FakeDeclineReason is the failure reason of FakeDecline.
+const FakeDeclineReason = "card_declined"
+
+// FakeProvider simulates a payment provider in memory, so top-ups and
+// checkouts work offline. The payment method picks the outcome. Events are
+// delivered to the webhook URL like a real provider would: signed, and again
+// on failure.
+type FakeProvider struct {
+	webhookURL string
+	secret     []byte
+	delay      time.Duration
+	client     *http.Client
+
+	// attempts and backoff shape webhook redelivery.
+	attempts int
+	backoff  time.Duration
+
+	mu      sync.Mutex
+	intents map[string]*Intent
+	// byReference makes CreateIntent idempotent.
+	byReference map[string]string
+	lastID      int
+	// pending lets Wait block until every webhook is delivered.
+	pending sync.WaitGroup
+
+	authorizations map[string]*authorization
+	// authByReference makes Authorize idempotent.
+	authByReference map[string]string
+	lastAuthID      int
+}
+
+// authorization is an amount reserved at checkout.
+type authorization struct {
+	amount float64
+	status string
+}
+
+// Статусы авторизации у FakeProvider
+const (
+	authAuthorized = "authorized"
+	authCaptured   = "captured"
+	authVoided     = "voided"
+	authRefunded   = "refunded"
+)
+
+func NewFakeProvider(webhookURL, secret string, delay time.Duration) *FakeProvider {
+	return &FakeProvider{
+		webhookURL: webhookURL,
+		secret:     []byte(secret),
+		delay:      delay,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+		attempts:    5,
+		backoff:     500 * time.Millisecond,
+		intents:     map[string]*Intent{},
+		byReference: map[string]string{},
+
+		authorizations:  map[string]*authorization{},
+		authByReference: map[string]string{},
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return ProviderFake
+}
+
+func (p *FakeProvider) CreateIntent(ctx context.Context, amount float64, reference string) (*Intent, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.byReference[reference]; ok {
+		intent := *p.intents[id]
+		return &intent, nil
+	}
+
+	p.lastID++
+	intent := &Intent{
+		ID:        "pi_fake_" + strconv.Itoa(p.lastID),
+		Amount:    amount,
+		Reference: reference,
+		Status:    StatusRequiresConfirmation,
+	}
+	p.intents[intent.ID] = intent
+	p.byReference[reference] = intent.ID
+	created := *intent
+	return &created, nil
+}
+
+// Confirm starts collecting the money; the outcome follows as a webhook.
+// Confirming an intent that is already underway changes nothing.
+func (p *FakeProvider) Confirm(ctx context.Context, intentID, paymentMethod string) (*Intent, error) {
+	var delay time.Duration
+	switch paymentMethod {
+	case FakeSuccess, FakeDecline:
+	case FakeDelay:
+		delay = p.delay
+	default:
+		return nil, fmt.Errorf("%w %q", ErrPaymentMethod, paymentMethod)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	intent, ok := p.intents[intentID]
+	if !ok {
+		return nil, ErrIntentNotFound
+	}
+	if intent.Status != StatusRequiresConfirmation {
+		current := *intent
+		return &current, nil
+	}
+	intent.Status = StatusProcessing
+
+	event := Event{ID: "evt_" + logger.NewID(), IntentID: intent.ID, Status: StatusSucceeded}
+	if paymentMethod == FakeDecline {
+		event.Status, event.FailureReason = StatusFailed, FakeDeclineReason
+	}
+
+	p.pending.Add(1)
+	// Ответ провайдера приходит уже после запроса, поэтому отмена ctx его не
+	// останавливает
+	go p.settle(context.WithoutCancel(ctx), event, delay)
+
+	confirmed := *intent
+	return &confirmed, nil
+}
+
+// settle finishes the intent of event after delay and delivers event.
+func (p *FakeProvider) settle(ctx context.Context, event Event, delay time.Duration) {
+	defer p.pending.Done()
+
+	time.Sleep(delay)
+
+	p.mu.Lock()
+	intent := p.intents[event.IntentID]
+	intent.Status, intent.FailureReason = event.Status, event.FailureReason
+	p.mu.Unlock()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal webhook event", "intent_id", event.IntentID, "error", err)
+		return
+	}
+
+	backoff := p.backoff
+	for attempt := 1; ; attempt++ {
+		err := p.deliver(ctx, body)
+		if err == nil {
+			return
+		}
+		if attempt == p.attempts {
+			slog.ErrorContext(ctx, "webhook not delivered, giving up", "intent_id", event.IntentID, "attempts", attempt, "error", err)
+			return
+		}
+		slog.WarnContext(ctx, "webhook delivery failed, retrying", "intent_id", event.IntentID, "attempt", attempt, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *FakeProvider) deliver(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, "sha256="+Sign(p.secret, timestamp, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *FakeProvider) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := Verify(p.secret, header, body, time.Now()); err != nil {
+		return nil, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook event: %w", err)
+	}
+	return &event, nil
+}
+
+// Authorize reserves amount on the payment method. FakeDelay answers after
+// the configured delay, FakeDecline is refused.
+func (p *FakeProvider) Authorize(ctx context.Context, amount float64, paymentMethod, reference string) (string, error) {
+	switch paymentMethod {
+	case FakeSuccess:
+	case FakeDecline:
+		return "", fmt.Errorf("%w: %s", ErrDeclined, FakeDeclineReason)
+	case FakeDelay:
+		select {
+		case <-time.After(p.delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	default:
+		return "", fmt.Errorf("%w %q", ErrPaymentMethod, paymentMethod)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.authByReference[reference]; ok {
+		return id, nil
+	}
+	p.lastAuthID++
+	id := "auth_fake_" + strconv.Itoa(p.lastAuthID)
+	p.authorizations[id] = &authorization{amount: amount, status: authAuthorized}
+	p.authByReference[reference] = id
+	return id, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, authorizationID string) error {
+	return p.settleAuthorization(authorizationID, authCaptured)
+}
+
+func (p *FakeProvider) Void(ctx context.Context, authorizationID string) error {
+	return p.settleAuthorization(authorizationID, authVoided)
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, authorizationID string, amount float64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	auth, ok := p.authorizations[authorizationID]
+	if !ok {
+		return ErrAuthorizationNotFound
+	}
+	switch auth.status {
+	case authRefunded:
+		return nil
+	case authCaptured:
+	default:
+		return ErrNotCaptured
+	}
+	if amount <= 0 || amount > auth.amount {
+		return fmt.Errorf("%w: %v of %v", ErrRefundAmount, amount, auth.amount)
+	}
+	auth.status = authRefunded
+	return nil
+}
+
+// settleAuthorization moves an authorization to status, which it may reach
+// only from authorized.
+func (p *FakeProvider) settleAuthorization(authorizationID, status string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	auth, ok := p.authorizations[authorizationID]
+	if !ok {
+		return ErrAuthorizationNotFound
+	}
+	switch auth.status {
+	case status:
+	case authAuthorized:
+		auth.status = status
+	default:
+		return ErrAuthorizationCompleted
+	}
+	return nil
+}
+
+// Wait blocks until every confirmed intent has been settled and its webhook
+// delivered or given up on.
+func (p *FakeProvider) Wait() {
+	p.pending.Wait()
+}
@@ -0,0 +1,160 @@
+// Package provider takes money for balance top-ups and order checkouts
+// through pluggable payment providers.
+package provider
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Провайдеры, которые можно указать в конфигурации
+const (
+	ProviderFake = "fake"
+)
+
+// Статусы платёжного намерения у провайдера
+const (
+	StatusRequiresConfirmation = "requires_confirmation"
+	StatusProcessing           = "processing"
+	StatusSucceeded            = "succeeded"
+	StatusFailed               = "failed"
+)
+
+// Заголовки, по которым получатель проверяет подпись вебхука
+const (
+	SignatureHeader = "X-Minishop-Signature"
+	TimestampHeader = "X-Minishop-Timestamp"
+)
+
+// SignatureTolerance is how old a webhook may be before it is refused as a
+// replay.
+const SignatureTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrIntentNotFound   = errors.New("payment intent not found")
+	// ErrPaymentMethod means the provider does not accept the payment method.
+	ErrPaymentMethod = errors.New("unsupported payment method")
+	// ErrDeclined means the payer's bank refused the authorization.
+	ErrDeclined               = errors.New("payment declined")
+	ErrAuthorizationNotFound  = errors.New("authorization not found")
+	ErrAuthorizationCompleted = errors.New("authorization already captured or voided")
+	// ErrNotCaptured means there is no captured money to refund.
+	ErrNotCaptured = errors.New("authorization is not captured")
+	// ErrRefundAmount means the refund is more than was captured.
+	ErrRefundAmount = errors.New("refund exceeds the captured amount")
+)
+
+// Intent is an amount the provider is asked to collect. Reference ties it to
+// the top-up it pays for.
+type Intent struct {
+	ID        string  `json:"id"`
+	Amount    float64 `json:"amount"`
+	Reference string  `json:"reference"`
+	Status    string  `json:"status"`
+	// FailureReason says why a failed intent did not collect the money.
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// Event is a webhook notification that an intent succeeded or failed.
+// Providers may deliver the same event more than once.
+type Event struct {
+	ID            string `json:"id"`
+	IntentID      string `json:"intentID"`
+	Status        string `json:"status"`
+	FailureReason string `json:"failureReason,omitempty"`
+}
+
+// PaymentProvider collects money in two steps: an intent is created for the
+// amount, then confirmed with the payer's payment method. The outcome comes
+// later as a signed webhook.
+type PaymentProvider interface {
+	// Name is the provider name from the configuration; webhooks arrive
+	// under it.
+	Name() string
+	// CreateIntent returns the intent for reference, creating it on the first
+	// call, so it is safe to retry.
+	CreateIntent(ctx context.Context, amount float64, reference string) (*Intent, error)
+	Confirm(ctx context.Context, intentID, paymentMethod string) (*Intent, error)
+	// ParseWebhook checks the signature of a webhook request and returns its
+	// event. It fails with ErrInvalidSignature for forged or stale requests.
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+	Acquirer
+}
+
+// Acquirer charges a payment method directly at checkout. Authorize reserves
+// the amount and answers right away; Capture then takes the reserved money
+// and Void releases it. Repeating a capture or a void changes nothing, but a
+// captured authorization cannot be voided and the other way round. Refund
+// gives captured money back to the payer.
+type Acquirer interface {
+	// Authorize returns the ID of the authorization for reference, making
+	// it on the first call, so it is safe to retry. A refused payment fails
+	// with ErrDeclined.
+	Authorize(ctx context.Context, amount float64, paymentMethod, reference string) (string, error)
+	Capture(ctx context.Context, authorizationID string) error
+	Void(ctx context.Context, authorizationID string) error
+	// Refund returns amount of a captured authorization. An authorization is
+	// refunded once; repeating the refund changes nothing.
+	Refund(ctx context.Context, authorizationID string, amount float64) error
+}
+
+// Config holds the settings of every built-in provider.
+type Config struct {
+	// WebhookURL is where the provider delivers events.
+	WebhookURL    string
+	WebhookSecret string
+
+	// FakeDelay is how long the fake provider takes to settle the
+	// "fake_delay" payment method.
+	FakeDelay time.Duration
+}
+
+// New builds the provider called name.
+func New(name string, cfg Config) (PaymentProvider, error) {
+	switch name {
+	case ProviderFake:
+		if cfg.WebhookURL == "" || cfg.WebhookSecret == "" {
+			return nil, fmt.Errorf("fake provider needs a webhook URL and a secret")
+		}
+		return NewFakeProvider(cfg.WebhookURL, cfg.WebhookSecret, cfg.FakeDelay), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", name)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" under secret.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a webhook signed with Sign and
+// refuses requests older than SignatureTolerance.
+func Verify(secret []byte, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get(TimestampHeader)
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(sent, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	signature, ok := strings.CutPrefix(header.Get(SignatureHeader), "sha256=")
+	if !ok || !hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
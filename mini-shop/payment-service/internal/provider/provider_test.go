@@ -0,0 +1,207 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+	signed := func(secret []byte, sentAt time.Time, body []byte) http.Header {
+		timestamp := strconv.FormatInt(sentAt.Unix(), 10)
+		header := http.Header{}
+		header.Set(TimestampHeader, timestamp)
+		header.Set(SignatureHeader, "sha256="+Sign(secret, timestamp, body))
+		return header
+	}
+
+	if err := Verify(secret, signed(secret, now, body), body, now); err != nil {
+		t.Errorf("Verify of a signed request: %v", err)
+	}
+
+	unprefixed := signed(secret, now, body)
+	unprefixed.Set(SignatureHeader, Sign(secret, unprefixed.Get(TimestampHeader), body))
+
+	tests := map[string]http.Header{
+		"changed body":      signed(secret, now, []byte(`{"id":"evt_2"}`)),
+		"other secret":      signed([]byte("other"), now, body),
+		"stale timestamp":   signed(secret, now.Add(-SignatureTolerance-time.Minute), body),
+		"missing prefix":    unprefixed,
+		"no headers at all": {},
+	}
+	for name, header := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := Verify(secret, header, body, now); !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Verify error = %v, want %v", err, ErrInvalidSignature)
+			}
+		})
+	}
+}
+
+// webhookSink receives the webhooks of a fake provider. It answers the first
+// failures requests with 503 to make the provider redeliver.
+type webhookSink struct {
+	mu       sync.Mutex
+	failures int
+	events   []*Event
+}
+
+func newFakeProvider(t *testing.T, sink *webhookSink) *FakeProvider {
+	t.Helper()
+
+	var p *FakeProvider
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		event, err := p.ParseWebhook(r.Header, body)
+		if err != nil {
+			t.Errorf("ParseWebhook: %v", err)
+		}
+
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		sink.events = append(sink.events, event)
+		if sink.failures > 0 {
+			sink.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	p = NewFakeProvider(srv.URL, "secret", 10*time.Millisecond)
+	p.backoff = time.Millisecond
+	return p
+}
+
+func TestFakeProvider(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		method     string
+		failures   int
+		wantStatus string
+		wantReason string
+		wantCalls  int
+	}{
+		{FakeSuccess, 0, StatusSucceeded, "", 1},
+		{FakeDecline, 0, StatusFailed, FakeDeclineReason, 1},
+		{FakeDelay, 0, StatusSucceeded, "", 1},
+		{FakeSuccess, 2, StatusSucceeded, "", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			sink := &webhookSink{failures: tt.failures}
+			p := newFakeProvider(t, sink)
+
+			intent, err := p.CreateIntent(ctx, 25, "topup:1")
+			if err != nil || intent.Status != StatusRequiresConfirmation {
+				t.Fatalf("CreateIntent = %+v, %v; want an intent to confirm", intent, err)
+			}
+			if again, err := p.CreateIntent(ctx, 25, "topup:1"); err != nil || again.ID != intent.ID {
+				t.Errorf("CreateIntent for the same reference = %+v, %v; want intent %s", again, err, intent.ID)
+			}
+
+			confirmed, err := p.Confirm(ctx, intent.ID, tt.method)
+			if err != nil || confirmed.Status != StatusProcessing {
+				t.Fatalf("Confirm = %+v, %v; want a processing intent", confirmed, err)
+			}
+			p.Wait()
+
+			if len(sink.events) != tt.wantCalls {
+				t.Fatalf("webhook called %d times, want %d", len(sink.events), tt.wantCalls)
+			}
+			event := sink.events[len(sink.events)-1]
+			if event.IntentID != intent.ID || event.Status != tt.wantStatus || event.FailureReason != tt.wantReason {
+				t.Errorf("event = %+v, want %s with reason %q", event, tt.wantStatus, tt.wantReason)
+			}
+			// Повторная доставка несёт то же событие
+			if event.ID != sink.events[0].ID {
+				t.Errorf("redelivered event ID %s, want %s", event.ID, sink.events[0].ID)
+			}
+		})
+	}
+}
+
+func TestFakeProviderRejectsUnknownPaymentMethod(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeProvider(t, &webhookSink{})
+
+	intent, err := p.CreateIntent(ctx, 25, "topup:1")
+	if err != nil {
+		t.Fatalf("CreateIntent: %v", err)
+	}
+	if _, err := p.Confirm(ctx, intent.ID, "visa"); !errors.Is(err, ErrPaymentMethod) {
+		t.Errorf("Confirm error = %v, want %v", err, ErrPaymentMethod)
+	}
+	if _, err := p.Confirm(ctx, "pi_missing", FakeSuccess); !errors.Is(err, ErrIntentNotFound) {
+		t.Errorf("Confirm of a missing intent: error = %v, want %v", err, ErrIntentNotFound)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(ProviderFake, Config{WebhookURL: "http://localhost/webhook", WebhookSecret: "secret"}); err != nil {
+		t.Errorf("New(fake): %v", err)
+	}
+	if _, err := New(ProviderFake, Config{}); err == nil {
+		t.Error("New(fake) without a webhook URL succeeded")
+	}
+	if _, err := New("acquirer", Config{}); err == nil {
+		t.Error("New of an unknown provider succeeded")
+	}
+}
+
+func TestFakeProviderAuthorization(t *testing.T) {
+	ctx := context.Background()
+	p := newFakeProvider(t, &webhookSink{})
+
+	id, err := p.Authorize(ctx, 25, FakeSuccess, "order:1")
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if again, err := p.Authorize(ctx, 25, FakeSuccess, "order:1"); err != nil || again != id {
+		t.Errorf("Authorize for the same reference = %q, %v; want %q", again, err, id)
+	}
+
+	for range 2 {
+		if err := p.Capture(ctx, id); err != nil {
+			t.Fatalf("Capture: %v", err)
+		}
+	}
+	if err := p.Void(ctx, id); !errors.Is(err, ErrAuthorizationCompleted) {
+		t.Errorf("Void of a captured authorization: error = %v, want %v", err, ErrAuthorizationCompleted)
+	}
+	if err := p.Refund(ctx, id, 30); !errors.Is(err, ErrRefundAmount) {
+		t.Errorf("Refund of more than was captured: error = %v, want %v", err, ErrRefundAmount)
+	}
+	for range 2 {
+		if err := p.Refund(ctx, id, 25); err != nil {
+			t.Fatalf("Refund: %v", err)
+		}
+	}
+
+	if _, err := p.Authorize(ctx, 25, FakeDecline, "order:2"); !errors.Is(err, ErrDeclined) {
+		t.Errorf("Authorize with %s: error = %v, want %v", FakeDecline, err, ErrDeclined)
+	}
+	if _, err := p.Authorize(ctx, 25, "visa", "order:3"); !errors.Is(err, ErrPaymentMethod) {
+		t.Errorf("Authorize with an unknown method: error = %v, want %v", err, ErrPaymentMethod)
+	}
+	if err := p.Capture(ctx, "auth_missing"); !errors.Is(err, ErrAuthorizationNotFound) {
+		t.Errorf("Capture of a missing authorization: error = %v, want %v", err, ErrAuthorizationNotFound)
+	}
+
+	uncaptured, err := p.Authorize(ctx, 25, FakeSuccess, "order:4")
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if err := p.Refund(ctx, uncaptured, 25); !errors.Is(err, ErrNotCaptured) {
+		t.Errorf("Refund of an uncaptured authorization: error = %v, want %v", err, ErrNotCaptured)
+	}
+}
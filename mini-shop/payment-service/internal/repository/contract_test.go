@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// testPaymentStore is the contract every model.PaymentStore implementation has
+// to satisfy. newStore must return an empty store.
+func testPaymentStore(t *testing.T, newStore func(t *testing.T) model.PaymentStore) {
+	ctx := context.Background()
+
+	t.Run("lookups return nil, nil for a missing payment", func(t *testing.T) {
+		store := newStore(t)
+
+		if payment, err := store.GetPaymentByID(ctx, 42); payment != nil || err != nil {
+			t.Errorf("GetPaymentByID = %v, %v; want nil, nil", payment, err)
+		}
+		if payment, err := store.GetPaymentByOrderID(ctx, 42); payment != nil || err != nil {
+			t.Errorf("GetPaymentByOrderID = %v, %v; want nil, nil", payment, err)
+		}
+		if payment, err := store.LockPaymentByOrderID(ctx, 42); payment != nil || err != nil {
+			t.Errorf("LockPaymentByOrderID = %v, %v; want nil, nil", payment, err)
+		}
+		if err := store.UpdatePaymentStatus(ctx, 42, "completed"); !errors.Is(err, model.ErrPaymentNotFound) {
+			t.Errorf("UpdatePaymentStatus error = %v, want %v", err, model.ErrPaymentNotFound)
+		}
+	})
+
+	t.Run("CreatePayment stores every field", func(t *testing.T) {
+		store := newStore(t)
+
+		created := mustCreatePayment(t, store, 1, 7, 12.5)
+		if created.ID == 0 || created.CreatedAt.IsZero() {
+			t.Fatalf("created payment = %+v, want an ID and a creation time", created)
+		}
+
+		for name, get := range map[string]func() (*model.Payment, error){
+			"GetPaymentByID":      func() (*model.Payment, error) { return store.GetPaymentByID(ctx, created.ID) },
+			"GetPaymentByOrderID": func() (*model.Payment, error) { return store.GetPaymentByOrderID(ctx, 1) },
+		} {
+			got, err := get()
+			if err != nil || got == nil {
+				t.Fatalf("%s = %v, %v; want the payment", name, got, err)
+			}
+			if got.ID != created.ID || got.UserID != 7 || got.Email != "ann@example.com" || got.Amount != 12.5 || got.Status != "completed" {
+				t.Errorf("%s = %+v, want the created fields", name, got)
+			}
+		}
+	})
+
+	t.Run("CreatePayment keeps the checkout details", func(t *testing.T) {
+		store := newStore(t)
+
+		created, err := store.CreatePayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Status: "authorized",
+			Method: model.MethodSplit, BalanceAmount: 10, ProviderAmount: 15, ProviderMethod: "fake_success", AuthorizationID: "auth_fake_1"})
+		if err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+
+		got, err := store.GetPaymentByID(ctx, created.ID)
+		if err != nil || got == nil {
+			t.Fatalf("GetPaymentByID = %v, %v; want the payment", got, err)
+		}
+		if got.Method != model.MethodSplit || got.BalanceAmount != 10 || got.ProviderAmount != 15 || got.ProviderMethod != "fake_success" || got.AuthorizationID != "auth_fake_1" {
+			t.Errorf("GetPaymentByID = %+v, want the checkout details", got)
+		}
+	})
+
+	t.Run("an order has at most one payment", func(t *testing.T) {
+		store := newStore(t)
+
+		mustCreatePayment(t, store, 1, 7, 10)
+		_, err := store.CreatePayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 10, Status: "failed"})
+		if !errors.Is(err, model.ErrPaymentExists) {
+			t.Fatalf("second CreatePayment for order 1: error = %v, want %v", err, model.ErrPaymentExists)
+		}
+	})
+
+	t.Run("UpdatePaymentStatus changes the status", func(t *testing.T) {
+		store := newStore(t)
+		payment := mustCreatePayment(t, store, 1, 7, 10)
+
+		if err := store.UpdatePaymentStatus(ctx, payment.ID, "refunded"); err != nil {
+			t.Fatalf("UpdatePaymentStatus: %v", err)
+		}
+		if got, _ := store.GetPaymentByID(ctx, payment.ID); got == nil || got.Status != "refunded" {
+			t.Errorf("payment after update = %+v, want status refunded", got)
+		}
+
+		err := store.InTx(ctx, func(tx model.PaymentStore) error {
+			got, err := tx.LockPaymentByOrderID(ctx, 1)
+			if err != nil || got == nil || got.ID != payment.ID || got.Status != "refunded" {
+				t.Errorf("LockPaymentByOrderID = %+v, %v; want the refunded payment", got, err)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("InTx: %v", err)
+		}
+	})
+
+	t.Run("UpdatePaymentAuthorization stores the authorization", func(t *testing.T) {
+		store := newStore(t)
+		payment := mustCreatePayment(t, store, 1, 7, 10)
+
+		if err := store.UpdatePaymentAuthorization(ctx, payment.ID, "auth_1"); err != nil {
+			t.Fatalf("UpdatePaymentAuthorization: %v", err)
+		}
+		if got, _ := store.GetPaymentByID(ctx, payment.ID); got == nil || got.AuthorizationID != "auth_1" {
+			t.Errorf("payment after update = %+v, want authorization auth_1", got)
+		}
+		if err := store.UpdatePaymentAuthorization(ctx, payment.ID+1, "auth_2"); !errors.Is(err, model.ErrPaymentNotFound) {
+			t.Errorf("UpdatePaymentAuthorization of a missing payment: error = %v, want %v", err, model.ErrPaymentNotFound)
+		}
+	})
+
+	t.Run("UpdateUserEmail rewrites only the payments of the user", func(t *testing.T) {
+		store := newStore(t)
+
+		first := mustCreatePayment(t, store, 1, 7, 10)
+		second := mustCreatePayment(t, store, 2, 7, 20)
+		other := mustCreatePayment(t, store, 3, 8, 30)
+		if err := store.UpdateUserEmail(ctx, 7, "anna@example.com"); err != nil {
+			t.Fatalf("UpdateUserEmail: %v", err)
+		}
+
+		for id, want := range map[int]string{first.ID: "anna@example.com", second.ID: "anna@example.com", other.ID: "ann@example.com"} {
+			got, err := store.GetPaymentByID(ctx, id)
+			if err != nil || got == nil {
+				t.Fatalf("GetPaymentByID(%d) = %v, %v; want the payment", id, got, err)
+			}
+			if got.Email != want {
+				t.Errorf("payment %d email = %q, want %q", id, got.Email, want)
+			}
+		}
+	})
+
+	t.Run("ListPaymentsByUser orders by the sort field and then by ID", func(t *testing.T) {
+		store := newStore(t)
+
+		var ids []int
+		for i, amount := range []float64{30, 10, 30, 20} {
+			ids = append(ids, mustCreatePayment(t, store, i+1, 7, amount).ID)
+		}
+		mustCreatePayment(t, store, 99, 8, 15)
+		if err := store.UpdatePaymentStatus(ctx, ids[1], "failed"); err != nil {
+			t.Fatalf("UpdatePaymentStatus: %v", err)
+		}
+
+		maxAmount := 25.0
+		tests := []struct {
+			name   string
+			filter model.PaymentFilter
+			params pagination.Params
+			want   []int
+		}{
+			{"createdAt", model.PaymentFilter{}, pagination.Params{Limit: 10, Sort: "createdAt"}, ids},
+			{"-createdAt", model.PaymentFilter{}, pagination.Params{Limit: 10, Sort: "createdAt", Desc: true}, []int{ids[3], ids[2], ids[1], ids[0]}},
+			{"amount", model.PaymentFilter{}, pagination.Params{Limit: 10, Sort: "amount"}, []int{ids[1], ids[3], ids[0], ids[2]}},
+			{"-amount", model.PaymentFilter{}, pagination.Params{Limit: 10, Sort: "amount", Desc: true}, []int{ids[2], ids[0], ids[3], ids[1]}},
+			{"limit fetches one extra row", model.PaymentFilter{}, pagination.Params{Limit: 2, Sort: "amount"}, []int{ids[1], ids[3], ids[0]}},
+			{"status filter", model.PaymentFilter{Status: "completed"}, pagination.Params{Limit: 10, Sort: "createdAt"}, []int{ids[0], ids[2], ids[3]}},
+			{"amount filter", model.PaymentFilter{MaxAmount: &maxAmount}, pagination.Params{Limit: 10, Sort: "amount"}, []int{ids[1], ids[3]}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				payments, err := store.ListPaymentsByUser(ctx, 7, tt.filter, tt.params)
+				if err != nil {
+					t.Fatalf("ListPaymentsByUser: %v", err)
+				}
+				if got := paymentIDs(payments); !slices.Equal(got, tt.want) {
+					t.Errorf("ListPaymentsByUser IDs = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		t.Run("cursor continues after the last row", func(t *testing.T) {
+			cursor := model.PaymentCursor(model.Payment{ID: ids[3], Amount: 20}, "amount")
+			rest, err := store.ListPaymentsByUser(ctx, 7, model.PaymentFilter{}, pagination.Params{Limit: 2, Sort: "amount", Cursor: &cursor})
+			if err != nil {
+				t.Fatalf("ListPaymentsByUser: %v", err)
+			}
+			if got, want := paymentIDs(rest), []int{ids[0], ids[2]}; !slices.Equal(got, want) {
+				t.Errorf("second page IDs = %v, want %v", got, want)
+			}
+		})
+	})
+
+	t.Run("outbox returns pending events in insertion order", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, eventType := range []string{"payment.completed", "payment.failed"} {
+			if err := store.AddOutboxEvent(ctx, model.OutboxEvent{EventType: eventType, Payload: []byte(`{}`)}); err != nil {
+				t.Fatalf("AddOutboxEvent: %v", err)
+			}
+		}
+
+		events, err := store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 2 || events[0].EventType != "payment.completed" || events[1].EventType != "payment.failed" {
+			t.Fatalf("pending events = %+v, want both in insertion order", events)
+		}
+
+		if err := store.MarkOutboxEventSent(ctx, events[0].ID); err != nil {
+			t.Fatalf("MarkOutboxEventSent: %v", err)
+		}
+		events, err = store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 1 || events[0].EventType != "payment.failed" {
+			t.Errorf("pending after send = %+v, want only payment.failed", events)
+		}
+	})
+
+	t.Run("top-ups are found by ID and by intent", func(t *testing.T) {
+		store := newStore(t)
+
+		if topUp, err := store.GetTopUp(ctx, 42); topUp != nil || err != nil {
+			t.Errorf("GetTopUp = %v, %v; want nil, nil", topUp, err)
+		}
+		if err := store.UpdateTopUp(ctx, &model.TopUp{ID: 42, Status: model.TopUpFailed}); !errors.Is(err, model.ErrTopUpNotFound) {
+			t.Errorf("UpdateTopUp error = %v, want %v", err, model.ErrTopUpNotFound)
+		}
+
+		topUp := &model.TopUp{UserID: 7, Amount: 25, Provider: "fake", Status: model.TopUpPending}
+		if err := store.CreateTopUp(ctx, topUp); err != nil {
+			t.Fatalf("CreateTopUp: %v", err)
+		}
+		if topUp.ID == 0 || topUp.CreatedAt.IsZero() {
+			t.Fatalf("created top-up = %+v, want an ID and a creation time", topUp)
+		}
+		// Без намерения пополнение по вебхуку не находится
+		if got, err := store.LockTopUpByIntent(ctx, "fake", ""); got != nil || err != nil {
+			t.Errorf("LockTopUpByIntent without an intent = %v, %v; want nil, nil", got, err)
+		}
+
+		topUp.IntentID, topUp.Status = "pi_1", model.TopUpProcessing
+		if err := store.UpdateTopUp(ctx, topUp); err != nil {
+			t.Fatalf("UpdateTopUp: %v", err)
+		}
+
+		err := store.InTx(ctx, func(tx model.PaymentStore) error {
+			got, err := tx.LockTopUpByIntent(ctx, "fake", "pi_1")
+			if err != nil || got == nil || got.ID != topUp.ID || got.Status != model.TopUpProcessing {
+				t.Errorf("LockTopUpByIntent = %+v, %v; want the processing top-up", got, err)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatalf("InTx: %v", err)
+		}
+		if got, err := store.LockTopUpByIntent(ctx, "other", "pi_1"); got != nil || err != nil {
+			t.Errorf("LockTopUpByIntent of another provider = %v, %v; want nil, nil", got, err)
+		}
+
+		got, err := store.GetTopUp(ctx, topUp.ID)
+		if err != nil || got == nil || got.UserID != 7 || got.Amount != 25 || got.IntentID != "pi_1" || got.Status != model.TopUpProcessing {
+			t.Errorf("GetTopUp = %+v, %v; want the updated top-up", got, err)
+		}
+	})
+
+	t.Run("InTx rolls back on error", func(t *testing.T) {
+		store := newStore(t)
+
+		errRollback := errors.New("rollback")
+		err := store.InTx(ctx, func(tx model.PaymentStore) error {
+			if _, err := tx.CreatePayment(ctx, model.Payment{OrderID: 1, UserID: 7, Amount: 10, Status: "completed"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("InTx error = %v, want %v", err, errRollback)
+		}
+
+		if payment, err := store.GetPaymentByOrderID(ctx, 1); payment != nil || err != nil {
+			t.Errorf("GetPaymentByOrderID after rollback = %v, %v; want nil, nil", payment, err)
+		}
+	})
+}
+
+func mustCreatePayment(t *testing.T, store model.PaymentStore, orderID, userID int, amount float64) *model.Payment {
+	t.Helper()
+
+	payment, err := store.CreatePayment(context.Background(), model.Payment{
+		OrderID: orderID,
+		UserID:  userID,
+		Email:   "ann@example.com",
+		Amount:  amount,
+		Status:  "completed",
+	})
+	if err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	return payment
+}
+
+func paymentIDs(payments []model.Payment) []int {
+	ids := make([]int, len(payments))
+	for i, payment := range payments {
+		ids[i] = payment.ID
+	}
+	return ids
+}
@@ -0,0 +1,316 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"mini-shop/platform/pagination"
+)
+
+// MemoryStore is an in-memory model.PaymentStore with the same semantics as
+// the Postgres Store. It is meant for tests and has no persistence.
+type MemoryStore struct {
+	state *paymentState
+	inTx  bool
+}
+
+type paymentState struct {
+	// tx serializes transactions, which is all the isolation the fake offers.
+	tx sync.Mutex
+
+	mu          sync.Mutex
+	payments    []model.Payment
+	outbox      []model.OutboxEvent
+	topUps      []model.TopUp
+	lastPayment int
+	lastOutbox  int
+	lastTopUp   int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: &paymentState{}}
+}
+
+func (s *MemoryStore) CreatePayment(ctx context.Context, payment model.Payment) (*model.Payment, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if s.state.paymentIndex(func(p model.Payment) bool { return p.OrderID == payment.OrderID }) >= 0 {
+		return nil, model.ErrPaymentExists
+	}
+
+	s.state.lastPayment++
+	payment.ID = s.state.lastPayment
+	payment.CreatedAt = now()
+	s.state.payments = append(s.state.payments, payment)
+	return &payment, nil
+}
+
+func (s *MemoryStore) GetPaymentByID(ctx context.Context, id int) (*model.Payment, error) {
+	return s.findPayment(func(p model.Payment) bool { return p.ID == id })
+}
+
+func (s *MemoryStore) GetPaymentByOrderID(ctx context.Context, orderID int) (*model.Payment, error) {
+	return s.findPayment(func(p model.Payment) bool { return p.OrderID == orderID })
+}
+
+// LockPaymentByOrderID has nothing to lock; transactions are already
+// serialized.
+func (s *MemoryStore) LockPaymentByOrderID(ctx context.Context, orderID int) (*model.Payment, error) {
+	return s.GetPaymentByOrderID(ctx, orderID)
+}
+
+func (s *MemoryStore) findPayment(match func(model.Payment) bool) (*model.Payment, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.paymentIndex(match)
+	if i < 0 {
+		return nil, nil
+	}
+	payment := s.state.payments[i]
+	return &payment, nil
+}
+
+func (s *MemoryStore) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.paymentIndex(func(p model.Payment) bool { return p.ID == id })
+	if i < 0 {
+		return model.ErrPaymentNotFound
+	}
+	s.state.payments[i].Status = status
+	return nil
+}
+
+func (s *MemoryStore) UpdatePaymentAuthorization(ctx context.Context, id int, authorizationID string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := s.state.paymentIndex(func(p model.Payment) bool { return p.ID == id })
+	if i < 0 {
+		return model.ErrPaymentNotFound
+	}
+	s.state.payments[i].AuthorizationID = authorizationID
+	return nil
+}
+
+func (s *MemoryStore) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.payments {
+		if s.state.payments[i].UserID == userID {
+			s.state.payments[i].Email = email
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListPaymentsByUser(ctx context.Context, userID int, filter model.PaymentFilter, params pagination.Params) ([]model.Payment, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	payments := []model.Payment{}
+	for _, payment := range s.state.payments {
+		if payment.UserID != userID {
+			continue
+		}
+		if filter.Status != "" && payment.Status != filter.Status {
+			continue
+		}
+		if filter.CreatedFrom != nil && payment.CreatedAt.Before(*filter.CreatedFrom) {
+			continue
+		}
+		if filter.CreatedTo != nil && !payment.CreatedAt.Before(*filter.CreatedTo) {
+			continue
+		}
+		if filter.MinAmount != nil && payment.Amount < *filter.MinAmount {
+			continue
+		}
+		if filter.MaxAmount != nil && payment.Amount > *filter.MaxAmount {
+			continue
+		}
+		payments = append(payments, payment)
+	}
+
+	return keysetPage(payments, params, model.PaymentCursor), nil
+}
+
+func (s *MemoryStore) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.lastOutbox++
+	event.ID = s.state.lastOutbox
+	event.Payload = slices.Clone(event.Payload)
+	event.Headers = maps.Clone(event.Headers)
+	event.Status = "pending"
+	event.CreatedAt = now()
+	event.SentAt = nil
+	s.state.outbox = append(s.state.outbox, event)
+	return nil
+}
+
+func (s *MemoryStore) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	var events []model.OutboxEvent
+	for _, event := range s.state.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.Status == "pending" {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkOutboxEventSent(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.outbox {
+		if s.state.outbox[i].ID == id {
+			sentAt := now()
+			s.state.outbox[i].Status = "sent"
+			s.state.outbox[i].SentAt = &sentAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateTopUp(ctx context.Context, topUp *model.TopUp) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.lastTopUp++
+	topUp.ID = s.state.lastTopUp
+	topUp.CreatedAt = now()
+	topUp.UpdatedAt = topUp.CreatedAt
+	s.state.topUps = append(s.state.topUps, *topUp)
+	return nil
+}
+
+func (s *MemoryStore) GetTopUp(ctx context.Context, id int) (*model.TopUp, error) {
+	return s.findTopUp(func(t model.TopUp) bool { return t.ID == id })
+}
+
+// LockTopUpByIntent needs no lock of its own: transactions are serialized.
+func (s *MemoryStore) LockTopUpByIntent(ctx context.Context, provider, intentID string) (*model.TopUp, error) {
+	if intentID == "" {
+		return nil, nil
+	}
+	return s.findTopUp(func(t model.TopUp) bool { return t.Provider == provider && t.IntentID == intentID })
+}
+
+func (s *MemoryStore) findTopUp(match func(model.TopUp) bool) (*model.TopUp, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.topUps, match)
+	if i < 0 {
+		return nil, nil
+	}
+	topUp := s.state.topUps[i]
+	return &topUp, nil
+}
+
+func (s *MemoryStore) UpdateTopUp(ctx context.Context, topUp *model.TopUp) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.topUps, func(t model.TopUp) bool { return t.ID == topUp.ID })
+	if i < 0 {
+		return model.ErrTopUpNotFound
+	}
+	topUp.UpdatedAt = now()
+	stored := &s.state.topUps[i]
+	stored.IntentID, stored.Status, stored.FailureReason, stored.UpdatedAt = topUp.IntentID, topUp.Status, topUp.FailureReason, topUp.UpdatedAt
+	return nil
+}
+
+// InTx runs fn with transactions serialized. If fn fails, every change made
+// since the transaction began is discarded.
+func (s *MemoryStore) InTx(ctx context.Context, fn func(tx model.PaymentStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.state.tx.Lock()
+	defer s.state.tx.Unlock()
+
+	s.state.mu.Lock()
+	payments, outbox, topUps := slices.Clone(s.state.payments), slices.Clone(s.state.outbox), slices.Clone(s.state.topUps)
+	s.state.mu.Unlock()
+
+	if err := fn(&MemoryStore{state: s.state, inTx: true}); err != nil {
+		s.state.mu.Lock()
+		s.state.payments, s.state.outbox, s.state.topUps = payments, outbox, topUps
+		s.state.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (st *paymentState) paymentIndex(match func(model.Payment) bool) int {
+	return slices.IndexFunc(st.payments, match)
+}
+
+// now matches the microsecond precision of Postgres timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// keysetPage applies the keyset condition, ordering and limit that pagination.Query
+// builds for Postgres.
+func keysetPage[T any](items []T, params pagination.Params, cursor func(item T, sort string) pagination.Cursor) []T {
+	compare := func(a, b pagination.Cursor) int {
+		c := compareSortValues(params.Sort, a.Value, b.Value)
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if params.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	if params.Cursor != nil {
+		items = slices.DeleteFunc(items, func(item T) bool {
+			return compare(cursor(item, params.Sort), *params.Cursor) <= 0
+		})
+	}
+
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(cursor(a, params.Sort), cursor(b, params.Sort))
+	})
+
+	if len(items) > params.Limit+1 {
+		items = items[:params.Limit+1]
+	}
+	return items
+}
+
+// compareSortValues compares two cursor values of the given sort field.
+func compareSortValues(sort, a, b string) int {
+	if sort == "createdAt" {
+		x, _ := time.Parse(time.RFC3339Nano, a)
+		y, _ := time.Parse(time.RFC3339Nano, b)
+		return x.Compare(y)
+	}
+
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	return cmp.Compare(x, y)
+}
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testPaymentStore(t, func(t *testing.T) model.PaymentStore {
+		return NewMemoryStore()
+	})
+}
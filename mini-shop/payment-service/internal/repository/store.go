@@ -1,25 +1,42 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/lib/pq"
+	"mini-shop/platform/database"
+	"mini-shop/platform/pagination"
 )
 
+// uniqueViolation is the Postgres error code for a unique constraint failure.
+const uniqueViolation = "23505"
+
+var paymentSortColumns = map[string]pagination.Column{
+	"createdAt": {Name: "createdAt", Cast: "timestamp"},
+	"amount":    {Name: "amount", Cast: "double precision"},
+}
+
 type Store struct {
 	db *sql.DB
+	q  database.DBTX
 }
 
 func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, q: db}
 }
 
-func scanRowsIntoPayment(rows *sql.Rows) (*model.Payment, error) {
+// paymentColumns is the column list scanPayment expects.
+const paymentColumns = "id, orderID, userID, email, amount, status, createdAt, method, balanceAmount, providerAmount, providerMethod, authorizationID"
+
+func scanPayment(row interface{ Scan(dest ...any) error }) (*model.Payment, error) {
 	payment := new(model.Payment)
 
-	err := rows.Scan(
+	err := row.Scan(
 		&payment.ID,
 		&payment.OrderID,
 		&payment.UserID,
@@ -27,6 +44,11 @@ func scanRowsIntoPayment(rows *sql.Rows) (*model.Payment, error) {
 		&payment.Amount,
 		&payment.Status,
 		&payment.CreatedAt,
+		&payment.Method,
+		&payment.BalanceAmount,
+		&payment.ProviderAmount,
+		&payment.ProviderMethod,
+		&payment.AuthorizationID,
 	)
 	if err != nil {
 		return nil, err
@@ -35,10 +57,17 @@ func scanRowsIntoPayment(rows *sql.Rows) (*model.Payment, error) {
 	return payment, nil
 }
 
-func (s *Store) CreatePayment(payment model.Payment) (*model.Payment, error) {
-	query := `INSERT INTO payments (orderID, userID, email, amount, status, createdAt) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+func (s *Store) CreatePayment(ctx context.Context, payment model.Payment) (*model.Payment, error) {
+	query := `INSERT INTO payments (orderID, userID, email, amount, status, createdAt,
+		method, balanceAmount, providerAmount, providerMethod, authorizationID)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
 	now := time.Now()
-	err := s.db.QueryRow(query, payment.OrderID, payment.UserID, payment.Email, payment.Amount, payment.Status, now).Scan(&payment.ID)
+	err := s.q.QueryRowContext(ctx, query, payment.OrderID, payment.UserID, payment.Email, payment.Amount, payment.Status, now,
+		payment.Method, payment.BalanceAmount, payment.ProviderAmount, payment.ProviderMethod, payment.AuthorizationID).Scan(&payment.ID)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolation {
+		return nil, model.ErrPaymentExists
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -46,13 +75,20 @@ func (s *Store) CreatePayment(payment model.Payment) (*model.Payment, error) {
 	return &payment, nil
 }
 
-func (s *Store) GetPaymentByID(id int) (*model.Payment, error) {
-	query := `SELECT id, orderID, userID, email, amount, status, createdAt FROM payments WHERE id = $1`
+func (s *Store) GetPaymentByID(ctx context.Context, id int) (*model.Payment, error) {
+	return s.getPayment(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = $1", id)
+}
+
+func (s *Store) GetPaymentByOrderID(ctx context.Context, orderID int) (*model.Payment, error) {
+	return s.getPayment(ctx, "SELECT "+paymentColumns+" FROM payments WHERE orderID = $1 ORDER BY id LIMIT 1", orderID)
+}
 
-	row := s.db.QueryRow(query, id)
+func (s *Store) LockPaymentByOrderID(ctx context.Context, orderID int) (*model.Payment, error) {
+	return s.getPayment(ctx, "SELECT "+paymentColumns+" FROM payments WHERE orderID = $1 FOR UPDATE", orderID)
+}
 
-	payment := &model.Payment{}
-	err := row.Scan(&payment.ID, &payment.OrderID, &payment.UserID, &payment.Email, &payment.Amount, &payment.Status, &payment.CreatedAt)
+func (s *Store) getPayment(ctx context.Context, query string, arg any) (*model.Payment, error) {
+	payment, err := scanPayment(s.q.QueryRowContext(ctx, query, arg))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -63,10 +99,10 @@ func (s *Store) GetPaymentByID(id int) (*model.Payment, error) {
 	return payment, nil
 }
 
-func (s *Store) UpdatePaymentStatus(id int, status string) error {
+func (s *Store) UpdatePaymentStatus(ctx context.Context, id int, status string) error {
 	query := `UPDATE payments SET status = $1 WHERE id = $2`
 
-	result, err := s.db.Exec(query, status, id)
+	result, err := s.q.ExecContext(ctx, query, status, id)
 	if err != nil {
 		return err
 	}
@@ -76,29 +112,115 @@ func (s *Store) UpdatePaymentStatus(id int, status string) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("no payment found with ID %d", id)
+		return model.ErrPaymentNotFound
 	}
 
 	return nil
 }
 
-func (s *Store) ListPaymentsByUser(userID int) ([]model.Payment, error) {
-	query := `SELECT id, orderID, userID, email, amount, status, createdAt FROM payments WHERE userID = $1`
+func (s *Store) UpdatePaymentAuthorization(ctx context.Context, id int, authorizationID string) error {
+	result, err := s.q.ExecContext(ctx, `UPDATE payments SET authorizationID = $1 WHERE id = $2`, authorizationID, id)
+	if err != nil {
+		return err
+	}
 
-	rows, err := s.db.Query(query, userID)
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return model.ErrPaymentNotFound
+	}
+
+	return nil
+}
+
+func (s *Store) UpdateUserEmail(ctx context.Context, userID int, email string) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE payments SET email = $1 WHERE userID = $2`, email, userID)
+	return err
+}
+
+func (s *Store) ListPaymentsByUser(ctx context.Context, userID int, filter model.PaymentFilter, params pagination.Params) ([]model.Payment, error) {
+	q := pagination.NewQuery()
+	q.Where("userID = ?", userID)
+	if filter.Status != "" {
+		q.Where("status = ?", filter.Status)
+	}
+	// createdAt хранится без часового пояса в UTC
+	if filter.CreatedFrom != nil {
+		q.Where("createdAt >= ?", filter.CreatedFrom.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.CreatedTo != nil {
+		q.Where("createdAt < ?", filter.CreatedTo.UTC().Format(time.RFC3339Nano))
+	}
+	if filter.MinAmount != nil {
+		q.Where("amount >= ?", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		q.Where("amount <= ?", *filter.MaxAmount)
+	}
+	query := q.Build("SELECT "+paymentColumns+" FROM payments", paymentSortColumns[params.Sort], params)
+
+	rows, err := s.q.QueryContext(ctx, query, q.Args()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var payments []model.Payment
+	payments := []model.Payment{}
 	for rows.Next() {
-		payment, err := scanRowsIntoPayment(rows)
+		payment, err := scanPayment(rows)
 		if err != nil {
 			return nil, err
 		}
 		payments = append(payments, *payment)
 	}
 
-	return payments, nil
+	return payments, rows.Err()
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	headers, err := json.Marshal(event.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox_events (eventType, payload, headers) VALUES ($1, $2, $3)`
+	_, err = s.q.ExecContext(ctx, query, event.EventType, string(event.Payload), string(headers))
+	return err
+}
+
+// PendingOutboxEvents locks up to limit unsent events. Rows locked by another
+// relay are skipped, so several replicas can drain the outbox concurrently.
+func (s *Store) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	query := `SELECT id, eventType, payload, headers, status, createdAt FROM outbox_events
+		WHERE status = 'pending' ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`
+
+	rows, err := s.q.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var (
+			event   model.OutboxEvent
+			headers []byte
+		)
+		if err := rows.Scan(&event.ID, &event.EventType, &event.Payload, &headers, &event.Status, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(headers, &event.Headers); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Store) MarkOutboxEventSent(ctx context.Context, id int) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE outbox_events SET status = 'sent', sentAt = $1 WHERE id = $2`, time.Now(), id)
+	return err
 }
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+)
+
+const topUpColumns = "id, userID, amount, provider, intentID, status, failureReason, createdAt, updatedAt"
+
+func (s *Store) CreateTopUp(ctx context.Context, topUp *model.TopUp) error {
+	now := time.Now()
+	err := s.q.QueryRowContext(ctx,
+		"INSERT INTO top_ups (userID, amount, provider, intentID, status, failureReason, createdAt, updatedAt) VALUES ($1, $2, $3, $4, $5, $6, $7, $7) RETURNING id",
+		topUp.UserID,
+		topUp.Amount,
+		topUp.Provider,
+		topUp.IntentID,
+		topUp.Status,
+		topUp.FailureReason,
+		now,
+	).Scan(&topUp.ID)
+	if err != nil {
+		return err
+	}
+
+	topUp.CreatedAt, topUp.UpdatedAt = now, now
+	return nil
+}
+
+func (s *Store) GetTopUp(ctx context.Context, id int) (*model.TopUp, error) {
+	return s.getTopUp(ctx, "SELECT "+topUpColumns+" FROM top_ups WHERE id = $1", id)
+}
+
+func (s *Store) LockTopUpByIntent(ctx context.Context, provider, intentID string) (*model.TopUp, error) {
+	return s.getTopUp(ctx, "SELECT "+topUpColumns+" FROM top_ups WHERE provider = $1 AND intentID = $2 AND intentID <> '' FOR UPDATE", provider, intentID)
+}
+
+func (s *Store) getTopUp(ctx context.Context, query string, args ...any) (*model.TopUp, error) {
+	var t model.TopUp
+	err := s.q.QueryRowContext(ctx, query, args...).Scan(
+		&t.ID, &t.UserID, &t.Amount, &t.Provider, &t.IntentID, &t.Status, &t.FailureReason, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+func (s *Store) UpdateTopUp(ctx context.Context, topUp *model.TopUp) error {
+	now := time.Now()
+	result, err := s.q.ExecContext(ctx,
+		"UPDATE top_ups SET intentID = $1, status = $2, failureReason = $3, updatedAt = $4 WHERE id = $5",
+		topUp.IntentID,
+		topUp.Status,
+		topUp.FailureReason,
+		now,
+		topUp.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return model.ErrTopUpNotFound
+	}
+
+	topUp.UpdatedAt = now
+	return nil
+}
@@ -0,0 +1,17 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"mini-shop/platform/database"
+)
+
+// InTx runs fn against a store bound to a single transaction. The transaction
+// is committed when fn returns nil and rolled back otherwise. Calls made on a
+// store that is already inside a transaction reuse it.
+func (s *Store) InTx(ctx context.Context, fn func(tx model.PaymentStore) error) error {
+	return database.InTx(ctx, s.db, s.q, func(q database.DBTX) error {
+		return fn(&Store{db: s.db, q: q})
+	})
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+)
+
+const (
+	ReasonPaymentDeclined     = "payment_declined"
+	ReasonPaymentMethod       = "unsupported_payment_method"
+	ReasonInvalidSplit        = "invalid_split"
+	ReasonProviderUnavailable = "provider_unavailable"
+	ReasonCaptureFailed       = "capture_failed"
+)
+
+// PaymentStrategy pays for an order with one payment method. Authorize
+// reserves the money and notes on the payment what it reserved, Capture
+// takes the reserved money for good and Void gives it back. Capture and Void
+// follow a successful Authorize and are safe to repeat. Refund returns the
+// money of a captured payment to where it came from and is safe to repeat too.
+type PaymentStrategy interface {
+	Authorize(ctx context.Context, payment *model.Payment) error
+	Capture(ctx context.Context, payment *model.Payment) error
+	Void(ctx context.Context, payment *model.Payment) error
+	Refund(ctx context.Context, payment *model.Payment) error
+}
+
+// errNoProvider fails payments through the provider when none is configured.
+var errNoProvider = errors.New("no payment provider configured")
+
+// CheckoutError explains why a payment method did not pay for the order.
+type CheckoutError struct {
+	Reason string
+	Err    error
+}
+
+func (e *CheckoutError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CheckoutError) Unwrap() error {
+	return e.Err
+}
+
+// balanceStrategy pays from the user's balance in user-service. The balance
+// knows no holds, so Authorize already withdraws the money, Capture keeps it
+// and Void puts it back.
+type balanceStrategy struct {
+	users *UserServiceClient
+}
+
+func (s balanceStrategy) Authorize(ctx context.Context, payment *model.Payment) error {
+	return s.users.Withdraw(ctx, payment.UserID, payment.BalanceAmount, fmt.Sprintf("order:%d", payment.OrderID))
+}
+
+func (balanceStrategy) Capture(ctx context.Context, payment *model.Payment) error {
+	return nil
+}
+
+func (s balanceStrategy) Void(ctx context.Context, payment *model.Payment) error {
+	return s.users.Refund(ctx, payment.UserID, payment.BalanceAmount, fmt.Sprintf("void:order:%d", payment.OrderID))
+}
+
+func (s balanceStrategy) Refund(ctx context.Context, payment *model.Payment) error {
+	return s.users.Refund(ctx, payment.UserID, payment.BalanceAmount, fmt.Sprintf("refund:order:%d", payment.OrderID))
+}
+
+// providerStrategy charges the payer's payment method at the provider.
+type providerStrategy struct {
+	acquirer provider.Acquirer
+}
+
+func (s providerStrategy) Authorize(ctx context.Context, payment *model.Payment) error {
+	if s.acquirer == nil {
+		return &CheckoutError{Reason: ReasonProviderUnavailable, Err: errNoProvider}
+	}
+
+	id, err := s.acquirer.Authorize(ctx, payment.ProviderAmount, payment.ProviderMethod, fmt.Sprintf("order:%d", payment.OrderID))
+	switch {
+	case errors.Is(err, provider.ErrDeclined):
+		return &CheckoutError{Reason: ReasonPaymentDeclined, Err: err}
+	case errors.Is(err, provider.ErrPaymentMethod):
+		return &CheckoutError{Reason: ReasonPaymentMethod, Err: err}
+	case err != nil:
+		return &CheckoutError{Reason: ReasonProviderUnavailable, Err: fmt.Errorf("failed to authorize payment: %w", err)}
+	}
+
+	payment.AuthorizationID = id
+	return nil
+}
+
+func (s providerStrategy) Capture(ctx context.Context, payment *model.Payment) error {
+	return s.acquirer.Capture(ctx, payment.AuthorizationID)
+}
+
+func (s providerStrategy) Void(ctx context.Context, payment *model.Payment) error {
+	return s.acquirer.Void(ctx, payment.AuthorizationID)
+}
+
+func (s providerStrategy) Refund(ctx context.Context, payment *model.Payment) error {
+	if s.acquirer == nil {
+		return errNoProvider
+	}
+	return s.acquirer.Refund(ctx, payment.AuthorizationID, payment.ProviderAmount)
+}
+
+// splitStrategy takes BalanceAmount from the balance and the rest through
+// the provider. When the provider refuses, the balance part is given back;
+// when it cannot be reached, the balance part stays taken for the retry.
+type splitStrategy struct {
+	balance  PaymentStrategy
+	provider PaymentStrategy
+}
+
+func (s splitStrategy) Authorize(ctx context.Context, payment *model.Payment) error {
+	if err := s.balance.Authorize(ctx, payment); err != nil {
+		return err
+	}
+	if err := s.provider.Authorize(ctx, payment); err != nil {
+		if retryable(err) {
+			// Балансовая часть остаётся списанной для повторной авторизации
+			return err
+		}
+		if voidErr := s.balance.Void(ctx, payment); voidErr != nil {
+			slog.ErrorContext(ctx, "failed to give back the balance part of a split payment", "order_id", payment.OrderID, "error", voidErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s splitStrategy) Capture(ctx context.Context, payment *model.Payment) error {
+	if err := s.provider.Capture(ctx, payment); err != nil {
+		return err
+	}
+	return s.balance.Capture(ctx, payment)
+}
+
+func (s splitStrategy) Void(ctx context.Context, payment *model.Payment) error {
+	return errors.Join(s.provider.Void(ctx, payment), s.balance.Void(ctx, payment))
+}
+
+// Refund sends each part back the way it was paid: the card part to the card
+// and BalanceAmount to the balance.
+func (s splitStrategy) Refund(ctx context.Context, payment *model.Payment) error {
+	return errors.Join(s.provider.Refund(ctx, payment), s.balance.Refund(ctx, payment))
+}
+
+// retryable reports whether authorizing may succeed when repeated. Such
+// failures leave open whether the money moved, so the payment is not failed
+// but authorized again by the same reference.
+func retryable(err error) bool {
+	switch FailureReason(err) {
+	case ReasonUserServiceUnavailable, "internal":
+		return true
+	case ReasonProviderUnavailable:
+		return !errors.Is(err, errNoProvider)
+	}
+	return false
+}
+
+// strategy returns how payments of method are paid; users moves the balance
+// of the payer.
+func (s *PaymentService) strategy(method string, users *UserServiceClient) PaymentStrategy {
+	balance := balanceStrategy{users: users}
+	acquirer := providerStrategy{acquirer: s.acquirer}
+	switch method {
+	case model.MethodProvider:
+		return acquirer
+	case model.MethodSplit:
+		return splitStrategy{balance: balance, provider: acquirer}
+	default:
+		return balance
+	}
+}
+
+// splitAmount fills in the method of a new payment and the parts of its
+// amount taken from the balance and through the provider. Orders placed
+// before checkout had a choice are paid from the balance.
+func splitAmount(payment *model.Payment) error {
+	switch payment.Method {
+	case "", model.MethodBalance:
+		payment.Method, payment.BalanceAmount, payment.ProviderAmount = model.MethodBalance, payment.Amount, 0
+	case model.MethodProvider:
+		payment.BalanceAmount, payment.ProviderAmount = 0, payment.Amount
+	case model.MethodSplit:
+		if payment.BalanceAmount <= 0 || payment.BalanceAmount >= payment.Amount {
+			return &CheckoutError{Reason: ReasonInvalidSplit, Err: fmt.Errorf("cannot take %v of %v from the balance", payment.BalanceAmount, payment.Amount)}
+		}
+		payment.ProviderAmount = payment.Amount - payment.BalanceAmount
+	default:
+		return &CheckoutError{Reason: ReasonPaymentMethod, Err: fmt.Errorf("unsupported payment method %q", payment.Method)}
+	}
+	return nil
+}
@@ -0,0 +1,447 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+	"github.com/Viltsev/minishop/payment-service/internal/repository"
+)
+
+// failingCapture authorizes through the wrapped acquirer but cannot capture.
+type failingCapture struct {
+	provider.Acquirer
+}
+
+func (failingCapture) Capture(ctx context.Context, authorizationID string) error {
+	return errors.New("acquirer is down")
+}
+
+// recordingRefunds refunds through the wrapped acquirer and records every
+// refund as "<authorization> <amount>".
+type recordingRefunds struct {
+	provider.Acquirer
+	refunds []string
+}
+
+func (r *recordingRefunds) Refund(ctx context.Context, authorizationID string, amount float64) error {
+	r.refunds = append(r.refunds, fmt.Sprintf("%s %v", authorizationID, amount))
+	return r.Acquirer.Refund(ctx, authorizationID, amount)
+}
+
+// cancellingAcquirer cancels the order right after authorizing its payment,
+// as a cancellation arriving while checkout is under way would.
+type cancellingAcquirer struct {
+	provider.Acquirer
+	cancel func()
+}
+
+func (a cancellingAcquirer) Authorize(ctx context.Context, amount float64, paymentMethod, reference string) (string, error) {
+	id, err := a.Acquirer.Authorize(ctx, amount, paymentMethod, reference)
+	a.cancel()
+	return id, err
+}
+
+func newCheckoutService(t *testing.T, acquirer provider.Acquirer) (*PaymentService, *repository.MemoryStore) {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	return NewPaymentService(store, NewOutboxRelay(store, broker), acquirer), store
+}
+
+// recordingUserService takes every balance change and records it as
+// "<action> <reference> <amount>".
+func recordingUserService(t *testing.T) (*UserServiceClient, func() []string) {
+	t.Helper()
+
+	var (
+		mu      sync.Mutex
+		changes []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Amount    float64 `json:"amount"`
+			Reference string  `json:"reference"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		mu.Lock()
+		changes = append(changes, fmt.Sprintf("%s %s %v", strings.TrimPrefix(r.URL.Path, "/api/v1/balance/7/"), payload.Reference, payload.Amount))
+		mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewUserServiceClient(srv.URL), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(changes)
+	}
+}
+
+func newAcquirer() *provider.FakeProvider {
+	return provider.NewFakeProvider("http://localhost/webhook", "secret", 0)
+}
+
+func TestProcessPaymentThroughProvider(t *testing.T) {
+	ctx := context.Background()
+	acquirer := newAcquirer()
+	s, store := newCheckoutService(t, acquirer)
+	client, calls := fakeUserService(t, http.StatusOK, "")
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodProvider, ProviderMethod: provider.FakeSuccess}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if payment.Status != "completed" || payment.ProviderAmount != 25 || payment.BalanceAmount != 0 || payment.AuthorizationID == "" {
+		t.Errorf("payment = %+v, want a completed payment of 25 through the provider", payment)
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("user service called %d times, want the balance left alone", n)
+	}
+	// Захваченную авторизацию уже нельзя отпустить
+	if err := acquirer.Void(ctx, payment.AuthorizationID); !errors.Is(err, provider.ErrAuthorizationCompleted) {
+		t.Errorf("Void of the authorization: error = %v, want %v", err, provider.ErrAuthorizationCompleted)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.authorized", "payment.completed"}) {
+		t.Errorf("outbox = %v, want [payment.authorized payment.completed]", got)
+	}
+}
+
+func TestProcessPaymentSplit(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newCheckoutService(t, newAcquirer())
+	client, calls := fakeUserService(t, http.StatusOK, "")
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeSuccess}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if payment.Status != "completed" || payment.BalanceAmount != 10 || payment.ProviderAmount != 15 {
+		t.Errorf("payment = %+v, want a completed payment of 10 from the balance and 15 through the provider", payment)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("user service called %d times, want a single withdrawal", n)
+	}
+}
+
+func TestSplitPaymentDeclinedGivesBalanceBack(t *testing.T) {
+	ctx := context.Background()
+	s, store := newCheckoutService(t, newAcquirer())
+	client, changes := recordingUserService(t)
+
+	_, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeDecline}, client)
+	if reason := FailureReason(err); reason != ReasonPaymentDeclined {
+		t.Fatalf("ProcessPayment error = %v, want reason %q", err, ReasonPaymentDeclined)
+	}
+	// Списание и возврат помечены заказом, повтор их не удвоит
+	if got := changes(); !slices.Equal(got, []string{"withdraw order:1 10", "refund void:order:1 10"}) {
+		t.Errorf("balance changes = %v, want the withdrawal for order:1 and its return", got)
+	}
+	if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored == nil || stored.Status != "failed" {
+		t.Errorf("stored payment = %+v, want a failed payment", stored)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.failed"}) {
+		t.Errorf("outbox = %v, want [payment.failed]", got)
+	}
+}
+
+func TestFailedCaptureVoidsPayment(t *testing.T) {
+	ctx := context.Background()
+	acquirer := newAcquirer()
+	s, store := newCheckoutService(t, failingCapture{acquirer})
+	client, _ := fakeUserService(t, http.StatusOK, "")
+
+	_, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodProvider, ProviderMethod: provider.FakeSuccess}, client)
+	if err == nil {
+		t.Fatal("ProcessPayment succeeded, want an error")
+	}
+
+	stored, _ := store.GetPaymentByOrderID(ctx, 1)
+	if stored == nil || stored.Status != "voided" {
+		t.Fatalf("stored payment = %+v, want a voided payment", stored)
+	}
+	if err := acquirer.Capture(ctx, stored.AuthorizationID); !errors.Is(err, provider.ErrAuthorizationCompleted) {
+		t.Errorf("Capture of the voided authorization: error = %v, want %v", err, provider.ErrAuthorizationCompleted)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.authorized", "payment.failed"}) {
+		t.Errorf("outbox = %v, want [payment.authorized payment.failed]", got)
+	}
+}
+
+func TestProcessPaymentCapturesAuthorizedPayment(t *testing.T) {
+	ctx := context.Background()
+	acquirer := newAcquirer()
+	s, store := newCheckoutService(t, acquirer)
+	client, _ := fakeUserService(t, http.StatusOK, "")
+
+	// Сервис упал после авторизации, не успев захватить деньги
+	authorizationID, err := acquirer.Authorize(ctx, 25, provider.FakeSuccess, "order:1")
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	authorized := model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Status: "authorized",
+		Method: model.MethodProvider, ProviderAmount: 25, ProviderMethod: provider.FakeSuccess, AuthorizationID: authorizationID}
+	if _, err := store.CreatePayment(ctx, authorized); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodProvider, ProviderMethod: provider.FakeSuccess}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if payment.Status != "completed" || payment.AuthorizationID != authorizationID {
+		t.Errorf("payment = %+v, want the authorized payment completed", payment)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.completed"}) {
+		t.Errorf("outbox = %v, want [payment.completed]", got)
+	}
+}
+
+func TestRefundPaymentVoidsAuthorizedPayment(t *testing.T) {
+	ctx := context.Background()
+	acquirer := newAcquirer()
+	s, store := newCheckoutService(t, acquirer)
+	client, changes := recordingUserService(t)
+
+	authorizationID, err := acquirer.Authorize(ctx, 15, provider.FakeSuccess, "order:1")
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	authorized := model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Status: "authorized",
+		Method: model.MethodSplit, BalanceAmount: 10, ProviderAmount: 15, ProviderMethod: provider.FakeSuccess, AuthorizationID: authorizationID}
+	if _, err := store.CreatePayment(ctx, authorized); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+
+	// Заказ отменили между авторизацией и захватом
+	for range 2 {
+		if payment, err := s.RefundPayment(ctx, 1, 7, client); err != nil || payment.Status != "voided" {
+			t.Fatalf("RefundPayment = %+v, %v; want a voided payment", payment, err)
+		}
+	}
+	if payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeSuccess}, client); err != nil || payment.Status != "voided" {
+		t.Errorf("ProcessPayment after the cancellation = %+v, %v; want the voided payment left alone", payment, err)
+	}
+
+	if err := acquirer.Capture(ctx, authorizationID); !errors.Is(err, provider.ErrAuthorizationCompleted) {
+		t.Errorf("Capture of the voided authorization: error = %v, want %v", err, provider.ErrAuthorizationCompleted)
+	}
+	if got := changes(); !slices.Equal(got, []string{"refund void:order:1 10", "refund void:order:1 10"}) {
+		t.Errorf("balance changes = %v, want the balance part given back under one reference", got)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.voided"}) {
+		t.Errorf("outbox = %v, want [payment.voided]", got)
+	}
+}
+
+func TestCancellationWaitsForAuthorization(t *testing.T) {
+	ctx := context.Background()
+	acquirer := newAcquirer()
+	client, changes := recordingUserService(t)
+	var s *PaymentService
+	s, store := newCheckoutService(t, cancellingAcquirer{Acquirer: acquirer, cancel: func() {
+		if _, err := s.RefundPayment(ctx, 1, 7, client); err == nil {
+			t.Error("RefundPayment of a payment being authorized succeeded, want an error to retry later")
+		}
+	}})
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeSuccess}, client)
+	if err != nil || payment.Status != "completed" {
+		t.Fatalf("ProcessPayment = %+v, %v; want a completed payment", payment, err)
+	}
+	// Повтор отмены застаёт деньги захваченными и возвращает их
+	if payment, err := s.RefundPayment(ctx, 1, 7, client); err != nil || payment.Status != "refunded" {
+		t.Fatalf("retried RefundPayment = %+v, %v; want a refunded payment", payment, err)
+	}
+	if got := changes(); !slices.Equal(got, []string{"withdraw order:1 10", "refund refund:order:1 10"}) {
+		t.Errorf("balance changes = %v, want the balance part taken and refunded once", got)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.authorized", "payment.completed", "payment.refunded"}) {
+		t.Errorf("outbox = %v, want [payment.authorized payment.completed payment.refunded]", got)
+	}
+}
+
+// blockingCapture captures through the wrapped acquirer once release is
+// closed, announcing on started that a capture is under way.
+type blockingCapture struct {
+	provider.Acquirer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (a blockingCapture) Capture(ctx context.Context, authorizationID string) error {
+	close(a.started)
+	<-a.release
+	return a.Acquirer.Capture(ctx, authorizationID)
+}
+
+func TestCancellationDuringCaptureIsRefunded(t *testing.T) {
+	ctx := context.Background()
+	acquirer := blockingCapture{Acquirer: &recordingRefunds{Acquirer: newAcquirer()}, started: make(chan struct{}), release: make(chan struct{})}
+	s, store := newCheckoutService(t, acquirer)
+	client, changes := recordingUserService(t)
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	// Обработчики как в app: ошибка возвращает сообщение брокеру на повтор
+	var cancellations atomic.Int32
+	broker.Consume("order.created", func(ctx context.Context, body []byte) error {
+		_, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeSuccess}, client)
+		return err
+	})
+	broker.Consume("order.cancelled", func(ctx context.Context, body []byte) error {
+		_, err := s.RefundPayment(ctx, 1, 7, client)
+		if cancellations.Add(1) == 1 {
+			close(acquirer.release)
+		}
+		return err
+	})
+
+	broker.Publish(ctx, "order.created", nil)
+	<-acquirer.started
+	broker.Publish(ctx, "order.cancelled", nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if payment, _ := store.GetPaymentByOrderID(ctx, 1); payment != nil && payment.Status == "refunded" {
+			break
+		}
+		if time.Now().After(deadline) {
+			payment, _ := store.GetPaymentByOrderID(ctx, 1)
+			t.Fatalf("payment = %+v, want it refunded after the capture", payment)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := cancellations.Load(); n < 2 {
+		t.Errorf("order.cancelled handled %d times, want it retried after waiting for the capture", n)
+	}
+	if got := changes(); !slices.Equal(got, []string{"withdraw order:1 10", "refund refund:order:1 10"}) {
+		t.Errorf("balance changes = %v, want the balance part taken and refunded once", got)
+	}
+	if refunds := acquirer.Acquirer.(*recordingRefunds).refunds; len(refunds) != 1 {
+		t.Errorf("provider refunds = %v, want the card part refunded once", refunds)
+	}
+}
+
+func TestProcessPaymentResumesPendingPayment(t *testing.T) {
+	ctx := context.Background()
+	s, store := newCheckoutService(t, newAcquirer())
+	unavailable, _ := fakeUserService(t, http.StatusServiceUnavailable, "")
+	client, changes := recordingUserService(t)
+	payment := model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}
+
+	// Ответ user-service потерялся: списались ли деньги, неизвестно
+	if _, err := s.ProcessPayment(ctx, payment, unavailable); FailureReason(err) != ReasonUserServiceUnavailable {
+		t.Fatalf("ProcessPayment error = %v, want reason %q", err, ReasonUserServiceUnavailable)
+	}
+	if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored == nil || stored.Status != "pending" {
+		t.Fatalf("stored payment = %+v, want it left pending", stored)
+	}
+	if _, err := s.RefundPayment(ctx, 1, 7, client); err == nil {
+		t.Error("RefundPayment of a pending payment succeeded, want an error to retry later")
+	}
+
+	completed, err := s.ProcessPayment(ctx, payment, client)
+	if err != nil || completed.Status != "completed" {
+		t.Fatalf("retried ProcessPayment = %+v, %v; want a completed payment", completed, err)
+	}
+	if got := changes(); !slices.Equal(got, []string{"withdraw order:1 25"}) {
+		t.Errorf("balance changes = %v, want the withdrawal repeated under the same reference", got)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.authorized", "payment.completed"}) {
+		t.Errorf("outbox = %v, want [payment.authorized payment.completed]", got)
+	}
+}
+
+func TestRefundPaymentWaitsForCapture(t *testing.T) {
+	ctx := context.Background()
+	s, store := newCheckoutService(t, newAcquirer())
+	client, calls := fakeUserService(t, http.StatusOK, "")
+
+	if _, err := store.CreatePayment(ctx, model.Payment{OrderID: 1, UserID: 7, Amount: 25, Status: "capturing", Method: model.MethodBalance, BalanceAmount: 25}); err != nil {
+		t.Fatalf("CreatePayment: %v", err)
+	}
+	if _, err := s.RefundPayment(ctx, 1, 7, client); err == nil {
+		t.Error("RefundPayment of a payment being captured succeeded, want an error to retry later")
+	}
+	if n := calls.Load(); n != 0 {
+		t.Errorf("user service called %d times, want none", n)
+	}
+}
+
+func TestRefundSplitPayment(t *testing.T) {
+	ctx := context.Background()
+	acquirer := &recordingRefunds{Acquirer: newAcquirer()}
+	s, store := newCheckoutService(t, acquirer)
+	client, changes := recordingUserService(t)
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25, Method: model.MethodSplit, BalanceAmount: 10, ProviderMethod: provider.FakeSuccess}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	for range 2 {
+		if _, err := s.RefundPayment(ctx, 1, 7, client); err != nil {
+			t.Fatalf("RefundPayment: %v", err)
+		}
+	}
+
+	// На баланс возвращается только списанное с него, остальное уходит на карту
+	if got := changes(); !slices.Equal(got, []string{"withdraw order:1 10", "refund refund:order:1 10"}) {
+		t.Errorf("balance changes = %v, want the 10 taken from the balance given back once", got)
+	}
+	if want := []string{payment.AuthorizationID + " 15"}; !slices.Equal(acquirer.refunds, want) {
+		t.Errorf("provider refunds = %v, want %v", acquirer.refunds, want)
+	}
+	if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored == nil || stored.Status != "refunded" {
+		t.Errorf("stored payment = %+v, want a refunded payment", stored)
+	}
+}
+
+func TestProcessPaymentRejectsCheckout(t *testing.T) {
+	tests := []struct {
+		name     string
+		acquirer provider.Acquirer
+		payment  model.Payment
+		reason   string
+	}{
+		{"unknown method", newAcquirer(), model.Payment{Method: "cash"}, ReasonPaymentMethod},
+		{"split without a provider part", newAcquirer(), model.Payment{Method: model.MethodSplit, BalanceAmount: 25, ProviderMethod: provider.FakeSuccess}, ReasonInvalidSplit},
+		{"method the provider does not know", newAcquirer(), model.Payment{Method: model.MethodProvider, ProviderMethod: "visa"}, ReasonPaymentMethod},
+		{"no provider configured", nil, model.Payment{Method: model.MethodProvider, ProviderMethod: provider.FakeSuccess}, ReasonProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s, store := newCheckoutService(t, tt.acquirer)
+			client, calls := fakeUserService(t, http.StatusOK, "")
+
+			payment := tt.payment
+			payment.OrderID, payment.UserID, payment.Email, payment.Amount = 1, 7, "ann@example.com", 25
+			if _, err := s.ProcessPayment(ctx, payment, client); FailureReason(err) != tt.reason {
+				t.Errorf("ProcessPayment error = %v, want reason %q", err, tt.reason)
+			}
+			if n := calls.Load(); n != 0 {
+				t.Errorf("user service called %d times, want none", n)
+			}
+			if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored == nil || stored.Status != "failed" {
+				t.Errorf("stored payment = %+v, want a failed payment", stored)
+			}
+		})
+	}
+}
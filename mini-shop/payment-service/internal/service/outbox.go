@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"mini-shop/platform/outbox"
+)
+
+// NewOutboxRelay publishes the events written to the outbox of store.
+func NewOutboxRelay(store model.PaymentStore, publisher messaging.Publisher) *outbox.Relay {
+	return outbox.NewRelay(func(ctx context.Context, fn func(tx outbox.Store) error) error {
+		return store.InTx(ctx, func(tx model.PaymentStore) error {
+			return fn(tx)
+		})
+	}, publisher)
+}
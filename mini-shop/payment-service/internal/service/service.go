@@ -1,76 +1,418 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"slices"
 
-	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/metrics"
 	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
 )
 
 type PaymentService struct {
-	store    model.PaymentStore
-	rabbitMQ *messaging.RabbitMQ
+	store  model.PaymentStore
+	outbox *outbox.Relay
+	// acquirer charges payment methods through the provider at checkout.
+	acquirer provider.Acquirer
 }
 
-func NewPaymentService(store model.PaymentStore, rabbitMQ *messaging.RabbitMQ) *PaymentService {
+func NewPaymentService(store model.PaymentStore, outbox *outbox.Relay, acquirer provider.Acquirer) *PaymentService {
 	return &PaymentService{
 		store:    store,
-		rabbitMQ: rabbitMQ,
+		outbox:   outbox,
+		acquirer: acquirer,
 	}
 }
 
-func (s *PaymentService) ProcessPayment(payment model.Payment, userService *UserServiceClient) (*model.Payment, error) {
-	log.Printf("Пробуем снять средства")
-	err := userService.Withdraw(payment.UserID, payment.Amount)
+// ProcessPayment pays for an order with the payment method chosen at
+// checkout. The payment is stored as pending before any money moves, then
+// authorized, announced with payment.authorized, and captured; only a
+// captured payment is completed. A payment that fails to capture is voided,
+// and so is one whose order was cancelled before the money was captured.
+// Every step is safe to repeat, so a failed order.created is retried and
+// picks up where the payment stopped.
+func (s *PaymentService) ProcessPayment(ctx context.Context, payment model.Payment, userService *UserServiceClient) (*model.Payment, error) {
+	// order.created может прийти повторно, деньги списываем только один раз
+	existing, err := s.store.GetPaymentByOrderID(ctx, payment.OrderID)
 	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		if existing, err = s.begin(ctx, payment); err != nil {
+			return nil, err
+		}
+	}
+
+	switch existing.Status {
+	case "pending":
+		// Платёж мог остаться pending, если сервис упал после списания;
+		// авторизация по той же ссылке денег второй раз не берёт
+		existing, err = s.authorize(ctx, existing, userService)
+		if err != nil || existing.Status != "authorized" {
+			return existing, err
+		}
+	case "authorized", "capturing":
+	default:
+		slog.InfoContext(ctx, "payment already processed", "order_id", payment.OrderID, "payment_id", existing.ID, "status", existing.Status)
+		return existing, nil
+	}
+	return s.capture(ctx, existing, userService)
+}
+
+// begin stores a new payment as pending before its money moves, so a
+// cancellation arriving meanwhile waits for the outcome. A payment whose
+// amount cannot be split is stored as failed. When the order already has a
+// payment, e.g. a cancelled one, that payment is returned.
+func (s *PaymentService) begin(ctx context.Context, payment model.Payment) (*model.Payment, error) {
+	if err := splitAmount(&payment); err != nil {
+		return nil, s.fail(ctx, payment, err)
+	}
+
+	payment.Status = "pending"
+	created, err := s.store.CreatePayment(ctx, payment)
+	if errors.Is(err, model.ErrPaymentExists) {
+		return s.store.GetPaymentByOrderID(ctx, payment.OrderID)
+	}
+	if err != nil {
+		metrics.PaymentsFailed.WithLabelValues("storage").Inc()
+		return nil, fmt.Errorf("failed to store payment: %w", err)
+	}
+	return created, nil
+}
+
+// authorize reserves the money of a pending payment and marks it authorized.
+// A refused payment fails; one that could not be reached stays pending, since
+// the money may have moved, and is authorized again by the same reference.
+func (s *PaymentService) authorize(ctx context.Context, payment *model.Payment, userService *UserServiceClient) (*model.Payment, error) {
+	strategy := s.strategy(payment.Method, userService)
+	slog.InfoContext(ctx, "authorizing payment", "order_id", payment.OrderID, "user_id", payment.UserID, "amount", payment.Amount, "method", payment.Method)
+	if authorizeErr := strategy.Authorize(ctx, payment); authorizeErr != nil {
+		if !retryable(authorizeErr) {
+			return nil, s.fail(ctx, *payment, authorizeErr)
+		}
+		// Ответ мог потеряться после списания: платёж остаётся pending до
+		// повторного события
+		return nil, fmt.Errorf("failed to authorize payment: %w", authorizeErr)
+	}
+
+	claimed, err := s.recordStatus(ctx, payment, []string{"pending"}, "authorized", "payment.authorized", paymentEvent("PaymentAuthorized", *payment))
+	if err != nil {
+		// Деньги уже зарезервированы по ссылке заказа, повторное событие
+		// получит ту же авторизацию
+		metrics.PaymentsFailed.WithLabelValues("storage").Inc()
+		return nil, err
+	}
+	if !claimed {
+		// Повторная доставка успела раньше, деньги зарезервированы один раз
+		slog.InfoContext(ctx, "payment authorized by another delivery", "order_id", payment.OrderID, "status", payment.Status)
+		return payment, nil
+	}
+
+	slog.InfoContext(ctx, "payment authorized", "order_id", payment.OrderID, "payment_id", payment.ID)
+	return payment, nil
+}
+
+// fail records that payment was refused for failure and returns the error to
+// report. A stored pending payment becomes failed; one that could not be
+// stored is stored as failed.
+func (s *PaymentService) fail(ctx context.Context, payment model.Payment, failure error) error {
+	event := paymentEvent("PaymentFailed", payment)
+	event["reason"] = FailureReason(failure)
+	event["error"] = failure.Error()
+
+	var err error
+	if payment.ID == 0 {
 		payment.Status = "failed"
-		s.store.CreatePayment(payment)
+		_, err = s.recordPayment(ctx, payment, "payment.failed", event)
+	} else {
+		_, err = s.recordStatus(ctx, &payment, []string{"pending"}, "failed", "payment.failed", event)
+	}
+	if err != nil {
+		return err
+	}
+
+	metrics.PaymentsFailed.WithLabelValues(FailureReason(failure)).Inc()
+	slog.WarnContext(ctx, "payment failed", "order_id", payment.OrderID, "method", payment.Method, "reason", FailureReason(failure), "error", failure)
+	return fmt.Errorf("failed to authorize payment: %w", failure)
+}
+
+// capture takes the money of an authorized payment and completes it. When the
+// capture fails, the authorization is voided and the payment fails. The
+// payment is marked capturing first, so a cancellation arriving meanwhile
+// waits for the outcome instead of voiding money being captured.
+func (s *PaymentService) capture(ctx context.Context, payment *model.Payment, userService *UserServiceClient) (*model.Payment, error) {
+	claimed, err := s.recordStatus(ctx, payment, []string{"authorized", "capturing"}, "capturing", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		// Заказ успели отменить, авторизацию отпустила отмена
+		slog.InfoContext(ctx, "payment no longer awaits capture", "order_id", payment.OrderID, "payment_id", payment.ID, "status", payment.Status)
+		return payment, nil
+	}
+
+	strategy := s.strategy(payment.Method, userService)
+	if captureErr := strategy.Capture(ctx, payment); captureErr != nil {
+		slog.WarnContext(ctx, "capture failed, voiding payment", "order_id", payment.OrderID, "payment_id", payment.ID, "error", captureErr)
+		if err := strategy.Void(ctx, payment); err != nil {
+			// Платёж остаётся capturing до повторного события
+			return nil, fmt.Errorf("failed to void payment after a failed capture: %w", errors.Join(captureErr, err))
+		}
 
-		event := map[string]interface{}{
-			"type":    "PaymentFailed",
-			"orderID": payment.OrderID,
-			"userID":  payment.UserID,
-			"email":   payment.Email,
-			"amount":  payment.Amount,
-			"error":   err.Error(),
+		event := paymentEvent("PaymentFailed", *payment)
+		event["reason"] = ReasonCaptureFailed
+		event["error"] = captureErr.Error()
+		if _, err := s.recordStatus(ctx, payment, []string{"capturing"}, "voided", "payment.failed", event); err != nil {
+			return nil, err
 		}
-		body, _ := json.Marshal(event)
-		s.rabbitMQ.Publish("payment.failed", body)
-		log.Printf("Недостаточно средств")
-		return nil, fmt.Errorf("failed to withdraw funds: %w", err)
+
+		metrics.PaymentsFailed.WithLabelValues(ReasonCaptureFailed).Inc()
+		return nil, fmt.Errorf("failed to capture payment: %w", captureErr)
+	}
+
+	if _, err := s.recordStatus(ctx, payment, []string{"capturing"}, "completed", "payment.completed", paymentEvent("PaymentCompleted", *payment)); err != nil {
+		metrics.PaymentsFailed.WithLabelValues("storage").Inc()
+		return nil, err
 	}
+	metrics.PaymentsCompleted.Inc()
 
-	// Деньги успешно списаны
-	payment.Status = "completed"
-	createdPayment, err := s.store.CreatePayment(payment)
+	slog.InfoContext(ctx, "payment completed", "order_id", payment.OrderID, "payment_id", payment.ID, "method", payment.Method)
+	return payment, nil
+}
+
+// RefundPayment settles the payment of a cancelled order. A payment still
+// being authorized or captured is waited for: the error makes the broker
+// retry the cancellation once the money has settled. A completed payment
+// is refunded and announced with payment.refunded; each part goes back the
+// way it was paid, so money taken from a card is refunded through the
+// provider, not to the balance. An authorized payment is voided and announced
+// with payment.voided. An order not paid yet gets a cancelled payment, so the
+// order.created still on its way charges nothing.
+func (s *PaymentService) RefundPayment(ctx context.Context, orderID, userID int, userService *UserServiceClient) (*model.Payment, error) {
+	payment, err := s.store.GetPaymentByOrderID(ctx, orderID)
 	if err != nil {
 		return nil, err
 	}
 
-	event := map[string]interface{}{
-		"type":    "PaymentCompleted",
-		"orderID": createdPayment.OrderID,
-		"userID":  createdPayment.UserID,
-		"email":   createdPayment.Email,
-		"amount":  createdPayment.Amount,
+	switch {
+	case payment == nil:
+		payment, err = s.store.CreatePayment(ctx, model.Payment{OrderID: orderID, UserID: userID, Status: "cancelled"})
+		if err != nil {
+			// Платёж мог появиться в этот момент, повторное событие его застанет
+			return nil, fmt.Errorf("failed to store payment of a cancelled order: %w", err)
+		}
+		slog.InfoContext(ctx, "order cancelled before payment", "order_id", orderID)
+		return payment, nil
+	case payment.Status == "pending" || payment.Status == "capturing":
+		// Деньги в пути, отмену повторит брокер, когда платёж определится
+		return nil, fmt.Errorf("payment %d is %s, the cancellation waits for it", payment.ID, payment.Status)
+	case payment.Status == "authorized" || payment.Status == "voided":
+		return s.voidPayment(ctx, payment, userService)
+	case payment.Status != "completed":
+		slog.InfoContext(ctx, "nothing to refund", "order_id", orderID, "status", payment.Status)
+		return payment, nil
+	}
+
+	slog.InfoContext(ctx, "refunding payment", "order_id", orderID, "payment_id", payment.ID, "amount", payment.Amount)
+	if err := s.strategy(payment.Method, userService).Refund(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to return funds: %w", err)
+	}
+
+	if _, err := s.recordStatus(ctx, payment, []string{"completed"}, "refunded", "payment.refunded", paymentEvent("PaymentRefunded", *payment)); err != nil {
+		return nil, err
+	}
+
+	metrics.PaymentsRefunded.Inc()
+	slog.InfoContext(ctx, "payment refunded", "order_id", orderID, "payment_id", payment.ID)
+	return payment, nil
+}
+
+// voidPayment releases the money of an authorized payment whose order was
+// cancelled. The payment is marked voided before the money is released; a
+// redelivered cancellation finds it voided and releases it again, which is
+// safe to repeat.
+func (s *PaymentService) voidPayment(ctx context.Context, payment *model.Payment, userService *UserServiceClient) (*model.Payment, error) {
+	if payment.Status == "authorized" {
+		claimed, err := s.recordStatus(ctx, payment, []string{"authorized"}, "voided", "payment.voided", paymentEvent("PaymentVoided", *payment))
+		if err != nil {
+			return nil, err
+		}
+		if !claimed {
+			return nil, fmt.Errorf("payment %d moved to %s while voiding it", payment.ID, payment.Status)
+		}
+	}
+
+	if err := s.strategy(payment.Method, userService).Void(ctx, payment); err != nil {
+		return nil, fmt.Errorf("failed to void payment: %w", err)
+	}
+	slog.InfoContext(ctx, "payment voided", "order_id", payment.OrderID, "payment_id", payment.ID)
+	return payment, nil
+}
+
+// recordPayment stores the payment and the event announcing it in one
+// transaction; the outbox relay publishes the event after commit.
+func (s *PaymentService) recordPayment(ctx context.Context, payment model.Payment, routingKey string, event map[string]interface{}) (*model.Payment, error) {
+	// Идентификатор хранится в outbox вместе с событием и не меняется при
+	// повторной публикации, по нему получатели отбрасывают дубликаты
+	event["eventID"] = logger.NewID()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	var createdPayment *model.Payment
+	err = s.store.InTx(ctx, func(tx model.PaymentStore) error {
+		createdPayment, err = tx.CreatePayment(ctx, payment)
+		if err != nil {
+			return err
+		}
+		return tx.AddOutboxEvent(ctx, outbox.NewEvent(ctx, routingKey, body))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store payment: %w", err)
 	}
-	body, _ := json.Marshal(event)
-	s.rabbitMQ.Publish("payment.completed", body)
+	s.outbox.Notify()
 
-	log.Printf("Средства сняты")
 	return createdPayment, nil
 }
 
-func (s *PaymentService) GetPaymentByID(id int) (*model.Payment, error) {
-	return s.store.GetPaymentByID(id)
+// recordStatus moves payment to status if it is still in one of the from
+// statuses, storing its authorization and the event announcing it, if any,
+// in the same transaction. Otherwise it changes nothing, reports false and leaves the
+// current status on payment, because a concurrent cancellation or capture
+// got there first.
+func (s *PaymentService) recordStatus(ctx context.Context, payment *model.Payment, from []string, status, routingKey string, event map[string]interface{}) (bool, error) {
+	var body []byte
+	if event != nil {
+		event["eventID"] = logger.NewID()
+		var err error
+		if body, err = json.Marshal(event); err != nil {
+			return false, fmt.Errorf("failed to marshal event: %w", err)
+		}
+	}
+
+	var moved bool
+	err := s.store.InTx(ctx, func(tx model.PaymentStore) error {
+		current, err := tx.LockPaymentByOrderID(ctx, payment.OrderID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return model.ErrPaymentNotFound
+		}
+		if !slices.Contains(from, current.Status) {
+			payment.Status = current.Status
+			return nil
+		}
+
+		if err := tx.UpdatePaymentStatus(ctx, current.ID, status); err != nil {
+			return err
+		}
+		if payment.AuthorizationID != current.AuthorizationID {
+			if err := tx.UpdatePaymentAuthorization(ctx, current.ID, payment.AuthorizationID); err != nil {
+				return err
+			}
+		}
+		moved = true
+		if body == nil {
+			return nil
+		}
+		return tx.AddOutboxEvent(ctx, outbox.NewEvent(ctx, routingKey, body))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to store payment: %w", err)
+	}
+	if !moved {
+		return false, nil
+	}
+
+	payment.Status = status
+	if body != nil {
+		s.outbox.Notify()
+	}
+	return true, nil
+}
+
+// paymentEvent is the body shared by the events about payment.
+func paymentEvent(eventType string, payment model.Payment) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    eventType,
+		"orderID": payment.OrderID,
+		"userID":  payment.UserID,
+		"email":   payment.Email,
+		"amount":  payment.Amount,
+		"method":  payment.Method,
+	}
+}
+
+func (s *PaymentService) GetPaymentByID(ctx context.Context, id int) (*model.Payment, error) {
+	payment, err := s.store.GetPaymentByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return nil, model.ErrPaymentNotFound
+	}
+	return payment, nil
+}
+
+func (s *PaymentService) UpdateStatus(ctx context.Context, id int, status string) error {
+	return s.store.UpdatePaymentStatus(ctx, id, status)
 }
 
-func (s *PaymentService) UpdateStatus(id int, status string) error {
-	return s.store.UpdatePaymentStatus(id, status)
+// ApplyUserEvent keeps the email copied onto the user's payments in sync with
+// user-service. Payments of an erased account keep no address; the payments
+// themselves stay for the books.
+func (s *PaymentService) ApplyUserEvent(ctx context.Context, event model.UserEvent) error {
+	var email string
+	switch event.Type {
+	case "UserUpdated":
+		if event.Email == "" {
+			return fmt.Errorf("missing or invalid 'email' in event")
+		}
+		email = event.Email
+	case "UserErased":
+	default:
+		return fmt.Errorf("unknown event type: %s", event.Type)
+	}
+
+	if err := s.store.UpdateUserEmail(ctx, event.UserID, email); err != nil {
+		return fmt.Errorf("failed to update payment emails: %w", err)
+	}
+	slog.InfoContext(ctx, "payment emails synced", "user_id", event.UserID, "event_type", event.Type)
+	return nil
 }
 
-func (s *PaymentService) ListPaymentsByUser(userID int) ([]model.Payment, error) {
-	return s.store.ListPaymentsByUser(userID)
+func (s *PaymentService) ListPaymentsByUser(ctx context.Context, userID int, filter model.PaymentFilter, params pagination.Params) (pagination.Page[model.Payment], error) {
+	payments, err := s.store.ListPaymentsByUser(ctx, userID, filter, params)
+	if err != nil {
+		return pagination.Page[model.Payment]{}, err
+	}
+	return pagination.NewPage(payments, params, model.PaymentCursor), nil
+}
+
+// ExportPayments returns every payment of userID, oldest first, for the data
+// export of user-service.
+func (s *PaymentService) ExportPayments(ctx context.Context, userID int) ([]model.Payment, error) {
+	params := pagination.Params{Limit: pagination.MaxLimit, Sort: "createdAt"}
+	payments := []model.Payment{}
+	for {
+		page, err := s.store.ListPaymentsByUser(ctx, userID, model.PaymentFilter{}, params)
+		if err != nil {
+			return nil, err
+		}
+		if len(page) <= params.Limit {
+			return append(payments, page...), nil
+		}
+		payments = append(payments, page[:params.Limit]...)
+		cursor := model.PaymentCursor(page[params.Limit-1], params.Sort)
+		params.Cursor = &cursor
+	}
 }
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/repository"
+)
+
+// balancePath matches the withdrawals, deposits and refunds of one user's balance.
+var balancePath = regexp.MustCompile(`^/api/v1/balance/[0-9]+/(withdraw|add|refund)$`)
+
+// fakeUserService answers withdrawals and deposits with status and a problem
+// body carrying code, and counts the requests it receives.
+func fakeUserService(t *testing.T, status int, code string) (*UserServiceClient, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !balancePath.MatchString(r.URL.Path) {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+		if code != "" {
+			json.NewEncoder(w).Encode(map[string]string{"code": code})
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewUserServiceClient(srv.URL), &calls
+}
+
+func newPaymentService(t *testing.T) (*PaymentService, *repository.MemoryStore) {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	return NewPaymentService(store, NewOutboxRelay(store, broker), nil), store
+}
+
+func pendingEventTypes(t *testing.T, store model.PaymentStore) []string {
+	t.Helper()
+
+	events, err := store.PendingOutboxEvents(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("PendingOutboxEvents: %v", err)
+	}
+	types := make([]string, len(events))
+	for i, event := range events {
+		types[i] = event.EventType
+	}
+	return types
+}
+
+func TestProcessPaymentCompleted(t *testing.T) {
+	ctx := context.Background()
+	s, store := newPaymentService(t)
+	client, _ := fakeUserService(t, http.StatusOK, "")
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+	if payment.Status != "completed" {
+		t.Errorf("status = %q, want completed", payment.Status)
+	}
+	if got := pendingEventTypes(t, store); !slices.Equal(got, []string{"payment.authorized", "payment.completed"}) {
+		t.Errorf("outbox = %v, want [payment.authorized payment.completed]", got)
+	}
+}
+
+func TestProcessPaymentInsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	s, store := newPaymentService(t)
+	client, _ := fakeUserService(t, http.StatusPaymentRequired, "insufficient_funds")
+
+	_, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}, client)
+	if err == nil {
+		t.Fatal("ProcessPayment succeeded, want an error")
+	}
+	if reason := FailureReason(err); reason != ReasonInsufficientFunds {
+		t.Errorf("FailureReason = %q, want %q", reason, ReasonInsufficientFunds)
+	}
+
+	payment, err := store.GetPaymentByOrderID(ctx, 1)
+	if err != nil || payment == nil || payment.Status != "failed" {
+		t.Errorf("stored payment = %+v, %v; want a failed payment", payment, err)
+	}
+	if got := pendingEventTypes(t, store); len(got) != 1 || got[0] != "payment.failed" {
+		t.Errorf("outbox = %v, want [payment.failed]", got)
+	}
+
+	events, _ := store.PendingOutboxEvents(ctx, 10)
+	var event struct {
+		EventID string `json:"eventID"`
+		Reason  string `json:"reason"`
+	}
+	if err := json.Unmarshal(events[0].Payload, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.EventID == "" || event.Reason != ReasonInsufficientFunds {
+		t.Errorf("event = %+v, want an event ID and reason %q", event, ReasonInsufficientFunds)
+	}
+}
+
+func TestProcessPaymentIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	s, store := newPaymentService(t)
+	client, calls := fakeUserService(t, http.StatusOK, "")
+
+	payment := model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}
+	first, err := s.ProcessPayment(ctx, payment, client)
+	if err != nil {
+		t.Fatalf("first ProcessPayment: %v", err)
+	}
+	second, err := s.ProcessPayment(ctx, payment, client)
+	if err != nil {
+		t.Fatalf("second ProcessPayment: %v", err)
+	}
+
+	if first.ID != second.ID {
+		t.Errorf("payment IDs = %d and %d, want the same payment", first.ID, second.ID)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("user service called %d times, want 1", n)
+	}
+	if got := pendingEventTypes(t, store); len(got) != 2 {
+		t.Errorf("outbox = %v, want the events of a single payment", got)
+	}
+}
+
+func TestRefundPayment(t *testing.T) {
+	ctx := context.Background()
+	s, store := newPaymentService(t)
+	client, calls := fakeUserService(t, http.StatusOK, "")
+
+	if _, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}, client); err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+
+	payment, err := s.RefundPayment(ctx, 1, 7, client)
+	if err != nil {
+		t.Fatalf("RefundPayment: %v", err)
+	}
+	if payment.Status != "refunded" {
+		t.Errorf("status = %q, want refunded", payment.Status)
+	}
+	if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored.Status != "refunded" {
+		t.Errorf("stored status = %q, want refunded", stored.Status)
+	}
+
+	// Повторная отмена и отмена неоплаченного заказа деньги не возвращают
+	if _, err := s.RefundPayment(ctx, 1, 7, client); err != nil {
+		t.Fatalf("second RefundPayment: %v", err)
+	}
+	if payment, err := s.RefundPayment(ctx, 2, 7, client); err != nil || payment.Status != "cancelled" {
+		t.Errorf("RefundPayment of an unpaid order = %+v, %v; want a cancelled payment", payment, err)
+	}
+	// order.created отменённого заказа пришёл последним и ничего не списывает
+	if payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 2, UserID: 7, Email: "ann@example.com", Amount: 25}, client); err != nil || payment.Status != "cancelled" {
+		t.Errorf("ProcessPayment of a cancelled order = %+v, %v; want the cancelled payment", payment, err)
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("user service called %d times, want a withdrawal and one deposit", n)
+	}
+	got := pendingEventTypes(t, store)
+	if len(got) != 3 || got[2] != "payment.refunded" {
+		t.Errorf("outbox = %v, want the payment events and payment.refunded", got)
+	}
+}
+
+func TestRefundPaymentDepositFails(t *testing.T) {
+	ctx := context.Background()
+	s, store := newPaymentService(t)
+
+	paid, _ := fakeUserService(t, http.StatusOK, "")
+	if _, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}, paid); err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+
+	unavailable, _ := fakeUserService(t, http.StatusServiceUnavailable, "")
+	if _, err := s.RefundPayment(ctx, 1, 7, unavailable); err == nil {
+		t.Fatal("RefundPayment succeeded, want an error")
+	}
+	if stored, _ := store.GetPaymentByOrderID(ctx, 1); stored.Status != "completed" {
+		t.Errorf("stored status = %q, want completed so the refund is retried", stored.Status)
+	}
+}
+
+func TestApplyUserEvent(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentService(t)
+	client, _ := fakeUserService(t, http.StatusOK, "")
+
+	payment, err := s.ProcessPayment(ctx, model.Payment{OrderID: 1, UserID: 7, Email: "ann@example.com", Amount: 25}, client)
+	if err != nil {
+		t.Fatalf("ProcessPayment: %v", err)
+	}
+
+	events := []struct {
+		event model.UserEvent
+		want  string
+	}{
+		{model.UserEvent{Type: "UserUpdated", UserID: 7, Email: "anna@example.com"}, "anna@example.com"},
+		{model.UserEvent{Type: "UserErased", UserID: 7}, ""},
+	}
+	for _, tt := range events {
+		if err := s.ApplyUserEvent(ctx, tt.event); err != nil {
+			t.Fatalf("ApplyUserEvent(%s): %v", tt.event.Type, err)
+		}
+		got, err := s.GetPaymentByID(ctx, payment.ID)
+		if err != nil {
+			t.Fatalf("GetPaymentByID: %v", err)
+		}
+		if got.Email != tt.want {
+			t.Errorf("email after %s = %q, want %q", tt.event.Type, got.Email, tt.want)
+		}
+	}
+
+	if err := s.ApplyUserEvent(ctx, model.UserEvent{Type: "UserRegistered", UserID: 7}); err == nil {
+		t.Error("ApplyUserEvent of an unknown type succeeded, want an error")
+	}
+}
+
+func TestExportPayments(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newPaymentService(t)
+	client, _ := fakeUserService(t, http.StatusOK, "")
+
+	for orderID, userID := range []int{7, 8, 7} {
+		_, err := s.ProcessPayment(ctx, model.Payment{OrderID: orderID + 1, UserID: userID, Email: "ann@example.com", Amount: 25}, client)
+		if err != nil {
+			t.Fatalf("ProcessPayment: %v", err)
+		}
+	}
+
+	payments, err := s.ExportPayments(ctx, 7)
+	if err != nil {
+		t.Fatalf("ExportPayments: %v", err)
+	}
+	if len(payments) != 2 || payments[0].OrderID != 1 || payments[1].OrderID != 3 {
+		t.Errorf("exported payments = %+v, want the payments of orders 1 and 3", payments)
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Viltsev/minishop/payment-service/internal/metrics"
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+)
+
+// TopUpService lets users top up their balance through a payment provider.
+// The balance in user-service is credited once the provider reports by
+// webhook that it collected the money.
+type TopUpService struct {
+	store    model.PaymentStore
+	provider provider.PaymentProvider
+	// users credits the balances kept by the user service.
+	users *UserServiceClient
+}
+
+func NewTopUpService(store model.PaymentStore, provider provider.PaymentProvider, userServiceURL string) *TopUpService {
+	return &TopUpService{store: store, provider: provider, users: NewUserServiceClient(userServiceURL)}
+}
+
+// Create starts a top-up of amount for userID with a payment intent at the
+// provider. The user confirms it with Confirm.
+func (s *TopUpService) Create(ctx context.Context, userID int, amount float64) (*model.TopUp, error) {
+	topUp := &model.TopUp{UserID: userID, Amount: amount, Provider: s.provider.Name(), Status: model.TopUpPending}
+	if err := s.store.CreateTopUp(ctx, topUp); err != nil {
+		return nil, fmt.Errorf("failed to store top-up: %w", err)
+	}
+
+	intent, err := s.provider.CreateIntent(ctx, amount, topUp.Reference())
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to create payment intent", "top_up_id", topUp.ID, "provider", topUp.Provider, "error", err)
+		topUp.Status, topUp.FailureReason = model.TopUpFailed, "provider_unavailable"
+		if err := s.store.UpdateTopUp(ctx, topUp); err != nil {
+			return nil, err
+		}
+		metrics.TopUps.WithLabelValues(model.TopUpFailed).Inc()
+		return nil, model.ErrProviderFailed
+	}
+
+	topUp.IntentID = intent.ID
+	if err := s.store.UpdateTopUp(ctx, topUp); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "top-up created", "top_up_id", topUp.ID, "user_id", userID, "amount", amount, "intent_id", intent.ID)
+	return topUp, nil
+}
+
+// Get returns top-up id of userID. Top-ups of other users are not found.
+func (s *TopUpService) Get(ctx context.Context, userID, id int) (*model.TopUp, error) {
+	topUp, err := s.store.GetTopUp(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if topUp == nil || topUp.UserID != userID {
+		return nil, model.ErrTopUpNotFound
+	}
+	return topUp, nil
+}
+
+// Confirm asks the provider to collect a pending top-up of userID with
+// paymentMethod. The top-up stays processing until the provider's webhook.
+func (s *TopUpService) Confirm(ctx context.Context, userID, id int, paymentMethod string) (*model.TopUp, error) {
+	topUp, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if topUp.Status != model.TopUpPending {
+		return nil, model.ErrTopUpConfirmed
+	}
+
+	if _, err := s.provider.Confirm(ctx, topUp.IntentID, paymentMethod); err != nil {
+		if errors.Is(err, provider.ErrPaymentMethod) {
+			return nil, model.InvalidInput("%v", err)
+		}
+		slog.ErrorContext(ctx, "failed to confirm payment intent", "top_up_id", id, "intent_id", topUp.IntentID, "error", err)
+		return nil, model.ErrProviderFailed
+	}
+
+	err = s.store.InTx(ctx, func(tx model.PaymentStore) error {
+		topUp, err = tx.LockTopUpByIntent(ctx, topUp.Provider, topUp.IntentID)
+		if err != nil {
+			return err
+		}
+		// Вебхук мог прийти раньше, чем мы сюда дошли
+		if topUp.Status != model.TopUpPending {
+			return nil
+		}
+		topUp.Status = model.TopUpProcessing
+		return tx.UpdateTopUp(ctx, topUp)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store top-up: %w", err)
+	}
+
+	slog.InfoContext(ctx, "top-up confirmed", "top_up_id", id, "intent_id", topUp.IntentID)
+	return topUp, nil
+}
+
+// HandleWebhook applies an event the provider called providerName sent
+// about an intent. A successful payment is credited to the balance; when
+// user-service cannot take it, the error asks the provider to redeliver, and
+// the credit is repeated safely. Events that arrive again change nothing.
+func (s *TopUpService) HandleWebhook(ctx context.Context, providerName string, header http.Header, body []byte) error {
+	if providerName != s.provider.Name() {
+		return model.ErrTopUpNotFound
+	}
+	event, err := s.provider.ParseWebhook(header, body)
+	if errors.Is(err, provider.ErrInvalidSignature) {
+		return model.ErrInvalidSignature
+	}
+	if err != nil {
+		return model.InvalidInput("%v", err)
+	}
+
+	var topUp *model.TopUp
+	err = s.store.InTx(ctx, func(tx model.PaymentStore) error {
+		topUp, err = tx.LockTopUpByIntent(ctx, providerName, event.IntentID)
+		if err != nil {
+			return err
+		}
+		if topUp == nil {
+			return model.ErrTopUpNotFound
+		}
+
+		switch {
+		case topUp.Status == model.TopUpCredited || topUp.Status == model.TopUpFailed || topUp.Status == model.TopUpSucceeded:
+			return nil
+		case event.Status == provider.StatusSucceeded:
+			topUp.Status = model.TopUpSucceeded
+		case event.Status == provider.StatusFailed:
+			topUp.Status, topUp.FailureReason = model.TopUpFailed, event.FailureReason
+			metrics.TopUps.WithLabelValues(model.TopUpFailed).Inc()
+		default:
+			// Промежуточные статусы ничего не меняют
+			return nil
+		}
+		return tx.UpdateTopUp(ctx, topUp)
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "top-up webhook received", "top_up_id", topUp.ID, "event_id", event.ID, "event_status", event.Status, "status", topUp.Status)
+	if topUp.Status != model.TopUpSucceeded {
+		return nil
+	}
+	return s.credit(ctx, topUp)
+}
+
+// credit puts a succeeded top-up on the user's balance.
+func (s *TopUpService) credit(ctx context.Context, topUp *model.TopUp) error {
+	if err := s.users.Deposit(ctx, topUp.UserID, topUp.Amount, topUp.Reference()); err != nil {
+		slog.WarnContext(ctx, "failed to credit top-up", "top_up_id", topUp.ID, "user_id", topUp.UserID, "error", err)
+		return model.ErrCreditUnavailable
+	}
+
+	err := s.store.InTx(ctx, func(tx model.PaymentStore) error {
+		locked, err := tx.LockTopUpByIntent(ctx, topUp.Provider, topUp.IntentID)
+		if err != nil {
+			return err
+		}
+		if locked.Status != model.TopUpSucceeded {
+			return nil
+		}
+		locked.Status = model.TopUpCredited
+		if err := tx.UpdateTopUp(ctx, locked); err != nil {
+			return err
+		}
+		metrics.TopUps.WithLabelValues(model.TopUpCredited).Inc()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store top-up: %w", err)
+	}
+
+	slog.InfoContext(ctx, "top-up credited", "top_up_id", topUp.ID, "user_id", topUp.UserID, "amount", topUp.Amount)
+	return nil
+}
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Viltsev/minishop/payment-service/internal/model"
+	"github.com/Viltsev/minishop/payment-service/internal/provider"
+	"github.com/Viltsev/minishop/payment-service/internal/repository"
+)
+
+// stubProvider settles intents only when the test sends a webhook. Webhooks
+// count as signed when they carry the stub header. Top-ups never authorize at
+// checkout, so the Acquirer is left nil.
+type stubProvider struct {
+	provider.Acquirer
+}
+
+const stubSignedHeader = "X-Stub-Signed"
+
+func (stubProvider) Name() string { return "stub" }
+
+func (stubProvider) CreateIntent(ctx context.Context, amount float64, reference string) (*provider.Intent, error) {
+	return &provider.Intent{ID: "pi_" + reference, Amount: amount, Reference: reference, Status: provider.StatusRequiresConfirmation}, nil
+}
+
+func (stubProvider) Confirm(ctx context.Context, intentID, paymentMethod string) (*provider.Intent, error) {
+	if paymentMethod != "card" {
+		return nil, provider.ErrPaymentMethod
+	}
+	return &provider.Intent{ID: intentID, Status: provider.StatusProcessing}, nil
+}
+
+func (stubProvider) ParseWebhook(header http.Header, body []byte) (*provider.Event, error) {
+	if header.Get(stubSignedHeader) == "" {
+		return nil, provider.ErrInvalidSignature
+	}
+	var event provider.Event
+	return &event, json.Unmarshal(body, &event)
+}
+
+// balanceService stands in for user-service and records the credits it gets.
+type balanceService struct {
+	status  atomic.Int32
+	mu      sync.Mutex
+	credits []string
+}
+
+func newTopUpService(t *testing.T) (*TopUpService, *balanceService) {
+	t.Helper()
+
+	balances := &balanceService{}
+	balances.status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/balance/7/add" {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Error("credit without a service token")
+		}
+		var payload struct {
+			Amount    float64 `json:"amount"`
+			Reference string  `json:"reference"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		status := int(balances.status.Load())
+		if status == http.StatusOK {
+			balances.mu.Lock()
+			balances.credits = append(balances.credits, payload.Reference)
+			balances.mu.Unlock()
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	return NewTopUpService(repository.NewMemoryStore(), stubProvider{}, srv.URL), balances
+}
+
+func sendWebhook(s *TopUpService, intentID, status string) error {
+	header := http.Header{}
+	header.Set(stubSignedHeader, "yes")
+	body, _ := json.Marshal(provider.Event{ID: "evt_" + intentID, IntentID: intentID, Status: status})
+	return s.HandleWebhook(context.Background(), "stub", header, body)
+}
+
+func confirmedTopUp(t *testing.T, s *TopUpService) *model.TopUp {
+	t.Helper()
+
+	ctx := context.Background()
+	topUp, err := s.Create(ctx, 7, 25)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if topUp.Status != model.TopUpPending || topUp.IntentID == "" {
+		t.Fatalf("created top-up = %+v, want a pending top-up with an intent", topUp)
+	}
+	topUp, err = s.Confirm(ctx, 7, topUp.ID, "card")
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if topUp.Status != model.TopUpProcessing {
+		t.Fatalf("confirmed top-up status = %q, want %q", topUp.Status, model.TopUpProcessing)
+	}
+	return topUp
+}
+
+func topUpStatus(t *testing.T, s *TopUpService, id int) string {
+	t.Helper()
+
+	topUp, err := s.Get(context.Background(), 7, id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	return topUp.Status
+}
+
+func TestTopUpCreditedOnce(t *testing.T) {
+	s, balances := newTopUpService(t)
+	topUp := confirmedTopUp(t, s)
+
+	for range 2 {
+		if err := sendWebhook(s, topUp.IntentID, provider.StatusSucceeded); err != nil {
+			t.Fatalf("HandleWebhook: %v", err)
+		}
+	}
+
+	if status := topUpStatus(t, s, topUp.ID); status != model.TopUpCredited {
+		t.Errorf("status = %q, want %q", status, model.TopUpCredited)
+	}
+	if len(balances.credits) != 1 || balances.credits[0] != "topup:1" {
+		t.Errorf("credits = %v, want [topup:1]", balances.credits)
+	}
+}
+
+func TestTopUpFailed(t *testing.T) {
+	s, balances := newTopUpService(t)
+	topUp := confirmedTopUp(t, s)
+
+	if err := sendWebhook(s, topUp.IntentID, provider.StatusFailed); err != nil {
+		t.Fatalf("HandleWebhook: %v", err)
+	}
+	// Успех после отказа уже ничего не меняет
+	if err := sendWebhook(s, topUp.IntentID, provider.StatusSucceeded); err != nil {
+		t.Fatalf("HandleWebhook: %v", err)
+	}
+
+	if status := topUpStatus(t, s, topUp.ID); status != model.TopUpFailed {
+		t.Errorf("status = %q, want %q", status, model.TopUpFailed)
+	}
+	if len(balances.credits) != 0 {
+		t.Errorf("credits = %v, want none", balances.credits)
+	}
+}
+
+func TestTopUpCreditRetriedOnRedelivery(t *testing.T) {
+	s, balances := newTopUpService(t)
+	topUp := confirmedTopUp(t, s)
+
+	balances.status.Store(http.StatusServiceUnavailable)
+	if err := sendWebhook(s, topUp.IntentID, provider.StatusSucceeded); !errors.Is(err, model.ErrCreditUnavailable) {
+		t.Fatalf("HandleWebhook error = %v, want %v", err, model.ErrCreditUnavailable)
+	}
+	if status := topUpStatus(t, s, topUp.ID); status != model.TopUpSucceeded {
+		t.Errorf("status after a failed credit = %q, want %q", status, model.TopUpSucceeded)
+	}
+
+	balances.status.Store(http.StatusOK)
+	if err := sendWebhook(s, topUp.IntentID, provider.StatusSucceeded); err != nil {
+		t.Fatalf("redelivered HandleWebhook: %v", err)
+	}
+	if status := topUpStatus(t, s, topUp.ID); status != model.TopUpCredited {
+		t.Errorf("status after redelivery = %q, want %q", status, model.TopUpCredited)
+	}
+	if len(balances.credits) != 1 {
+		t.Errorf("credits = %v, want one", balances.credits)
+	}
+}
+
+func TestTopUpRejections(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTopUpService(t)
+	topUp := confirmedTopUp(t, s)
+
+	if _, err := s.Get(ctx, 8, topUp.ID); !errors.Is(err, model.ErrTopUpNotFound) {
+		t.Errorf("Get by another user: error = %v, want %v", err, model.ErrTopUpNotFound)
+	}
+	if _, err := s.Confirm(ctx, 7, topUp.ID, "card"); !errors.Is(err, model.ErrTopUpConfirmed) {
+		t.Errorf("second Confirm: error = %v, want %v", err, model.ErrTopUpConfirmed)
+	}
+
+	pending, err := s.Create(ctx, 7, 10)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	_, err = s.Confirm(ctx, 7, pending.ID, "cash")
+	var domainErr *model.Error
+	if !errors.As(err, &domainErr) || domainErr.Kind != model.KindInvalid {
+		t.Errorf("Confirm with an unsupported method: error = %v, want an invalid input error", err)
+	}
+
+	forged := []byte(`{"intentID":"pi_topup:1","status":"succeeded"}`)
+	if err := s.HandleWebhook(ctx, "stub", http.Header{}, forged); !errors.Is(err, model.ErrInvalidSignature) {
+		t.Errorf("unsigned webhook: error = %v, want %v", err, model.ErrInvalidSignature)
+	}
+	if err := s.HandleWebhook(ctx, "other", http.Header{}, forged); !errors.Is(err, model.ErrTopUpNotFound) {
+		t.Errorf("webhook of another provider: error = %v, want %v", err, model.ErrTopUpNotFound)
+	}
+	if err := sendWebhook(s, "pi_unknown", provider.StatusSucceeded); !errors.Is(err, model.ErrTopUpNotFound) {
+		t.Errorf("webhook for an unknown intent: error = %v, want %v", err, model.ErrTopUpNotFound)
+	}
+}
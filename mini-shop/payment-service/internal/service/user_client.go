@@ -2,38 +2,159 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
+
+	"github.com/Viltsev/minishop/payment-service/internal/auth"
+	"github.com/Viltsev/minishop/payment-service/internal/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"mini-shop/platform/logger"
+)
+
+const (
+	ReasonInsufficientFunds      = "insufficient_funds"
+	ReasonUserNotFound           = "user_not_found"
+	ReasonWithdrawRejected       = "withdraw_rejected"
+	ReasonUserServiceUnavailable = "user_service_unavailable"
 )
 
+// serviceName is how the user service knows this service.
+const serviceName = "payment-service"
+
+// httpClient propagates the trace context to the user service.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// UserServiceClient moves the balances kept by the user service.
 type UserServiceClient struct {
+	// BaseURL is the base URL of the user service.
 	BaseURL string
 }
 
+// WithdrawError explains why the user service did not withdraw the funds.
+type WithdrawError struct {
+	Reason string
+	Err    error
+}
+
+func (e *WithdrawError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *WithdrawError) Unwrap() error {
+	return e.Err
+}
+
+// FailureReason maps a payment error to a short machine-readable reason.
+func FailureReason(err error) string {
+	var withdrawErr *WithdrawError
+	if errors.As(err, &withdrawErr) {
+		return withdrawErr.Reason
+	}
+	var checkoutErr *CheckoutError
+	if errors.As(err, &checkoutErr) {
+		return checkoutErr.Reason
+	}
+	return "internal"
+}
+
 func NewUserServiceClient(baseURL string) *UserServiceClient {
 	return &UserServiceClient{BaseURL: baseURL}
 }
 
-func (u *UserServiceClient) Withdraw(userID int, amount float64) error {
-	payload := map[string]interface{}{
-		"amount": amount,
+// Withdraw charges amount to the user's balance for the payment named by
+// reference. The user service charges each reference once, so a withdrawal
+// repeated after a crash takes no money twice.
+func (u *UserServiceClient) Withdraw(ctx context.Context, userID int, amount float64, reference string) error {
+	resp, err := u.post(ctx, userID, "withdraw", map[string]any{"amount": amount, "reference": reference})
+	if err != nil {
+		return &WithdrawError{
+			Reason: ReasonUserServiceUnavailable,
+			Err:    fmt.Errorf("failed to contact user service: %w", err),
+		}
 	}
+	defer resp.Body.Close()
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		return err
+	if resp.StatusCode != http.StatusOK {
+		// user-service отвечает в формате RFC 7807, причину берём из поля code
+		var problem struct {
+			Code string `json:"code"`
+		}
+		json.NewDecoder(resp.Body).Decode(&problem)
+
+		return &WithdrawError{
+			Reason: withdrawReason(resp.StatusCode, problem.Code),
+			Err:    fmt.Errorf("withdrawal failed with status: %d", resp.StatusCode),
+		}
 	}
 
-	resp, err := http.Post(fmt.Sprintf("%s/withdraw", u.BaseURL), "application/json", bytes.NewBuffer(data))
+	return nil
+}
+
+// Deposit credits a top-up of amount to the user's balance. The user service
+// credits each reference once, so a failed deposit can be retried.
+func (u *UserServiceClient) Deposit(ctx context.Context, userID int, amount float64, reference string) error {
+	return u.credit(ctx, userID, "add", amount, reference)
+}
+
+// Refund gives back amount taken from the user's balance for a payment that
+// was voided or whose order was cancelled. The user service records it as a
+// refund, not a top-up, and credits each reference once, as in Deposit.
+func (u *UserServiceClient) Refund(ctx context.Context, userID int, amount float64, reference string) error {
+	return u.credit(ctx, userID, "refund", amount, reference)
+}
+
+func (u *UserServiceClient) credit(ctx context.Context, userID int, action string, amount float64, reference string) error {
+	resp, err := u.post(ctx, userID, action, map[string]any{"amount": amount, "reference": reference})
 	if err != nil {
 		return fmt.Errorf("failed to contact user service: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("withdrawal failed with status: %d", resp.StatusCode)
+		return fmt.Errorf("%s failed with status: %d", action, resp.StatusCode)
 	}
 
 	return nil
 }
+
+// post sends payload to action on the balance of userID.
+func (u *UserServiceClient) post(ctx context.Context, userID int, action string, payload map[string]any) (*http.Response, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/v1/balance/%d/%s", u.BaseURL, userID, action), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	// Деньги в user-service двигаются только по токену сервиса
+	token, err := auth.CreateServiceJWT([]byte(config.Envs.JWTSecret), serviceName, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue service token: %w", err)
+	}
+	req.Header.Set("Authorization", token)
+	req.Header.Set("Content-Type", "application/json")
+	if id := logger.CorrelationID(ctx); id != "" {
+		req.Header.Set(logger.CorrelationIDHeader, id)
+	}
+
+	return httpClient.Do(req)
+}
+
+func withdrawReason(status int, code string) string {
+	switch {
+	case code == ReasonInsufficientFunds || status == http.StatusPaymentRequired:
+		return ReasonInsufficientFunds
+	case code == ReasonUserNotFound || status == http.StatusNotFound:
+		return ReasonUserNotFound
+	case status >= http.StatusInternalServerError:
+		return ReasonUserServiceUnavailable
+	default:
+		return ReasonWithdrawRejected
+	}
+}
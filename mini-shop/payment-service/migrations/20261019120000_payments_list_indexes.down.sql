@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_payments_user_status;
+DROP INDEX IF EXISTS idx_payments_user_amount;
+DROP INDEX IF EXISTS idx_payments_user_created_at;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS idx_payments_user_created_at ON payments (userID, createdAt, id);
+CREATE INDEX IF NOT EXISTS idx_payments_user_amount ON payments (userID, amount, id);
+CREATE INDEX IF NOT EXISTS idx_payments_user_status ON payments (userID, status);
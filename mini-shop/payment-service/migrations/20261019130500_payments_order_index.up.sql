@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_payments_order ON payments (orderID);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS top_ups (
+    id SERIAL PRIMARY KEY,
+    userID INTEGER NOT NULL,
+    amount DOUBLE PRECISION NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    intentID VARCHAR(100) NOT NULL DEFAULT '',
+    status VARCHAR(20) NOT NULL,
+    failureReason VARCHAR(100) NOT NULL DEFAULT '',
+    createdAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updatedAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Вебхуки провайдера находят пополнение по намерению
+CREATE UNIQUE INDEX IF NOT EXISTS idx_top_ups_intent ON top_ups (provider, intentID) WHERE intentID <> '';
+CREATE INDEX IF NOT EXISTS idx_top_ups_user ON top_ups (userID, createdAt, id);
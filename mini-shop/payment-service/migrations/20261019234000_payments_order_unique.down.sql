@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_payments_order;
+CREATE INDEX IF NOT EXISTS idx_payments_order ON payments (orderID);
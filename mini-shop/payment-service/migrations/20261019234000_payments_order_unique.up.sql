@@ -0,0 +1,16 @@
+-- Повторные платежи по одному заказу удалять автоматически нельзя: это деньги.
+-- Если они есть, миграция останавливается, и их нужно разобрать вручную.
+DO $$
+DECLARE
+    duplicated INTEGER;
+BEGIN
+    SELECT count(*) INTO duplicated FROM (
+        SELECT orderID FROM payments GROUP BY orderID HAVING count(*) > 1
+    ) d;
+    IF duplicated > 0 THEN
+        RAISE EXCEPTION '% orders have more than one payment; resolve them before making payments.orderID unique (SELECT orderID, array_agg(id) FROM payments GROUP BY orderID HAVING count(*) > 1)', duplicated;
+    END IF;
+END $$;
+
+DROP INDEX IF EXISTS idx_payments_order;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_payments_order ON payments (orderID);
@@ -0,0 +1 @@
+ALTER TABLE payments ALTER COLUMN amount TYPE INTEGER USING round(amount);
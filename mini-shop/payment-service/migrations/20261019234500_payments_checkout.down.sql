@@ -0,0 +1,5 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS authorizationID;
+ALTER TABLE payments DROP COLUMN IF EXISTS providerMethod;
+ALTER TABLE payments DROP COLUMN IF EXISTS providerAmount;
+ALTER TABLE payments DROP COLUMN IF EXISTS balanceAmount;
+ALTER TABLE payments DROP COLUMN IF EXISTS method;
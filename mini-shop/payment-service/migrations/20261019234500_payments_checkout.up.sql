@@ -0,0 +1,8 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS method VARCHAR(20) NOT NULL DEFAULT 'balance';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS balanceAmount DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS providerAmount DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS providerMethod VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS authorizationID VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Раньше все платежи списывались с баланса целиком
+UPDATE payments SET balanceAmount = amount WHERE method = 'balance';
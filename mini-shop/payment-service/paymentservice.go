@@ -0,0 +1,27 @@
+// Package paymentservice embeds the payment service into another process, such as
+// the all-in-one local runner.
+package paymentservice
+
+import (
+	"context"
+
+	"github.com/Viltsev/minishop/payment-service/internal/app"
+	"github.com/Viltsev/minishop/payment-service/internal/messaging"
+)
+
+// Broker is the message bus the service publishes to and consumes from.
+type Broker = messaging.Broker
+
+// Options configures the service.
+type Options = app.Options
+
+// NewMemoryBroker returns an in-process topic broker. Its method set uses only
+// built-in types, so one instance can be shared by every service in a process.
+func NewMemoryBroker() Broker {
+	return messaging.NewMemoryBroker()
+}
+
+// Run serves the payment service until ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	return app.Run(ctx, opts)
+}
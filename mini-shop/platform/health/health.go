@@ -0,0 +1,109 @@
+// Package health serves the liveness and readiness probes of a service.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a single dependency is usable. A nil error means "up".
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+func (c *Checker) RegisterRoutes(router *mux.Router) {
+	router.HandleFunc("/healthz", c.handleLiveness).Methods("GET")
+	router.HandleFunc("/readyz", c.handleReadiness).Methods("GET")
+}
+
+// Readiness runs every registered check concurrently and aggregates the results.
+func (c *Checker) Readiness(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	results := make([]DependencyStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			if err := nc.check(ctx); err != nil {
+				results[i] = DependencyStatus{Status: "down", Error: err.Error()}
+				return
+			}
+			results[i] = DependencyStatus{Status: "up"}
+		}(i, nc)
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Dependencies: make(map[string]DependencyStatus, len(checks))}
+	for i, nc := range checks {
+		report.Dependencies[nc.name] = results[i]
+		if results[i].Status != "up" {
+			report.Status = "unavailable"
+		}
+	}
+
+	return report
+}
+
+func (c *Checker) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: "ok"})
+}
+
+func (c *Checker) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	report := c.Readiness(r.Context())
+
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
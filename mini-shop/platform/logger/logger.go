@@ -0,0 +1,138 @@
+// Package logger sets up structured logging and carries request and
+// correlation IDs through contexts.
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RequestIDHeader     = "X-Request-ID"
+	CorrelationIDHeader = "X-Correlation-ID"
+)
+
+type contextKey string
+
+const (
+	requestIDKey     contextKey = "requestID"
+	correlationIDKey contextKey = "correlationID"
+)
+
+// piiKeys are attribute keys whose values must never reach the logs verbatim.
+var piiKeys = map[string]bool{
+	"email":    true,
+	"password": true,
+	"token":    true,
+	"secret":   true,
+}
+
+// Setup installs a JSON logger that annotates every record with the request,
+// correlation and trace IDs found in the context. level is one of "debug",
+// "info", "warn" or "error".
+func Setup(service, level string) {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       parseLevel(level),
+		ReplaceAttr: redact,
+	})
+
+	slog.SetDefault(slog.New(contextHandler{handler}).With("service", service))
+}
+
+// Fatal logs at error level and exits, replacing log.Fatal.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey).(string)
+	return id
+}
+
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func parseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if !piiKeys[strings.ToLower(a.Key)] {
+		return a
+	}
+
+	if strings.EqualFold(a.Key, "email") {
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	}
+	return slog.String(a.Key, "[REDACTED]")
+}
+
+// MaskEmail keeps the first character and the domain: "j***@example.com".
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return "[REDACTED]"
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id := CorrelationID(ctx); id != "" {
+		r.AddAttrs(slog.String("correlation_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(
+			slog.String("trace_id", sc.TraceID().String()),
+			slog.String("span_id", sc.SpanID().String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
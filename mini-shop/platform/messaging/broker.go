@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+
+	"mini-shop/platform/metrics"
+)
+
+const (
+	BrokerRabbitMQ = "rabbitmq"
+	BrokerMemory   = "memory"
+)
+
+// Publisher sends a message to the exchange under the given routing key.
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte) error
+}
+
+// Subscriber delivers every message whose routing key matches bindingKey to
+// handler. Binding keys follow AMQP topic rules ("payment.*", "order.#").
+type Subscriber interface {
+	Consume(bindingKey string, handler func(context.Context, []byte) error) error
+}
+
+// Broker is what the services need from the message bus. Method signatures use
+// only built-in types, so one in-memory broker can be shared by every service
+// running in the same process.
+type Broker interface {
+	Publisher
+	Subscriber
+	Check(ctx context.Context) error
+	CheckConsumer(bindingKey string) error
+	Close()
+}
+
+// Exchange is the topic exchange every service publishes to.
+const Exchange = "minishop"
+
+// New returns the broker selected by kind: RabbitMQ at url, or an in-process
+// broker for tests and local runs. service names the queues the broker
+// declares; m counts the messages it handles.
+func New(kind, url, service string, m *metrics.Set) (Broker, error) {
+	switch kind {
+	case "", BrokerRabbitMQ:
+		return NewRabbitMQ(url, Exchange, service, m)
+	case BrokerMemory:
+		return NewMemoryBroker(m), nil
+	default:
+		return nil, fmt.Errorf("unknown broker %q", kind)
+	}
+}
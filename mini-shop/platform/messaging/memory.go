@@ -0,0 +1,231 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/metrics"
+	"mini-shop/platform/tracing"
+)
+
+const memoryExchange = "memory"
+
+// MemoryBroker is an in-process topic exchange. Every Consume call gets its own
+// queue, drained by a dedicated goroutine, so handlers run asynchronously and
+// in publish order just like with RabbitMQ. A failed message is retried like
+// with RabbitMQ and dropped when it keeps failing. One broker can carry the messages
+// of every service running in the process.
+type MemoryBroker struct {
+	metrics *metrics.Set
+
+	mu     sync.RWMutex
+	queues []*memoryQueue
+	closed bool
+}
+
+type memoryMessage struct {
+	routingKey string
+	headers    map[string]string
+	body       []byte
+	timestamp  time.Time
+}
+
+type memoryQueue struct {
+	bindingKey string
+	handler    func(context.Context, []byte) error
+	metrics    *metrics.Set
+
+	mu      sync.Mutex
+	pending []memoryMessage
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// NewMemoryBroker returns a broker that counts its messages in m.
+func NewMemoryBroker(m *metrics.Set) *MemoryBroker {
+	return &MemoryBroker{metrics: m}
+}
+
+func (b *MemoryBroker) Publish(ctx context.Context, routingKey string, body []byte) error {
+	ctx, span := tracer().Start(ctx, "publish "+routingKey,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(memoryExchange, routingKey)...),
+	)
+	defer span.End()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		err := fmt.Errorf("broker is closed")
+		b.metrics.MessagesFailed.WithLabelValues(routingKey, "publish").Inc()
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	headers := map[string]string{logger.CorrelationIDHeader: correlationID(ctx)}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+
+	msg := memoryMessage{
+		routingKey: routingKey,
+		headers:    headers,
+		body:       append([]byte(nil), body...),
+		timestamp:  time.Now(),
+	}
+	for _, q := range b.queues {
+		if MatchTopic(q.bindingKey, routingKey) {
+			q.push(msg)
+		}
+	}
+
+	b.metrics.MessagesPublished.WithLabelValues(routingKey).Inc()
+	return nil
+}
+
+func (b *MemoryBroker) Consume(bindingKey string, handler func(context.Context, []byte) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return fmt.Errorf("broker is closed")
+	}
+
+	q := &memoryQueue{
+		bindingKey: bindingKey,
+		handler:    handler,
+		metrics:    b.metrics,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	b.queues = append(b.queues, q)
+	go q.run()
+
+	slog.Info("waiting for messages", "broker", BrokerMemory, "binding_key", bindingKey)
+	return nil
+}
+
+func (b *MemoryBroker) Check(ctx context.Context) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return fmt.Errorf("broker is closed")
+	}
+	return nil
+}
+
+func (b *MemoryBroker) CheckConsumer(bindingKey string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return fmt.Errorf("consumer %q stopped", bindingKey)
+	}
+	for _, q := range b.queues {
+		if q.bindingKey == bindingKey {
+			return nil
+		}
+	}
+	return fmt.Errorf("consumer %q not started", bindingKey)
+}
+
+func (b *MemoryBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for _, q := range b.queues {
+		close(q.done)
+	}
+}
+
+func (q *memoryQueue) push(msg memoryMessage) {
+	q.mu.Lock()
+	q.pending = append(q.pending, msg)
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *memoryQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+				continue
+			case <-q.done:
+				return
+			}
+		}
+		msg := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		q.deliver(msg)
+	}
+}
+
+// deliver mirrors RabbitMQ.handleDelivery for in-process messages.
+func (q *memoryQueue) deliver(msg memoryMessage) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(msg.headers))
+	ctx = logger.WithCorrelationID(ctx, msg.headers[logger.CorrelationIDHeader])
+	ctx, span := tracer().Start(ctx, "process "+msg.routingKey,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(memoryExchange, msg.routingKey)...),
+	)
+	defer span.End()
+
+	q.metrics.MessagesConsumed.WithLabelValues(msg.routingKey).Inc()
+	q.metrics.ConsumerLag.WithLabelValues(msg.routingKey).Observe(time.Since(msg.timestamp).Seconds())
+
+	slog.DebugContext(ctx, "message received", "routing_key", msg.routingKey, "size", len(msg.body))
+	if err := handleWithRetry(ctx, q.metrics, msg.routingKey, msg.body, q.handler); err != nil {
+		// Очереди мёртвых писем в памяти нет, сообщение остаётся только в логе
+		slog.ErrorContext(ctx, "failed to handle message, dropping it", "routing_key", msg.routingKey, "attempts", maxAttempts, "error", err)
+		q.metrics.MessagesFailed.WithLabelValues(msg.routingKey, "consume").Inc()
+		tracing.RecordError(span, err)
+	}
+}
+
+// MatchTopic reports whether routingKey matches an AMQP topic binding key:
+// words are separated by dots, "*" matches exactly one word and "#" matches
+// zero or more words.
+func MatchTopic(bindingKey, routingKey string) bool {
+	return matchWords(strings.Split(bindingKey, "."), strings.Split(routingKey, "."))
+}
+
+func matchWords(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if matchWords(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && matchWords(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && matchWords(pattern[1:], words[1:])
+	}
+}
@@ -0,0 +1,55 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mini-shop/platform/metrics"
+)
+
+var testMetrics = metrics.New("messaging-test")
+
+func TestMemoryBrokerRetriesFailedMessages(t *testing.T) {
+	defer func(delay time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = time.Millisecond
+
+	broker := NewMemoryBroker(testMetrics)
+	defer broker.Close()
+
+	var attempts atomic.Int32
+	handled := make(chan string, 2)
+	err := broker.Consume("order.*", func(ctx context.Context, body []byte) error {
+		// Первое сообщение проходит с третьей попытки, "poison" не проходит никогда
+		if string(body) == "poison" || (string(body) == "first" && attempts.Add(1) < 3) {
+			return errors.New("not yet")
+		}
+		handled <- string(body)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Consume: %v", err)
+	}
+
+	for _, body := range []string{"first", "poison", "last"} {
+		if err := broker.Publish(context.Background(), "order.created", []byte(body)); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	for _, want := range []string{"first", "last"} {
+		select {
+		case got := <-handled:
+			if got != want {
+				t.Errorf("handled %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %q was not handled", want)
+		}
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("first message handled in %d attempts, want 3", n)
+	}
+}
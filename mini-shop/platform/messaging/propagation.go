@@ -0,0 +1,60 @@
+package messaging
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"mini-shop/platform/logger"
+)
+
+// headerCarrier lets the OpenTelemetry propagator read and write W3C trace
+// context in AMQP message headers.
+type headerCarrier amqp.Table
+
+func (c headerCarrier) Get(key string) string {
+	value, _ := c[key].(string)
+	return value
+}
+
+func (c headerCarrier) Set(key, value string) {
+	c[key] = value
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func messagingAttributes(exchange, routingKey string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.destination.name", exchange),
+		attribute.String("messaging.rabbitmq.destination.routing_key", routingKey),
+	}
+}
+
+// correlationID returns the ID that ties together every message caused by the
+// same originating request, starting a new chain when there is none.
+func correlationID(ctx context.Context) string {
+	if id := logger.CorrelationID(ctx); id != "" {
+		return id
+	}
+	return logger.NewID()
+}
+
+func correlationIDFromHeaders(headers amqp.Table) string {
+	if id := headerCarrier(headers).Get(logger.CorrelationIDHeader); id != "" {
+		return id
+	}
+	return logger.NewID()
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer("mini-shop/platform/messaging")
+}
@@ -0,0 +1,261 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/metrics"
+	"mini-shop/platform/tracing"
+)
+
+// RabbitMQ publishes to a durable topic exchange and consumes through durable
+// queues named after the service, so messages wait for the service while it
+// is down and replicas of one service share the work. A message is
+// acknowledged once its handler succeeds; one that keeps failing is moved to
+// the dead-letter queue "<queue>.dlq", and one whose consumer died is
+// delivered again.
+type RabbitMQ struct {
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	exchange string
+	service  string
+	metrics  *metrics.Set
+
+	channelClosed atomic.Bool
+	mu            sync.Mutex
+	consumers     map[string]*atomic.Bool
+}
+
+// prefetch is how many unacknowledged messages a consumer holds at a time.
+const prefetch = 10
+
+func NewRabbitMQ(amqpURL, exchange, service string, m *metrics.Set) (*RabbitMQ, error) {
+	conn, err := amqp.Dial(amqpURL)
+	if err != nil {
+		return nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	err = ch.ExchangeDeclare(
+		exchange,
+		"topic",
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err == nil {
+		err = ch.ExchangeDeclare(deadLetterExchange(exchange), "direct", true, false, false, false, nil)
+	}
+	if err == nil {
+		// Неподтверждённых сообщений на потребителя не больше prefetch
+		err = ch.Qos(prefetch, 0, false)
+	}
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	r := &RabbitMQ{
+		conn:      conn,
+		channel:   ch,
+		exchange:  exchange,
+		service:   service,
+		metrics:   m,
+		consumers: make(map[string]*atomic.Bool),
+	}
+	r.watchChannel()
+
+	return r, nil
+}
+
+func (r *RabbitMQ) Publish(ctx context.Context, routingKey string, body []byte) error {
+	ctx, span := tracer().Start(ctx, "publish "+routingKey,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(messagingAttributes(r.exchange, routingKey)...),
+	)
+	defer span.End()
+
+	headers := amqp.Table{logger.CorrelationIDHeader: correlationID(ctx)}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	slog.DebugContext(ctx, "publishing message", "exchange", r.exchange, "routing_key", routingKey, "size", len(body))
+	err := r.channel.Publish(
+		r.exchange,
+		routingKey,
+		false,
+		false,
+		amqp.Publishing{
+			ContentType: "application/json",
+			Headers:     headers,
+			Body:        body,
+			Timestamp:   time.Now(),
+		},
+	)
+	if err != nil {
+		r.metrics.MessagesFailed.WithLabelValues(routingKey, "publish").Inc()
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	r.metrics.MessagesPublished.WithLabelValues(routingKey).Inc()
+	return nil
+}
+
+func (r *RabbitMQ) Consume(bindingKey string, handler func(context.Context, []byte) error) error {
+	// Очередь переживает рестарт сервиса, поэтому события не теряются, пока он лежит
+	name := r.service + "." + bindingKey
+	dlx := deadLetterExchange(r.exchange)
+	q, err := r.channel.QueueDeclare(
+		name,
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false,
+		amqp.Table{"x-dead-letter-exchange": dlx, "x-dead-letter-routing-key": name},
+	)
+	if err != nil {
+		return err
+	}
+
+	dlq, err := r.channel.QueueDeclare(name+".dlq", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+	if err := r.channel.QueueBind(dlq.Name, name, dlx, false, nil); err != nil {
+		return err
+	}
+
+	slog.Info("binding queue", "queue", q.Name, "exchange", r.exchange, "binding_key", bindingKey)
+	err = r.channel.QueueBind(
+		q.Name,
+		bindingKey,
+		r.exchange,
+		false,
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := r.channel.Consume(
+		q.Name, "", false, false, false, false, nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	alive := r.trackConsumer(bindingKey)
+	go func() {
+		defer alive.Store(false)
+		for msg := range msgs {
+			r.handleDelivery(msg, handler)
+		}
+	}()
+
+	slog.Info("waiting for messages", "queue", q.Name)
+	return nil
+}
+
+// handleDelivery continues the publisher's trace and passes the message to
+// handler, acknowledging it on success and dead-lettering it when the retries
+// run out.
+func (r *RabbitMQ) handleDelivery(msg amqp.Delivery, handler func(context.Context, []byte) error) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), headerCarrier(msg.Headers))
+	ctx = logger.WithCorrelationID(ctx, correlationIDFromHeaders(msg.Headers))
+	ctx, span := tracer().Start(ctx, "process "+msg.RoutingKey,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(messagingAttributes(msg.Exchange, msg.RoutingKey)...),
+	)
+	defer span.End()
+
+	r.metrics.MessagesConsumed.WithLabelValues(msg.RoutingKey).Inc()
+	if !msg.Timestamp.IsZero() {
+		r.metrics.ConsumerLag.WithLabelValues(msg.RoutingKey).Observe(time.Since(msg.Timestamp).Seconds())
+	}
+
+	slog.DebugContext(ctx, "message received", "routing_key", msg.RoutingKey, "size", len(msg.Body))
+	if err := handleWithRetry(ctx, r.metrics, msg.RoutingKey, msg.Body, handler); err != nil {
+		slog.ErrorContext(ctx, "failed to handle message, dead-lettering it", "routing_key", msg.RoutingKey, "attempts", maxAttempts, "error", err)
+		r.metrics.MessagesFailed.WithLabelValues(msg.RoutingKey, "consume").Inc()
+		tracing.RecordError(span, err)
+		if err := msg.Nack(false, false); err != nil {
+			slog.ErrorContext(ctx, "failed to reject message", "routing_key", msg.RoutingKey, "error", err)
+		}
+		return
+	}
+	if err := msg.Ack(false); err != nil {
+		// Сообщение придёт снова, обработчики это переносят
+		slog.ErrorContext(ctx, "failed to acknowledge message", "routing_key", msg.RoutingKey, "error", err)
+	}
+}
+
+// deadLetterExchange is where the queues bound to exchange move the messages
+// their consumers gave up on.
+func deadLetterExchange(exchange string) string {
+	return exchange + ".dlx"
+}
+func (r *RabbitMQ) watchChannel() {
+	closed := r.channel.NotifyClose(make(chan *amqp.Error, 1))
+	go func() {
+		<-closed
+		r.channelClosed.Store(true)
+	}()
+}
+
+// Check reports whether both the broker connection and the channel are open.
+func (r *RabbitMQ) Check(ctx context.Context) error {
+	if r.conn.IsClosed() {
+		return fmt.Errorf("connection is closed")
+	}
+	if r.channelClosed.Load() {
+		return fmt.Errorf("channel is closed")
+	}
+	return nil
+}
+
+// CheckConsumer reports whether the consumer started for the key is still receiving deliveries.
+func (r *RabbitMQ) CheckConsumer(key string) error {
+	r.mu.Lock()
+	alive, ok := r.consumers[key]
+	r.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("consumer %q not started", key)
+	}
+	if !alive.Load() {
+		return fmt.Errorf("consumer %q stopped", key)
+	}
+	return nil
+}
+
+func (r *RabbitMQ) trackConsumer(key string) *atomic.Bool {
+	alive := new(atomic.Bool)
+	alive.Store(true)
+
+	r.mu.Lock()
+	r.consumers[key] = alive
+	r.mu.Unlock()
+
+	return alive
+}
+
+func (r *RabbitMQ) Close() {
+	r.channel.Close()
+	r.conn.Close()
+}
@@ -0,0 +1,36 @@
+package messaging
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"mini-shop/platform/metrics"
+)
+
+// maxAttempts is how many times a message is handed to its handler before it
+// is dead-lettered.
+const maxAttempts = 5
+
+// retryDelay is the pause before the first retry; it doubles with every
+// attempt, so a message is given up on about 6 seconds after it arrived.
+var retryDelay = 200 * time.Millisecond
+
+// handleWithRetry runs handler on body until it succeeds or maxAttempts runs
+// out and returns the last error. Handlers are idempotent, so a message that
+// failed halfway through is safe to hand over again; retrying in place keeps
+// the messages of one queue in order.
+func handleWithRetry(ctx context.Context, m *metrics.Set, routingKey string, body []byte, handler func(context.Context, []byte) error) error {
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err := handler(ctx, body)
+		if err == nil || attempt == maxAttempts {
+			return err
+		}
+
+		slog.WarnContext(ctx, "failed to handle message, retrying", "routing_key", routingKey, "attempt", attempt, "delay", delay, "error", err)
+		m.MessagesFailed.WithLabelValues(routingKey, "retry").Inc()
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,129 @@
+// Package metrics holds the Prometheus metrics that every MiniShop service
+// exposes.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the metric names of every service.
+const Namespace = "minishop"
+
+// Set is the HTTP and broker metrics of one service. Services use the same
+// metric names and differ in the constant "service" label, so several of them
+// can register in one process.
+type Set struct {
+	// Registerer adds the "service" label; services register their own
+	// metrics with it.
+	Registerer prometheus.Registerer
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	MessagesPublished *prometheus.CounterVec
+	MessagesConsumed  *prometheus.CounterVec
+	MessagesFailed    *prometheus.CounterVec
+	ConsumerLag       *prometheus.HistogramVec
+}
+
+// New registers the metrics of service with the default registry.
+func New(service string) *Set {
+	registerer := prometheus.WrapRegistererWith(
+		prometheus.Labels{"service": service},
+		prometheus.DefaultRegisterer,
+	)
+	factory := promauto.With(registerer)
+
+	return &Set{
+		Registerer: registerer,
+
+		httpRequests: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route, method and status code.",
+		}, []string{"method", "route", "status"}),
+
+		httpDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency, by route, method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+
+		MessagesPublished: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_published_total",
+			Help:      "Messages published to the broker, by routing key.",
+		}, []string{"routing_key"}),
+
+		MessagesConsumed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_consumed_total",
+			Help:      "Messages consumed from the broker, by routing key.",
+		}, []string{"routing_key"}),
+
+		MessagesFailed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "messages_failed_total",
+			Help:      "Messages that failed to publish or to be handled, by routing key and operation.",
+		}, []string{"routing_key", "operation"}),
+
+		ConsumerLag: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "consumer_lag_seconds",
+			Help:      "Time between a message being published and being picked up by a consumer.",
+			Buckets:   []float64{.005, .01, .05, .1, .5, 1, 5, 15, 60, 300},
+		}, []string{"routing_key"}),
+	}
+}
+
+// RegisterDB exposes connection pool statistics of db.
+func (s *Set) RegisterDB(db *sql.DB, name string) {
+	s.Registerer.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// RegisterRoutes serves the default registry, so one endpoint shows every
+// service registered in the process.
+func (s *Set) RegisterRoutes(router *mux.Router) {
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+}
+
+// Middleware records request count and latency labelled with the matched route template.
+func (s *Set) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tmpl, err := current.GetPathTemplate(); err == nil {
+				route = tmpl
+			}
+		}
+
+		status := strconv.Itoa(rec.status)
+		s.httpRequests.WithLabelValues(r.Method, route, status).Inc()
+		s.httpDuration.WithLabelValues(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
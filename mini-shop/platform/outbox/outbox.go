@@ -0,0 +1,127 @@
+// Package outbox publishes events that services store in the same
+// transaction as the change that caused them.
+package outbox
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"mini-shop/platform/logger"
+)
+
+const (
+	batchSize    = 50
+	pollInterval = 2 * time.Second
+)
+
+// Event is a message stored in the same transaction as the change that
+// caused it and published to the broker afterwards. Headers carry the trace
+// and correlation context of the original request.
+type Event struct {
+	ID        int               `db:"id"`
+	EventType string            `db:"event_type"`
+	Payload   []byte            `db:"payload"`
+	Headers   map[string]string `db:"headers"`
+	Status    string            `db:"status"`
+	CreatedAt time.Time         `db:"created_at"`
+	SentAt    *time.Time        `db:"sent_at"`
+}
+
+// Store is the outbox table of a service.
+type Store interface {
+	// PendingOutboxEvents returns unsent events in insertion order.
+	PendingOutboxEvents(ctx context.Context, limit int) ([]Event, error)
+	MarkOutboxEventSent(ctx context.Context, id int) error
+}
+
+// InTx runs fn against the outbox inside a single transaction.
+type InTx func(ctx context.Context, fn func(tx Store) error) error
+
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte) error
+}
+
+// Relay publishes events written to the outbox table. It drains the outbox
+// right after a transaction commits and polls periodically to pick up
+// anything left behind by a crash or a broker outage.
+type Relay struct {
+	inTx      InTx
+	publisher Publisher
+	wake      chan struct{}
+}
+
+func NewRelay(inTx InTx, publisher Publisher) *Relay {
+	return &Relay{
+		inTx:      inTx,
+		publisher: publisher,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// NewEvent captures the trace and correlation context of ctx so the relay can
+// publish the event as part of the original request.
+func NewEvent(ctx context.Context, eventType string, payload []byte) Event {
+	headers := map[string]string{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(headers))
+	if id := logger.CorrelationID(ctx); id != "" {
+		headers[logger.CorrelationIDHeader] = id
+	}
+
+	return Event{EventType: eventType, Payload: payload, Headers: headers}
+}
+
+// Notify asks the relay to drain the outbox without waiting for the next poll.
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Flush(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to flush outbox", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Flush publishes the pending events once. An event the broker rejects stays
+// pending, and so do the events after it.
+func (r *Relay) Flush(ctx context.Context) error {
+	return r.inTx(ctx, func(tx Store) error {
+		events, err := tx.PendingOutboxEvents(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			eventCtx := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(event.Headers))
+			eventCtx = logger.WithCorrelationID(eventCtx, event.Headers[logger.CorrelationIDHeader])
+
+			if err := r.publisher.Publish(eventCtx, event.EventType, event.Payload); err != nil {
+				// Оставляем событие в очереди, повторим на следующем проходе
+				slog.WarnContext(eventCtx, "failed to publish outbox event", "event_id", event.ID, "event_type", event.EventType, "error", err)
+				return nil
+			}
+
+			if err := tx.MarkOutboxEventSent(ctx, event.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
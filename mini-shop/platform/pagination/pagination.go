@@ -0,0 +1,150 @@
+// Package pagination implements the keyset pagination shared by the list
+// endpoints.
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Cursor points at the last row of a page. It remembers the sort it was issued
+// for, so a cursor cannot be replayed against a different ordering.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// Params is the parsed limit/cursor/sort part of a list request.
+type Params struct {
+	Limit  int
+	Cursor *Cursor
+	Sort   string
+	Desc   bool
+}
+
+// Page is the response envelope of every paginated list endpoint.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// Parse reads limit, cursor and sort from the query string. sort is a field
+// name, optionally prefixed with "-" for descending order.
+func Parse(q url.Values, sortable []string, defaultSort string) (Params, error) {
+	params := Params{Limit: DefaultLimit}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > MaxLimit {
+			return params, fmt.Errorf("limit must be between 1 and %d", MaxLimit)
+		}
+		params.Limit = limit
+	}
+
+	sort := q.Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	params.Sort, params.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	if !slices.Contains(sortable, params.Sort) {
+		return params, fmt.Errorf("sort must be one of %s", strings.Join(sortable, ", "))
+	}
+
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := DecodeCursor(raw)
+		if err != nil {
+			return params, err
+		}
+		if cursor.Sort != sort {
+			return params, errors.New("cursor was issued for a different sort")
+		}
+		params.Cursor = cursor
+	}
+
+	return params, nil
+}
+
+// SortKey is the sort as it appears in the query string, e.g. "-createdAt".
+func (p Params) SortKey() string {
+	if p.Desc {
+		return "-" + p.Sort
+	}
+	return p.Sort
+}
+
+func EncodeCursor(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(raw string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+
+	var cursor Cursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	return &cursor, nil
+}
+
+// NewPage trims the extra row fetched to detect the next page and builds the
+// cursor from the last returned item.
+func NewPage[T any](items []T, params Params, cursor func(item T, sort string) Cursor) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+
+	page := Page[T]{Items: items}
+	if len(items) > params.Limit {
+		page.Items = items[:params.Limit]
+		page.HasMore = true
+
+		next := cursor(page.Items[len(page.Items)-1], params.Sort)
+		next.Sort = params.SortKey()
+		page.NextCursor = EncodeCursor(next)
+	}
+	return page
+}
+
+// TimeParam parses an optional RFC 3339 timestamp from the query string.
+func TimeParam(q url.Values, name string) (*time.Time, error) {
+	raw := q.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return &t, nil
+}
+
+// FloatParam parses an optional number from the query string.
+func FloatParam(q url.Values, name string) (*float64, error) {
+	raw := q.Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a number", name)
+	}
+	return &f, nil
+}
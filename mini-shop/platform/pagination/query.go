@@ -0,0 +1,63 @@
+package pagination
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Column maps a public sort field to its column and the type the cursor value
+// has to be cast to.
+type Column struct {
+	Name string
+	Cast string
+}
+
+// Query assembles a filtered keyset query for Postgres. Conditions use "?" as
+// the placeholder; every "?" of a condition is replaced with its one
+// positional argument.
+type Query struct {
+	conds []string
+	args  []any
+}
+
+// NewQuery starts a query with conditions that take no arguments.
+func NewQuery(conds ...string) *Query {
+	return &Query{conds: conds}
+}
+
+func (q *Query) Where(cond string, arg any) {
+	q.args = append(q.args, arg)
+	q.conds = append(q.conds, strings.ReplaceAll(cond, "?", fmt.Sprintf("$%d", len(q.args))))
+}
+
+// Build appends the keyset condition, ordering and limit to base. One extra row
+// is requested so the caller can tell whether there is a next page.
+func (q *Query) Build(base string, column Column, params Params) string {
+	dir, op := "ASC", ">"
+	if params.Desc {
+		dir, op = "DESC", "<"
+	}
+
+	if params.Cursor != nil {
+		q.args = append(q.args, params.Cursor.Value, params.Cursor.ID)
+		q.conds = append(q.conds, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)", column.Name, op, len(q.args)-1, column.Cast, len(q.args)))
+	}
+
+	query := base
+	if len(q.conds) > 0 {
+		query += " WHERE " + strings.Join(q.conds, " AND ")
+	}
+
+	q.args = append(q.args, params.Limit+1)
+	return query + fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT $%d", column.Name, dir, dir, len(q.args))
+}
+
+// Args are the positional arguments of the built query.
+func (q *Query) Args() []any {
+	return q.args
+}
+
+// LikePrefix is a LIKE pattern matching strings that start with s.
+func LikePrefix(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
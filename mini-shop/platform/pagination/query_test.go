@@ -0,0 +1,26 @@
+package pagination
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryBuild(t *testing.T) {
+	q := NewQuery("deletedAt IS NULL")
+	q.Where("(email LIKE ? OR name LIKE ?)", LikePrefix("a_b"))
+	q.Where("status = ?", "paid")
+
+	params := Params{Limit: 10, Sort: "createdAt", Desc: true, Cursor: &Cursor{Value: "2026-10-19T00:00:00Z", ID: 7}}
+	got := q.Build("SELECT id FROM t", Column{Name: "createdAt", Cast: "timestamptz"}, params)
+
+	want := "SELECT id FROM t WHERE deletedAt IS NULL AND (email LIKE $1 OR name LIKE $1) AND status = $2" +
+		" AND (createdAt, id) < ($3::timestamptz, $4) ORDER BY createdAt DESC, id DESC LIMIT $5"
+	if got != want {
+		t.Errorf("Build =\n%s\nwant\n%s", got, want)
+	}
+
+	wantArgs := []any{`a\_b%`, "paid", "2026-10-19T00:00:00Z", 7, 11}
+	if !reflect.DeepEqual(q.Args(), wantArgs) {
+		t.Errorf("Args = %v, want %v", q.Args(), wantArgs)
+	}
+}
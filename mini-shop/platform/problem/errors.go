@@ -0,0 +1,52 @@
+// Package problem holds the domain error type of the services and writes it
+// as an RFC 7807 problem response.
+package problem
+
+import "fmt"
+
+// ErrorKind classifies domain errors so the HTTP layer can pick a status code.
+type ErrorKind int
+
+const (
+	KindInternal ErrorKind = iota
+	KindInvalid
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindInsufficientFunds
+	KindTooManyRequests
+	KindUnavailable
+)
+
+// Error is a domain error with a stable, machine-readable code.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+// FieldError describes why a single request field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches domain errors by code so wrapped sentinels still compare equal.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+func InvalidInput(format string, args ...any) error {
+	return &Error{Kind: KindInvalid, Code: "invalid_request", Message: fmt.Sprintf(format, args...)}
+}
+
+func ValidationFailed(fields []FieldError) error {
+	return &Error{Kind: KindInvalid, Code: "validation_failed", Message: "invalid payload", Fields: fields}
+}
@@ -0,0 +1,107 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"mini-shop/platform/logger"
+)
+
+const contentType = "application/problem+json"
+
+// Problem is the RFC 7807 body returned for every failed request.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Instance  string       `json:"instance,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+var kindStatus = map[ErrorKind]int{
+	KindInvalid:           http.StatusBadRequest,
+	KindUnauthorized:      http.StatusUnauthorized,
+	KindForbidden:         http.StatusForbidden,
+	KindNotFound:          http.StatusNotFound,
+	KindConflict:          http.StatusConflict,
+	KindInsufficientFunds: http.StatusPaymentRequired,
+	KindTooManyRequests:   http.StatusTooManyRequests,
+	KindUnavailable:       http.StatusServiceUnavailable,
+}
+
+// WriteError maps err to a problem response. Errors that are not domain errors
+// are logged and reported as a generic internal error.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var domainErr *Error
+	if !errors.As(err, &domainErr) || domainErr.Kind == KindInternal {
+		slog.ErrorContext(r.Context(), "request failed", "path", r.URL.Path, "error", err)
+		Write(w, r, http.StatusInternalServerError, "internal_error", "internal server error", nil)
+		return
+	}
+
+	Write(w, r, kindStatus[domainErr.Kind], domainErr.Code, domainErr.Message, domainErr.Fields)
+}
+
+// WriteValidationError reports request payload validation failures field by field.
+func WriteValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		WriteError(w, r, InvalidInput("invalid payload: %v", err))
+		return
+	}
+
+	fields := make([]FieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		fields = append(fields, FieldError{Field: fe.Field(), Message: fieldMessage(fe)})
+	}
+
+	WriteError(w, r, ValidationFailed(fields))
+}
+
+func Write(w http.ResponseWriter, r *http.Request, status int, code, message string, fields []FieldError) {
+	problem := Problem{
+		Type:      "urn:minishop:problem:" + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		Instance:  r.URL.Path,
+		Errors:    fields,
+		RequestID: logger.RequestID(r.Context()),
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		return fmt.Sprintf("must be at least %s characters", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "lte":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("failed on the %q rule", fe.Tag())
+	}
+}
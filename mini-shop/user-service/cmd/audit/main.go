@@ -0,0 +1,74 @@
+// Command audit exports the audit log as JSON lines, oldest first, and
+// verifies its hash chain. It exits with status 1 if a record was changed,
+// removed or inserted, or if the head given with -head is gone.
+//
+// It reads the same DB_* environment variables as the server:
+//
+//	go run ./cmd/audit -o audit.jsonl -head <hash printed by the last export>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mini-shop/platform/database"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/config"
+	"mini-shop/user-service/internal/repository"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	output := flag.String("o", "", "file to write the records to, stdout by default")
+	head := flag.String("head", "", "hash of the last record of an earlier export that must still be in the log")
+	flag.Parse()
+
+	if err := run(*output, *head); err != nil {
+		fmt.Fprintln(os.Stderr, "audit:", err)
+		os.Exit(1)
+	}
+}
+
+func run(output, head string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.NewPostgresStorage(database.Config{
+		Host:     config.Envs.DBAddress,
+		Port:     config.Envs.Port,
+		User:     config.Envs.DBUser,
+		Password: config.Envs.DBPassword,
+		DBName:   config.Envs.DBName,
+		SSLMode:  config.Envs.SSLMode,
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	summary, err := audit.Export(ctx, repository.NewStore(db), w, head)
+	var chainErr *audit.ChainError
+	switch {
+	case errors.As(err, &chainErr):
+		return fmt.Errorf("chain broken after %d intact records: %w", summary.Records, err)
+	case err != nil:
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "verified %d records, head %s\n", summary.Records, summary.Head)
+	return nil
+}
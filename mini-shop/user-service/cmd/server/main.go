@@ -1,53 +1,69 @@
 package main
 
 import (
-	"database/sql"
-	"log"
+	"context"
+	"log/slog"
+	"mini-shop/platform/logger"
 	"mini-shop/user-service/internal/app"
 	"mini-shop/user-service/internal/config"
-	"mini-shop/user-service/internal/database"
+	"mini-shop/user-service/internal/messaging"
+	"mini-shop/user-service/internal/tracing"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	log.Println("STARTING USER SERVICE")
-	cfg := database.Config{
-		Host:     config.Envs.DBAddress,
-		Port:     config.Envs.Port,
-		User:     config.Envs.DBUser,
-		Password: config.Envs.DBPassword,
-		DBName:   config.Envs.DBName,
-		SSLMode:  config.Envs.SSLMode,
-	}
+	logger.Setup(tracing.ServiceName, config.Envs.LogLevel)
+	slog.Info("starting user service")
 
-	log.Println("Connecting to DB...")
-	db, err := database.NewPostgresStorage(cfg)
-	if err != nil {
-		log.Fatal(err)
-		log.Fatal("DB connection failed:", err)
+	if config.Envs.DBPassword == "" {
+		logger.Fatal("required environment variable is not set", "key", "DB_PASSWORD")
 	}
-	log.Println("DB connection established")
 
-	initStorage(db)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	log.Println("Running migrations...")
-	if err := database.RunMigrations(cfg); err != nil {
-		log.Fatalf("migration failed: %v", err)
+	shutdownTracing, err := tracing.Init(ctx)
+	if err != nil {
+		logger.Fatal("failed to initialize tracing", "error", err)
 	}
-	log.Println("Migrations applied successfully.")
+	defer shutdownTracing(context.Background())
 
-	log.Println("Starting API server...")
-	server := app.NewAPIServer(":8080", db)
-	if err := server.Run(); err != nil {
-		log.Fatal(err)
-		log.Fatal("API server failed:", err)
+	slog.Info("connecting to message broker", "broker", config.Envs.Broker)
+	broker, err := messaging.New(config.Envs.Broker, config.Envs.RabbitMQURL)
+	if err != nil {
+		logger.Fatal("failed to connect to message broker", "error", err)
 	}
-	log.Print("server has started")
-}
+	defer broker.Close()
+	slog.Info("connected to message broker")
 
-func initStorage(db *sql.DB) {
-	err := db.Ping()
+	err = app.Run(ctx, app.Options{
+		Addr:                   ":8080",
+		DBHost:                 config.Envs.DBAddress,
+		DBPort:                 config.Envs.Port,
+		DBUser:                 config.Envs.DBUser,
+		DBPassword:             config.Envs.DBPassword,
+		DBName:                 config.Envs.DBName,
+		DBSSLMode:              config.Envs.SSLMode,
+		Broker:                 broker,
+		LowBalanceThreshold:    config.Envs.LowBalanceThreshold,
+		PublicURL:              config.Envs.PublicURL,
+		ResetPasswordURL:       config.Envs.ResetPasswordURL,
+		VerifyTokenTTL:         config.Envs.VerifyTokenTTL,
+		ResetTokenTTL:          config.Envs.ResetTokenTTL,
+		RateLimitStore:         config.Envs.RateLimitStore,
+		PasswordMinLength:      config.Envs.PasswordMinLength,
+		BreachedPasswords:      config.Envs.BreachedPasswordsFile,
+		TwoFactorIssuer:        config.Envs.TwoFactorIssuer,
+		TwoFactorRoles:         config.Envs.TwoFactorRequiredRoles,
+		AdminEmails:            config.Envs.AdminEmails,
+		AccountRetention:       config.Envs.AccountRetention,
+		OrderServiceURL:        config.Envs.OrderServiceURL,
+		PaymentServiceURL:      config.Envs.PaymentServiceURL,
+		NotificationServiceURL: config.Envs.NotificationServiceURL,
+	})
 	if err != nil {
-		log.Println("Waiting for DB to be ready...")
+		logger.Fatal("user service failed", "error", err)
 	}
-	log.Println("DB is ready!")
+	slog.Info("user service stopped")
 }
@@ -1,40 +1,329 @@
 package app
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 
+	"mini-shop/platform/database"
+	"mini-shop/platform/health"
+	"mini-shop/platform/logger"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/config"
 	"mini-shop/user-service/internal/handler"
+	"mini-shop/user-service/internal/messaging"
+	"mini-shop/user-service/internal/metrics"
+	"mini-shop/user-service/internal/ratelimit"
 	"mini-shop/user-service/internal/repository"
 	"mini-shop/user-service/internal/service"
+	"mini-shop/user-service/internal/tracing"
+	"mini-shop/user-service/migrations"
 
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
+// Options configures one instance of the user service.
+type Options struct {
+	Addr string
+
+	DBHost     string
+	DBPort     string
+	DBUser     string
+	DBPassword string
+	DBName     string
+	DBSSLMode  string
+
+	// Broker carries user events. It is owned by the caller.
+	Broker messaging.Broker
+	// LowBalanceThreshold is the balance below which a withdrawal publishes
+	// user.balance_low; zero disables the event.
+	LowBalanceThreshold float64
+
+	// PublicURL is where users reach the service from links in emails, e.g.
+	// "http://localhost:8080". ResetPasswordURL is the shop page that sets a
+	// new password; it defaults to PublicURL + "/reset-password".
+	PublicURL        string
+	ResetPasswordURL string
+	// VerifyTokenTTL and ResetTokenTTL limit how long the mailed links work.
+	// Zero values take service.DefaultTokenConfig.
+	VerifyTokenTTL time.Duration
+	ResetTokenTTL  time.Duration
+
+	// RateLimitStore is "memory" (the default), which limits each instance on
+	// its own, or "postgres", which shares the limits between instances.
+	RateLimitStore string
+
+	// PasswordMinLength is the shortest new password accepted; zero takes
+	// auth.DefaultMinPasswordLength. BreachedPasswords is a file of leaked
+	// passwords, one per line, that are refused; empty uses the short list
+	// shipped with the service.
+	PasswordMinLength int
+	BreachedPasswords string
+
+	// TwoFactorIssuer names the shop in authenticator apps. TwoFactorRoles
+	// lists the roles that must log in with a second factor until an admin
+	// changes them; nil takes service.DefaultTwoFactorConfig and an empty
+	// list requires it of nobody.
+	TwoFactorIssuer string
+	TwoFactorRoles  []string
+	// AdminEmails are the accounts that become admins once their email is
+	// verified. It is how a new shop gets its first admin.
+	AdminEmails []string
+
+	// AccountRetention is how long a deleted account keeps its personal data
+	// before it is erased; zero takes service.DefaultAccountRetention.
+	AccountRetention time.Duration
+	// OrderServiceURL, PaymentServiceURL and NotificationServiceURL are the
+	// base URLs of the services asked for their part of GET /me/export. A
+	// service without a URL is left out.
+	OrderServiceURL        string
+	PaymentServiceURL      string
+	NotificationServiceURL string
+}
+
+// Run connects to the database, applies migrations and serves the API until
+// ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	cfg := database.Config{
+		Host:     opts.DBHost,
+		Port:     opts.DBPort,
+		User:     opts.DBUser,
+		Password: opts.DBPassword,
+		DBName:   opts.DBName,
+		SSLMode:  opts.DBSSLMode,
+	}
+
+	slog.Info("connecting to database", "host", cfg.Host, "port", cfg.Port, "db", cfg.DBName)
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		return fmt.Errorf("database connection failed: %w", err)
+	}
+	defer db.Close()
+	slog.Info("database connection established")
+
+	slog.Info("running migrations")
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	slog.Info("migrations applied")
+
+	passwords, err := auth.LoadPasswordPolicy(opts.PasswordMinLength, opts.BreachedPasswords)
+	if err != nil {
+		return err
+	}
+
+	retention := opts.AccountRetention
+	if retention <= 0 {
+		retention = service.DefaultAccountRetention
+	}
+
+	return NewAPIServer(ServerConfig{
+		Addr:        opts.Addr,
+		DB:          db,
+		Broker:      opts.Broker,
+		LowBalance:  opts.LowBalanceThreshold,
+		Tokens:      tokenConfig(opts),
+		RateLimits:  opts.RateLimitStore,
+		Passwords:   passwords,
+		TwoFactor:   twoFactorConfig(opts),
+		AdminEmails: opts.AdminEmails,
+		Retention:   retention,
+		Export:      exportConfig(opts),
+	}).Run(ctx)
+}
+
+func tokenConfig(opts Options) service.TokenConfig {
+	tokens := service.DefaultTokenConfig
+	tokens.Secret = []byte(config.Envs.JWTSecret)
+
+	publicURL := strings.TrimRight(opts.PublicURL, "/")
+	tokens.VerifyURL = publicURL + "/api/v1/email/verify"
+	tokens.ResetURL = opts.ResetPasswordURL
+	if tokens.ResetURL == "" {
+		tokens.ResetURL = publicURL + "/reset-password"
+	}
+
+	if opts.VerifyTokenTTL > 0 {
+		tokens.VerifyTTL = opts.VerifyTokenTTL
+	}
+	if opts.ResetTokenTTL > 0 {
+		tokens.ResetTTL = opts.ResetTokenTTL
+	}
+	return tokens
+}
+
+func twoFactorConfig(opts Options) service.TwoFactorConfig {
+	twoFactor := service.DefaultTwoFactorConfig
+	if opts.TwoFactorIssuer != "" {
+		twoFactor.Issuer = opts.TwoFactorIssuer
+	}
+	if opts.TwoFactorRoles != nil {
+		twoFactor.RequiredRoles = opts.TwoFactorRoles
+	}
+	return twoFactor
+}
+
+func exportConfig(opts Options) service.ExportConfig {
+	export := service.DefaultExportConfig
+	export.OrderServiceURL = opts.OrderServiceURL
+	export.PaymentServiceURL = opts.PaymentServiceURL
+	export.NotificationServiceURL = opts.NotificationServiceURL
+	return export
+}
+
 type APIServer struct {
-	addr string
-	db   *sql.DB
+	addr        string
+	db          *sql.DB
+	broker      messaging.Broker
+	lowBalance  float64
+	tokens      service.TokenConfig
+	rateLimits  string
+	passwords   *auth.PasswordPolicy
+	twoFactor   service.TwoFactorConfig
+	adminEmails []string
+	retention   time.Duration
+	export      service.ExportConfig
 }
 
-func NewAPIServer(addr string, db *sql.DB) *APIServer {
+// ServerConfig is what the API server is built from. Run fills it from
+// Options with the defaults applied.
+type ServerConfig struct {
+	Addr   string
+	DB     *sql.DB
+	Broker messaging.Broker
+
+	// LowBalance is the balance below which users are warned.
+	LowBalance float64
+	Tokens     service.TokenConfig
+	// RateLimits names the rate limit store, see Options.RateLimitStore.
+	RateLimits  string
+	Passwords   *auth.PasswordPolicy
+	TwoFactor   service.TwoFactorConfig
+	AdminEmails []string
+	Retention   time.Duration
+	Export      service.ExportConfig
+}
+
+func NewAPIServer(cfg ServerConfig) *APIServer {
 	return &APIServer{
-		addr: addr,
-		db:   db,
+		addr:        cfg.Addr,
+		db:          cfg.DB,
+		broker:      cfg.Broker,
+		lowBalance:  cfg.LowBalance,
+		tokens:      cfg.Tokens,
+		rateLimits:  cfg.RateLimits,
+		passwords:   cfg.Passwords,
+		twoFactor:   cfg.TwoFactor,
+		adminEmails: cfg.AdminEmails,
+		retention:   cfg.Retention,
+		export:      cfg.Export,
 	}
 }
 
-func (s *APIServer) Run() error {
+func (s *APIServer) Run(ctx context.Context) error {
 
 	router := mux.NewRouter()
+	router.Use(otelmux.Middleware(tracing.ServiceName))
+	router.Use(logger.Middleware)
+	router.Use(metrics.Common.Middleware)
+	router.Use(audit.Middleware(ratelimit.ClientIP))
+
+	metrics.Common.RegisterDB(s.db, "users")
+	metrics.Common.RegisterRoutes(router)
+
+	checker := health.NewChecker()
+	checker.Register("database", s.db.PingContext)
+	checker.Register("migrations", func(ctx context.Context) error {
+		return database.CheckMigrations(ctx, s.db, migrations.FS)
+	})
+	checker.Register("broker", s.broker.Check)
+	checker.Register("consumer", func(ctx context.Context) error {
+		var errs []error
+		for _, key := range service.PaymentEventKeys {
+			errs = append(errs, s.broker.CheckConsumer(key))
+		}
+		return errors.Join(errs...)
+	})
+	checker.RegisterRoutes(router)
+
 	subrouter := router.PathPrefix("/api/v1").Subrouter()
 
 	userStore := repository.NewStore(s.db)
-	balanceService := service.NewBalanceService(userStore)
-	userHandler := handler.NewUserHandler(userStore, *balanceService)
+
+	outbox := service.NewOutboxRelay(userStore, s.broker)
+	go outbox.Run(ctx)
+
+	userService := service.NewUserService(userStore, outbox, s.tokens, s.passwords, s.adminEmails)
+	if err := userService.BootstrapAdmins(ctx); err != nil {
+		return fmt.Errorf("failed to grant admin emails: %w", err)
+	}
+	balanceService := service.NewBalanceService(userStore, outbox, s.lowBalance)
+	limiter, err := s.newLimiter()
+	if err != nil {
+		return err
+	}
+	go limiter.Run(ctx)
+
+	twoFactor := service.NewTwoFactorService(userStore, s.tokens.Secret, s.twoFactor)
+	exportService := service.NewExportService(userStore, s.export)
+
+	eraser := service.NewEraser(userStore, outbox, s.retention)
+	go eraser.Run(ctx)
+
+	if err := s.startPaymentAuditListener(service.NewPaymentAuditor(userStore)); err != nil {
+		return fmt.Errorf("failed to start payment audit consumer: %w", err)
+	}
+
+	adminService := service.NewAdminService(userStore)
+	userHandler := handler.NewUserHandler(userStore, userService, *balanceService, twoFactor, exportService, adminService, limiter)
 	userHandler.RegisterRoutes(subrouter)
 
-	log.Println("Server starts on http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
-	return http.ListenAndServe(s.addr, router)
+	slog.Info("server listening", "addr", s.addr)
+	return serve(ctx, s.addr, router)
+}
+
+// startPaymentAuditListener записывает решения payment-service в журнал аудита
+func (s *APIServer) startPaymentAuditListener(auditor *service.PaymentAuditor) error {
+	for _, key := range service.PaymentEventKeys {
+		slog.Info("initializing consumer", "binding_key", key)
+		if err := s.broker.Consume(key, auditor.HandleEvent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) newLimiter() (*ratelimit.Limiter, error) {
+	switch s.rateLimits {
+	case "", "memory":
+		return ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.DefaultPolicy), nil
+	case "postgres":
+		return ratelimit.NewLimiter(ratelimit.NewPostgresStore(s.db), ratelimit.DefaultPolicy), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", s.rateLimits)
+	}
+}
+
+// serve runs the HTTP server until ctx is cancelled, then shuts it down gracefully.
+func serve(ctx context.Context, addr string, handler http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: handler}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,168 @@
+// Package audit keeps the tamper-evident log of security and money-moving
+// actions and records security events, such as failed logins, so they can be
+// told apart from ordinary request logs.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mini-shop/platform/logger"
+	"mini-shop/user-service/internal/model"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// События безопасности
+const (
+	LoginSucceeded = "login.succeeded"
+	LoginFailed    = "login.failed"
+	LoginLocked    = "login.locked"
+	LoginBlocked   = "login.blocked"
+	RateLimited    = "rate_limited"
+
+	TwoFactorEnabled  = "2fa.enabled"
+	TwoFactorDisabled = "2fa.disabled"
+	TwoFactorFailed   = "2fa.failed"
+	RecoveryCodeUsed  = "2fa.recovery_code_used"
+
+	AccountDeleted  = "account.deleted"
+	AccountErased   = "account.erased"
+	AccountExported = "account.exported"
+
+	BalanceToppedUp  = "balance.topped_up"
+	BalanceWithdrawn = "balance.withdrawn"
+	BalanceRefunded  = "balance.refunded"
+
+	// Действия администраторов
+	AccountBlocked   = "account.blocked"
+	AccountUnblocked = "account.unblocked"
+	RolesChanged     = "account.roles_changed"
+	BalanceAdjusted  = "balance.adjusted"
+	// TwoFactorPolicyChanged means the roles that require 2FA changed.
+	TwoFactorPolicyChanged = "2fa.policy_changed"
+
+	// Решения payment-service, приходят событиями
+	PaymentCompleted = "payment.completed"
+	PaymentFailed    = "payment.failed"
+	PaymentRefunded  = "payment.refunded"
+)
+
+// Actors that are not users.
+const (
+	// Anonymous acts before anyone has logged in, e.g. on a failed login.
+	Anonymous = "anonymous"
+	// System acts on its own or for other services, e.g. erasing accounts
+	// after retention or moving money for orders.
+	System         = "system"
+	PaymentService = "payment-service"
+)
+
+// User names userID as an actor or target.
+func User(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// AllUsers is the target of actions on every account at once.
+const AllUsers = "user:*"
+
+// Order names orderID as a target.
+func Order(orderID int) string {
+	return "order:" + strconv.Itoa(orderID)
+}
+
+// Setting names the setting key as a target.
+func Setting(key string) string {
+	return "setting:" + key
+}
+
+// Entry is one action for the audit log. Before and After are the state of
+// the target around the action and are stored as JSON; leave out personal
+// data, it cannot be erased from the log later.
+type Entry struct {
+	Actor  string
+	Action string
+	Target string
+	Before any
+	After  any
+	// EventID is the ID of the broker message the entry comes from.
+	EventID string
+}
+
+// Log appends entry to the audit log of store with the client IP and request
+// ID of ctx and mirrors it to the application log. With a store bound to a
+// transaction the record is kept only if the transaction commits, and the
+// mirrored line has no audit ID when the record is stored at commit.
+func Log(ctx context.Context, store model.AuditStore, entry Entry) error {
+	record := model.AuditRecord{
+		OccurredAt: time.Now(),
+		Actor:      entry.Actor,
+		Action:     entry.Action,
+		Target:     entry.Target,
+		IP:         ClientIP(ctx),
+		RequestID:  logger.RequestID(ctx),
+		EventID:    entry.EventID,
+	}
+	// У сообщений из брокера нет запроса, их связывает correlation ID
+	if record.RequestID == "" {
+		record.RequestID = logger.CorrelationID(ctx)
+	}
+
+	var err error
+	if record.Before, err = marshalState(entry.Before); err != nil {
+		return err
+	}
+	if record.After, err = marshalState(entry.After); err != nil {
+		return err
+	}
+
+	if err := store.AppendAudit(ctx, &record); err != nil {
+		return fmt.Errorf("failed to append audit record: %w", err)
+	}
+
+	attrs := []any{"actor", entry.Actor, "target", entry.Target}
+	if record.ID != 0 {
+		attrs = append(attrs, "audit_id", record.ID)
+	}
+	Record(ctx, entry.Action, attrs...)
+	return nil
+}
+
+func marshalState(state any) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit state: %w", err)
+	}
+	return data, nil
+}
+
+// Record logs event with attrs as key-value pairs. Every record carries
+// "audit": true for log pipelines to route on. Unlike Log it leaves no
+// durable trace, so it suits noisy events like rate limiting.
+func Record(ctx context.Context, event string, attrs ...any) {
+	slog.WarnContext(ctx, "security event", append([]any{"audit", true, "event", event}, attrs...)...)
+}
+
+type ipKey struct{}
+
+// Middleware puts the client IP of every request, as clientIP tells it, into
+// the request context for Log.
+func Middleware(clientIP func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ipKey{}, clientIP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the IP Middleware stored in ctx.
+func ClientIP(ctx context.Context) string {
+	ip, _ := ctx.Value(ipKey{}).(string)
+	return ip
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mini-shop/user-service/internal/model"
+)
+
+// exportBatch is how many records Export reads at a time.
+const exportBatch = 500
+
+// ErrHeadMissing means a hash noted from an earlier export is no longer in the
+// chain, i.e. records were cut off the end of the log.
+var ErrHeadMissing = errors.New("known head is not in the audit log")
+
+// ChainError reports the first record at which the audit log chain breaks.
+type ChainError struct {
+	ID     int
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("audit record %d %s", e.ID, e.Reason)
+}
+
+// Summary describes an exported chain. Head is the hash of the last record;
+// keep it to check later exports with.
+type Summary struct {
+	Records int
+	Head    string
+}
+
+// Export writes the audit log of store to w as JSON lines, oldest first, and
+// verifies the chain on the way. It stops with a *ChainError at the first
+// record that was changed, removed or inserted, after writing it. A non-empty
+// knownHead has to appear in the chain, otherwise Export fails with
+// ErrHeadMissing.
+func Export(ctx context.Context, store model.AuditStore, w io.Writer, knownHead string) (Summary, error) {
+	var summary Summary
+	enc := json.NewEncoder(w)
+	seen := knownHead == ""
+	afterID := 0
+	for {
+		records, err := store.AuditChain(ctx, afterID, exportBatch)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read audit log: %w", err)
+		}
+
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return summary, fmt.Errorf("failed to write audit record: %w", err)
+			}
+			if err := verify(record, summary.Head); err != nil {
+				return summary, err
+			}
+			summary.Records++
+			summary.Head = record.Hash
+			seen = seen || record.Hash == knownHead
+			afterID = record.ID
+		}
+
+		if len(records) < exportBatch {
+			break
+		}
+	}
+
+	if !seen {
+		return summary, ErrHeadMissing
+	}
+	return summary, nil
+}
+
+// verify checks that record follows the record with hash prevHash and that
+// its content still matches its hash.
+func verify(record model.AuditRecord, prevHash string) error {
+	if record.PrevHash != prevHash {
+		return &ChainError{ID: record.ID, Reason: "does not follow the previous record"}
+	}
+	if record.ComputeHash() != record.Hash {
+		return &ChainError{ID: record.ID, Reason: "does not match its hash"}
+	}
+	return nil
+}
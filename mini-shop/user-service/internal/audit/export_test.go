@@ -0,0 +1,106 @@
+package audit_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/repository"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tamperedStore serves the audit chain of a real store after tamper has
+// edited it, the way someone with write access to the table could.
+type tamperedStore struct {
+	model.AuditStore
+	tamper func(records []model.AuditRecord) []model.AuditRecord
+}
+
+func (s tamperedStore) AuditChain(ctx context.Context, afterID, limit int) ([]model.AuditRecord, error) {
+	records, err := s.AuditStore.AuditChain(ctx, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.tamper(records), nil
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	for i, amount := range []float64{50, -20, 5} {
+		err := audit.Log(ctx, store, audit.Entry{
+			Actor:  audit.User(1),
+			Action: audit.BalanceAdjusted,
+			Target: audit.User(2),
+			Before: map[string]any{"step": i},
+			After:  map[string]any{"amount": amount},
+		})
+		if err != nil {
+			t.Fatalf("Log: %v", err)
+		}
+	}
+	chain, err := store.AuditChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("AuditChain: %v", err)
+	}
+
+	t.Run("intact chain", func(t *testing.T) {
+		var out strings.Builder
+		summary, err := audit.Export(ctx, store, &out, chain[1].Hash)
+		if err != nil {
+			t.Fatalf("Export: %v", err)
+		}
+		if summary.Records != 3 || summary.Head != chain[2].Hash {
+			t.Errorf("summary = %+v, want 3 records ending at %s", summary, chain[2].Hash)
+		}
+		lines := 0
+		for scanner := bufio.NewScanner(strings.NewReader(out.String())); scanner.Scan(); {
+			lines++
+		}
+		if lines != 3 {
+			t.Errorf("exported %d lines, want 3", lines)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		tamper func(records []model.AuditRecord) []model.AuditRecord
+		wantID int
+	}{
+		{"changed state", func(records []model.AuditRecord) []model.AuditRecord {
+			records[1].After = []byte(`{"amount":-2}`)
+			return records
+		}, 2},
+		{"changed and rehashed", func(records []model.AuditRecord) []model.AuditRecord {
+			records[1].Actor = audit.System
+			records[1].Hash = records[1].ComputeHash()
+			return records
+		}, 3},
+		{"removed record", func(records []model.AuditRecord) []model.AuditRecord {
+			return slices.Delete(records, 0, 1)
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			_, err := audit.Export(ctx, tamperedStore{AuditStore: store, tamper: tt.tamper}, &out, "")
+			var chainErr *audit.ChainError
+			if !errors.As(err, &chainErr) || chainErr.ID != tt.wantID {
+				t.Errorf("Export error = %v, want a broken chain at record %d", err, tt.wantID)
+			}
+		})
+	}
+
+	t.Run("cut off head", func(t *testing.T) {
+		cut := tamperedStore{AuditStore: store, tamper: func(records []model.AuditRecord) []model.AuditRecord {
+			return records[:2]
+		}}
+		var out strings.Builder
+		if _, err := audit.Export(ctx, cut, &out, chain[2].Hash); !errors.Is(err, audit.ErrHeadMissing) {
+			t.Errorf("Export error = %v, want %v", err, audit.ErrHeadMissing)
+		}
+	})
+}
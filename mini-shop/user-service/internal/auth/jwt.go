@@ -3,7 +3,8 @@ package auth
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"mini-shop/platform/problem"
 	"mini-shop/user-service/internal/config"
 	"mini-shop/user-service/internal/model"
 	"mini-shop/user-service/internal/utils"
@@ -16,15 +17,23 @@ import (
 
 type contextKey string
 
-const UserKey contextKey = "userID"
+const (
+	UserKey    contextKey = "userID"
+	ServiceKey contextKey = "service"
+)
 
-func CreateJWT(secret []byte, userID int, email string) (string, error) {
+// CreateJWT issues a token for user. emailVerified lets other services
+// restrict accounts whose email is not confirmed yet, and roles lets them
+// admit admins.
+func CreateJWT(secret []byte, user model.User) (string, error) {
 	expiration := time.Second * time.Duration(config.Envs.JWTExpirationInSeconds)
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"userID":    strconv.Itoa(userID),
-		"email":     email,
-		"expiredAt": time.Now().Add(expiration).Unix(),
+		"userID":        strconv.Itoa(user.ID),
+		"email":         user.Email,
+		"emailVerified": user.EmailVerified(),
+		"roles":         user.Roles,
+		"expiredAt":     time.Now().Add(expiration).Unix(),
 	})
 
 	tokenString, err := token.SignedString(secret)
@@ -36,37 +45,60 @@ func CreateJWT(secret []byte, userID int, email string) (string, error) {
 	return tokenString, nil
 }
 
+// WithJWTAuth lets through requests with a valid token of a user who is not
+// blocked and puts the user's ID under UserKey.
 func WithJWTAuth(handlerFunc http.HandlerFunc, store model.UserStore) http.HandlerFunc {
+	return withAuth(handlerFunc, store, "")
+}
+
+// WithRole is WithJWTAuth for users that have role.
+func WithRole(role string, handlerFunc http.HandlerFunc, store model.UserStore) http.HandlerFunc {
+	return withAuth(handlerFunc, store, role)
+}
+
+func withAuth(handlerFunc http.HandlerFunc, store model.UserStore, role string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		tokenString := utils.GetTokenFromRequest(r)
 
 		token, err := validateJWT(tokenString)
 		if err != nil {
-			log.Printf("failed to validate token: %v", err)
-			permissionDenied(w)
+			slog.WarnContext(r.Context(), "failed to validate token", "error", err)
+			permissionDenied(w, r)
 			return
 		}
 
 		if !token.Valid {
-			log.Println("invalid token")
-			permissionDenied(w)
+			slog.WarnContext(r.Context(), "invalid token")
+			permissionDenied(w, r)
 			return
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
-		str := claims["userID"].(string)
+		// У токенов сервисов нет userID
+		str, _ := claims["userID"].(string)
 
 		userID, err := strconv.Atoi(str)
 		if err != nil {
-			log.Printf("failed to convert userID to int: %v", err)
-			permissionDenied(w)
+			slog.WarnContext(r.Context(), "failed to convert userID to int", "error", err)
+			permissionDenied(w, r)
 			return
 		}
 
-		u, err := store.GetUserByID(userID)
+		u, err := store.GetUserByID(r.Context(), userID)
 		if err != nil {
-			log.Printf("failed to get user by id: %v", err)
-			permissionDenied(w)
+			slog.WarnContext(r.Context(), "failed to get user by id", "user_id", userID, "error", err)
+			permissionDenied(w, r)
+			return
+		}
+		// Токен заблокированного пользователя перестаёт действовать сразу
+		if u.Blocked() {
+			slog.WarnContext(r.Context(), "blocked user", "user_id", userID)
+			permissionDenied(w, r)
+			return
+		}
+		if role != "" && !u.HasRole(role) {
+			slog.WarnContext(r.Context(), "missing role", "user_id", userID, "role", role)
+			permissionDenied(w, r)
 			return
 		}
 
@@ -78,6 +110,42 @@ func WithJWTAuth(handlerFunc http.HandlerFunc, store model.UserStore) http.Handl
 	}
 }
 
+// CreateServiceJWT issues a token for the named service to call endpoints
+// guarded by WithServiceAuth. It expires after ttl.
+func CreateServiceJWT(secret []byte, service string, ttl time.Duration) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"service": service,
+		"exp":     time.Now().Add(ttl).Unix(),
+	})
+	return token.SignedString(secret)
+}
+
+// WithServiceAuth lets through requests with a valid service token, so that
+// money only moves on behalf of other services, and puts the service name
+// under ServiceKey. User tokens are refused.
+func WithServiceAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := validateJWT(utils.GetTokenFromRequest(r))
+		if err != nil || !token.Valid {
+			slog.WarnContext(r.Context(), "invalid service token", "error", err)
+			permissionDenied(w, r)
+			return
+		}
+
+		claims := token.Claims.(jwt.MapClaims)
+		service, _ := claims["service"].(string)
+		// Без срока действия токен сервиса работал бы вечно
+		if service == "" || !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+			slog.WarnContext(r.Context(), "not a service token")
+			permissionDenied(w, r)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ServiceKey, service)
+		handlerFunc(w, r.WithContext(ctx))
+	}
+}
+
 func validateJWT(tokenString string) (*jwt.Token, error) {
 	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -88,6 +156,6 @@ func validateJWT(tokenString string) (*jwt.Token, error) {
 	})
 }
 
-func permissionDenied(w http.ResponseWriter) {
-	utils.WriteError(w, http.StatusForbidden, fmt.Errorf("permission denied"))
+func permissionDenied(w http.ResponseWriter, r *http.Request) {
+	problem.WriteError(w, r, model.ErrPermissionDenied)
 }
@@ -1,18 +1,119 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mini-shop/user-service/internal/model"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordAlgorithm is what HashPassword uses.
+const PasswordAlgorithm = model.PasswordArgon2id
+
+// argon2Params follow the OWASP recommendation for argon2id. Hashes made with
+// other parameters are upgraded like bcrypt ones, see NeedsRehash.
+type argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+var currentArgon2 = argon2Params{Memory: 19 * 1024, Time: 2, Threads: 1}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var errMalformedHash = errors.New("malformed argon2id hash")
+
+// HashPassword hashes password with argon2id into the PHC string format,
+// $argon2id$v=19$m=...,t=...,p=...$salt$key.
 func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := currentArgon2
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
 
+func decodeArgon2(hash string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errMalformedHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, errMalformedHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return p, nil, nil, errMalformedHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errMalformedHash
+	}
+	return p, salt, key, nil
+}
+
+// comparePassword checks plain against a hash made with algorithm. Unknown
+// algorithms are taken for bcrypt, which every hash used before the marker was
+// stored.
+func comparePassword(algorithm, hashed, plain string) bool {
+	if algorithm != model.PasswordArgon2id {
+		return bcrypt.CompareHashAndPassword([]byte(hashed), []byte(plain)) == nil
+	}
+
+	p, salt, key, err := decodeArgon2(hashed)
 	if err != nil {
-		return "", err
+		return false
 	}
+	got := argon2.IDKey([]byte(plain), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(got, key) == 1
+}
+
+// dummyHash is checked when there is no account, see VerifyPassword.
+var dummyHash = sync.OnceValue(func() string {
+	hash, _ := HashPassword("no account has this password")
+	return hash
+})
 
-	return string(hash), nil
+// VerifyPassword reports whether plain is the password of user. A nil user
+// takes as long to check as a real one, so response times do not reveal which
+// emails have an account.
+func VerifyPassword(user *model.User, plain string) bool {
+	if user == nil {
+		comparePassword(PasswordAlgorithm, dummyHash(), plain)
+		return false
+	}
+	return comparePassword(user.PasswordAlgorithm, user.Password, plain)
 }
 
-func ComparePasswords(hashed string, plain []byte) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), plain)
-	return err == nil
-}
\ No newline at end of file
+// NeedsRehash reports whether the password of user is hashed with another
+// algorithm or weaker parameters than HashPassword uses now.
+func NeedsRehash(user *model.User) bool {
+	if user.PasswordAlgorithm != PasswordAlgorithm {
+		return true
+	}
+	p, _, _, err := decodeArgon2(user.Password)
+	return err != nil || p != currentArgon2
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"mini-shop/user-service/internal/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct-horse-battery")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("hash = %q, want the PHC argon2id format", hash)
+	}
+	if other, _ := HashPassword("correct-horse-battery"); other == hash {
+		t.Error("two hashes of a password are equal, want distinct salts")
+	}
+
+	user := &model.User{Password: hash, PasswordAlgorithm: PasswordAlgorithm}
+	if !VerifyPassword(user, "correct-horse-battery") || VerifyPassword(user, "wrong-horse-battery") {
+		t.Error("VerifyPassword does not tell the password from a wrong one")
+	}
+	if NeedsRehash(user) {
+		t.Error("NeedsRehash of a current hash = true, want false")
+	}
+
+	weaker := strings.Replace(hash, "t=2", "t=1", 1)
+	if !NeedsRehash(&model.User{Password: weaker, PasswordAlgorithm: PasswordAlgorithm}) {
+		t.Error("NeedsRehash of a hash with weaker parameters = false, want true")
+	}
+	for _, malformed := range []string{"", "$argon2id$v=19$m=1,t=1,p=1$", "$argon2i$v=19$m=1,t=1,p=1$c2FsdA$a2V5", hash[:len(hash)-3] + "!!!"} {
+		if VerifyPassword(&model.User{Password: malformed, PasswordAlgorithm: PasswordAlgorithm}, "correct-horse-battery") {
+			t.Errorf("VerifyPassword accepted the malformed hash %q", malformed)
+		}
+	}
+	if VerifyPassword(nil, "correct-horse-battery") {
+		t.Error("VerifyPassword without a user = true, want false")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte("# leaked\n\nHunter2Hunter2\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	policy, err := LoadPasswordPolicy(10, path)
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy: %v", err)
+	}
+
+	tests := []struct {
+		password string
+		rules    int
+	}{
+		{"correct-horse-battery", 0},
+		{"hunter2hunter2", 1},
+		{"password123", 0},
+		{"short", 1},
+		{"пароль-из-кириллицы", 0},
+		{"jo@example.com-and-more", 1},
+		{"joe-and-more", 0},
+		{strings.Repeat("x", DefaultMaxPasswordLength+1), 1},
+	}
+	for _, tt := range tests {
+		err := policy.Check("password", tt.password, "Jo@Example.com")
+		var e *model.Error
+		switch {
+		case tt.rules == 0 && err != nil:
+			t.Errorf("Check(%q) = %v, want nil", tt.password, err)
+		case tt.rules > 0 && (!errors.As(err, &e) || len(e.Fields) != tt.rules || e.Fields[0].Field != "password"):
+			t.Errorf("Check(%q) = %v, want %d rules broken on password", tt.password, err, tt.rules)
+		}
+	}
+
+	if _, err := LoadPasswordPolicy(0, filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("LoadPasswordPolicy with a missing file succeeded, want an error")
+	}
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"mini-shop/user-service/internal/model"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+//go:embed breached_passwords.txt
+var defaultBreached string
+
+// PasswordPolicy decides which new passwords are accepted. It does not apply
+// to passwords users already have, so tightening it locks nobody out.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// breached holds leaked passwords in lower case.
+	breached map[string]struct{}
+}
+
+const (
+	DefaultMinPasswordLength = 8
+	// Длинные пароли не ослабляют argon2id, но дорого обходятся при хешировании
+	DefaultMaxPasswordLength = 128
+)
+
+// DefaultPasswordPolicy checks against the short list of the most common
+// leaked passwords shipped with the service.
+var DefaultPasswordPolicy = mustPasswordPolicy(DefaultMinPasswordLength, strings.NewReader(defaultBreached))
+
+func mustPasswordPolicy(minLength int, breached io.Reader) *PasswordPolicy {
+	p, err := NewPasswordPolicy(minLength, breached)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// NewPasswordPolicy reads the breached passwords one per line; blank lines and
+// lines starting with # are skipped.
+func NewPasswordPolicy(minLength int, breached io.Reader) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength: minLength,
+		MaxLength: DefaultMaxPasswordLength,
+		breached:  make(map[string]struct{}),
+	}
+
+	scanner := bufio.NewScanner(breached)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached passwords: %w", err)
+	}
+	return p, nil
+}
+
+// LoadPasswordPolicy reads the breached passwords from path, or uses the
+// shipped list when path is empty. A non-positive minLength takes the default.
+func LoadPasswordPolicy(minLength int, path string) (*PasswordPolicy, error) {
+	if minLength <= 0 {
+		minLength = DefaultMinPasswordLength
+	}
+	if path == "" {
+		return NewPasswordPolicy(minLength, strings.NewReader(defaultBreached))
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached passwords: %w", err)
+	}
+	defer f.Close()
+	return NewPasswordPolicy(minLength, f)
+}
+
+// Check returns a validation error for field listing every rule password
+// breaks, or nil. email is the address of the account the password is for.
+func (p *PasswordPolicy) Check(field, password, email string) error {
+	var fields []model.FieldError
+	reject := func(format string, args ...any) {
+		fields = append(fields, model.FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		reject("must be at least %d characters long", p.MinLength)
+	}
+	if length > p.MaxLength {
+		reject("must be at most %d characters long", p.MaxLength)
+	}
+
+	lower := strings.ToLower(password)
+	if _, ok := p.breached[lower]; ok {
+		reject("is too common and appears in known data breaches")
+	}
+	if containsEmail(lower, strings.ToLower(email)) {
+		reject("must not contain your email")
+	}
+
+	if fields != nil {
+		return model.ValidationFailed(fields)
+	}
+	return nil
+}
+
+// containsEmail also catches the part before @, unless it is too short to
+// stand for the address.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return utf8.RuneCountInString(local) >= 4 && strings.Contains(password, local)
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// Последние шесть цифр восьмизначных кодов из приложения B RFC 6238
+	tests := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, want := range tests {
+		got, err := TOTPCode(rfcSecret, time.Unix(unix, 0))
+		if err != nil || got != want {
+			t.Errorf("TOTPCode at %d = %q, %v; want %q", unix, got, err, want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := TOTPCode(rfcSecret, now)
+
+	step, ok := ValidateTOTP(rfcSecret, code, now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("ValidateTOTP = %d, %v; want step %d", step, ok, totpStep(now))
+	}
+	if _, ok := ValidateTOTP(rfcSecret, code, now, step); ok {
+		t.Error("ValidateTOTP accepted a code of an already used step")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, code, now.Add(totpPeriod), 0); !ok {
+		t.Error("ValidateTOTP rejected a code one period old")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, code, now.Add(3*totpPeriod), 0); ok {
+		t.Error("ValidateTOTP accepted a code three periods old")
+	}
+	if _, ok := ValidateTOTP(rfcSecret, "12345", now, 0); ok {
+		t.Error("ValidateTOTP accepted a five-digit code")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil || len(secret) != 32 {
+		t.Fatalf("NewTOTPSecret = %q, %v; want 32 base32 characters", secret, err)
+	}
+
+	u, err := url.Parse(TOTPProvisioningURI("Mini Shop", "ann@example.com", secret))
+	if err != nil {
+		t.Fatalf("parse URI: %v", err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Mini Shop:ann@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/ with the issuer and account", u)
+	}
+	if q := u.Query(); q.Get("secret") != secret || q.Get("issuer") != "Mini Shop" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("URI query = %v, want the secret, issuer, digits and period", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' || seen[code] {
+			t.Errorf("recovery code %q, want a new code like xxxxx-xxxxx", code)
+		}
+		seen[code] = true
+		if got := NormalizeRecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", " "))); got != code {
+			t.Errorf("NormalizeRecoveryCode of %q = %q", code, got)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package dbtest provides the Postgres databases of store tests.
+package dbtest
+
+import (
+	"database/sql"
+	"fmt"
+	"mini-shop/platform/database"
+	"mini-shop/user-service/migrations"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+// Open creates a migrated scratch database on the server named by
+// TEST_POSTGRES_DSN and drops it when the test ends. The test is skipped when
+// the variable is not set.
+func Open(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("parse TEST_POSTGRES_DSN: %v", err)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("open admin connection: %v", err)
+	}
+	name := fmt.Sprintf("user_service_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE DATABASE " + name); err != nil {
+		admin.Close()
+		t.Fatalf("create database: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s WITH (FORCE)", name))
+		admin.Close()
+	})
+
+	password, _ := u.User.Password()
+	sslMode := u.Query().Get("sslmode")
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	cfg := database.Config{
+		Host:     u.Hostname(),
+		Port:     u.Port(),
+		User:     u.User.Username(),
+		Password: password,
+		DBName:   name,
+		SSLMode:  sslMode,
+	}
+
+	if err := database.RunMigrations(cfg, migrations.FS); err != nil {
+		t.Fatalf("run migrations: %v", err)
+	}
+	db, err := database.NewPostgresStorage(cfg)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"fmt"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func (h *Handler) getUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	details, err := h.admin.GetUser(r.Context(), id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, details)
+}
+
+func (h *Handler) blockUser(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	user, err := h.admin.Block(r.Context(), adminID, id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) unblockUser(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	user, err := h.admin.Unblock(r.Context(), adminID, id)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) setUserRoles(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	var payload model.SetRolesPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	user, err := h.admin.SetRoles(r.Context(), adminID, id, payload.Roles)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) adjustBalance(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value(auth.UserKey).(int)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	var payload model.AdjustBalancePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	balance, err := h.balanceService.Adjust(r.Context(), adminID, id, payload.Amount, payload.Reason)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]float64{"balance": balance})
+}
+
+func (h *Handler) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	params, err := pagination.Parse(r.URL.Query(), model.AuditSorts, "-occurredAt")
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	page, err := h.admin.AuditLog(r.Context(), filter, params)
+	if err != nil {
+		problem.WriteError(w, r, fmt.Errorf("failed to get audit log: %w", err))
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func parseAuditFilter(q url.Values) (model.AuditFilter, error) {
+	var (
+		filter = model.AuditFilter{Actor: q.Get("actor"), Action: q.Get("action"), Target: q.Get("target")}
+		err    error
+	)
+	if filter.From, err = pagination.TimeParam(q, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = pagination.TimeParam(q, "to"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func (h *Handler) getTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	roles, err := h.twoFactor.RequiredRoles(r.Context())
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, model.TwoFactorPolicy{RequiredRoles: roles})
+}
+
+func (h *Handler) setTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	adminID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.TwoFactorPolicy
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	roles, err := h.twoFactor.SetRequiredRoles(r.Context(), adminID, payload.RequiredRoles)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, model.TwoFactorPolicy{RequiredRoles: roles})
+}
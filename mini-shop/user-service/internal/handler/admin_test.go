@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// registerUser registers payload and returns its access token and user ID.
+func registerUser(t *testing.T, router *mux.Router, payload map[string]string) (string, int) {
+	t.Helper()
+
+	var tokens map[string]string
+	if err := json.NewDecoder(serve(t, router, http.MethodPost, "/register", payload).Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	var me model.User
+	if err := json.NewDecoder(serveAs(t, router, http.MethodGet, "/me", tokens["accessToken"], nil).Body).Decode(&me); err != nil {
+		t.Fatalf("decode me: %v", err)
+	}
+	return tokens["accessToken"], me.ID
+}
+
+// verifyEmail follows the last verification link mailed to email.
+func verifyEmail(t *testing.T, router *mux.Router, store model.UserStore, email string) {
+	t.Helper()
+
+	events, err := store.PendingOutboxEvents(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("PendingOutboxEvents: %v", err)
+	}
+	var link string
+	for _, event := range events {
+		var payload struct{ Email, VerificationURL string }
+		if err := json.Unmarshal(event.Payload, &payload); err == nil && payload.Email == email && payload.VerificationURL != "" {
+			link = payload.VerificationURL
+		}
+	}
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("token") == "" {
+		t.Fatalf("verification link for %s = %q, want a link with a token", email, link)
+	}
+	if rec := serve(t, router, http.MethodGet, "/email/verify?token="+url.QueryEscape(u.Query().Get("token")), nil); rec.Code != http.StatusOK {
+		t.Fatalf("verify %s: status %d, want %d", email, rec.Code, http.StatusOK)
+	}
+}
+
+func TestAdminEmailsGrantFirstAdmin(t *testing.T) {
+	router, store := newStoreTestRouter(t, service.DefaultExportConfig, []string{"ANN@example.com"})
+	token, annID := registerUser(t, router, ann)
+
+	// Роль даётся только владельцу почты, регистрации с этим адресом мало
+	if rec := serveAs(t, router, http.MethodGet, "/users", token, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("users before verifying the email: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	verifyEmail(t, router, store, ann["email"])
+
+	// Администраторам нужен второй фактор, его настраивают при первом входе
+	rec := serve(t, router, http.MethodPost, "/login", map[string]string{"email": ann["email"], "password": ann["password"]})
+	var challenge struct {
+		SetupRequired  bool
+		ChallengeToken string
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&challenge); err != nil || rec.Code != http.StatusOK || !challenge.SetupRequired {
+		t.Fatalf("login as an admin: status %d, %+v, %v; want a two-factor setup", rec.Code, challenge, err)
+	}
+	rec = serve(t, router, http.MethodPost, "/login/2fa/setup", map[string]string{"challengeToken": challenge.ChallengeToken})
+	var setup struct{ Secret string }
+	if err := json.NewDecoder(rec.Body).Decode(&setup); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("two-factor setup: status %d, %v", rec.Code, err)
+	}
+	code, err := auth.TOTPCode(setup.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	rec = serve(t, router, http.MethodPost, "/login/2fa", map[string]string{"challengeToken": challenge.ChallengeToken, "code": code})
+	var login struct{ Token string }
+	if err := json.NewDecoder(rec.Body).Decode(&login); err != nil || rec.Code != http.StatusOK || login.Token == "" {
+		t.Fatalf("two-factor login: status %d, %v; want a token", rec.Code, err)
+	}
+
+	if rec := serveAs(t, router, http.MethodGet, "/users", login.Token, nil); rec.Code != http.StatusOK {
+		t.Errorf("users as the first admin: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec = serveAs(t, router, http.MethodGet, "/audit?action="+audit.RolesChanged, login.Token, nil)
+	var page struct{ Items []model.AuditRecord }
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("audit log: status %d, %v", rec.Code, err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Actor != audit.System || page.Items[0].Target != audit.User(annID) {
+		t.Errorf("role changes = %+v, want the grant to Ann by the system", page.Items)
+	}
+}
+
+func TestAdminUsers(t *testing.T) {
+	router, store := newStoreTestRouter(t, service.DefaultExportConfig, []string{ann["email"]})
+	admin, adminID := registerUser(t, router, ann)
+	bob, bobID := registerUser(t, router, map[string]string{
+		"firstName": "Bob",
+		"lastName":  "Brown",
+		"email":     "bob@example.com",
+		"password":  "correct-horse-battery",
+	})
+
+	if rec := serveAs(t, router, http.MethodGet, "/users", admin, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("users before becoming an admin: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	verifyEmail(t, router, store, ann["email"])
+
+	rec := serveAs(t, router, http.MethodGet, "/users?q=BO", admin, nil)
+	var page struct{ Items []model.User }
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("search: status %d, %v", rec.Code, err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != bobID {
+		t.Errorf("search for BO = %+v, want Bob", page.Items)
+	}
+
+	userPath := fmt.Sprintf("/users/%d", bobID)
+	rec = serveAs(t, router, http.MethodPost, userPath+"/balance/adjust", admin, map[string]any{"amount": 50})
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != "validation_failed" {
+		t.Errorf("adjust without a reason: status %d, want %d validation_failed", rec.Code, http.StatusBadRequest)
+	}
+	rec = serveAs(t, router, http.MethodPost, userPath+"/balance/adjust", admin, map[string]any{"amount": 50, "reason": "goodwill"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("adjust: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec = serveAs(t, router, http.MethodPost, userPath+"/balance/adjust", admin, map[string]any{"amount": -80, "reason": "chargeback"})
+	if rec.Code != http.StatusPaymentRequired {
+		t.Errorf("adjust below zero: status %d, want %d", rec.Code, http.StatusPaymentRequired)
+	}
+
+	rec = serveAs(t, router, http.MethodGet, userPath, admin, nil)
+	var details model.UserDetails
+	if err := json.NewDecoder(rec.Body).Decode(&details); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("get user: status %d, %v", rec.Code, err)
+	}
+	if details.User.Balance != 50 || len(details.Ledger) != 1 || details.Ledger[0].Comment != "goodwill" ||
+		details.Ledger[0].ActorID == nil || *details.Ledger[0].ActorID != adminID {
+		t.Errorf("user details = %+v, want the balance and the adjustment by the admin", details)
+	}
+
+	if rec := serveAs(t, router, http.MethodPost, fmt.Sprintf("/users/%d/block", adminID), admin, nil); rec.Code != http.StatusConflict {
+		t.Errorf("block yourself: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := serveAs(t, router, http.MethodPost, userPath+"/block", admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("block: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveAs(t, router, http.MethodGet, "/me", bob, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("me while blocked: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = serve(t, router, http.MethodPost, "/login", map[string]string{"email": "bob@example.com", "password": "correct-horse-battery"})
+	if rec.Code != http.StatusForbidden || problemCode(t, rec) != "account_blocked" {
+		t.Errorf("login while blocked: status %d, want %d account_blocked", rec.Code, http.StatusForbidden)
+	}
+	if rec := serveAs(t, router, http.MethodPost, userPath+"/unblock", admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("unblock: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveAs(t, router, http.MethodGet, "/me", bob, nil); rec.Code != http.StatusOK {
+		t.Errorf("me after unblocking: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = serveAs(t, router, http.MethodPut, userPath+"/roles", admin, map[string]any{"roles": []string{"root"}})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown role: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	rec = serveAs(t, router, http.MethodPut, fmt.Sprintf("/users/%d/roles", adminID), admin, map[string]any{"roles": []string{}})
+	if rec.Code != http.StatusConflict {
+		t.Errorf("drop your own admin role: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	rec = serveAs(t, router, http.MethodPut, userPath+"/roles", admin, map[string]any{"roles": []string{model.RoleAdmin}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set roles: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveAs(t, router, http.MethodGet, "/users", bob, nil); rec.Code != http.StatusOK {
+		t.Errorf("users as a new admin: status %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	router, store := newStoreTestRouter(t, service.DefaultExportConfig, []string{ann["email"]})
+	admin, adminID := registerUser(t, router, ann)
+	bob, bobID := registerUser(t, router, map[string]string{
+		"firstName": "Bob",
+		"lastName":  "Brown",
+		"email":     "bob@example.com",
+		"password":  "correct-horse-battery",
+	})
+	verifyEmail(t, router, store, ann["email"])
+
+	userPath := fmt.Sprintf("/users/%d", bobID)
+	if rec := serveAs(t, router, http.MethodPost, userPath+"/balance/adjust", admin, map[string]any{"amount": 50, "reason": "goodwill"}); rec.Code != http.StatusOK {
+		t.Fatalf("adjust: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveAs(t, router, http.MethodPost, userPath+"/block", admin, nil); rec.Code != http.StatusOK {
+		t.Fatalf("block: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	serve(t, router, http.MethodPost, "/login", map[string]string{"email": "bob@example.com", "password": "correct-horse-battery"})
+
+	if rec := serveAs(t, router, http.MethodGet, "/audit", bob, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("audit log as a customer: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := serveAs(t, router, http.MethodGet, "/audit?target=user:"+strconv.Itoa(bobID), admin, nil)
+	var page struct{ Items []model.AuditRecord }
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("audit log: status %d, %v", rec.Code, err)
+	}
+	var actions []string
+	for _, r := range page.Items {
+		actions = append(actions, r.Action)
+	}
+	want := []string{audit.LoginBlocked, audit.AccountBlocked, audit.BalanceAdjusted}
+	if !slices.Equal(actions, want) {
+		t.Fatalf("audit actions = %v, want %v", actions, want)
+	}
+
+	adjusted := page.Items[2]
+	if adjusted.Actor != audit.User(adminID) || adjusted.IP != "192.0.2.1" ||
+		string(adjusted.Before) != `{"balance":0}` || !strings.Contains(string(adjusted.After), `"balance":50`) {
+		t.Errorf("adjustment record = %+v, want the admin, the client IP and the balance before and after", adjusted)
+	}
+
+	var out strings.Builder
+	if summary, err := audit.Export(context.Background(), store, &out, adjusted.Hash); err != nil || summary.Head != page.Items[0].Hash {
+		t.Errorf("Export = %+v, %v, want an intact chain ending at the last record", summary, err)
+	}
+}
+
+func TestTwoFactorPolicy(t *testing.T) {
+	bob := map[string]string{
+		"firstName": "Bob",
+		"lastName":  "Brown",
+		"email":     "bob@example.com",
+		"password":  "correct-horse-battery",
+	}
+	router, store := newStoreTestRouter(t, service.DefaultExportConfig, []string{ann["email"], bob["email"]})
+	admin, adminID := registerUser(t, router, ann)
+	bobToken, _ := registerUser(t, router, bob)
+
+	if rec := serveAs(t, router, http.MethodGet, "/settings/two-factor", bobToken, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("policy before becoming an admin: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	verifyEmail(t, router, store, ann["email"])
+	verifyEmail(t, router, store, bob["email"])
+
+	policy := func(rec *httptest.ResponseRecorder) []string {
+		t.Helper()
+		var got model.TwoFactorPolicy
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("policy: status %d, %v", rec.Code, err)
+		}
+		return got.RequiredRoles
+	}
+	if got := policy(serveAs(t, router, http.MethodGet, "/settings/two-factor", admin, nil)); !slices.Equal(got, []string{model.RoleAdmin}) {
+		t.Errorf("default policy = %v, want the configured [admin]", got)
+	}
+	rec := serveAs(t, router, http.MethodPut, "/settings/two-factor", admin, map[string]any{"requiredRoles": []string{"root"}})
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != "validation_failed" {
+		t.Errorf("unknown role: status %d, want %d validation_failed", rec.Code, http.StatusBadRequest)
+	}
+
+	login := func() map[string]any {
+		t.Helper()
+		var body map[string]any
+		rec := serve(t, router, http.MethodPost, "/login", map[string]string{"email": bob["email"], "password": bob["password"]})
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("login: status %d, %v", rec.Code, err)
+		}
+		return body
+	}
+	if body := login(); body["twoFactorRequired"] != true {
+		t.Fatalf("admin login = %v, want a second step", body)
+	}
+
+	// Изменение действует со следующего входа, без перезапуска
+	if got := policy(serveAs(t, router, http.MethodPut, "/settings/two-factor", admin, map[string]any{"requiredRoles": []string{}})); len(got) != 0 {
+		t.Errorf("policy after clearing = %v, want none", got)
+	}
+	if body := login(); body["token"] == nil {
+		t.Errorf("admin login without required roles = %v, want a token", body)
+	}
+
+	rec = serveAs(t, router, http.MethodGet, "/audit?action="+audit.TwoFactorPolicyChanged, admin, nil)
+	var page struct{ Items []model.AuditRecord }
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("audit log: status %d, %v", rec.Code, err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Actor != audit.User(adminID) || page.Items[0].Target != audit.Setting(model.SettingTwoFactorRoles) {
+		t.Errorf("policy changes = %+v, want one by the admin", page.Items)
+	}
+}
@@ -1,129 +1,260 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/pagination"
+	"mini-shop/platform/problem"
+	"mini-shop/user-service/internal/audit"
 	"mini-shop/user-service/internal/auth"
 	"mini-shop/user-service/internal/config"
+	"mini-shop/user-service/internal/metrics"
 	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/ratelimit"
 	"mini-shop/user-service/internal/service"
 	"mini-shop/user-service/internal/utils"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 )
 
 type Handler struct {
 	store          model.UserStore
+	userService    *service.UserService
 	balanceService service.BalanceService
+	twoFactor      *service.TwoFactorService
+	export         *service.ExportService
+	admin          *service.AdminService
+	limiter        *ratelimit.Limiter
 }
 
-func NewUserHandler(store model.UserStore, balanceService service.BalanceService) *Handler {
-	return &Handler{store: store, balanceService: balanceService}
+func NewUserHandler(store model.UserStore, userService *service.UserService, balanceService service.BalanceService, twoFactor *service.TwoFactorService, export *service.ExportService, admin *service.AdminService, limiter *ratelimit.Limiter) *Handler {
+	return &Handler{store: store, userService: userService, balanceService: balanceService, twoFactor: twoFactor, export: export, admin: admin, limiter: limiter}
 }
 
 func (h *Handler) RegisterRoutes(router *mux.Router) {
-	router.HandleFunc("/login", h.handleLogin).Methods("POST")
-	router.HandleFunc("/register", h.handleRegister).Methods("POST")
+	policy := h.limiter.Policy
+	router.HandleFunc("/login", h.limiter.PerIP("login", policy.LoginPerIP, h.handleLogin)).Methods("POST")
+	router.HandleFunc("/register", h.limiter.PerIP("register", policy.RegisterPerIP, h.handleRegister)).Methods("POST")
+	// Второй шаг входа: вместо JWT его подтверждает токен вызова из /login
+	router.HandleFunc("/login/2fa", h.limiter.PerIP("login", policy.LoginPerIP, h.handleLoginTwoFactor)).Methods("POST")
+	router.HandleFunc("/login/2fa/setup", h.limiter.PerIP("login", policy.LoginPerIP, h.handleLoginTwoFactorSetup)).Methods("POST")
+
+	// Ссылки из писем открываются без входа, их подлинность подтверждает токен
+	router.HandleFunc("/email/verify", h.handleVerifyEmail).Methods("GET")
+	router.HandleFunc("/email/verify/resend", auth.WithJWTAuth(h.handleResendVerification, h.store)).Methods("POST")
+	router.HandleFunc("/password/forgot", h.limiter.PerIP("password", policy.PasswordPerIP, h.handleForgotPassword)).Methods("POST")
+	router.HandleFunc("/password/reset", h.limiter.PerIP("password", policy.PasswordPerIP, h.handleResetPassword)).Methods("POST")
+
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleGetMe, h.store)).Methods("GET")
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleUpdateMe, h.store)).Methods("PATCH")
+	router.HandleFunc("/me", auth.WithJWTAuth(h.handleDeleteMe, h.store)).Methods("DELETE")
+	router.HandleFunc("/me/password", auth.WithJWTAuth(h.handleChangePassword, h.store)).Methods("POST")
+	router.HandleFunc("/me/export", auth.WithJWTAuth(h.handleExportMe, h.store)).Methods("GET")
+	router.HandleFunc("/me/2fa/setup", auth.WithJWTAuth(h.handleTwoFactorSetup, h.store)).Methods("POST")
+	router.HandleFunc("/me/2fa/enable", h.limiter.PerIP("two_factor", policy.LoginPerIP, auth.WithJWTAuth(h.handleTwoFactorEnable, h.store))).Methods("POST")
+	router.HandleFunc("/me/2fa/disable", h.limiter.PerIP("two_factor", policy.LoginPerIP, auth.WithJWTAuth(h.handleTwoFactorDisable, h.store))).Methods("POST")
+	router.HandleFunc("/me/2fa/recovery-codes", h.limiter.PerIP("two_factor", policy.LoginPerIP, auth.WithJWTAuth(h.handleRecoveryCodes, h.store))).Methods("POST")
 
 	router.HandleFunc("/secret", auth.WithJWTAuth(h.secretMethod, h.store)).Methods("GET")
 
-	router.HandleFunc("/users", auth.WithJWTAuth(h.getUsers, h.store)).Methods("GET")
-	router.HandleFunc("/users/{id:[0-9]+}", auth.WithJWTAuth(h.deleteUser, h.store)).Methods("DELETE")
-	router.HandleFunc("/users", auth.WithJWTAuth(h.deleteAllUsers, h.store)).Methods("DELETE")
+	// Управление пользователями доступно только администраторам
+	router.HandleFunc("/users", auth.WithRole(model.RoleAdmin, h.getUsers, h.store)).Methods("GET")
+	router.HandleFunc("/users/{id:[0-9]+}", auth.WithRole(model.RoleAdmin, h.getUser, h.store)).Methods("GET")
+	router.HandleFunc("/users/{id:[0-9]+}", auth.WithRole(model.RoleAdmin, h.deleteUser, h.store)).Methods("DELETE")
+	router.HandleFunc("/users", auth.WithRole(model.RoleAdmin, h.deleteAllUsers, h.store)).Methods("DELETE")
+	router.HandleFunc("/users/{id:[0-9]+}/block", auth.WithRole(model.RoleAdmin, h.blockUser, h.store)).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/unblock", auth.WithRole(model.RoleAdmin, h.unblockUser, h.store)).Methods("POST")
+	router.HandleFunc("/users/{id:[0-9]+}/roles", auth.WithRole(model.RoleAdmin, h.setUserRoles, h.store)).Methods("PUT")
+	router.HandleFunc("/users/{id:[0-9]+}/balance/adjust", auth.WithRole(model.RoleAdmin, h.adjustBalance, h.store)).Methods("POST")
+	router.HandleFunc("/settings/two-factor", auth.WithRole(model.RoleAdmin, h.getTwoFactorPolicy, h.store)).Methods("GET")
+	router.HandleFunc("/settings/two-factor", auth.WithRole(model.RoleAdmin, h.setTwoFactorPolicy, h.store)).Methods("PUT")
+	router.HandleFunc("/audit", auth.WithRole(model.RoleAdmin, h.getAuditLog, h.store)).Methods("GET")
 
 	router.HandleFunc("/balance/{id:[0-9]+}", h.handleGetBalance).Methods("GET")
-	router.HandleFunc("/balance/{id:[0-9]+}/add", h.handleAddBalance).Methods("POST")
-	router.HandleFunc("/balance/{id:[0-9]+}/withdraw", h.handleWithdrawBalance).Methods("POST")
+	// Деньги двигает только payment-service: пополнения проходят через
+	// платёжного провайдера, списания оплачивают заказы
+	router.HandleFunc("/balance/{id:[0-9]+}/add", auth.WithServiceAuth(h.handleAddBalance)).Methods("POST")
+	router.HandleFunc("/balance/{id:[0-9]+}/withdraw", auth.WithServiceAuth(h.handleWithdrawBalance)).Methods("POST")
+	router.HandleFunc("/balance/{id:[0-9]+}/refund", auth.WithServiceAuth(h.handleRefundBalance)).Methods("POST")
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 	var user model.LoginUserPayload
 	if err := utils.ParseJSON(r, &user); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
 		return
 	}
 
 	if err := utils.Validate.Struct(user); err != nil {
-		errors := err.(validator.ValidationErrors)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		problem.WriteValidationError(w, r, err)
 		return
 	}
 
-	u, err := h.store.GetUserByEmail(user.Email)
+	// Лимиты и блокировка считаются по адресу, есть у него аккаунт или нет,
+	// поэтому ответы не выдают, какие адреса зарегистрированы
+	ctx := r.Context()
+	ip := ratelimit.ClientIP(r)
+	account := strings.ToLower(strings.TrimSpace(user.Email))
+	if h.limiter.Throttled(w, r, "login_account", "account:"+account, h.limiter.Policy.LoginPerAccount, "email", user.Email, "ip", ip) {
+		return
+	}
+	lockKey := lockoutKey(user.Email)
+	locked, err := h.limiter.LockedFor(ctx, lockKey)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("not found, invalid email or password"))
+		slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+	}
+	if locked > 0 {
+		metrics.RateLimited.WithLabelValues("lockout").Inc()
+		h.logLogin(ctx, audit.Entry{Actor: audit.Anonymous, Action: audit.LoginLocked, Target: emailTarget(user.Email)})
+		ratelimit.WriteTooManyRequests(w, r, locked)
+		return
+	}
+
+	u, err := h.store.GetUserByEmail(ctx, user.Email)
+	if err != nil && !errors.Is(err, model.ErrUserNotFound) {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	if !auth.VerifyPassword(u, user.Password) {
+		locked, err := h.limiter.Fail(ctx, lockKey)
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+		}
+		target := emailTarget(user.Email)
+		if u != nil {
+			target = audit.User(u.ID)
+		}
+		h.logLogin(ctx, audit.Entry{Actor: audit.Anonymous, Action: audit.LoginFailed, Target: target, After: map[string]any{"lockedFor": locked.String()}})
+		problem.WriteError(w, r, model.ErrInvalidCredentials)
+		return
+	}
+	// О блокировке сообщаем только после верного пароля
+	if u.Blocked() {
+		h.logLogin(ctx, audit.Entry{Actor: audit.User(u.ID), Action: audit.LoginBlocked, Target: audit.User(u.ID)})
+		problem.WriteError(w, r, model.ErrAccountBlocked)
 		return
 	}
+	// Открытый пароль есть только при входе, поэтому старые хеши обновляем здесь
+	if auth.NeedsRehash(u) {
+		if err := h.userService.RehashPassword(ctx, u, user.Password); err != nil {
+			slog.ErrorContext(ctx, "failed to rehash password", "user_id", u.ID, "error", err)
+		}
+	}
 
-	if !auth.ComparePasswords(u.Password, []byte(user.Password)) {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid email or password"))
+	// Счётчик неудач сбрасывается только после второго шага, иначе пароль
+	// позволил бы перебирать коды без блокировки
+	required, err := h.twoFactor.Required(ctx, u)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if u.TwoFactorEnabled() || required {
+		challenge, err := h.twoFactor.StartLogin(ctx, u.ID)
+		if err != nil {
+			problem.WriteError(w, r, err)
+			return
+		}
+		utils.WriteJSON(w, http.StatusOK, map[string]any{
+			"twoFactorRequired": true,
+			"setupRequired":     !u.TwoFactorEnabled(),
+			"challengeToken":    challenge,
+		})
 		return
 	}
 
+	if err := h.limiter.Succeed(ctx, lockKey); err != nil {
+		slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+	}
+	h.writeToken(w, r, u, nil)
+}
+
+// writeToken answers a completed login. recoveryCodes are added when the
+// login has just enabled two-factor authentication.
+func (h *Handler) writeToken(w http.ResponseWriter, r *http.Request, u *model.User, recoveryCodes []string) {
 	secret := []byte(config.Envs.JWTSecret)
-	token, err := auth.CreateJWT(secret, u.ID, u.Email)
+	token, err := auth.CreateJWT(secret, *u)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
+	h.logLogin(r.Context(), audit.Entry{Actor: audit.User(u.ID), Action: audit.LoginSucceeded, Target: audit.User(u.ID)})
 
+	if recoveryCodes != nil {
+		utils.WriteJSON(w, http.StatusOK, map[string]any{"token": token, "recoveryCodes": recoveryCodes})
+		return
+	}
 	utils.WriteJSON(w, http.StatusOK, map[string]string{"token": token})
 }
 
+// logLogin writes a login attempt to the audit log. Logins go on when the
+// audit log is unavailable, so the failure is only logged.
+func (h *Handler) logLogin(ctx context.Context, entry audit.Entry) {
+	if err := audit.Log(ctx, h.store, entry); err != nil {
+		slog.ErrorContext(ctx, "failed to audit login", "action", entry.Action, "target", entry.Target, "error", err)
+	}
+}
+
+// emailTarget names an account by masked email when there is no user to name,
+// so the audit log keeps no address that erasure could not reach.
+func emailTarget(email string) string {
+	return "email:" + logger.MaskEmail(strings.TrimSpace(email))
+}
+
+// lockoutKey is where failed logins of email are counted.
+func lockoutKey(email string) string {
+	return "lockout:" + strings.ToLower(strings.TrimSpace(email))
+}
+
 func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	var user model.RegisterUserPayload
 	if err := utils.ParseJSON(r, &user); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
 		return
 	}
 
 	if err := utils.Validate.Struct(user); err != nil {
-		errors := err.(validator.ValidationErrors)
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid payload: %v", errors))
+		problem.WriteValidationError(w, r, err)
 		return
 	}
 
-	_, err := h.store.GetUserByEmail(user.Email)
+	_, err := h.store.GetUserByEmail(r.Context(), user.Email)
 	if err == nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("user with email %s already exists", user.Email))
+		problem.WriteError(w, r, model.ErrUserExists)
 		return
 	}
-
-	hashedPassword, err := auth.HashPassword(user.Password)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+	if !errors.Is(err, model.ErrUserNotFound) {
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	err = h.store.CreateUser(model.User{
+	u, err := h.userService.Register(r.Context(), model.User{
 		FirstName: user.FirstName,
 		LastName:  user.LastName,
 		Email:     user.Email,
-		Password:  hashedPassword,
-	})
+	}, user.Password)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
-		return
-	}
-
-	u, err := h.store.GetUserByEmail(user.Email)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
 	secret := []byte(config.Envs.JWTSecret)
-	accessToken, err := auth.CreateJWT(secret, u.ID, u.Email)
+	accessToken, err := auth.CreateJWT(secret, *u)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
-	refreshToken, err := auth.CreateJWT(secret, u.ID, u.Email)
+	refreshToken, err := auth.CreateJWT(secret, *u)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
@@ -133,6 +264,167 @@ func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (h *Handler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		problem.WriteError(w, r, model.ErrInvalidToken)
+		return
+	}
+
+	if _, err := h.userService.VerifyEmail(r.Context(), token); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	// Выданные раньше токены не знают о подтверждении, поэтому просим войти заново
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "email verified, please log in again"})
+}
+
+func (h *Handler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	if err := h.userService.ResendVerification(r.Context(), userID); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, map[string]string{"message": "verification email sent"})
+}
+
+func (h *Handler) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload model.ForgotPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	if err := h.userService.ForgotPassword(r.Context(), payload.Email); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	// Ответ одинаков для любого адреса, чтобы по нему нельзя было узнать, есть ли аккаунт
+	utils.WriteJSON(w, http.StatusAccepted, map[string]string{"message": "if the account exists, a reset link has been sent"})
+}
+
+func (h *Handler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload model.ResetPasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	if err := h.userService.ResetPassword(r.Context(), payload.Token, payload.Password); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "password changed"})
+}
+
+func (h *Handler) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	user, err := h.store.GetUserByID(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.UpdateProfilePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(r.Context(), userID, payload)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, user)
+}
+
+func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.ChangePasswordPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	if err := h.userService.ChangePassword(r.Context(), userID, payload.OldPassword, payload.NewPassword); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "password changed"})
+}
+
+func (h *Handler) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	// Удаление необратимо, поэтому просим пароль даже при действующем токене
+	var payload model.DeleteAccountPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	if err := h.userService.DeleteAccount(r.Context(), userID, payload.Password); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "account deleted"})
+}
+
+func (h *Handler) handleExportMe(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	export, err := h.export.Export(r.Context(), userID, utils.GetTokenFromRequest(r))
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="minishop-export.json"`)
+	utils.WriteJSON(w, http.StatusOK, export)
+}
+
 func (h *Handler) secretMethod(w http.ResponseWriter, r *http.Request) {
 	utils.WriteJSON(w, http.StatusOK, map[string]string{
 		"message": "секретный метод",
@@ -140,13 +432,45 @@ func (h *Handler) secretMethod(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) getUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.store.GetUsers()
+	params, err := pagination.Parse(r.URL.Query(), model.UserSorts, "-createdAt")
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	filter, err := parseUserFilter(r.URL.Query())
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("%v", err))
+		return
+	}
+
+	page, err := h.admin.SearchUsers(r.Context(), filter, params)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to get users: %v", err))
+		problem.WriteError(w, r, fmt.Errorf("failed to get users: %w", err))
 		return
 	}
 
-	utils.WriteJSON(w, http.StatusOK, users)
+	utils.WriteJSON(w, http.StatusOK, page)
+}
+
+func parseUserFilter(q url.Values) (model.UserFilter, error) {
+	var (
+		filter = model.UserFilter{Query: strings.TrimSpace(q.Get("q"))}
+		err    error
+	)
+	if filter.CreatedFrom, err = pagination.TimeParam(q, "createdFrom"); err != nil {
+		return filter, err
+	}
+	if filter.CreatedTo, err = pagination.TimeParam(q, "createdTo"); err != nil {
+		return filter, err
+	}
+	if filter.MinBalance, err = pagination.FloatParam(q, "minBalance"); err != nil {
+		return filter, err
+	}
+	if filter.MaxBalance, err = pagination.FloatParam(q, "maxBalance"); err != nil {
+		return filter, err
+	}
+	return filter, nil
 }
 
 func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
@@ -155,13 +479,13 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 
 	id, err := strconv.Atoi(userID)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user id"))
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
 		return
 	}
 
-	err = h.store.DeleteUser(id)
-	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete user: %v", err))
+	adminID := r.Context().Value(auth.UserKey).(int)
+	if err := h.userService.DeleteUser(r.Context(), adminID, id); err != nil {
+		problem.WriteError(w, r, err)
 		return
 	}
 
@@ -169,9 +493,10 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) deleteAllUsers(w http.ResponseWriter, r *http.Request) {
-	err := h.store.DeleteAllUsers()
+	adminID := r.Context().Value(auth.UserKey).(int)
+	err := h.userService.DeleteAllUsers(r.Context(), adminID)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, fmt.Errorf("failed to delete all users: %v", err))
+		problem.WriteError(w, r, fmt.Errorf("failed to delete all users: %w", err))
 		return
 	}
 
@@ -182,13 +507,13 @@ func (h *Handler) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
 		return
 	}
 
-	balance, err := h.balanceService.GetBalance(id)
+	balance, err := h.balanceService.GetBalance(r.Context(), id)
 	if err != nil {
-		utils.WriteError(w, http.StatusNotFound, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
@@ -199,27 +524,33 @@ func (h *Handler) handleAddBalance(w http.ResponseWriter, r *http.Request) {
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
 		return
 	}
 
 	var payload struct {
 		Amount float64 `json:"amount"`
+		// Reference names the payment behind the credit, see AddBalance.
+		Reference string `json:"reference"`
 	}
 
 	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
 		return
 	}
 
 	if payload.Amount <= 0 {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("amount must be positive"))
+		problem.WriteError(w, r, model.InvalidInput("amount must be positive"))
+		return
+	}
+	if len(payload.Reference) > 100 {
+		problem.WriteError(w, r, model.InvalidInput("reference must be at most 100 characters"))
 		return
 	}
 
-	balance, err := h.balanceService.AddBalance(id, payload.Amount)
+	balance, err := h.balanceService.AddBalance(r.Context(), id, payload.Amount, payload.Reference)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
@@ -230,27 +561,70 @@ func (h *Handler) handleWithdrawBalance(w http.ResponseWriter, r *http.Request)
 	idStr := mux.Vars(r)["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid user ID"))
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
 		return
 	}
 
 	var payload struct {
 		Amount float64 `json:"amount"`
+		// Reference names the payment behind the charge, see Withdraw.
+		Reference string `json:"reference"`
 	}
 
 	if err := utils.ParseJSON(r, &payload); err != nil {
-		utils.WriteError(w, http.StatusBadRequest, err)
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
 		return
 	}
 
 	if payload.Amount <= 0 {
-		utils.WriteError(w, http.StatusBadRequest, fmt.Errorf("amount must be positive"))
+		problem.WriteError(w, r, model.InvalidInput("amount must be positive"))
+		return
+	}
+	if len(payload.Reference) > 100 {
+		problem.WriteError(w, r, model.InvalidInput("reference must be at most 100 characters"))
+		return
+	}
+
+	balance, err := h.balanceService.Withdraw(r.Context(), id, payload.Amount, payload.Reference)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]float64{"balance": balance})
+}
+
+func (h *Handler) handleRefundBalance(w http.ResponseWriter, r *http.Request) {
+	idStr := mux.Vars(r)["id"]
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		problem.WriteError(w, r, model.InvalidInput("invalid user ID"))
+		return
+	}
+
+	var payload struct {
+		Amount float64 `json:"amount"`
+		// Reference names the payment being refunded, see Refund.
+		Reference string `json:"reference"`
+	}
+
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if payload.Amount <= 0 {
+		problem.WriteError(w, r, model.InvalidInput("amount must be positive"))
+		return
+	}
+	if payload.Reference == "" || len(payload.Reference) > 100 {
+		problem.WriteError(w, r, model.InvalidInput("reference must be 1 to 100 characters"))
 		return
 	}
 
-	balance, err := h.balanceService.Withdraw(id, payload.Amount)
+	balance, err := h.balanceService.Refund(r.Context(), id, payload.Amount, payload.Reference)
 	if err != nil {
-		utils.WriteError(w, http.StatusInternalServerError, err)
+		problem.WriteError(w, r, err)
 		return
 	}
 
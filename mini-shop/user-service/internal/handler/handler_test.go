@@ -0,0 +1,428 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"mini-shop/platform/problem"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/config"
+	"mini-shop/user-service/internal/messaging"
+	"mini-shop/user-service/internal/ratelimit"
+	"mini-shop/user-service/internal/repository"
+	"mini-shop/user-service/internal/service"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func newTestRouter(t *testing.T) *mux.Router {
+	t.Helper()
+	return newExportTestRouter(t, service.DefaultExportConfig)
+}
+
+// newExportTestRouter exports the data of the services configured in export.
+func newExportTestRouter(t *testing.T, export service.ExportConfig) *mux.Router {
+	t.Helper()
+	router, _ := newStoreTestRouter(t, export, nil)
+	return router
+}
+
+// newStoreTestRouter grants the admin role to adminEmails once they are
+// verified and also returns the store, whose outbox has the mailed links.
+func newStoreTestRouter(t *testing.T, export service.ExportConfig, adminEmails []string) (*mux.Router, *repository.MemoryStore) {
+	t.Helper()
+
+	store := repository.NewMemoryStore()
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+
+	outbox := service.NewOutboxRelay(store, broker)
+	router := mux.NewRouter()
+	router.Use(audit.Middleware(ratelimit.ClientIP))
+	limiter := ratelimit.NewLimiter(ratelimit.NewMemoryStore(), ratelimit.DefaultPolicy)
+	userService := service.NewUserService(store, outbox, service.DefaultTokenConfig, auth.DefaultPasswordPolicy, adminEmails)
+	twoFactor := service.NewTwoFactorService(store, service.DefaultTokenConfig.Secret, service.DefaultTwoFactorConfig)
+	exportService := service.NewExportService(store, export)
+	adminService := service.NewAdminService(store)
+	NewUserHandler(store, userService, *service.NewBalanceService(store, outbox, 0), twoFactor, exportService, adminService, limiter).RegisterRoutes(router)
+	return router, store
+}
+
+func serve(t *testing.T, router http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serveAs(t, router, method, path, "", body)
+}
+
+// serveAs sends the request with token in the Authorization header.
+func serveAs(t *testing.T, router http.Handler, method, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal body: %v", err)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func problemCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Fatalf("Content-Type = %q, want application/problem+json", ct)
+	}
+	var body problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("decode problem: %v", err)
+	}
+	return body.Code
+}
+
+var ann = map[string]string{
+	"firstName": "Ann",
+	"lastName":  "Smith",
+	"email":     "ann@example.com",
+	"password":  "correct-horse-battery",
+}
+
+func TestRegister(t *testing.T) {
+	router := newTestRouter(t)
+
+	rec := serve(t, router, http.MethodPost, "/register", ann)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("register: status %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || tokens["accessToken"] == "" {
+		t.Fatalf("register response = %v, %v; want an access token", tokens, err)
+	}
+
+	rec = serve(t, router, http.MethodPost, "/register", ann)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate register: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if code := problemCode(t, rec); code != "user_exists" {
+		t.Errorf("duplicate register: code %q, want user_exists", code)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	rec := serve(t, newTestRouter(t), http.MethodPost, "/register", map[string]string{"email": "not-an-email"})
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if code := problemCode(t, rec); code != "validation_failed" {
+		t.Errorf("code %q, want validation_failed", code)
+	}
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := map[string]string{
+		"too short":         "horse",
+		"breached":          "Password123",
+		"contains email":    "annsmith@example.com!",
+		"contains its name": "i-am-AnnSmith-42",
+	}
+	for name, password := range tests {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, router, http.MethodPost, "/register", map[string]string{
+				"firstName": "Ann",
+				"lastName":  "Smith",
+				"email":     "annsmith@example.com",
+				"password":  password,
+			})
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			var body problem.Problem
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatalf("decode problem: %v", err)
+			}
+			if body.Code != "validation_failed" || len(body.Errors) == 0 || body.Errors[0].Field != "password" {
+				t.Errorf("problem = %+v, want validation_failed on password", body)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	router := newTestRouter(t)
+	serve(t, router, http.MethodPost, "/register", ann)
+
+	rec := serve(t, router, http.MethodPost, "/login", map[string]string{"email": ann["email"], "password": ann["password"]})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	for name, creds := range map[string]map[string]string{
+		"wrong password": {"email": ann["email"], "password": "wrong"},
+		"unknown email":  {"email": "bob@example.com", "password": ann["password"]},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(t, router, http.MethodPost, "/login", creds)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+			if code := problemCode(t, rec); code != "invalid_credentials" {
+				t.Errorf("code %q, want invalid_credentials", code)
+			}
+		})
+	}
+}
+
+// serviceToken returns a token payment-service would send.
+func serviceToken(t *testing.T) string {
+	t.Helper()
+	token, err := auth.CreateServiceJWT([]byte(config.Envs.JWTSecret), "payment-service", time.Minute)
+	if err != nil {
+		t.Fatalf("CreateServiceJWT: %v", err)
+	}
+	return token
+}
+
+func TestBalance(t *testing.T) {
+	router := newTestRouter(t)
+	rec := serve(t, router, http.MethodPost, "/register", ann)
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	paymentService := serviceToken(t)
+
+	for name, token := range map[string]string{"without a token": "", "with a user token": tokens["accessToken"]} {
+		rec = serveAs(t, router, http.MethodPost, "/balance/1/add", token, map[string]float64{"amount": 40})
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("add %s: status %d, want %d", name, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	// Повтор с той же ссылкой не зачисляет деньги второй раз
+	for range 2 {
+		rec = serveAs(t, router, http.MethodPost, "/balance/1/add", paymentService, map[string]any{"amount": 40, "reference": "topup:1"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("add: status %d, want %d", rec.Code, http.StatusOK)
+		}
+	}
+	var resp map[string]float64
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp["balance"] != 40 {
+		t.Errorf("balance after a repeated top-up = %v, %v; want 40", resp, err)
+	}
+
+	rec = serveAs(t, router, http.MethodPost, "/balance/1/withdraw", paymentService, map[string]float64{"amount": 50})
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("withdraw: status %d, want %d", rec.Code, http.StatusPaymentRequired)
+	}
+	if code := problemCode(t, rec); code != "insufficient_funds" {
+		t.Errorf("withdraw: code %q, want insufficient_funds", code)
+	}
+
+	rec = serveAs(t, router, http.MethodPost, "/balance/1/refund", paymentService, map[string]any{"amount": 10, "reference": "refund:order:1"})
+	if err := json.NewDecoder(rec.Body).Decode(&resp); rec.Code != http.StatusOK || err != nil || resp["balance"] != 50 {
+		t.Errorf("refund: status %d, balance %v; want %d and 50", rec.Code, resp, http.StatusOK)
+	}
+	rec = serveAs(t, router, http.MethodPost, "/balance/1/refund", paymentService, map[string]float64{"amount": 10})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("refund without a reference: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = serveAs(t, router, http.MethodPost, "/balance/1/add", paymentService, map[string]float64{"amount": -1})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("negative add: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = serve(t, router, http.MethodGet, "/balance/2", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown user: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestForgotPasswordHidesUnknownEmails(t *testing.T) {
+	router := newTestRouter(t)
+	serve(t, router, http.MethodPost, "/register", ann)
+
+	for _, email := range []string{ann["email"], "bob@example.com"} {
+		rec := serve(t, router, http.MethodPost, "/password/forgot", map[string]string{"email": email})
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("forgot %s: status %d, want %d", email, rec.Code, http.StatusAccepted)
+		}
+	}
+}
+
+func TestTokenLinksRejectInvalidTokens(t *testing.T) {
+	router := newTestRouter(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   any
+		code   string
+	}{
+		{"verify without token", http.MethodGet, "/email/verify", nil, "invalid_token"},
+		{"verify with unknown token", http.MethodGet, "/email/verify?token=forged", nil, "invalid_token"},
+		{"reset with unknown token", http.MethodPost, "/password/reset", map[string]string{"token": "forged", "password": "new-secret"}, "invalid_token"},
+		{"reset without password", http.MethodPost, "/password/reset", map[string]string{"token": "forged"}, "validation_failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, router, tt.method, tt.path, tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+			if code := problemCode(t, rec); code != tt.code {
+				t.Errorf("code %q, want %s", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestMe(t *testing.T) {
+	router := newTestRouter(t)
+	rec := serve(t, router, http.MethodPost, "/register", ann)
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	token := tokens["accessToken"]
+
+	if rec := serve(t, router, http.MethodGet, "/me", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("me without a token: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec = serveAs(t, router, http.MethodPatch, "/me", token, map[string]string{"lastName": "Jones", "email": "ann.jones@example.com"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("update me: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec = serveAs(t, router, http.MethodGet, "/me", token, nil)
+	var me map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&me); err != nil {
+		t.Fatalf("decode me: %v", err)
+	}
+	if me["firstName"] != "Ann" || me["lastName"] != "Jones" || me["email"] != "ann.jones@example.com" || me["emailVerifiedAt"] != nil {
+		t.Errorf("me = %v, want the new last name and an unverified new email", me)
+	}
+	if _, ok := me["password"]; ok {
+		t.Errorf("me = %v, want no password", me)
+	}
+
+	rec = serveAs(t, router, http.MethodPatch, "/me", token, map[string]string{"email": "not-an-email"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("update me with a bad email: status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = serveAs(t, router, http.MethodPost, "/me/password", token, map[string]string{"oldPassword": "wrong", "newPassword": "new-secret"})
+	if rec.Code != http.StatusForbidden || problemCode(t, rec) != "wrong_password" {
+		t.Errorf("change password with a wrong one: status %d, want %d wrong_password", rec.Code, http.StatusForbidden)
+	}
+	rec = serveAs(t, router, http.MethodPost, "/me/password", token, map[string]string{"oldPassword": ann["password"], "newPassword": "new-secret"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("change password: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	rec = serve(t, router, http.MethodPost, "/login", map[string]string{"email": "ann.jones@example.com", "password": "new-secret"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("login with the new password: status %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	rec = serveAs(t, router, http.MethodDelete, "/me", token, map[string]string{"password": "new-secret"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete me: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := serveAs(t, router, http.MethodGet, "/me", token, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("me after deletion: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestExportMe(t *testing.T) {
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/me/export" || r.Header.Get("Authorization") == "" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"orders": [{"id": 1, "amount": 25}]}`))
+	}))
+	t.Cleanup(orders.Close)
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	t.Cleanup(broken.Close)
+
+	export := service.DefaultExportConfig
+	export.OrderServiceURL = orders.URL
+	router := newExportTestRouter(t, export)
+	rec := serve(t, router, http.MethodPost, "/register", ann)
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	token := tokens["accessToken"]
+
+	if rec := serve(t, router, http.MethodGet, "/me/export", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("export without a token: status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	rec = serveAs(t, router, http.MethodGet, "/me/export", token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got struct {
+		Profile        map[string]any   `json:"profile"`
+		BalanceHistory []map[string]any `json:"balanceHistory"`
+		Orders         []map[string]any `json:"orders"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode export: %v", err)
+	}
+	if got.Profile["email"] != ann["email"] || got.BalanceHistory == nil || len(got.Orders) != 1 {
+		t.Errorf("export = %+v, want the profile, an empty balance history and the order", got)
+	}
+
+	export.PaymentServiceURL = broken.URL
+	router = newExportTestRouter(t, export)
+	rec = serve(t, router, http.MethodPost, "/register", ann)
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	rec = serveAs(t, router, http.MethodGet, "/me/export", tokens["accessToken"], nil)
+	if rec.Code != http.StatusServiceUnavailable || problemCode(t, rec) != "export_unavailable" {
+		t.Errorf("export with a failing service: status %d, want %d export_unavailable", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	router := newTestRouter(t)
+	serve(t, router, http.MethodPost, "/register", ann)
+
+	// Известный и неизвестный адрес блокируются одинаково
+	for _, email := range []string{ann["email"], "bob@example.com"} {
+		t.Run(email, func(t *testing.T) {
+			for i := range ratelimit.DefaultPolicy.Lockout.Threshold {
+				rec := serve(t, router, http.MethodPost, "/login", map[string]string{"email": email, "password": "wrong"})
+				if rec.Code != http.StatusUnauthorized {
+					t.Fatalf("failure %d: status %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+				}
+			}
+
+			rec := serve(t, router, http.MethodPost, "/login", map[string]string{"email": email, "password": ann["password"]})
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("login while locked: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+			}
+			if rec.Header().Get("Retry-After") != "60" {
+				t.Errorf("Retry-After = %q, want 60", rec.Header().Get("Retry-After"))
+			}
+			if code := problemCode(t, rec); code != "too_many_requests" {
+				t.Errorf("code %q, want too_many_requests", code)
+			}
+		})
+	}
+}
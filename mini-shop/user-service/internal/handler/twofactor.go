@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"mini-shop/platform/problem"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/metrics"
+	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/ratelimit"
+	"mini-shop/user-service/internal/utils"
+	"net/http"
+)
+
+func (h *Handler) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var payload model.TwoFactorLoginPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	u, err := h.twoFactor.ChallengeUser(ctx, payload.ChallengeToken)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	// Коды считаются неудачными входами той же учётной записи, что и пароли
+	lockKey := lockoutKey(u.Email)
+	locked, err := h.limiter.LockedFor(ctx, lockKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+	}
+	if locked > 0 {
+		metrics.RateLimited.WithLabelValues("lockout").Inc()
+		h.logLogin(ctx, audit.Entry{Actor: audit.Anonymous, Action: audit.LoginLocked, Target: audit.User(u.ID)})
+		ratelimit.WriteTooManyRequests(w, r, locked)
+		return
+	}
+
+	user, recoveryCodes, err := h.twoFactor.CompleteLogin(ctx, payload.ChallengeToken, payload.Code)
+	if errors.Is(err, model.ErrInvalidTwoFactorCode) {
+		locked, err := h.limiter.Fail(ctx, lockKey)
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+		}
+		h.logLogin(ctx, audit.Entry{Actor: audit.Anonymous, Action: audit.TwoFactorFailed, Target: audit.User(u.ID), After: map[string]any{"lockedFor": locked.String()}})
+		problem.WriteError(w, r, model.ErrInvalidTwoFactorCode)
+		return
+	}
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+	if err := h.limiter.Succeed(ctx, lockKey); err != nil {
+		slog.ErrorContext(ctx, "rate limiter unavailable", "scope", "lockout", "error", err)
+	}
+
+	h.writeToken(w, r, user, recoveryCodes)
+}
+
+// handleLoginTwoFactorSetup lets a user whose role requires two-factor
+// authentication set it up before the first login that needs it.
+func (h *Handler) handleLoginTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	var payload model.TwoFactorChallengePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	u, err := h.twoFactor.ChallengeUser(r.Context(), payload.ChallengeToken)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	setup, err := h.twoFactor.Setup(r.Context(), u.ID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, setup)
+}
+
+func (h *Handler) handleTwoFactorSetup(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	setup, err := h.twoFactor.Setup(r.Context(), userID)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, setup)
+}
+
+func (h *Handler) handleTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.TwoFactorCodePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	codes, err := h.twoFactor.Enable(r.Context(), userID, payload.Code)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
+
+func (h *Handler) handleTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.DisableTwoFactorPayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	if err := h.twoFactor.Disable(r.Context(), userID, payload.Password, payload.Code); err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]string{"message": "two-factor authentication disabled"})
+}
+
+func (h *Handler) handleRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID := r.Context().Value(auth.UserKey).(int)
+
+	var payload model.TwoFactorCodePayload
+	if err := utils.ParseJSON(r, &payload); err != nil {
+		problem.WriteError(w, r, model.InvalidInput("malformed JSON body: %v", err))
+		return
+	}
+
+	if err := utils.Validate.Struct(payload); err != nil {
+		problem.WriteValidationError(w, r, err)
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), userID, payload.Code)
+	if err != nil {
+		problem.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, map[string]any{"recoveryCodes": codes})
+}
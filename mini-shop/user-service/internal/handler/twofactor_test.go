@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"encoding/json"
+	"mini-shop/user-service/internal/auth"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoginTwoFactor(t *testing.T) {
+	router := newTestRouter(t)
+	rec := serve(t, router, http.MethodPost, "/register", ann)
+	var tokens map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	token := tokens["accessToken"]
+
+	rec = serveAs(t, router, http.MethodPost, "/me/2fa/setup", token, nil)
+	var setup struct{ Secret, ProvisioningURI string }
+	if err := json.NewDecoder(rec.Body).Decode(&setup); err != nil || rec.Code != http.StatusOK || setup.Secret == "" {
+		t.Fatalf("setup: status %d, %+v, %v; want a secret", rec.Code, setup, err)
+	}
+	code, err := auth.TOTPCode(setup.Secret, time.Now())
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	rec = serveAs(t, router, http.MethodPost, "/me/2fa/enable", token, map[string]string{"code": code})
+	var enabled struct{ RecoveryCodes []string }
+	if err := json.NewDecoder(rec.Body).Decode(&enabled); err != nil || rec.Code != http.StatusOK || len(enabled.RecoveryCodes) == 0 {
+		t.Fatalf("enable: status %d, %+v, %v; want recovery codes", rec.Code, enabled, err)
+	}
+
+	rec = serve(t, router, http.MethodPost, "/login", map[string]string{"email": ann["email"], "password": ann["password"]})
+	var challenge struct {
+		Token             string
+		TwoFactorRequired bool
+		ChallengeToken    string
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&challenge); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d, %v", rec.Code, err)
+	}
+	if challenge.Token != "" || !challenge.TwoFactorRequired || challenge.ChallengeToken == "" {
+		t.Fatalf("login response = %+v, want a challenge instead of a token", challenge)
+	}
+
+	rec = serve(t, router, http.MethodPost, "/login/2fa", map[string]string{"challengeToken": challenge.ChallengeToken, "code": "000000"})
+	if rec.Code != http.StatusUnauthorized || problemCode(t, rec) != "invalid_two_factor_code" {
+		t.Fatalf("login with a wrong code: status %d, want %d invalid_two_factor_code", rec.Code, http.StatusUnauthorized)
+	}
+	rec = serve(t, router, http.MethodPost, "/login/2fa", map[string]string{"challengeToken": challenge.ChallengeToken, "code": enabled.RecoveryCodes[0]})
+	if err := json.NewDecoder(rec.Body).Decode(&tokens); err != nil || rec.Code != http.StatusOK || tokens["token"] == "" {
+		t.Fatalf("login with a recovery code: status %d, %v, %v; want a token", rec.Code, tokens, err)
+	}
+
+	rec = serve(t, router, http.MethodPost, "/login/2fa", map[string]string{"challengeToken": challenge.ChallengeToken, "code": enabled.RecoveryCodes[1]})
+	if rec.Code != http.StatusBadRequest || problemCode(t, rec) != "invalid_token" {
+		t.Errorf("reusing the challenge: status %d, want %d invalid_token", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	platform "mini-shop/platform/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Common are the HTTP and broker metrics of user-service. The metrics below
+// share its "service" label.
+var Common = platform.New("user-service")
+
+var (
+	BalanceWithdrawn = promauto.With(Common.Registerer).NewCounter(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "balance_withdrawn_total",
+		Help:      "Total amount withdrawn from user balances.",
+	})
+
+	RateLimited = promauto.With(Common.Registerer).NewCounterVec(prometheus.CounterOpts{
+		Namespace: platform.Namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by rate limits and lockouts, by scope.",
+	}, []string{"scope"})
+)
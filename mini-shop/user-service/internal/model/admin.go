@@ -0,0 +1,26 @@
+package model
+
+// UserDetails is what an admin sees about one user.
+type UserDetails struct {
+	User   *User         `json:"user"`
+	Ledger []LedgerEntry `json:"ledger"`
+}
+
+// SetRolesPayload replaces every role of the user; an empty list makes the
+// user an ordinary customer.
+type SetRolesPayload struct {
+	Roles []string `json:"roles"`
+}
+
+// AdjustBalancePayload is a manual balance change. A negative amount takes
+// money away.
+type AdjustBalancePayload struct {
+	Amount float64 `json:"amount" validate:"required"`
+	Reason string  `json:"reason" validate:"required,max=500"`
+}
+
+// TwoFactorPolicy lists the roles that cannot log in without a second factor.
+// An empty list makes two-factor authentication optional for everyone.
+type TwoFactorPolicy struct {
+	RequiredRoles []string `json:"requiredRoles"`
+}
@@ -0,0 +1,80 @@
+package model
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mini-shop/platform/pagination"
+	"time"
+)
+
+// AuditStore keeps the audit log. Records are never changed once appended.
+type AuditStore interface {
+	// AppendAudit links record to the last record of the log and stores it,
+	// setting ID, PrevHash and Hash. A record whose EventID is already in the
+	// log is skipped and its ID stays zero. Inside a transaction the record may
+	// be stored only when the transaction commits; its fields are set then.
+	AppendAudit(ctx context.Context, record *AuditRecord) error
+	ListAudit(ctx context.Context, filter AuditFilter, params pagination.Params) ([]AuditRecord, error)
+	// AuditChain returns up to limit records after afterID in the order they
+	// were appended.
+	AuditChain(ctx context.Context, afterID, limit int) ([]AuditRecord, error)
+}
+
+// AuditRecord is one action in the audit log. Before and After hold the state
+// of the target around the action. Hash covers every field and the hash of the
+// previous record, so changing, removing or inserting a record breaks the chain
+// from that point on.
+type AuditRecord struct {
+	ID         int             `json:"id"`
+	OccurredAt time.Time       `json:"occurredAt"`
+	Actor      string          `json:"actor"`
+	Action     string          `json:"action"`
+	Target     string          `json:"target,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"requestID,omitempty"`
+	// EventID is set for records made from broker messages, so a redelivered
+	// message is logged once.
+	EventID  string `json:"eventID,omitempty"`
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+// ComputeHash returns the hash the record should have given its PrevHash. The
+// time is hashed at the microsecond precision Postgres keeps.
+func (r AuditRecord) ComputeHash() string {
+	data, _ := json.Marshal([]any{
+		r.PrevHash,
+		r.OccurredAt.UTC().Truncate(time.Microsecond).Format(time.RFC3339Nano),
+		r.Actor,
+		r.Action,
+		r.Target,
+		r.Before,
+		r.After,
+		r.IP,
+		r.RequestID,
+		r.EventID,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditSorts lists the fields the audit log can be sorted by.
+var AuditSorts = []string{"occurredAt"}
+
+// AuditFilter narrows the audit log down; empty fields match everything.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	From   *time.Time
+	To     *time.Time
+}
+
+// AuditCursor builds the keyset cursor pointing at record.
+func AuditCursor(record AuditRecord, sort string) pagination.Cursor {
+	return pagination.Cursor{Value: record.OccurredAt.Format(time.RFC3339Nano), ID: record.ID}
+}
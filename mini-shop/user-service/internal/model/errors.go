@@ -0,0 +1,53 @@
+package model
+
+import "mini-shop/platform/problem"
+
+// Domain errors are the shared problem errors, so every service reports them
+// the same way.
+type (
+	ErrorKind  = problem.ErrorKind
+	Error      = problem.Error
+	FieldError = problem.FieldError
+)
+
+const (
+	KindInternal          = problem.KindInternal
+	KindInvalid           = problem.KindInvalid
+	KindUnauthorized      = problem.KindUnauthorized
+	KindForbidden         = problem.KindForbidden
+	KindNotFound          = problem.KindNotFound
+	KindConflict          = problem.KindConflict
+	KindInsufficientFunds = problem.KindInsufficientFunds
+	KindTooManyRequests   = problem.KindTooManyRequests
+	KindUnavailable       = problem.KindUnavailable
+)
+
+var (
+	ErrUserNotFound       = &Error{Kind: KindNotFound, Code: "user_not_found", Message: "user not found"}
+	ErrUserExists         = &Error{Kind: KindConflict, Code: "user_exists", Message: "user already exists"}
+	ErrInvalidCredentials = &Error{Kind: KindUnauthorized, Code: "invalid_credentials", Message: "invalid email or password"}
+	ErrInsufficientFunds  = &Error{Kind: KindInsufficientFunds, Code: "insufficient_funds", Message: "insufficient funds"}
+	ErrPermissionDenied   = &Error{Kind: KindForbidden, Code: "permission_denied", Message: "permission denied"}
+	ErrInvalidToken       = &Error{Kind: KindInvalid, Code: "invalid_token", Message: "token is invalid or has expired"}
+	ErrEmailVerified      = &Error{Kind: KindConflict, Code: "email_already_verified", Message: "email is already verified"}
+	ErrWrongPassword      = &Error{Kind: KindForbidden, Code: "wrong_password", Message: "current password is incorrect"}
+	ErrTooManyRequests    = &Error{Kind: KindTooManyRequests, Code: "too_many_requests", Message: "too many attempts, try again later"}
+	ErrAccountBlocked     = &Error{Kind: KindForbidden, Code: "account_blocked", Message: "account is blocked"}
+	ErrOwnAccount         = &Error{Kind: KindConflict, Code: "own_account", Message: "admins cannot block themselves or drop their own admin role"}
+	ErrExportUnavailable  = &Error{Kind: KindUnavailable, Code: "export_unavailable", Message: "some of your data cannot be collected right now, try again later"}
+	ErrReferenceUsed      = &Error{Kind: KindConflict, Code: "reference_used", Message: "reference was already used for another balance change"}
+
+	ErrInvalidTwoFactorCode = &Error{Kind: KindUnauthorized, Code: "invalid_two_factor_code", Message: "two-factor code is invalid"}
+	ErrTwoFactorEnabled     = &Error{Kind: KindConflict, Code: "two_factor_already_enabled", Message: "two-factor authentication is already enabled"}
+	ErrTwoFactorNotEnabled  = &Error{Kind: KindConflict, Code: "two_factor_not_enabled", Message: "two-factor authentication is not enabled"}
+	ErrTwoFactorNotSetUp    = &Error{Kind: KindConflict, Code: "two_factor_not_set_up", Message: "start two-factor setup first"}
+	ErrTwoFactorRequired    = &Error{Kind: KindForbidden, Code: "two_factor_required", Message: "two-factor authentication is required for this account"}
+)
+
+func InvalidInput(format string, args ...any) error {
+	return problem.InvalidInput(format, args...)
+}
+
+func ValidationFailed(fields []FieldError) error {
+	return problem.ValidationFailed(fields)
+}
@@ -0,0 +1,17 @@
+package model
+
+// UpdateProfilePayload changes only the fields that are present.
+type UpdateProfilePayload struct {
+	FirstName *string `json:"firstName" validate:"omitempty,min=1"`
+	LastName  *string `json:"lastName" validate:"omitempty,min=1"`
+	Email     *string `json:"email" validate:"omitempty,email"`
+}
+
+type ChangePasswordPayload struct {
+	OldPassword string `json:"oldPassword" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+type DeleteAccountPayload struct {
+	Password string `json:"password" validate:"required"`
+}
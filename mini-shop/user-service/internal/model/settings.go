@@ -0,0 +1,15 @@
+package model
+
+import "context"
+
+// SettingsStore keeps the settings admins change while the service runs.
+// Values are JSON.
+type SettingsStore interface {
+	// GetSetting returns nil, nil when key has never been set.
+	GetSetting(ctx context.Context, key string) ([]byte, error)
+	PutSetting(ctx context.Context, key string, value []byte) error
+}
+
+// SettingTwoFactorRoles holds the roles that cannot log in without a second
+// factor.
+const SettingTwoFactorRoles = "two_factor_required_roles"
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Назначения одноразовых токенов. Кроме ссылок из писем это вызов второго
+// шага входа и коды восстановления двухфакторной аутентификации
+const (
+	TokenVerifyEmail        = "verify_email"
+	TokenResetPassword      = "reset_password"
+	TokenTwoFactorChallenge = "two_factor_challenge"
+	TokenRecoveryCode       = "recovery_code"
+)
+
+// UserToken is a single-use, expiring token given to a user. Only a keyed hash
+// of the token is stored, so the table alone is not enough to forge a link.
+type UserToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	Hash      string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Valid reports whether the token can still be used at t.
+func (t UserToken) Valid(at time.Time) bool {
+	return t.UsedAt == nil && at.Before(t.ExpiresAt)
+}
@@ -0,0 +1,35 @@
+package model
+
+// Роли пользователей. Без ролей аккаунт — обычный покупатель
+const (
+	RoleAdmin = "admin"
+)
+
+// Roles lists the roles an admin can grant.
+var Roles = []string{RoleAdmin}
+
+// TwoFactorSetup is what an authenticator app needs to generate codes.
+type TwoFactorSetup struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningURI"`
+}
+
+// TwoFactorCodePayload carries a code from the authenticator app or a
+// recovery code.
+type TwoFactorCodePayload struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorLoginPayload struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code           string `json:"code" validate:"required"`
+}
+
+type TwoFactorChallengePayload struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+}
+
+type DisableTwoFactorPayload struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required"`
+}
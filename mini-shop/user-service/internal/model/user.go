@@ -1,25 +1,152 @@
 package model
 
 import (
+	"context"
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
+	"slices"
+	"strconv"
 	"time"
 )
 
 type UserStore interface {
-	GetUserByEmail(email string) (*User, error)
-	GetUserByID(id int) (*User, error)
-	GetUsers() ([]User, error)
-	CreateUser(User) error
-	DeleteUser(id int) error
-	DeleteAllUsers() error
-	UpdateUser(user *User) error
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	LockUserByID(ctx context.Context, id int) (*User, error)
+	GetUsers(ctx context.Context, filter UserFilter, params pagination.Params) ([]User, error)
+	CreateUser(ctx context.Context, user User) error
+	// DeleteUser marks the user deleted. Deleted users are not found by the
+	// other methods until EraseUser removes their personal data for good.
+	DeleteUser(ctx context.Context, id int) error
+	DeleteAllUsers(ctx context.Context) error
+	// DeletedUsers returns the IDs of users deleted before the given time and
+	// not erased yet, oldest first.
+	DeletedUsers(ctx context.Context, before time.Time, limit int) ([]int, error)
+	// EraseUser anonymizes a deleted user and drops its tokens and sent events.
+	// The balance ledger stays. It returns false if the user is not deleted or
+	// already erased.
+	EraseUser(ctx context.Context, id int) (bool, error)
+	UpdateUser(ctx context.Context, user *User) error
+	AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error
+	// ListLedger returns the balance history of userID, oldest first.
+	ListLedger(ctx context.Context, userID int) ([]LedgerEntry, error)
+	// LedgerEntryByReference returns nil, nil when no entry has reference.
+	LedgerEntryByReference(ctx context.Context, reference string) (*LedgerEntry, error)
+	AddOutboxEvent(ctx context.Context, event OutboxEvent) error
+	// PendingOutboxEvents returns unsent events in insertion order.
+	PendingOutboxEvents(ctx context.Context, limit int) ([]OutboxEvent, error)
+	MarkOutboxEventSent(ctx context.Context, id int) error
+	CreateToken(ctx context.Context, token *UserToken) error
+	// GetToken returns nil, nil when no token of purpose has the hash.
+	GetToken(ctx context.Context, purpose, hash string) (*UserToken, error)
+	// UseToken marks the token used and fails with ErrInvalidToken if it
+	// already was, so a token is accepted at most once.
+	UseToken(ctx context.Context, id int) error
+	// RevokeTokens marks every unused token of purpose issued to userID used.
+	RevokeTokens(ctx context.Context, userID int, purpose string) error
+	AuditStore
+	SettingsStore
+	// InTx runs fn inside a single database transaction.
+	InTx(ctx context.Context, fn func(tx UserStore) error) error
 }
 
 type User struct {
-	ID        int       `json:"id"`
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	Balance   float64   `json:"balance"`
+	ID        int    `json:"id"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"-"`
+	// PasswordAlgorithm names how Password is hashed, see PasswordBcrypt and
+	// PasswordArgon2id.
+	PasswordAlgorithm string    `json:"-"`
+	Balance           float64   `json:"balance"`
+	CreatedAt         time.Time `json:"createdAt"`
+	// EmailVerifiedAt is nil until the user follows the verification link.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	Roles           []string   `json:"roles,omitempty"`
+	// TOTPSecret is set by two-factor setup and used once TwoFactorEnabledAt
+	// is set, after the user has entered a code from it. TOTPLastStep is the
+	// time step of the last accepted code, which cannot be used again.
+	TOTPSecret         string     `json:"-"`
+	TwoFactorEnabledAt *time.Time `json:"twoFactorEnabledAt"`
+	TOTPLastStep       int64      `json:"-"`
+	// DeletedAt is set when the account is deleted and ErasedAt once its
+	// personal data is gone, after the retention period.
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	ErasedAt  *time.Time `json:"erasedAt,omitempty"`
+	// BlockedAt is set while an admin has blocked the account.
+	BlockedAt *time.Time `json:"blockedAt,omitempty"`
+}
+
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+func (u User) TwoFactorEnabled() bool {
+	return u.TwoFactorEnabledAt != nil
+}
+
+func (u User) Blocked() bool {
+	return u.BlockedAt != nil
+}
+
+func (u User) HasRole(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// Алгоритмы хеширования паролей. Аккаунты, заведённые до argon2id, хранят
+// bcrypt и переводятся на argon2id при следующем входе.
+const (
+	PasswordBcrypt   = "bcrypt"
+	PasswordArgon2id = "argon2id"
+)
+
+// Причины движения средств в журнале баланса
+const (
+	LedgerTopUp      = "top_up"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+	// LedgerRefund returns the money of a cancelled or voided payment.
+	LedgerRefund = "refund"
+)
+
+// LedgerEntry records a single balance change together with the resulting
+// balance.
+type LedgerEntry struct {
+	ID      int     `json:"id"`
+	UserID  int     `json:"userID"`
+	Amount  float64 `json:"amount"`
+	Balance float64 `json:"balance"`
+	Reason  string  `json:"reason"`
+	// Comment and ActorID are set on manual adjustments: why the admin with
+	// that ID changed the balance.
+	Comment string `json:"comment,omitempty"`
+	ActorID *int   `json:"actorID,omitempty"`
+	// Reference identifies the payment of another service the entry comes
+	// from, such as "topup:12"; an entry per reference at most.
+	Reference string    `json:"reference,omitempty"`
 	CreatedAt time.Time `json:"createdAt"`
 }
+
+// OutboxEvent is an event waiting in the outbox table to be published.
+type OutboxEvent = outbox.Event
+
+// UserSorts lists the fields the user list can be sorted by.
+var UserSorts = []string{"createdAt", "balance"}
+
+type UserFilter struct {
+	// Query matches the start of the email, first or last name, ignoring case.
+	Query       string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	MinBalance  *float64
+	MaxBalance  *float64
+}
+
+// UserCursor builds the keyset cursor pointing at user for the given sort.
+func UserCursor(user User, sort string) pagination.Cursor {
+	if sort == "balance" {
+		return pagination.Cursor{Value: strconv.FormatFloat(user.Balance, 'f', -1, 64), ID: user.ID}
+	}
+	return pagination.Cursor{Value: user.CreatedAt.Format(time.RFC3339Nano), ID: user.ID}
+}
@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps limits in the process. Each service instance then limits
+// on its own, which is enough for a single instance and for tests.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: map[string]State{}}
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(state *State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.state[key]
+	fn(&state)
+	s.state[key] = state
+	return nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, state := range s.state {
+		if state.UpdatedAt.Before(before) {
+			delete(s.state, key)
+		}
+	}
+	return nil
+}
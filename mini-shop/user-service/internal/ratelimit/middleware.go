@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"math"
+	"mini-shop/platform/problem"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/metrics"
+	"mini-shop/user-service/internal/model"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PerIP limits requests to next from each client address to rate. scope
+// separates the buckets of different endpoints.
+func (l *Limiter) PerIP(scope string, rate Rate, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ClientIP(r)
+		if l.Throttled(w, r, scope, "ip:"+scope+":"+ip, rate, "ip", ip) {
+			return
+		}
+		next(w, r)
+	}
+}
+
+// Throttled takes a token for key and, when there is none, writes the 429
+// response and reports true. A failing store lets the request through:
+// an outage of the limiter must not take logins down with it.
+func (l *Limiter) Throttled(w http.ResponseWriter, r *http.Request, scope, key string, rate Rate, attrs ...any) bool {
+	wait, err := l.Allow(r.Context(), key, rate)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "rate limiter unavailable", "scope", scope, "error", err)
+		return false
+	}
+	if wait == 0 {
+		return false
+	}
+
+	metrics.RateLimited.WithLabelValues(scope).Inc()
+	audit.Record(r.Context(), audit.RateLimited, append([]any{"scope", scope}, attrs...)...)
+	WriteTooManyRequests(w, r, wait)
+	return true
+}
+
+// WriteTooManyRequests answers 429 with Retry-After. Rate limits and lockouts
+// share the response, so it does not tell which one applied.
+func WriteTooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	problem.WriteError(w, r, model.ErrTooManyRequests)
+}
+
+// ClientIP returns the address of the peer. X-Forwarded-For is ignored: it is
+// set by the client unless a trusted proxy overwrites it, and none is deployed
+// in front of the service.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// PostgresStore keeps limits in the rate_limits table, so every instance of
+// the service shares them.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Update(ctx context.Context, key string, fn func(state *State)) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Строка нужна заранее, чтобы конкурентные запросы ждали на FOR UPDATE
+	_, err = tx.ExecContext(ctx, `INSERT INTO rate_limits (key) VALUES ($1) ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return err
+	}
+
+	var (
+		state                  State
+		lockedUntil, updatedAt sql.NullTime
+	)
+	err = tx.QueryRowContext(ctx,
+		`SELECT tokens, failures, lockedUntil, updatedAt FROM rate_limits WHERE key = $1 FOR UPDATE`, key,
+	).Scan(&state.Tokens, &state.Failures, &lockedUntil, &updatedAt)
+	if err != nil {
+		return err
+	}
+	state.LockedUntil = lockedUntil.Time
+	state.UpdatedAt = updatedAt.Time
+
+	fn(&state)
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE rate_limits SET tokens = $1, failures = $2, lockedUntil = $3, updatedAt = $4 WHERE key = $5`,
+		state.Tokens, state.Failures, nullTime(state.LockedUntil), nullTime(state.UpdatedAt), key,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updatedAt < $1 OR updatedAt IS NULL`, before)
+	return err
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,168 @@
+// Package ratelimit throttles requests with token buckets and locks out keys,
+// such as an account, after repeated failures.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Rate allows Burst requests at once, refilled at Burst requests per Per.
+type Rate struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (r Rate) perSecond() float64 {
+	return float64(r.Burst) / r.Per.Seconds()
+}
+
+// Lockout blocks a key for BaseDelay after Threshold failures in a row and
+// doubles the block with every further failure, up to MaxDelay. Failures are
+// forgotten after Reset without one.
+type Lockout struct {
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Reset     time.Duration
+}
+
+func (l Lockout) delay(failures int) time.Duration {
+	if failures < l.Threshold {
+		return 0
+	}
+	delay := float64(l.BaseDelay) * math.Pow(2, float64(failures-l.Threshold))
+	return time.Duration(min(delay, float64(l.MaxDelay)))
+}
+
+// Policy holds the limits of the user-service endpoints.
+type Policy struct {
+	LoginPerIP      Rate
+	LoginPerAccount Rate
+	RegisterPerIP   Rate
+	// PasswordPerIP covers the forgot and reset password endpoints.
+	PasswordPerIP Rate
+	Lockout       Lockout
+}
+
+var DefaultPolicy = Policy{
+	LoginPerIP:      Rate{Burst: 20, Per: time.Minute},
+	LoginPerAccount: Rate{Burst: 10, Per: 15 * time.Minute},
+	RegisterPerIP:   Rate{Burst: 20, Per: time.Hour},
+	PasswordPerIP:   Rate{Burst: 10, Per: 15 * time.Minute},
+	Lockout:         Lockout{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Reset: 24 * time.Hour},
+}
+
+// State is what a Store keeps per key. Buckets use Tokens, lockouts use
+// Failures and LockedUntil; UpdatedAt is zero for a key seen for the first
+// time.
+type State struct {
+	Tokens      float64
+	Failures    int
+	LockedUntil time.Time
+	UpdatedAt   time.Time
+}
+
+// Store keeps State per key and may be shared by several service instances.
+type Store interface {
+	// Update loads the state of key, lets fn change it and saves it, with no
+	// other Update of key in between.
+	Update(ctx context.Context, key string, fn func(state *State)) error
+	// Prune forgets keys not updated since before.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type Limiter struct {
+	store  Store
+	Policy Policy
+	now    func() time.Time
+}
+
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, Policy: policy, now: time.Now}
+}
+
+// Allow takes a token from the bucket of key. It returns zero when the request
+// may proceed and otherwise how long until a token is available.
+func (l *Limiter) Allow(ctx context.Context, key string, rate Rate) (time.Duration, error) {
+	var wait time.Duration
+	err := l.store.Update(ctx, key, func(state *State) {
+		now := l.now()
+		if state.UpdatedAt.IsZero() {
+			state.Tokens = float64(rate.Burst)
+		} else {
+			refill := now.Sub(state.UpdatedAt).Seconds() * rate.perSecond()
+			state.Tokens = min(float64(rate.Burst), state.Tokens+refill)
+		}
+		state.UpdatedAt = now
+
+		if state.Tokens >= 1 {
+			state.Tokens--
+			return
+		}
+		wait = time.Duration((1 - state.Tokens) / rate.perSecond() * float64(time.Second))
+	})
+	return wait, err
+}
+
+// LockedFor returns how long key stays locked out, or zero.
+func (l *Limiter) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	var locked time.Duration
+	err := l.store.Update(ctx, key, func(state *State) {
+		locked = max(state.LockedUntil.Sub(l.now()), 0)
+	})
+	return locked, err
+}
+
+// Fail records a failure of key and returns how long key is locked out as a
+// result, or zero.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	var locked time.Duration
+	err := l.store.Update(ctx, key, func(state *State) {
+		now := l.now()
+		if now.Sub(state.UpdatedAt) > l.Policy.Lockout.Reset {
+			state.Failures = 0
+		}
+		state.Failures++
+		state.UpdatedAt = now
+
+		locked = l.Policy.Lockout.delay(state.Failures)
+		if locked > 0 {
+			state.LockedUntil = now.Add(locked)
+		}
+	})
+	return locked, err
+}
+
+// Succeed forgets the failures of key.
+func (l *Limiter) Succeed(ctx context.Context, key string) error {
+	return l.store.Update(ctx, key, func(state *State) {
+		*state = State{UpdatedAt: l.now()}
+	})
+}
+
+// Run prunes keys that no longer limit anything until ctx is cancelled.
+func (l *Limiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.store.Prune(ctx, l.now().Add(-l.retention())); err != nil {
+				slog.ErrorContext(ctx, "failed to prune rate limits", "error", err)
+			}
+		}
+	}
+}
+
+// retention is how long a key may matter: until its bucket refills or its
+// failures are forgotten.
+func (l *Limiter) retention() time.Duration {
+	p := l.Policy
+	return max(p.LoginPerIP.Per, p.LoginPerAccount.Per, p.RegisterPerIP.Per, p.PasswordPerIP.Per, p.Lockout.Reset, p.Lockout.MaxDelay)
+}
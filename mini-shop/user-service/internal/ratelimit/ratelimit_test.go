@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter over store whose clock moves only when the
+// returned function is called.
+func newTestLimiter(store Store, policy Policy) (*Limiter, func(time.Duration)) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	l := NewLimiter(store, policy)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimiterAllow(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter(NewMemoryStore(), DefaultPolicy)
+	rate := Rate{Burst: 3, Per: time.Minute}
+
+	for i := range 3 {
+		if wait, err := l.Allow(ctx, "ip:1", rate); err != nil || wait != 0 {
+			t.Fatalf("request %d: Allow = %v, %v; want it allowed", i+1, wait, err)
+		}
+	}
+	if wait, _ := l.Allow(ctx, "ip:1", rate); wait != 20*time.Second {
+		t.Errorf("request over the burst: wait %v, want 20s for the next token", wait)
+	}
+	if wait, _ := l.Allow(ctx, "ip:2", rate); wait != 0 {
+		t.Errorf("another key: wait %v, want it allowed", wait)
+	}
+
+	advance(20 * time.Second)
+	if wait, _ := l.Allow(ctx, "ip:1", rate); wait != 0 {
+		t.Errorf("after a refill: wait %v, want it allowed", wait)
+	}
+	if wait, _ := l.Allow(ctx, "ip:1", rate); wait == 0 {
+		t.Error("second request after a single refill allowed, want it limited")
+	}
+}
+
+func TestLimiterLockout(t *testing.T) {
+	ctx := context.Background()
+	policy := DefaultPolicy
+	policy.Lockout = Lockout{Threshold: 3, BaseDelay: time.Minute, MaxDelay: 3 * time.Minute, Reset: time.Hour}
+	l, advance := newTestLimiter(NewMemoryStore(), policy)
+
+	fail := func() time.Duration {
+		t.Helper()
+		locked, err := l.Fail(ctx, "lockout:ann")
+		if err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+		return locked
+	}
+	lockedFor := func() time.Duration {
+		t.Helper()
+		locked, err := l.LockedFor(ctx, "lockout:ann")
+		if err != nil {
+			t.Fatalf("LockedFor: %v", err)
+		}
+		return locked
+	}
+
+	if fail() != 0 || fail() != 0 || lockedFor() != 0 {
+		t.Fatal("key locked before the threshold")
+	}
+	// Каждая следующая неудача удваивает блокировку, но не дольше MaxDelay
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		if got := fail(); got != want {
+			t.Fatalf("Fail locked the key for %v, want %v", got, want)
+		}
+		if got := lockedFor(); got != want {
+			t.Errorf("LockedFor = %v, want %v", got, want)
+		}
+		advance(want)
+	}
+	if got := lockedFor(); got != 0 {
+		t.Errorf("LockedFor after the lock ran out = %v, want 0", got)
+	}
+
+	advance(time.Hour + time.Second)
+	if got := fail(); got != 0 {
+		t.Errorf("first failure after the reset window locked the key for %v", got)
+	}
+
+	fail()
+	if err := l.Succeed(ctx, "lockout:ann"); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+	if got := fail(); got != 0 {
+		t.Errorf("failure after a success locked the key for %v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"mini-shop/user-service/internal/database/dbtest"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T) Store { return NewMemoryStore() })
+}
+
+func TestPostgresStore(t *testing.T) {
+	db := dbtest.Open(t)
+
+	testStore(t, func(t *testing.T) Store {
+		if _, err := db.Exec("TRUNCATE rate_limits"); err != nil {
+			t.Fatalf("truncate rate_limits: %v", err)
+		}
+		return NewPostgresStore(db)
+	})
+}
+
+// testStore is the contract every Store has to satisfy. newStore must return
+// an empty store.
+func testStore(t *testing.T, newStore func(t *testing.T) Store) {
+	ctx := context.Background()
+	at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Update starts new keys from the zero state and saves changes", func(t *testing.T) {
+		store := newStore(t)
+
+		err := store.Update(ctx, "key", func(state *State) {
+			if *state != (State{}) {
+				t.Errorf("new key state = %+v, want the zero state", *state)
+			}
+			*state = State{Tokens: 2.5, Failures: 3, LockedUntil: at.Add(time.Minute), UpdatedAt: at}
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+
+		var got State
+		if err := store.Update(ctx, "key", func(state *State) { got = *state }); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if got.Tokens != 2.5 || got.Failures != 3 || !got.LockedUntil.Equal(at.Add(time.Minute)) || !got.UpdatedAt.Equal(at) {
+			t.Errorf("saved state = %+v, want the state set by the first update", got)
+		}
+	})
+
+	t.Run("Prune forgets keys not updated since the cutoff", func(t *testing.T) {
+		store := newStore(t)
+
+		for key, updated := range map[string]time.Time{"old": at.Add(-time.Hour), "new": at} {
+			if err := store.Update(ctx, key, func(state *State) { state.Tokens, state.UpdatedAt = 1, updated }); err != nil {
+				t.Fatalf("Update(%s): %v", key, err)
+			}
+		}
+		if err := store.Prune(ctx, at.Add(-time.Minute)); err != nil {
+			t.Fatalf("Prune: %v", err)
+		}
+
+		for key, want := range map[string]float64{"old": 0, "new": 1} {
+			var got float64
+			if err := store.Update(ctx, key, func(state *State) { got = state.Tokens }); err != nil {
+				t.Fatalf("Update(%s): %v", key, err)
+			}
+			if got != want {
+				t.Errorf("%s tokens after Prune = %v, want %v", key, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"mini-shop/platform/pagination"
+	"mini-shop/user-service/internal/model"
+	"time"
+)
+
+// auditLockKey is the advisory lock that serializes appends, so every record
+// links to the one appended right before it. It is taken at the end of a
+// transaction and released by its commit.
+const auditLockKey = 0x61756469
+
+const auditColumns = "id, occurredAt, actor, action, target, beforeState, afterState, ip, requestID, eventID, prevHash, hash"
+
+var auditSortColumns = map[string]pagination.Column{
+	"occurredAt": {Name: "occurredAt", Cast: "timestamptz"},
+}
+
+// AppendAudit queues record until the transaction of s is about to commit;
+// outside a transaction it starts one of its own.
+func (s *Store) AppendAudit(ctx context.Context, record *model.AuditRecord) error {
+	if s.audit == nil {
+		return s.InTx(ctx, func(tx model.UserStore) error {
+			return tx.AppendAudit(ctx, record)
+		})
+	}
+
+	*s.audit = append(*s.audit, record)
+	return nil
+}
+
+// flushAudit appends the queued records under the advisory lock. Everything
+// else the transaction does has already run, so concurrent transactions wait
+// for each other only for the read of the chain head, these inserts and the
+// commit.
+func (s *Store) flushAudit(ctx context.Context) error {
+	if len(*s.audit) == 0 {
+		return nil
+	}
+
+	if _, err := s.q.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditLockKey); err != nil {
+		return err
+	}
+
+	var prevHash string
+	err := s.q.QueryRowContext(ctx, "SELECT hash FROM audit_log ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	for _, record := range *s.audit {
+		appended, err := s.insertAudit(ctx, record, prevHash)
+		if err != nil {
+			return err
+		}
+		if appended {
+			prevHash = record.Hash
+		}
+	}
+	*s.audit = nil
+	return nil
+}
+
+// insertAudit links record to prevHash and stores it. It reports false for a
+// record whose event is already in the log.
+func (s *Store) insertAudit(ctx context.Context, record *model.AuditRecord, prevHash string) (bool, error) {
+	if record.EventID != "" {
+		var exists bool
+		err := s.q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM audit_log WHERE eventID = $1)", record.EventID).Scan(&exists)
+		if err != nil || exists {
+			return false, err
+		}
+	}
+
+	record.OccurredAt = record.OccurredAt.Truncate(time.Microsecond)
+	record.PrevHash = prevHash
+	record.Hash = record.ComputeHash()
+
+	var eventID any
+	if record.EventID != "" {
+		eventID = record.EventID
+	}
+	err := s.q.QueryRowContext(ctx,
+		`INSERT INTO audit_log (occurredAt, actor, action, target, beforeState, afterState, ip, requestID, eventID, prevHash, hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		record.OccurredAt,
+		record.Actor,
+		record.Action,
+		record.Target,
+		nullJSON(record.Before),
+		nullJSON(record.After),
+		record.IP,
+		record.RequestID,
+		eventID,
+		record.PrevHash,
+		record.Hash,
+	).Scan(&record.ID)
+	return err == nil, err
+}
+
+func (s *Store) ListAudit(ctx context.Context, filter model.AuditFilter, params pagination.Params) ([]model.AuditRecord, error) {
+	q := pagination.NewQuery()
+	if filter.Actor != "" {
+		q.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		q.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		q.Where("target = ?", filter.Target)
+	}
+	if filter.From != nil {
+		q.Where("occurredAt >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		q.Where("occurredAt < ?", *filter.To)
+	}
+	query := q.Build("SELECT "+auditColumns+" FROM audit_log", auditSortColumns[params.Sort], params)
+
+	return s.queryAudit(ctx, query, q.Args()...)
+}
+
+func (s *Store) AuditChain(ctx context.Context, afterID, limit int) ([]model.AuditRecord, error) {
+	return s.queryAudit(ctx, "SELECT "+auditColumns+" FROM audit_log WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+}
+
+func (s *Store) queryAudit(ctx context.Context, query string, args ...any) ([]model.AuditRecord, error) {
+	rows, err := s.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []model.AuditRecord{}
+	for rows.Next() {
+		var (
+			record        model.AuditRecord
+			before, after []byte
+			eventID       sql.NullString
+		)
+		err := rows.Scan(&record.ID, &record.OccurredAt, &record.Actor, &record.Action, &record.Target, &before, &after,
+			&record.IP, &record.RequestID, &eventID, &record.PrevHash, &record.Hash)
+		if err != nil {
+			return nil, err
+		}
+		record.Before, record.After, record.EventID = before, after, eventID.String
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// nullJSON stores a missing state as SQL NULL.
+func nullJSON(raw json.RawMessage) any {
+	if raw == nil {
+		return nil
+	}
+	return string(raw)
+}
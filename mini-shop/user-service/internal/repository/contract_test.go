@@ -0,0 +1,656 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mini-shop/platform/pagination"
+	"mini-shop/user-service/internal/model"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testUserStore is the contract every model.UserStore implementation has to
+// satisfy. newStore must return an empty store.
+func testUserStore(t *testing.T, newStore func(t *testing.T) model.UserStore) {
+	ctx := context.Background()
+
+	t.Run("GetUserByID returns ErrUserNotFound for a missing user", func(t *testing.T) {
+		store := newStore(t)
+
+		if _, err := store.GetUserByID(ctx, 42); !errors.Is(err, model.ErrUserNotFound) {
+			t.Fatalf("GetUserByID error = %v, want %v", err, model.ErrUserNotFound)
+		}
+		if _, err := store.GetUserByEmail(ctx, "missing@example.com"); !errors.Is(err, model.ErrUserNotFound) {
+			t.Fatalf("GetUserByEmail error = %v, want %v", err, model.ErrUserNotFound)
+		}
+	})
+
+	t.Run("CreateUser assigns an ID and a zero balance", func(t *testing.T) {
+		store := newStore(t)
+
+		mustCreateUser(t, store, "ann@example.com")
+
+		u, err := store.GetUserByEmail(ctx, "ann@example.com")
+		if err != nil {
+			t.Fatalf("GetUserByEmail: %v", err)
+		}
+		if u.ID == 0 || u.Balance != 0 || u.CreatedAt.IsZero() {
+			t.Errorf("created user = %+v, want an ID, zero balance and a creation time", u)
+		}
+		if u.FirstName != "Ann" || u.Password != "hash" || u.PasswordAlgorithm != model.PasswordBcrypt {
+			t.Errorf("created user = %+v, want the stored name and password", u)
+		}
+
+		byID, err := store.GetUserByID(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if byID.Email != u.Email {
+			t.Errorf("GetUserByID email = %q, want %q", byID.Email, u.Email)
+		}
+
+		enabledAt := time.Now().Truncate(time.Second)
+		u.Password, u.PasswordAlgorithm = "new-hash", model.PasswordArgon2id
+		u.Roles = []string{model.RoleAdmin}
+		u.TOTPSecret, u.TwoFactorEnabledAt, u.TOTPLastStep = "SECRET", &enabledAt, 42
+		u.BlockedAt = &enabledAt
+		if err := store.UpdateUser(ctx, u); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		updated, err := store.GetUserByID(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if updated.Password != "new-hash" || updated.PasswordAlgorithm != model.PasswordArgon2id {
+			t.Errorf("updated password = %q (%s), want new-hash (%s)", updated.Password, updated.PasswordAlgorithm, model.PasswordArgon2id)
+		}
+		if !updated.HasRole(model.RoleAdmin) || updated.TOTPSecret != "SECRET" || updated.TOTPLastStep != 42 ||
+			updated.TwoFactorEnabledAt == nil || !updated.TwoFactorEnabledAt.Equal(enabledAt) {
+			t.Errorf("updated user = %+v, want the admin role and two-factor state", updated)
+		}
+		if !updated.Blocked() || !updated.BlockedAt.Equal(enabledAt) {
+			t.Errorf("updated blockedAt = %v, want %v", updated.BlockedAt, enabledAt)
+		}
+	})
+
+	t.Run("emails are unique", func(t *testing.T) {
+		store := newStore(t)
+
+		mustCreateUser(t, store, "ann@example.com")
+		err := store.CreateUser(ctx, model.User{FirstName: "Ann", LastName: "Other", Email: "ann@example.com", Password: "hash"})
+		if !errors.Is(err, model.ErrUserExists) {
+			t.Fatalf("CreateUser with a taken email: error = %v, want %v", err, model.ErrUserExists)
+		}
+
+		bob := mustCreateUser(t, store, "bob@example.com")
+		bob.Email = "ann@example.com"
+		if err := store.UpdateUser(ctx, bob); !errors.Is(err, model.ErrUserExists) {
+			t.Fatalf("UpdateUser to a taken email: error = %v, want %v", err, model.ErrUserExists)
+		}
+	})
+
+	t.Run("GetUsers orders by the sort field and then by ID", func(t *testing.T) {
+		store := newStore(t)
+
+		var ids []int
+		for i, balance := range []float64{30, 10, 30, 20} {
+			u := mustCreateUser(t, store, string(rune('a'+i))+"@example.com")
+			u.Balance = balance
+			if err := store.UpdateUser(ctx, u); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+			ids = append(ids, u.ID)
+		}
+
+		tests := []struct {
+			name   string
+			params pagination.Params
+			want   []int
+		}{
+			{"createdAt", pagination.Params{Limit: 10, Sort: "createdAt"}, ids},
+			{"-createdAt", pagination.Params{Limit: 10, Sort: "createdAt", Desc: true}, []int{ids[3], ids[2], ids[1], ids[0]}},
+			{"balance", pagination.Params{Limit: 10, Sort: "balance"}, []int{ids[1], ids[3], ids[0], ids[2]}},
+			{"-balance", pagination.Params{Limit: 10, Sort: "balance", Desc: true}, []int{ids[2], ids[0], ids[3], ids[1]}},
+			{"limit fetches one extra row", pagination.Params{Limit: 2, Sort: "balance"}, []int{ids[1], ids[3], ids[0]}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				users, err := store.GetUsers(ctx, model.UserFilter{}, tt.params)
+				if err != nil {
+					t.Fatalf("GetUsers: %v", err)
+				}
+				if got := userIDs(users); !slices.Equal(got, tt.want) {
+					t.Errorf("GetUsers IDs = %v, want %v", got, tt.want)
+				}
+				for _, u := range users {
+					if u.Password != "" {
+						t.Errorf("GetUsers returned the password of user %d", u.ID)
+					}
+				}
+			})
+		}
+
+		t.Run("cursor continues after the last row", func(t *testing.T) {
+			first, err := store.GetUsers(ctx, model.UserFilter{}, pagination.Params{Limit: 2, Sort: "balance"})
+			if err != nil {
+				t.Fatalf("GetUsers: %v", err)
+			}
+			cursor := model.UserCursor(first[1], "balance")
+
+			rest, err := store.GetUsers(ctx, model.UserFilter{}, pagination.Params{Limit: 2, Sort: "balance", Cursor: &cursor})
+			if err != nil {
+				t.Fatalf("GetUsers: %v", err)
+			}
+			if got, want := userIDs(rest), []int{ids[0], ids[2]}; !slices.Equal(got, want) {
+				t.Errorf("second page IDs = %v, want %v", got, want)
+			}
+		})
+
+		t.Run("search by email or name prefix", func(t *testing.T) {
+			u, err := store.GetUserByID(ctx, ids[1])
+			if err != nil {
+				t.Fatalf("GetUserByID: %v", err)
+			}
+			u.LastName = "Brown_50%"
+			if err := store.UpdateUser(ctx, u); err != nil {
+				t.Fatalf("UpdateUser: %v", err)
+			}
+
+			tests := []struct {
+				query string
+				want  []int
+			}{
+				{"C@EX", []int{ids[2]}},
+				{"smi", []int{ids[0], ids[2], ids[3]}},
+				{"brown_5", []int{ids[1]}},
+				{"brown%", nil},
+				{"_", nil},
+				{"example", nil},
+			}
+			for _, tt := range tests {
+				users, err := store.GetUsers(ctx, model.UserFilter{Query: tt.query}, pagination.Params{Limit: 10, Sort: "createdAt"})
+				if err != nil {
+					t.Fatalf("GetUsers(%q): %v", tt.query, err)
+				}
+				if got := userIDs(users); !slices.Equal(got, tt.want) {
+					t.Errorf("GetUsers(%q) IDs = %v, want %v", tt.query, got, tt.want)
+				}
+			}
+		})
+
+		t.Run("balance filter", func(t *testing.T) {
+			minBalance, maxBalance := 15.0, 30.0
+			users, err := store.GetUsers(ctx, model.UserFilter{MinBalance: &minBalance, MaxBalance: &maxBalance}, pagination.Params{Limit: 10, Sort: "balance"})
+			if err != nil {
+				t.Fatalf("GetUsers: %v", err)
+			}
+			if got, want := userIDs(users), []int{ids[3], ids[0], ids[2]}; !slices.Equal(got, want) {
+				t.Errorf("filtered IDs = %v, want %v", got, want)
+			}
+		})
+	})
+
+	t.Run("DeleteUser removes the user and ignores missing ones", func(t *testing.T) {
+		store := newStore(t)
+
+		u := mustCreateUser(t, store, "ann@example.com")
+		if err := store.DeleteUser(ctx, u.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		if _, err := store.GetUserByID(ctx, u.ID); !errors.Is(err, model.ErrUserNotFound) {
+			t.Errorf("GetUserByID after delete: error = %v, want %v", err, model.ErrUserNotFound)
+		}
+		if err := store.DeleteUser(ctx, u.ID); err != nil {
+			t.Errorf("DeleteUser of a missing user: %v", err)
+		}
+	})
+
+	t.Run("deleted users free their email and are erased after retention", func(t *testing.T) {
+		store := newStore(t)
+
+		u := mustCreateUser(t, store, "ann@example.com")
+		if err := store.AddLedgerEntry(ctx, &model.LedgerEntry{UserID: u.ID, Amount: 10, Balance: 10, Reason: model.LedgerTopUp}); err != nil {
+			t.Fatalf("AddLedgerEntry: %v", err)
+		}
+		if err := store.AddOutboxEvent(ctx, model.OutboxEvent{EventType: "user.registered", Payload: []byte(fmt.Sprintf(`{"userID": %d}`, u.ID))}); err != nil {
+			t.Fatalf("AddOutboxEvent: %v", err)
+		}
+		events, err := store.PendingOutboxEvents(ctx, 10)
+		if err != nil || len(events) != 1 {
+			t.Fatalf("PendingOutboxEvents = %v, %v; want one event", events, err)
+		}
+		if err := store.MarkOutboxEventSent(ctx, events[0].ID); err != nil {
+			t.Fatalf("MarkOutboxEventSent: %v", err)
+		}
+
+		if erased, err := store.EraseUser(ctx, u.ID); erased || err != nil {
+			t.Fatalf("EraseUser of an active user = %v, %v; want false, nil", erased, err)
+		}
+		if err := store.DeleteUser(ctx, u.ID); err != nil {
+			t.Fatalf("DeleteUser: %v", err)
+		}
+		again := mustCreateUser(t, store, "ann@example.com")
+		users, err := store.GetUsers(ctx, model.UserFilter{}, pagination.Params{Limit: 10, Sort: "createdAt"})
+		if err != nil {
+			t.Fatalf("GetUsers: %v", err)
+		}
+		if got, want := userIDs(users), []int{again.ID}; !slices.Equal(got, want) {
+			t.Errorf("GetUsers IDs = %v, want only the new account %v", got, want)
+		}
+
+		if ids, err := store.DeletedUsers(ctx, time.Now().Add(-time.Hour), 10); len(ids) != 0 || err != nil {
+			t.Errorf("DeletedUsers before the deletion = %v, %v; want none", ids, err)
+		}
+		ids, err := store.DeletedUsers(ctx, time.Now().Add(time.Hour), 10)
+		if err != nil {
+			t.Fatalf("DeletedUsers: %v", err)
+		}
+		if !slices.Equal(ids, []int{u.ID}) {
+			t.Fatalf("DeletedUsers = %v, want [%d]", ids, u.ID)
+		}
+
+		if erased, err := store.EraseUser(ctx, u.ID); !erased || err != nil {
+			t.Fatalf("EraseUser = %v, %v; want true, nil", erased, err)
+		}
+		if erased, err := store.EraseUser(ctx, u.ID); erased || err != nil {
+			t.Errorf("second EraseUser = %v, %v; want false, nil", erased, err)
+		}
+		if ids, err := store.DeletedUsers(ctx, time.Now().Add(time.Hour), 10); len(ids) != 0 || err != nil {
+			t.Errorf("DeletedUsers after erasure = %v, %v; want none", ids, err)
+		}
+
+		ledger, err := store.ListLedger(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("ListLedger: %v", err)
+		}
+		if len(ledger) != 1 || ledger[0].Amount != 10 {
+			t.Errorf("ledger after erasure = %+v, want the top-up kept", ledger)
+		}
+	})
+
+	t.Run("AddLedgerEntry requires an existing user", func(t *testing.T) {
+		store := newStore(t)
+
+		u := mustCreateUser(t, store, "ann@example.com")
+		entry := &model.LedgerEntry{UserID: u.ID, Amount: 10, Balance: 10, Reason: model.LedgerTopUp}
+		if err := store.AddLedgerEntry(ctx, entry); err != nil {
+			t.Fatalf("AddLedgerEntry: %v", err)
+		}
+		if entry.ID == 0 || entry.CreatedAt.IsZero() {
+			t.Errorf("ledger entry = %+v, want an ID and a creation time", entry)
+		}
+
+		adminID := u.ID
+		adjustment := &model.LedgerEntry{UserID: u.ID, Amount: -4, Balance: 6, Reason: model.LedgerAdjustment, Comment: "double top-up", ActorID: &adminID}
+		if err := store.AddLedgerEntry(ctx, adjustment); err != nil {
+			t.Fatalf("AddLedgerEntry: %v", err)
+		}
+		ledger, err := store.ListLedger(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("ListLedger: %v", err)
+		}
+		if len(ledger) != 2 || ledger[0].ActorID != nil || ledger[1].Comment != "double top-up" || ledger[1].ActorID == nil || *ledger[1].ActorID != adminID {
+			t.Errorf("ledger = %+v, want the top-up and the commented adjustment", ledger)
+		}
+
+		err = store.AddLedgerEntry(ctx, &model.LedgerEntry{UserID: u.ID + 1, Amount: 10, Balance: 10, Reason: model.LedgerTopUp})
+		if !errors.Is(err, model.ErrUserNotFound) {
+			t.Errorf("AddLedgerEntry for a missing user: error = %v, want %v", err, model.ErrUserNotFound)
+		}
+	})
+
+	t.Run("ledger references are unique", func(t *testing.T) {
+		store := newStore(t)
+
+		u := mustCreateUser(t, store, "ann@example.com")
+		if entry, err := store.LedgerEntryByReference(ctx, "topup:1"); err != nil || entry != nil {
+			t.Fatalf("LedgerEntryByReference before the top-up = %+v, %v; want nil, nil", entry, err)
+		}
+		for _, ref := range []string{"topup:1", "", ""} {
+			if err := store.AddLedgerEntry(ctx, &model.LedgerEntry{UserID: u.ID, Amount: 10, Balance: 10, Reason: model.LedgerTopUp, Reference: ref}); err != nil {
+				t.Fatalf("AddLedgerEntry %q: %v", ref, err)
+			}
+		}
+		err := store.AddLedgerEntry(ctx, &model.LedgerEntry{UserID: u.ID, Amount: 10, Balance: 20, Reason: model.LedgerTopUp, Reference: "topup:1"})
+		if !errors.Is(err, model.ErrReferenceUsed) {
+			t.Errorf("AddLedgerEntry with a used reference: error = %v, want %v", err, model.ErrReferenceUsed)
+		}
+
+		entry, err := store.LedgerEntryByReference(ctx, "topup:1")
+		if err != nil || entry == nil || entry.UserID != u.ID || entry.Reference != "topup:1" {
+			t.Errorf("LedgerEntryByReference = %+v, %v; want the top-up of user %d", entry, err, u.ID)
+		}
+		if entry, err := store.LedgerEntryByReference(ctx, ""); err != nil || entry != nil {
+			t.Errorf("LedgerEntryByReference(\"\") = %+v, %v; want nil, nil", entry, err)
+		}
+	})
+
+	t.Run("outbox returns pending events in insertion order", func(t *testing.T) {
+		store := newStore(t)
+
+		for _, eventType := range []string{"user.registered", "user.balance_low"} {
+			if err := store.AddOutboxEvent(ctx, model.OutboxEvent{EventType: eventType, Payload: []byte(`{}`)}); err != nil {
+				t.Fatalf("AddOutboxEvent: %v", err)
+			}
+		}
+
+		events, err := store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 2 || events[0].EventType != "user.registered" || events[1].EventType != "user.balance_low" {
+			t.Fatalf("pending events = %+v, want both in insertion order", events)
+		}
+
+		if err := store.MarkOutboxEventSent(ctx, events[0].ID); err != nil {
+			t.Fatalf("MarkOutboxEventSent: %v", err)
+		}
+		events, err = store.PendingOutboxEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingOutboxEvents: %v", err)
+		}
+		if len(events) != 1 || events[0].EventType != "user.balance_low" {
+			t.Errorf("pending after send = %+v, want only user.balance_low", events)
+		}
+	})
+
+	t.Run("email verification is stored", func(t *testing.T) {
+		store := newStore(t)
+
+		u := mustCreateUser(t, store, "ann@example.com")
+		if u.EmailVerified() {
+			t.Fatalf("new user = %+v, want an unverified email", u)
+		}
+
+		verifiedAt := time.Now().Truncate(time.Microsecond)
+		u.EmailVerifiedAt = &verifiedAt
+		if err := store.UpdateUser(ctx, u); err != nil {
+			t.Fatalf("UpdateUser: %v", err)
+		}
+		got, err := store.GetUserByEmail(ctx, "ann@example.com")
+		if err != nil {
+			t.Fatalf("GetUserByEmail: %v", err)
+		}
+		if got.EmailVerifiedAt == nil || !got.EmailVerifiedAt.Equal(verifiedAt) {
+			t.Errorf("emailVerifiedAt = %v, want %v", got.EmailVerifiedAt, verifiedAt)
+		}
+	})
+
+	t.Run("tokens are found by purpose and hash and used once", func(t *testing.T) {
+		store := newStore(t)
+		u := mustCreateUser(t, store, "ann@example.com")
+
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Microsecond)
+		token := &model.UserToken{UserID: u.ID, Purpose: model.TokenResetPassword, Hash: "hash-1", ExpiresAt: expiresAt}
+		if err := store.CreateToken(ctx, token); err != nil {
+			t.Fatalf("CreateToken: %v", err)
+		}
+		if token.ID == 0 || token.CreatedAt.IsZero() {
+			t.Errorf("token = %+v, want an ID and a creation time", token)
+		}
+
+		if got, err := store.GetToken(ctx, model.TokenVerifyEmail, "hash-1"); got != nil || err != nil {
+			t.Errorf("GetToken with another purpose = %v, %v; want nil, nil", got, err)
+		}
+		got, err := store.GetToken(ctx, model.TokenResetPassword, "hash-1")
+		if err != nil || got == nil {
+			t.Fatalf("GetToken = %v, %v; want the token", got, err)
+		}
+		if got.UserID != u.ID || !got.ExpiresAt.Equal(expiresAt) || got.UsedAt != nil {
+			t.Errorf("token = %+v, want the stored unused token", got)
+		}
+
+		if err := store.UseToken(ctx, got.ID); err != nil {
+			t.Fatalf("UseToken: %v", err)
+		}
+		if err := store.UseToken(ctx, got.ID); !errors.Is(err, model.ErrInvalidToken) {
+			t.Errorf("second UseToken error = %v, want %v", err, model.ErrInvalidToken)
+		}
+		if got, _ := store.GetToken(ctx, model.TokenResetPassword, "hash-1"); got == nil || got.UsedAt == nil {
+			t.Errorf("used token = %+v, want a use time", got)
+		}
+
+		err = store.CreateToken(ctx, &model.UserToken{UserID: u.ID + 1, Purpose: model.TokenResetPassword, Hash: "hash-2", ExpiresAt: expiresAt})
+		if !errors.Is(err, model.ErrUserNotFound) {
+			t.Errorf("CreateToken for a missing user: error = %v, want %v", err, model.ErrUserNotFound)
+		}
+	})
+
+	t.Run("RevokeTokens uses up the user's tokens of one purpose", func(t *testing.T) {
+		store := newStore(t)
+		ann := mustCreateUser(t, store, "ann@example.com")
+		bob := mustCreateUser(t, store, "bob@example.com")
+
+		expiresAt := time.Now().Add(time.Hour)
+		for _, token := range []model.UserToken{
+			{UserID: ann.ID, Purpose: model.TokenResetPassword, Hash: "ann-reset", ExpiresAt: expiresAt},
+			{UserID: ann.ID, Purpose: model.TokenVerifyEmail, Hash: "ann-verify", ExpiresAt: expiresAt},
+			{UserID: bob.ID, Purpose: model.TokenResetPassword, Hash: "bob-reset", ExpiresAt: expiresAt},
+		} {
+			if err := store.CreateToken(ctx, &token); err != nil {
+				t.Fatalf("CreateToken: %v", err)
+			}
+		}
+
+		if err := store.RevokeTokens(ctx, ann.ID, model.TokenResetPassword); err != nil {
+			t.Fatalf("RevokeTokens: %v", err)
+		}
+		for purpose, hashes := range map[string][]string{
+			model.TokenResetPassword: {"ann-reset", "bob-reset"},
+			model.TokenVerifyEmail:   {"ann-verify"},
+		} {
+			for _, hash := range hashes {
+				got, err := store.GetToken(ctx, purpose, hash)
+				if err != nil || got == nil {
+					t.Fatalf("GetToken(%s) = %v, %v", hash, got, err)
+				}
+				if revoked := got.UsedAt != nil; revoked != (hash == "ann-reset") {
+					t.Errorf("token %s used = %v, want only ann-reset revoked", hash, revoked)
+				}
+			}
+		}
+	})
+
+	t.Run("audit records are chained and event IDs are logged once", func(t *testing.T) {
+		store := newStore(t)
+		at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+		records := []*model.AuditRecord{
+			{OccurredAt: at, Actor: "user:1", Action: "balance.adjusted", Target: "user:2", Before: []byte(`{"balance":10}`), After: []byte(`{"balance":15}`), IP: "10.0.0.1", RequestID: "req-1"},
+			{OccurredAt: at.Add(time.Second), Actor: "payment-service", Action: "payment.completed", Target: "order:7", EventID: "event-1"},
+			{OccurredAt: at.Add(2 * time.Second), Actor: "payment-service", Action: "payment.completed", Target: "order:7", EventID: "event-1"},
+		}
+		for _, r := range records {
+			if err := store.AppendAudit(ctx, r); err != nil {
+				t.Fatalf("AppendAudit: %v", err)
+			}
+		}
+		if records[2].ID != 0 {
+			t.Errorf("duplicate event got ID %d, want it skipped", records[2].ID)
+		}
+
+		errRollback := errors.New("rollback")
+		err := store.InTx(ctx, func(tx model.UserStore) error {
+			if err := tx.AppendAudit(ctx, &model.AuditRecord{OccurredAt: at, Actor: "system", Action: "account.erased"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("InTx error = %v, want %v", err, errRollback)
+		}
+
+		chain, err := store.AuditChain(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("AuditChain: %v", err)
+		}
+		if len(chain) != 2 {
+			t.Fatalf("chain has %d records, want 2", len(chain))
+		}
+		prev := ""
+		for i, r := range chain {
+			if r.ID != records[i].ID || r.PrevHash != prev || r.Hash != r.ComputeHash() || r.Hash != records[i].Hash {
+				t.Errorf("record %d = %+v, want it linked to %q with a matching hash", i, r, prev)
+			}
+			prev = r.Hash
+		}
+		if string(chain[0].After) != `{"balance":15}` || chain[0].Before == nil || chain[1].Before != nil || chain[1].EventID != "event-1" {
+			t.Errorf("stored states = %s/%s and %s, want them as appended", chain[0].Before, chain[0].After, chain[1].Before)
+		}
+		if !chain[0].OccurredAt.Equal(at) || chain[0].IP != "10.0.0.1" || chain[0].RequestID != "req-1" {
+			t.Errorf("first record = %+v, want its time, IP and request ID", chain[0])
+		}
+
+		rest, err := store.AuditChain(ctx, chain[0].ID, 10)
+		if err != nil {
+			t.Fatalf("AuditChain: %v", err)
+		}
+		if len(rest) != 1 || rest[0].ID != chain[1].ID {
+			t.Errorf("chain after %d = %+v, want the second record", chain[0].ID, rest)
+		}
+	})
+
+	t.Run("ListAudit filters by actor, action, target and time", func(t *testing.T) {
+		store := newStore(t)
+		at := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+		for i, r := range []model.AuditRecord{
+			{Actor: "user:1", Action: "account.blocked", Target: "user:2"},
+			{Actor: "user:1", Action: "account.unblocked", Target: "user:2"},
+			{Actor: "user:2", Action: "login.succeeded", Target: "user:2"},
+			{Actor: "user:1", Action: "account.blocked", Target: "user:3"},
+		} {
+			r.OccurredAt = at.Add(time.Duration(i) * time.Minute)
+			if err := store.AppendAudit(ctx, &r); err != nil {
+				t.Fatalf("AppendAudit: %v", err)
+			}
+		}
+
+		from, to := at.Add(time.Minute), at.Add(3*time.Minute)
+		tests := []struct {
+			name   string
+			filter model.AuditFilter
+			desc   bool
+			want   []int
+		}{
+			{"all, newest first", model.AuditFilter{}, true, []int{4, 3, 2, 1}},
+			{"actor", model.AuditFilter{Actor: "user:1"}, false, []int{1, 2, 4}},
+			{"action", model.AuditFilter{Action: "account.blocked"}, false, []int{1, 4}},
+			{"target", model.AuditFilter{Target: "user:2"}, false, []int{1, 2, 3}},
+			{"time range", model.AuditFilter{From: &from, To: &to}, false, []int{2, 3}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				records, err := store.ListAudit(ctx, tt.filter, pagination.Params{Sort: "occurredAt", Desc: tt.desc, Limit: 10})
+				if err != nil {
+					t.Fatalf("ListAudit: %v", err)
+				}
+				got := make([]int, len(records))
+				for i, r := range records {
+					got[i] = r.ID
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("ListAudit IDs = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		first, err := store.ListAudit(ctx, model.AuditFilter{}, pagination.Params{Sort: "occurredAt", Limit: 1})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		cursor := model.AuditCursor(first[0], "occurredAt")
+		next, err := store.ListAudit(ctx, model.AuditFilter{}, pagination.Params{Sort: "occurredAt", Limit: 1, Cursor: &cursor})
+		if err != nil {
+			t.Fatalf("ListAudit: %v", err)
+		}
+		if len(first) != 2 || len(next) != 2 || next[0].ID != 2 {
+			t.Errorf("pages = %+v and %+v, want limit+1 rows each, the second starting at 2", first, next)
+		}
+	})
+
+	t.Run("settings are replaced by key", func(t *testing.T) {
+		store := newStore(t)
+
+		if value, err := store.GetSetting(ctx, "unset"); err != nil || value != nil {
+			t.Fatalf("GetSetting of an unset key = %s, %v; want nil", value, err)
+		}
+		for _, value := range []string{`{"requiredRoles":["admin"]}`, `{"requiredRoles":[]}`} {
+			if err := store.PutSetting(ctx, model.SettingTwoFactorRoles, []byte(value)); err != nil {
+				t.Fatalf("PutSetting: %v", err)
+			}
+		}
+		value, err := store.GetSetting(ctx, model.SettingTwoFactorRoles)
+		if err != nil || string(value) != `{"requiredRoles":[]}` {
+			t.Errorf("GetSetting = %s, %v; want the last value", value, err)
+		}
+	})
+
+	t.Run("InTx commits on success and rolls back on error", func(t *testing.T) {
+		store := newStore(t)
+		u := mustCreateUser(t, store, "ann@example.com")
+
+		err := store.InTx(ctx, func(tx model.UserStore) error {
+			locked, err := tx.LockUserByID(ctx, u.ID)
+			if err != nil {
+				return err
+			}
+			locked.Balance = 50
+			return tx.UpdateUser(ctx, locked)
+		})
+		if err != nil {
+			t.Fatalf("InTx: %v", err)
+		}
+
+		errRollback := errors.New("rollback")
+		err = store.InTx(ctx, func(tx model.UserStore) error {
+			locked, err := tx.LockUserByID(ctx, u.ID)
+			if err != nil {
+				return err
+			}
+			locked.Balance = 0
+			if err := tx.UpdateUser(ctx, locked); err != nil {
+				return err
+			}
+			if err := tx.AddLedgerEntry(ctx, &model.LedgerEntry{UserID: u.ID, Amount: -50, Reason: model.LedgerWithdrawal}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("InTx error = %v, want %v", err, errRollback)
+		}
+
+		got, err := store.GetUserByID(ctx, u.ID)
+		if err != nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		if got.Balance != 50 {
+			t.Errorf("balance = %v, want 50 from the committed transaction only", got.Balance)
+		}
+	})
+}
+
+func mustCreateUser(t *testing.T, store model.UserStore, email string) *model.User {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := store.CreateUser(ctx, model.User{FirstName: "Ann", LastName: "Smith", Email: email, Password: "hash", PasswordAlgorithm: model.PasswordBcrypt}); err != nil {
+		t.Fatalf("CreateUser(%s): %v", email, err)
+	}
+	u, err := store.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail(%s): %v", email, err)
+	}
+	return u
+}
+
+func userIDs(users []model.User) []int {
+	ids := make([]int, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	return ids
+}
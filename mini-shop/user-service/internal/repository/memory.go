@@ -0,0 +1,536 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"maps"
+	"mini-shop/platform/pagination"
+	"mini-shop/user-service/internal/model"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory model.UserStore with the same semantics as the
+// Postgres Store. It is meant for tests and has no persistence.
+type MemoryStore struct {
+	state *userState
+	inTx  bool
+}
+
+type userState struct {
+	// tx serializes transactions, which is all the isolation the fake offers.
+	tx sync.Mutex
+
+	mu         sync.Mutex
+	users      []model.User
+	ledger     []model.LedgerEntry
+	outbox     []model.OutboxEvent
+	tokens     []model.UserToken
+	audit      []model.AuditRecord
+	settings   map[string][]byte
+	lastUser   int
+	lastItem   int
+	lastOutbox int
+	lastToken  int
+	lastAudit  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: &userState{}}
+}
+
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.findUser(func(u model.User) bool { return u.Email == email })
+}
+
+func (s *MemoryStore) GetUserByID(ctx context.Context, id int) (*model.User, error) {
+	return s.findUser(func(u model.User) bool { return u.ID == id })
+}
+
+// LockUserByID has nothing to lock; transactions are already serialized.
+func (s *MemoryStore) LockUserByID(ctx context.Context, id int) (*model.User, error) {
+	return s.GetUserByID(ctx, id)
+}
+
+func (s *MemoryStore) findUser(match func(model.User) bool) (*model.User, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.users, func(u model.User) bool { return u.DeletedAt == nil && match(u) })
+	if i < 0 {
+		return nil, model.ErrUserNotFound
+	}
+	u := s.state.users[i]
+	u.Roles = slices.Clone(u.Roles)
+	return &u, nil
+}
+
+func (s *MemoryStore) GetUsers(ctx context.Context, filter model.UserFilter, params pagination.Params) ([]model.User, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	users := []model.User{}
+	for _, u := range s.state.users {
+		if u.DeletedAt != nil {
+			continue
+		}
+		if filter.Query != "" && !matchesPrefix(filter.Query, u.Email, u.FirstName, u.LastName) {
+			continue
+		}
+		if filter.CreatedFrom != nil && u.CreatedAt.Before(*filter.CreatedFrom) {
+			continue
+		}
+		if filter.CreatedTo != nil && !u.CreatedAt.Before(*filter.CreatedTo) {
+			continue
+		}
+		if filter.MinBalance != nil && u.Balance < *filter.MinBalance {
+			continue
+		}
+		if filter.MaxBalance != nil && u.Balance > *filter.MaxBalance {
+			continue
+		}
+		// Список не отдаёт хеш пароля, как и запрос в Postgres
+		u.Password, u.PasswordAlgorithm, u.TOTPSecret, u.TOTPLastStep = "", "", "", 0
+		users = append(users, u)
+	}
+
+	return keysetPage(users, params, model.UserCursor), nil
+}
+
+// matchesPrefix reports whether one of fields starts with query, ignoring case.
+func matchesPrefix(query string, fields ...string) bool {
+	return slices.ContainsFunc(fields, func(field string) bool {
+		return strings.HasPrefix(strings.ToLower(field), strings.ToLower(query))
+	})
+}
+
+func (s *MemoryStore) CreateUser(ctx context.Context, user model.User) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if s.state.emailTaken(user.Email, 0) {
+		return model.ErrUserExists
+	}
+
+	s.state.lastUser++
+	s.state.users = append(s.state.users, model.User{
+		ID:                s.state.lastUser,
+		FirstName:         user.FirstName,
+		LastName:          user.LastName,
+		Email:             user.Email,
+		Password:          user.Password,
+		PasswordAlgorithm: user.PasswordAlgorithm,
+		CreatedAt:         now(),
+		Roles:             slices.Clone(user.Roles),
+	})
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.delete(func(u model.User) bool { return u.ID == id })
+	return nil
+}
+
+func (s *MemoryStore) DeleteAllUsers(ctx context.Context) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.delete(func(model.User) bool { return true })
+	return nil
+}
+
+func (s *MemoryStore) DeletedUsers(ctx context.Context, before time.Time, limit int) ([]int, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	var deleted []model.User
+	for _, u := range s.state.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(before) && u.ErasedAt == nil {
+			deleted = append(deleted, u)
+		}
+	}
+	slices.SortStableFunc(deleted, func(a, b model.User) int { return a.DeletedAt.Compare(*b.DeletedAt) })
+
+	var ids []int
+	for _, u := range deleted[:min(limit, len(deleted))] {
+		ids = append(ids, u.ID)
+	}
+	return ids, nil
+}
+
+func (s *MemoryStore) EraseUser(ctx context.Context, id int) (bool, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.users, func(u model.User) bool {
+		return u.ID == id && u.DeletedAt != nil && u.ErasedAt == nil
+	})
+	if i < 0 {
+		return false, nil
+	}
+
+	u := s.state.users[i]
+	erasedAt := now()
+	s.state.users[i] = model.User{
+		ID:                u.ID,
+		PasswordAlgorithm: u.PasswordAlgorithm,
+		Balance:           u.Balance,
+		CreatedAt:         u.CreatedAt,
+		DeletedAt:         u.DeletedAt,
+		ErasedAt:          &erasedAt,
+		BlockedAt:         u.BlockedAt,
+	}
+	s.state.tokens = slices.DeleteFunc(s.state.tokens, func(t model.UserToken) bool { return t.UserID == id })
+	s.state.outbox = slices.DeleteFunc(s.state.outbox, func(e model.OutboxEvent) bool {
+		var payload struct {
+			UserID int `json:"userID"`
+		}
+		return e.Status == "sent" && json.Unmarshal(e.Payload, &payload) == nil && payload.UserID == id
+	})
+	return true, nil
+}
+
+func (s *MemoryStore) UpdateUser(ctx context.Context, user *model.User) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.users, func(u model.User) bool { return u.ID == user.ID })
+	if i < 0 {
+		return nil
+	}
+	if s.state.emailTaken(user.Email, user.ID) {
+		return model.ErrUserExists
+	}
+
+	deletedAt, erasedAt := s.state.users[i].DeletedAt, s.state.users[i].ErasedAt
+	s.state.users[i] = *user
+	s.state.users[i].Roles = slices.Clone(user.Roles)
+	s.state.users[i].DeletedAt, s.state.users[i].ErasedAt = deletedAt, erasedAt
+	return nil
+}
+
+func (s *MemoryStore) AddLedgerEntry(ctx context.Context, entry *model.LedgerEntry) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if !slices.ContainsFunc(s.state.users, func(u model.User) bool { return u.ID == entry.UserID }) {
+		return model.ErrUserNotFound
+	}
+	if entry.Reference != "" && slices.ContainsFunc(s.state.ledger, func(e model.LedgerEntry) bool { return e.Reference == entry.Reference }) {
+		return model.ErrReferenceUsed
+	}
+
+	s.state.lastItem++
+	entry.ID = s.state.lastItem
+	entry.CreatedAt = now()
+	s.state.ledger = append(s.state.ledger, *entry)
+	return nil
+}
+
+func (s *MemoryStore) ListLedger(ctx context.Context, userID int) ([]model.LedgerEntry, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	entries := []model.LedgerEntry{}
+	for _, e := range s.state.ledger {
+		if e.UserID == userID {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) LedgerEntryByReference(ctx context.Context, reference string) (*model.LedgerEntry, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if reference == "" {
+		return nil, nil
+	}
+	for _, e := range s.state.ledger {
+		if e.Reference == reference {
+			return &e, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) AppendAudit(ctx context.Context, record *model.AuditRecord) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if record.EventID != "" && slices.ContainsFunc(s.state.audit, func(r model.AuditRecord) bool { return r.EventID == record.EventID }) {
+		return nil
+	}
+
+	record.PrevHash = ""
+	if n := len(s.state.audit); n > 0 {
+		record.PrevHash = s.state.audit[n-1].Hash
+	}
+	s.state.lastAudit++
+	record.ID = s.state.lastAudit
+	record.OccurredAt = record.OccurredAt.Truncate(time.Microsecond)
+	record.Before, record.After = slices.Clone(record.Before), slices.Clone(record.After)
+	record.Hash = record.ComputeHash()
+	s.state.audit = append(s.state.audit, *record)
+	return nil
+}
+
+func (s *MemoryStore) ListAudit(ctx context.Context, filter model.AuditFilter, params pagination.Params) ([]model.AuditRecord, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	records := []model.AuditRecord{}
+	for _, r := range s.state.audit {
+		if filter.Actor != "" && r.Actor != filter.Actor ||
+			filter.Action != "" && r.Action != filter.Action ||
+			filter.Target != "" && r.Target != filter.Target {
+			continue
+		}
+		if filter.From != nil && r.OccurredAt.Before(*filter.From) {
+			continue
+		}
+		if filter.To != nil && !r.OccurredAt.Before(*filter.To) {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return keysetPage(records, params, model.AuditCursor), nil
+}
+
+func (s *MemoryStore) AuditChain(ctx context.Context, afterID, limit int) ([]model.AuditRecord, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	records := []model.AuditRecord{}
+	for _, r := range s.state.audit {
+		if r.ID > afterID && len(records) < limit {
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+func (s *MemoryStore) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	s.state.lastOutbox++
+	event.ID = s.state.lastOutbox
+	event.Payload = slices.Clone(event.Payload)
+	event.Headers = maps.Clone(event.Headers)
+	event.Status = "pending"
+	event.CreatedAt = now()
+	event.SentAt = nil
+	s.state.outbox = append(s.state.outbox, event)
+	return nil
+}
+
+func (s *MemoryStore) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	var events []model.OutboxEvent
+	for _, event := range s.state.outbox {
+		if len(events) == limit {
+			break
+		}
+		if event.Status == "pending" {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) MarkOutboxEventSent(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i := range s.state.outbox {
+		if s.state.outbox[i].ID == id {
+			sentAt := now()
+			s.state.outbox[i].Status = "sent"
+			s.state.outbox[i].SentAt = &sentAt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateToken(ctx context.Context, token *model.UserToken) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if !slices.ContainsFunc(s.state.users, func(u model.User) bool { return u.ID == token.UserID }) {
+		return model.ErrUserNotFound
+	}
+
+	s.state.lastToken++
+	token.ID = s.state.lastToken
+	token.CreatedAt = now()
+	token.ExpiresAt = token.ExpiresAt.Truncate(time.Microsecond)
+	token.UsedAt = nil
+	s.state.tokens = append(s.state.tokens, *token)
+	return nil
+}
+
+func (s *MemoryStore) GetToken(ctx context.Context, purpose, hash string) (*model.UserToken, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.tokens, func(t model.UserToken) bool { return t.Purpose == purpose && t.Hash == hash })
+	if i < 0 {
+		return nil, nil
+	}
+	token := s.state.tokens[i]
+	return &token, nil
+}
+
+func (s *MemoryStore) UseToken(ctx context.Context, id int) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	i := slices.IndexFunc(s.state.tokens, func(t model.UserToken) bool { return t.ID == id })
+	if i < 0 || s.state.tokens[i].UsedAt != nil {
+		return model.ErrInvalidToken
+	}
+	usedAt := now()
+	s.state.tokens[i].UsedAt = &usedAt
+	return nil
+}
+
+func (s *MemoryStore) RevokeTokens(ctx context.Context, userID int, purpose string) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	for i, t := range s.state.tokens {
+		if t.UserID == userID && t.Purpose == purpose && t.UsedAt == nil {
+			usedAt := now()
+			s.state.tokens[i].UsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+// InTx runs fn with transactions serialized. If fn fails, every change made
+// since the transaction began is discarded.
+func (s *MemoryStore) GetSetting(ctx context.Context, key string) ([]byte, error) {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	return slices.Clone(s.state.settings[key]), nil
+}
+
+func (s *MemoryStore) PutSetting(ctx context.Context, key string, value []byte) error {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	if s.state.settings == nil {
+		s.state.settings = map[string][]byte{}
+	}
+	s.state.settings[key] = slices.Clone(value)
+	return nil
+}
+
+func (s *MemoryStore) InTx(ctx context.Context, fn func(tx model.UserStore) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.state.tx.Lock()
+	defer s.state.tx.Unlock()
+
+	s.state.mu.Lock()
+	users, ledger, outbox := slices.Clone(s.state.users), slices.Clone(s.state.ledger), slices.Clone(s.state.outbox)
+	tokens, audit, settings := slices.Clone(s.state.tokens), slices.Clone(s.state.audit), maps.Clone(s.state.settings)
+	s.state.mu.Unlock()
+
+	if err := fn(&MemoryStore{state: s.state, inTx: true}); err != nil {
+		s.state.mu.Lock()
+		s.state.users, s.state.ledger, s.state.outbox = users, ledger, outbox
+		s.state.tokens, s.state.audit, s.state.settings = tokens, audit, settings
+		s.state.mu.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+func (st *userState) emailTaken(email string, exceptID int) bool {
+	return slices.ContainsFunc(st.users, func(u model.User) bool {
+		return u.Email == email && u.ID != exceptID && u.DeletedAt == nil
+	})
+}
+
+// delete marks the matching users deleted and revokes their tokens, like the
+// Postgres store.
+func (st *userState) delete(match func(model.User) bool) {
+	deletedAt := now()
+	deleted := map[int]bool{}
+	for i, u := range st.users {
+		if u.DeletedAt == nil && match(u) {
+			st.users[i].DeletedAt = &deletedAt
+			deleted[u.ID] = true
+		}
+	}
+	for i, t := range st.tokens {
+		if deleted[t.UserID] && t.UsedAt == nil {
+			st.tokens[i].UsedAt = &deletedAt
+		}
+	}
+}
+
+// now matches the microsecond precision of Postgres timestamps.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// keysetPage applies the keyset condition, ordering and limit that pagination.Query
+// builds for Postgres.
+func keysetPage[T any](items []T, params pagination.Params, cursor func(item T, sort string) pagination.Cursor) []T {
+	compare := func(a, b pagination.Cursor) int {
+		c := compareSortValues(params.Sort, a.Value, b.Value)
+		if c == 0 {
+			c = cmp.Compare(a.ID, b.ID)
+		}
+		if params.Desc {
+			c = -c
+		}
+		return c
+	}
+
+	if params.Cursor != nil {
+		items = slices.DeleteFunc(items, func(item T) bool {
+			return compare(cursor(item, params.Sort), *params.Cursor) <= 0
+		})
+	}
+
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(cursor(a, params.Sort), cursor(b, params.Sort))
+	})
+
+	if len(items) > params.Limit+1 {
+		items = items[:params.Limit+1]
+	}
+	return items
+}
+
+// compareSortValues compares two cursor values of the given sort field.
+func compareSortValues(sort, a, b string) int {
+	if sort == "createdAt" || sort == "occurredAt" {
+		x, _ := time.Parse(time.RFC3339Nano, a)
+		y, _ := time.Parse(time.RFC3339Nano, b)
+		return x.Compare(y)
+	}
+
+	x, _ := strconv.ParseFloat(a, 64)
+	y, _ := strconv.ParseFloat(b, 64)
+	return cmp.Compare(x, y)
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"mini-shop/user-service/internal/model"
+	"testing"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testUserStore(t, func(t *testing.T) model.UserStore {
+		return NewMemoryStore()
+	})
+}
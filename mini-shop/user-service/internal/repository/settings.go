@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func (s *Store) GetSetting(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.q.QueryRowContext(ctx, "SELECT value FROM settings WHERE key = $1", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (s *Store) PutSetting(ctx context.Context, key string, value []byte) error {
+	_, err := s.q.ExecContext(ctx,
+		`INSERT INTO settings (key, value, updatedAt) VALUES ($1, $2, NOW())
+		 ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updatedAt = EXCLUDED.updatedAt`,
+		key, string(value))
+	return err
+}
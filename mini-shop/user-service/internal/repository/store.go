@@ -1,17 +1,44 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"mini-shop/platform/database"
+	"mini-shop/platform/pagination"
 	"mini-shop/user-service/internal/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres error codes the store translates into domain errors.
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
 )
 
+// userColumns are read by scanRowsIntoUser.
+const userColumns = "id, firstName, lastName, email, password, passwordAlgorithm, balance, createdAt, emailVerifiedAt, roles, totpSecret, twoFactorEnabledAt, totpLastStep, deletedAt, erasedAt, blockedAt"
+
+var userSortColumns = map[string]pagination.Column{
+	"createdAt": {Name: "createdAt", Cast: "timestamptz"},
+	"balance":   {Name: "balance", Cast: "double precision"},
+}
+
 type Store struct {
 	db *sql.DB
+	q  database.DBTX
+	// audit collects the audit records of the transaction q is bound to; they
+	// are appended right before it commits.
+	audit *[]*model.AuditRecord
 }
 
 func NewStore(db *sql.DB) *Store {
-	return &Store{db: db}
+	return &Store{db: db, q: db}
 }
 
 func scanRowsIntoUser(rows *sql.Rows) (*model.User, error) {
@@ -23,8 +50,17 @@ func scanRowsIntoUser(rows *sql.Rows) (*model.User, error) {
 		&user.LastName,
 		&user.Email,
 		&user.Password,
+		&user.PasswordAlgorithm,
 		&user.Balance,
 		&user.CreatedAt,
+		&user.EmailVerifiedAt,
+		pq.Array(&user.Roles),
+		&user.TOTPSecret,
+		&user.TwoFactorEnabledAt,
+		&user.TOTPLastStep,
+		&user.DeletedAt,
+		&user.ErasedAt,
+		&user.BlockedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -33,8 +69,8 @@ func scanRowsIntoUser(rows *sql.Rows) (*model.User, error) {
 	return user, nil
 }
 
-func (s *Store) GetUserByEmail(email string) (*model.User, error) {
-	rows, err := s.db.Query("SELECT id, firstName, lastName, email, password, balance, createdAt FROM users WHERE email = $1", email)
+func (s *Store) getUser(ctx context.Context, query string, arg any) (*model.User, error) {
+	rows, err := s.q.QueryContext(ctx, query, arg)
 	if err != nil {
 		return nil, err
 	}
@@ -47,44 +83,44 @@ func (s *Store) GetUserByEmail(email string) (*model.User, error) {
 			return nil, err
 		}
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	if u.ID == 0 {
-		return nil, fmt.Errorf("user not found")
+		return nil, model.ErrUserNotFound
 	}
 
 	return u, nil
 }
 
-func (s *Store) GetUserByID(id int) (*model.User, error) {
-	rows, err := s.db.Query("SELECT id, firstName, lastName, email, password, balance, createdAt FROM users WHERE id = $1", id)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	u := new(model.User)
-	for rows.Next() {
-		u, err = scanRowsIntoUser(rows)
-		if err != nil {
-			return nil, err
-		}
-	}
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*model.User, error) {
+	return s.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE email = $1 AND deletedAt IS NULL", email)
+}
 
-	if u.ID == 0 {
-		return nil, fmt.Errorf("user not found")
-	}
+func (s *Store) GetUserByID(ctx context.Context, id int) (*model.User, error) {
+	return s.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deletedAt IS NULL", id)
+}
 
-	return u, nil
+// LockUserByID reads the user and holds a row lock until the surrounding
+// transaction ends, so concurrent balance changes are serialized.
+func (s *Store) LockUserByID(ctx context.Context, id int) (*model.User, error) {
+	return s.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deletedAt IS NULL FOR UPDATE", id)
 }
 
-func (s *Store) CreateUser(user model.User) error {
-	_, err := s.db.Exec(
-		"INSERT INTO users (firstName, lastName, email, password) VALUES ($1, $2, $3, $4)",
+func (s *Store) CreateUser(ctx context.Context, user model.User) error {
+	_, err := s.q.ExecContext(ctx,
+		"INSERT INTO users (firstName, lastName, email, password, passwordAlgorithm, roles) VALUES ($1, $2, $3, $4, $5, $6)",
 		user.FirstName,
 		user.LastName,
 		user.Email,
 		user.Password,
+		user.PasswordAlgorithm,
+		pq.Array(roles(user.Roles)),
 	)
+	if errorCode(err) == uniqueViolation {
+		return model.ErrUserExists
+	}
 	if err != nil {
 		return err
 	}
@@ -92,9 +128,27 @@ func (s *Store) CreateUser(user model.User) error {
 	return nil
 }
 
-func (s *Store) GetUsers() ([]model.User, error) {
-	var users []model.User
-	rows, err := s.db.Query("SELECT id, firstName, lastName, email, password, balance, createdAt FROM users")
+func (s *Store) GetUsers(ctx context.Context, filter model.UserFilter, params pagination.Params) ([]model.User, error) {
+	q := pagination.NewQuery("deletedAt IS NULL")
+	if filter.Query != "" {
+		q.Where("(lower(email) LIKE ? OR lower(firstName) LIKE ? OR lower(lastName) LIKE ?)", pagination.LikePrefix(strings.ToLower(filter.Query)))
+	}
+	if filter.CreatedFrom != nil {
+		q.Where("createdAt >= ?", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		q.Where("createdAt < ?", *filter.CreatedTo)
+	}
+	if filter.MinBalance != nil {
+		q.Where("balance >= ?", *filter.MinBalance)
+	}
+	if filter.MaxBalance != nil {
+		q.Where("balance <= ?", *filter.MaxBalance)
+	}
+	query := q.Build("SELECT id, firstName, lastName, email, balance, createdAt, emailVerifiedAt, roles, twoFactorEnabledAt, blockedAt FROM users", userSortColumns[params.Sort], params)
+
+	users := []model.User{}
+	rows, err := s.q.QueryContext(ctx, query, q.Args()...)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +156,7 @@ func (s *Store) GetUsers() ([]model.User, error) {
 
 	for rows.Next() {
 		var user model.User
-		if err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.Balance, &user.CreatedAt); err != nil {
+		if err := rows.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Balance, &user.CreatedAt, &user.EmailVerifiedAt, pq.Array(&user.Roles), &user.TwoFactorEnabledAt, &user.BlockedAt); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
@@ -115,27 +169,288 @@ func (s *Store) GetUsers() ([]model.User, error) {
 	return users, nil
 }
 
-func (s *Store) DeleteUser(id int) error {
-	_, err := s.db.Exec("DELETE FROM users WHERE id = $1", id)
+// DeleteUser marks the user deleted and revokes its tokens. The row stays
+// until the retention period ends, see EraseUser.
+func (s *Store) DeleteUser(ctx context.Context, id int) error {
+	now := time.Now()
+	_, err := s.q.ExecContext(ctx, "UPDATE users SET deletedAt = $1 WHERE id = $2 AND deletedAt IS NULL", now, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.q.ExecContext(ctx, "UPDATE user_tokens SET usedAt = $1 WHERE userID = $2 AND usedAt IS NULL", now, id)
 	return err
 }
 
-func (s *Store) DeleteAllUsers() error {
-	_, err := s.db.Exec("DELETE FROM users")
+func (s *Store) DeleteAllUsers(ctx context.Context) error {
+	now := time.Now()
+	_, err := s.q.ExecContext(ctx, "UPDATE users SET deletedAt = $1 WHERE deletedAt IS NULL", now)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.q.ExecContext(ctx, "UPDATE user_tokens SET usedAt = $1 WHERE usedAt IS NULL", now)
 	return err
 }
 
-func (s *Store) UpdateUser(user *model.User) error {
-	_, err := s.db.Exec(
-		"UPDATE users SET firstName=$1, lastName=$2, email=$3, password=$4, balance=$5, createdAt=$6 WHERE id=$7",
+func (s *Store) DeletedUsers(ctx context.Context, before time.Time, limit int) ([]int, error) {
+	rows, err := s.q.QueryContext(ctx,
+		"SELECT id FROM users WHERE deletedAt < $1 AND erasedAt IS NULL ORDER BY deletedAt, id LIMIT $2",
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+func (s *Store) EraseUser(ctx context.Context, id int) (bool, error) {
+	res, err := s.q.ExecContext(ctx,
+		`UPDATE users SET firstName = '', lastName = '', email = '', password = '', emailVerifiedAt = NULL,
+			roles = '{}', totpSecret = '', twoFactorEnabledAt = NULL, totpLastStep = 0, erasedAt = $1
+		WHERE id = $2 AND deletedAt IS NOT NULL AND erasedAt IS NULL`,
+		time.Now(),
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err := s.q.ExecContext(ctx, "DELETE FROM user_tokens WHERE userID = $1", id); err != nil {
+		return false, err
+	}
+	// Отправленные события больше не нужны, а в них имя и адрес пользователя
+	_, err = s.q.ExecContext(ctx,
+		"DELETE FROM outbox_events WHERE status = 'sent' AND payload->>'userID' = $1",
+		strconv.Itoa(id),
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *model.User) error {
+	_, err := s.q.ExecContext(ctx,
+		`UPDATE users SET firstName=$1, lastName=$2, email=$3, password=$4, passwordAlgorithm=$5, balance=$6, createdAt=$7, emailVerifiedAt=$8,
+			roles=$9, totpSecret=$10, twoFactorEnabledAt=$11, totpLastStep=$12, blockedAt=$13 WHERE id=$14`,
 		user.FirstName,
 		user.LastName,
 		user.Email,
 		user.Password,
+		user.PasswordAlgorithm,
 		user.Balance,
 		user.CreatedAt,
+		user.EmailVerifiedAt,
+		pq.Array(roles(user.Roles)),
+		user.TOTPSecret,
+		user.TwoFactorEnabledAt,
+		user.TOTPLastStep,
+		user.BlockedAt,
 		user.ID,
 	)
+	if errorCode(err) == uniqueViolation {
+		return model.ErrUserExists
+	}
+
+	return err
+}
+
+func (s *Store) AddLedgerEntry(ctx context.Context, entry *model.LedgerEntry) error {
+	err := s.q.QueryRowContext(ctx,
+		"INSERT INTO balance_ledger (userID, amount, balance, reason, comment, actorID, reference) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, createdAt",
+		entry.UserID,
+		entry.Amount,
+		entry.Balance,
+		entry.Reason,
+		entry.Comment,
+		entry.ActorID,
+		entry.Reference,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	switch errorCode(err) {
+	case foreignKeyViolation:
+		return model.ErrUserNotFound
+	case uniqueViolation:
+		return model.ErrReferenceUsed
+	}
+
+	return err
+}
+
+func (s *Store) ListLedger(ctx context.Context, userID int) ([]model.LedgerEntry, error) {
+	rows, err := s.q.QueryContext(ctx,
+		"SELECT "+ledgerColumns+" FROM balance_ledger WHERE userID = $1 ORDER BY createdAt, id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []model.LedgerEntry{}
+	for rows.Next() {
+		var entry model.LedgerEntry
+		if err := scanLedgerEntry(rows, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *Store) LedgerEntryByReference(ctx context.Context, reference string) (*model.LedgerEntry, error) {
+	var entry model.LedgerEntry
+	row := s.q.QueryRowContext(ctx, "SELECT "+ledgerColumns+" FROM balance_ledger WHERE reference = $1 AND reference <> ''", reference)
+	err := scanLedgerEntry(row, &entry)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+const ledgerColumns = "id, userID, amount, balance, reason, comment, actorID, reference, createdAt"
+
+func scanLedgerEntry(row interface{ Scan(dest ...any) error }, entry *model.LedgerEntry) error {
+	return row.Scan(&entry.ID, &entry.UserID, &entry.Amount, &entry.Balance, &entry.Reason, &entry.Comment, &entry.ActorID, &entry.Reference, &entry.CreatedAt)
+}
+
+func (s *Store) AddOutboxEvent(ctx context.Context, event model.OutboxEvent) error {
+	headers, err := json.Marshal(event.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox_events (eventType, payload, headers) VALUES ($1, $2, $3)`
+	_, err = s.q.ExecContext(ctx, query, event.EventType, string(event.Payload), string(headers))
+	return err
+}
+
+// PendingOutboxEvents locks up to limit unsent events. Rows locked by another
+// relay are skipped, so several replicas can drain the outbox concurrently.
+func (s *Store) PendingOutboxEvents(ctx context.Context, limit int) ([]model.OutboxEvent, error) {
+	query := `SELECT id, eventType, payload, headers, status, createdAt FROM outbox_events
+		WHERE status = 'pending' ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED`
+
+	rows, err := s.q.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []model.OutboxEvent
+	for rows.Next() {
+		var (
+			event   model.OutboxEvent
+			headers []byte
+		)
+		if err := rows.Scan(&event.ID, &event.EventType, &event.Payload, &headers, &event.Status, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(headers, &event.Headers); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+func (s *Store) MarkOutboxEventSent(ctx context.Context, id int) error {
+	_, err := s.q.ExecContext(ctx, `UPDATE outbox_events SET status = 'sent', sentAt = $1 WHERE id = $2`, time.Now(), id)
+	return err
+}
+
+func (s *Store) CreateToken(ctx context.Context, token *model.UserToken) error {
+	err := s.q.QueryRowContext(ctx,
+		"INSERT INTO user_tokens (userID, purpose, tokenHash, expiresAt) VALUES ($1, $2, $3, $4) RETURNING id, createdAt",
+		token.UserID,
+		token.Purpose,
+		token.Hash,
+		token.ExpiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+	if errorCode(err) == foreignKeyViolation {
+		return model.ErrUserNotFound
+	}
+
+	return err
+}
+
+func (s *Store) GetToken(ctx context.Context, purpose, hash string) (*model.UserToken, error) {
+	var token model.UserToken
+	err := s.q.QueryRowContext(ctx,
+		"SELECT id, userID, purpose, tokenHash, expiresAt, usedAt, createdAt FROM user_tokens WHERE purpose = $1 AND tokenHash = $2",
+		purpose,
+		hash,
+	).Scan(&token.ID, &token.UserID, &token.Purpose, &token.Hash, &token.ExpiresAt, &token.UsedAt, &token.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func (s *Store) UseToken(ctx context.Context, id int) error {
+	res, err := s.q.ExecContext(ctx, "UPDATE user_tokens SET usedAt = $1 WHERE id = $2 AND usedAt IS NULL", time.Now(), id)
+	if err != nil {
+		return err
+	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return model.ErrInvalidToken
+	}
+	return nil
+}
+
+func (s *Store) RevokeTokens(ctx context.Context, userID int, purpose string) error {
+	_, err := s.q.ExecContext(ctx,
+		"UPDATE user_tokens SET usedAt = $1 WHERE userID = $2 AND purpose = $3 AND usedAt IS NULL",
+		time.Now(),
+		userID,
+		purpose,
+	)
 	return err
 }
+
+// roles keeps the column NOT NULL for users without roles.
+func roles(r []string) []string {
+	if r == nil {
+		return []string{}
+	}
+	return r
+}
+
+func errorCode(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return string(pqErr.Code)
+	}
+	return ""
+}
@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"mini-shop/user-service/internal/database/dbtest"
+	"mini-shop/user-service/internal/model"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	db := dbtest.Open(t)
+
+	testUserStore(t, func(t *testing.T) model.UserStore {
+		return resetStore(t, db)
+	})
+}
+
+func TestStoreAuditLockHeldOnlyAtCommit(t *testing.T) {
+	db := dbtest.Open(t)
+	store := resetStore(t, db)
+	ctx := context.Background()
+
+	// Открытая транзакция с записью аудита не должна задерживать остальные
+	appended, release := make(chan struct{}), make(chan struct{})
+	slow := make(chan error, 1)
+	go func() {
+		slow <- store.InTx(ctx, func(tx model.UserStore) error {
+			if err := tx.AppendAudit(ctx, &model.AuditRecord{OccurredAt: time.Now(), Actor: "user:1", Action: "slow", Target: "user:1"}); err != nil {
+				return err
+			}
+			close(appended)
+			<-release
+			return nil
+		})
+	}()
+	<-appended
+
+	const writers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.AppendAudit(ctx, &model.AuditRecord{OccurredAt: time.Now(), Actor: "system", Action: "fast", Target: fmt.Sprintf("user:%d", i)})
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		close(release)
+		t.Fatal("appends waited for an open transaction that appended to the audit log")
+	}
+	close(release)
+	if err := <-slow; err != nil {
+		t.Fatalf("InTx: %v", err)
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+	}
+
+	chain, err := store.AuditChain(ctx, 0, 100)
+	if err != nil {
+		t.Fatalf("AuditChain: %v", err)
+	}
+	if len(chain) != writers+1 || chain[writers].Action != "slow" {
+		t.Fatalf("chain has %d records ending with %q, want %d ending with the slow one", len(chain), chain[len(chain)-1].Action, writers+1)
+	}
+	prevHash := ""
+	for _, record := range chain {
+		if record.PrevHash != prevHash || record.Hash != record.ComputeHash() {
+			t.Fatalf("record %d breaks the chain", record.ID)
+		}
+		prevHash = record.Hash
+	}
+}
+
+// resetStore empties every table the store writes to.
+func resetStore(t *testing.T, db *sql.DB) *Store {
+	t.Helper()
+
+	if _, err := db.Exec("TRUNCATE users, balance_ledger, outbox_events, user_tokens RESTART IDENTITY CASCADE"); err != nil {
+		t.Fatalf("truncate tables: %v", err)
+	}
+	// Журнал аудита защищён триггером от очистки, в тестах его отключаем
+	if _, err := db.Exec("ALTER TABLE audit_log DISABLE TRIGGER USER; TRUNCATE audit_log RESTART IDENTITY; ALTER TABLE audit_log ENABLE TRIGGER USER"); err != nil {
+		t.Fatalf("truncate audit log: %v", err)
+	}
+	return NewStore(db)
+}
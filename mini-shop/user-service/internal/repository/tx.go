@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"context"
+	"mini-shop/platform/database"
+	"mini-shop/user-service/internal/model"
+)
+
+// InTx runs fn against a store bound to a single transaction. The transaction
+// is committed when fn returns nil and rolled back otherwise. Calls made on a
+// store that is already inside a transaction reuse it. Audit records appended
+// by fn are written last, so the lock on the audit log is held only until the
+// commit.
+func (s *Store) InTx(ctx context.Context, fn func(tx model.UserStore) error) error {
+	if s.audit != nil {
+		return fn(s)
+	}
+
+	return database.InTx(ctx, s.db, s.q, func(q database.DBTX) error {
+		tx := &Store{db: s.db, q: q, audit: new([]*model.AuditRecord)}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.flushAudit(ctx)
+	})
+}
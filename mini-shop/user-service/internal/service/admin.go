@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"mini-shop/platform/pagination"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+	"slices"
+	"time"
+)
+
+// AdminService is what support staff use to manage accounts. Every change is
+// recorded in the audit log together with the acting admin.
+type AdminService struct {
+	store model.UserStore
+	now   func() time.Time
+}
+
+func NewAdminService(store model.UserStore) *AdminService {
+	return &AdminService{store: store, now: time.Now}
+}
+
+func (s *AdminService) SearchUsers(ctx context.Context, filter model.UserFilter, params pagination.Params) (pagination.Page[model.User], error) {
+	users, err := s.store.GetUsers(ctx, filter, params)
+	if err != nil {
+		return pagination.Page[model.User]{}, err
+	}
+	return pagination.NewPage(users, params, model.UserCursor), nil
+}
+
+// AuditLog returns the audit log, newest first unless params say otherwise.
+func (s *AdminService) AuditLog(ctx context.Context, filter model.AuditFilter, params pagination.Params) (pagination.Page[model.AuditRecord], error) {
+	records, err := s.store.ListAudit(ctx, filter, params)
+	if err != nil {
+		return pagination.Page[model.AuditRecord]{}, err
+	}
+	return pagination.NewPage(records, params, model.AuditCursor), nil
+}
+
+// GetUser returns userID with its balance history.
+func (s *AdminService) GetUser(ctx context.Context, userID int) (*model.UserDetails, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := s.store.ListLedger(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UserDetails{User: user, Ledger: ledger}, nil
+}
+
+// Block stops userID from logging in and makes its tokens fail. Blocking a
+// blocked user changes nothing.
+func (s *AdminService) Block(ctx context.Context, adminID, userID int) (*model.User, error) {
+	if adminID == userID {
+		return nil, model.ErrOwnAccount
+	}
+
+	return s.update(ctx, adminID, userID, audit.AccountBlocked, blockedState, func(user *model.User) bool {
+		if user.Blocked() {
+			return false
+		}
+		now := s.now()
+		user.BlockedAt = &now
+		return true
+	})
+}
+
+func (s *AdminService) Unblock(ctx context.Context, adminID, userID int) (*model.User, error) {
+	return s.update(ctx, adminID, userID, audit.AccountUnblocked, blockedState, func(user *model.User) bool {
+		if !user.Blocked() {
+			return false
+		}
+		user.BlockedAt = nil
+		return true
+	})
+}
+
+// SetRoles replaces the roles of userID. Admins cannot drop their own admin
+// role, so there is always someone left to manage accounts.
+func (s *AdminService) SetRoles(ctx context.Context, adminID, userID int, roles []string) (*model.User, error) {
+	if err := checkRoles(roles); err != nil {
+		return nil, err
+	}
+	if adminID == userID && !slices.Contains(roles, model.RoleAdmin) {
+		return nil, model.ErrOwnAccount
+	}
+
+	roles = slices.Compact(slices.Sorted(slices.Values(roles)))
+	return s.update(ctx, adminID, userID, audit.RolesChanged, rolesState, func(user *model.User) bool {
+		if slices.Equal(slices.Sorted(slices.Values(user.Roles)), roles) {
+			return false
+		}
+		user.Roles = roles
+		return true
+	})
+}
+
+// checkRoles fails with a validation error if roles has an unknown role.
+func checkRoles(roles []string) error {
+	var fields []model.FieldError
+	for _, role := range roles {
+		if !slices.Contains(model.Roles, role) {
+			fields = append(fields, model.FieldError{Field: "roles", Message: "unknown role " + role})
+		}
+	}
+	if len(fields) > 0 {
+		return model.ValidationFailed(fields)
+	}
+	return nil
+}
+
+func blockedState(user *model.User) any {
+	return map[string]any{"blocked": user.Blocked()}
+}
+
+func rolesState(user *model.User) any {
+	return map[string]any{"roles": slices.Sorted(slices.Values(user.Roles))}
+}
+
+// update applies change to userID in a transaction. If change reports that
+// something changed, it stores the user and logs action by adminID with the
+// state of the user before and after.
+func (s *AdminService) update(ctx context.Context, adminID, userID int, action string, state func(user *model.User) any, change func(user *model.User) bool) (*model.User, error) {
+	var user *model.User
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		var err error
+		user, err = tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		before := state(user)
+		if !change(user) {
+			return nil
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		return audit.Log(ctx, tx, audit.Entry{
+			Actor:  audit.User(adminID),
+			Action: action,
+			Target: audit.User(userID),
+			Before: before,
+			After:  state(user),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
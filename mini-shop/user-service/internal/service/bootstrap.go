@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+	"slices"
+	"strings"
+)
+
+// BootstrapAdmins makes admins of the accounts that verified one of the
+// admin emails before it was configured. Emails nobody has verified yet are
+// granted in VerifyEmail.
+func (s *UserService) BootstrapAdmins(ctx context.Context) error {
+	for _, email := range s.adminEmails {
+		err := s.store.InTx(ctx, func(tx model.UserStore) error {
+			user, err := tx.GetUserByEmail(ctx, email)
+			if err != nil {
+				return err
+			}
+			if user, err = tx.LockUserByID(ctx, user.ID); err != nil {
+				return err
+			}
+			return s.grantAdmin(ctx, tx, user)
+		})
+		if err != nil && !errors.Is(err, model.ErrUserNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// grantAdmin adds the admin role to user if its email is verified and is one
+// of the admin emails. Only the owner of the mailbox can verify it, so an
+// account registered with someone else's address gets nothing.
+func (s *UserService) grantAdmin(ctx context.Context, tx model.UserStore, user *model.User) error {
+	if !user.EmailVerified() || slices.Contains(user.Roles, model.RoleAdmin) {
+		return nil
+	}
+	if !slices.ContainsFunc(s.adminEmails, func(email string) bool { return strings.EqualFold(email, user.Email) }) {
+		return nil
+	}
+
+	before := rolesState(user)
+	user.Roles = append(slices.Clone(user.Roles), model.RoleAdmin)
+	if err := tx.UpdateUser(ctx, user); err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "admin role granted from the admin emails", "user_id", user.ID)
+	return audit.Log(ctx, tx, audit.Entry{
+		Actor:  audit.System,
+		Action: audit.RolesChanged,
+		Target: audit.User(user.ID),
+		Before: before,
+		After:  rolesState(user),
+	})
+}
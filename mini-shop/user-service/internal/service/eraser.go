@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"mini-shop/platform/outbox"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+	"time"
+)
+
+// DefaultAccountRetention is how long the personal data of a deleted account
+// is kept before it is erased.
+const DefaultAccountRetention = 30 * 24 * time.Hour
+
+const eraseBatchSize = 100
+
+// Eraser removes the personal data of accounts deleted more than the retention
+// period ago. Each erasure is announced with user.erased, on which the other
+// services anonymize their copies; balances, orders and payments stay for the
+// books.
+type Eraser struct {
+	store     model.UserStore
+	outbox    *outbox.Relay
+	retention time.Duration
+	now       func() time.Time
+}
+
+func NewEraser(store model.UserStore, outbox *outbox.Relay, retention time.Duration) *Eraser {
+	return &Eraser{store: store, outbox: outbox, retention: retention, now: time.Now}
+}
+
+// Run erases due accounts every hour until ctx is cancelled.
+func (e *Eraser) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		if _, err := e.EraseDue(ctx); err != nil {
+			slog.ErrorContext(ctx, "failed to erase deleted accounts", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EraseDue erases every account whose retention period is over and returns
+// how many it erased.
+func (e *Eraser) EraseDue(ctx context.Context) (int, error) {
+	erased := 0
+	for {
+		ids, err := e.store.DeletedUsers(ctx, e.now().Add(-e.retention), eraseBatchSize)
+		if err != nil {
+			return erased, err
+		}
+
+		for _, id := range ids {
+			ok, err := e.erase(ctx, id)
+			if err != nil {
+				return erased, err
+			}
+			if ok {
+				erased++
+			}
+		}
+		if len(ids) < eraseBatchSize {
+			return erased, nil
+		}
+	}
+}
+
+// erase anonymizes one account. Another instance may have erased it first, in
+// which case there is nothing to announce.
+func (e *Eraser) erase(ctx context.Context, userID int) (bool, error) {
+	var erased bool
+	err := e.store.InTx(ctx, func(tx model.UserStore) error {
+		var err error
+		erased, err = tx.EraseUser(ctx, userID)
+		if err != nil || !erased {
+			return err
+		}
+		err = audit.Log(ctx, tx, audit.Entry{
+			Actor:  audit.System,
+			Action: audit.AccountErased,
+			Target: audit.User(userID),
+			Before: map[string]any{"erased": false},
+			After:  map[string]any{"erased": true},
+		})
+		if err != nil {
+			return err
+		}
+		return addEvent(ctx, tx, "user.erased", map[string]interface{}{
+			"type":   "UserErased",
+			"userID": userID,
+		})
+	})
+	if err != nil || !erased {
+		return false, err
+	}
+	e.outbox.Notify()
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mini-shop/platform/logger"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// ExportConfig points at the services that keep data of a user besides this
+// one, e.g. "http://localhost:8081". A service without a URL is left out of
+// exports.
+type ExportConfig struct {
+	OrderServiceURL        string
+	PaymentServiceURL      string
+	NotificationServiceURL string
+	// Timeout limits each request to another service.
+	Timeout time.Duration
+}
+
+var DefaultExportConfig = ExportConfig{Timeout: 10 * time.Second}
+
+// ExportService collects everything the shop keeps about a user into one
+// document, as the right of access requires.
+type ExportService struct {
+	store  model.UserStore
+	config ExportConfig
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewExportService(store model.UserStore, config ExportConfig) *ExportService {
+	return &ExportService{
+		store:  store,
+		config: config,
+		client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: config.Timeout},
+		now:    time.Now,
+	}
+}
+
+// Export returns the profile and balance history of userID together with the
+// sections every other service returns from GET /api/v1/me/export, such as
+// "orders". The services are called with the user's own authorization header,
+// so each returns only what belongs to the user. If one of them fails, there
+// is no partial export.
+func (s *ExportService) Export(ctx context.Context, userID int, authorization string) (map[string]any, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := s.store.ListLedger(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	services := map[string]string{
+		"order-service":        s.config.OrderServiceURL,
+		"payment-service":      s.config.PaymentServiceURL,
+		"notification-service": s.config.NotificationServiceURL,
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		sections = map[string]json.RawMessage{}
+		failed   bool
+	)
+	for name, baseURL := range services {
+		if baseURL == "" {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := s.fetch(ctx, baseURL, authorization)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to export user data", "service", name, "user_id", userID, "error", err)
+				failed = true
+				return
+			}
+			for key, section := range got {
+				sections[key] = section
+			}
+		}()
+	}
+	wg.Wait()
+	if failed {
+		return nil, model.ErrExportUnavailable
+	}
+
+	export := map[string]any{}
+	for key, section := range sections {
+		export[key] = section
+	}
+	export["exportedAt"] = s.now().UTC()
+	export["profile"] = user
+	export["balanceHistory"] = ledger
+
+	err = audit.Log(ctx, s.store, audit.Entry{Actor: audit.User(userID), Action: audit.AccountExported, Target: audit.User(userID)})
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// fetch returns the sections of the export of the service at baseURL.
+func (s *ExportService) fetch(ctx context.Context, baseURL, authorization string) (map[string]json.RawMessage, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(baseURL, "/")+"/api/v1/me/export", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", authorization)
+	if id := logger.CorrelationID(ctx); id != "" {
+		req.Header.Set(logger.CorrelationIDHeader, id)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("export failed with status: %d", resp.StatusCode)
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&sections); err != nil {
+		return nil, fmt.Errorf("malformed export: %w", err)
+	}
+	return sections, nil
+}
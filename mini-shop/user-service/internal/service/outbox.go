@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+	"mini-shop/platform/outbox"
+	"mini-shop/user-service/internal/messaging"
+	"mini-shop/user-service/internal/model"
+)
+
+// NewOutboxRelay publishes the events written to the outbox of store.
+func NewOutboxRelay(store model.UserStore, publisher messaging.Publisher) *outbox.Relay {
+	return outbox.NewRelay(func(ctx context.Context, fn func(tx outbox.Store) error) error {
+		return store.InTx(ctx, func(tx model.UserStore) error {
+			return fn(tx)
+		})
+	}, publisher)
+}
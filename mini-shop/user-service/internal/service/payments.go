@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/model"
+)
+
+// PaymentEventKeys are the payment-service events whose decisions go into the
+// audit log. Each key gets a queue of its own, so the auditor does not take
+// messages from other consumers of "payment.*".
+var PaymentEventKeys = []string{"payment.completed", "payment.failed", "payment.refunded"}
+
+// paymentActions maps payment event types to audit actions.
+var paymentActions = map[string]string{
+	"PaymentCompleted": audit.PaymentCompleted,
+	"PaymentFailed":    audit.PaymentFailed,
+	"PaymentRefunded":  audit.PaymentRefunded,
+}
+
+// paymentEvent is the part of a payment-service event the audit log keeps.
+// The email is left out on purpose.
+type paymentEvent struct {
+	EventID string  `json:"eventID"`
+	Type    string  `json:"type"`
+	OrderID int     `json:"orderID"`
+	UserID  int     `json:"userID"`
+	Amount  float64 `json:"amount"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// PaymentAuditor records the decisions payment-service announces, so money
+// moved for orders shows up in the same audit log as balance changes.
+type PaymentAuditor struct {
+	store model.AuditStore
+}
+
+func NewPaymentAuditor(store model.AuditStore) *PaymentAuditor {
+	return &PaymentAuditor{store: store}
+}
+
+// HandleEvent logs one payment event. Redelivered events are logged once;
+// unknown types are skipped.
+func (a *PaymentAuditor) HandleEvent(ctx context.Context, body []byte) error {
+	var event paymentEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return fmt.Errorf("failed to unmarshal payment event: %w", err)
+	}
+
+	action, ok := paymentActions[event.Type]
+	if !ok {
+		slog.DebugContext(ctx, "payment event not audited", "type", event.Type)
+		return nil
+	}
+
+	after := map[string]any{"userID": event.UserID, "amount": event.Amount}
+	if event.Reason != "" {
+		after["reason"] = event.Reason
+	}
+	return audit.Log(ctx, a.store, audit.Entry{
+		Actor:   audit.PaymentService,
+		Action:  action,
+		Target:  audit.Order(event.OrderID),
+		After:   after,
+		EventID: event.EventID,
+	})
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/model"
+)
+
+// UpdateProfile changes the name and email of userID and announces the new
+// profile with user.updated. A new email is unverified until the user follows
+// the link mailed to it.
+func (s *UserService) UpdateProfile(ctx context.Context, userID int, update model.UpdateProfilePayload) (*model.User, error) {
+	var updated *model.User
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		updated = user
+
+		var fields []string
+		if update.FirstName != nil && *update.FirstName != user.FirstName {
+			user.FirstName = *update.FirstName
+			fields = append(fields, "firstName")
+		}
+		if update.LastName != nil && *update.LastName != user.LastName {
+			user.LastName = *update.LastName
+			fields = append(fields, "lastName")
+		}
+		previousEmail := user.Email
+		if update.Email != nil && *update.Email != user.Email {
+			user.Email = *update.Email
+			user.EmailVerifiedAt = nil
+			fields = append(fields, "email")
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		if user.Email != previousEmail {
+			// Старые ссылки вели на прежний адрес
+			if err := tx.RevokeTokens(ctx, user.ID, model.TokenResetPassword); err != nil {
+				return err
+			}
+			link, err := s.issueToken(ctx, tx, user.ID, model.TokenVerifyEmail)
+			if err != nil {
+				return err
+			}
+			err = addEvent(ctx, tx, "user.verification_requested", map[string]interface{}{
+				"type":            "VerificationRequested",
+				"userID":          user.ID,
+				"email":           user.Email,
+				"firstName":       user.FirstName,
+				"verificationURL": link,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return addUserUpdated(ctx, tx, user, fields, previousEmail)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.outbox.Notify()
+
+	slog.InfoContext(ctx, "profile updated", "user_id", userID)
+	return updated, nil
+}
+
+// ChangePassword replaces the password of userID after checking the current
+// one.
+func (s *UserService) ChangePassword(ctx context.Context, userID int, oldPassword, newPassword string) error {
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !auth.VerifyPassword(user, oldPassword) {
+			return model.ErrWrongPassword
+		}
+
+		if err := s.setPassword(user, "newPassword", newPassword); err != nil {
+			return err
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := tx.RevokeTokens(ctx, user.ID, model.TokenResetPassword); err != nil {
+			return err
+		}
+		return addUserUpdated(ctx, tx, user, []string{"password"}, user.Email)
+	})
+	if err != nil {
+		return err
+	}
+	s.outbox.Notify()
+
+	slog.InfoContext(ctx, "password changed", "user_id", userID)
+	return nil
+}
+
+// DeleteAccount deletes userID after checking its password, see DeleteUser.
+func (s *UserService) DeleteAccount(ctx context.Context, userID int, password string) error {
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !auth.VerifyPassword(user, password) {
+			return model.ErrWrongPassword
+		}
+		return deleteUser(ctx, tx, audit.User(userID), user)
+	})
+	if err != nil {
+		return err
+	}
+	s.outbox.Notify()
+	return nil
+}
+
+// DeleteUser deletes userID and announces it with user.deleted. The account
+// stops working at once; its personal data stays until the Eraser removes it
+// after the retention period. adminID is the admin who deletes it.
+func (s *UserService) DeleteUser(ctx context.Context, adminID, userID int) error {
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		return deleteUser(ctx, tx, audit.User(adminID), user)
+	})
+	if err != nil {
+		return err
+	}
+	s.outbox.Notify()
+	return nil
+}
+
+// DeleteAllUsers deletes every account on behalf of adminID. Unlike
+// DeleteUser it announces nothing.
+func (s *UserService) DeleteAllUsers(ctx context.Context, adminID int) error {
+	return s.store.InTx(ctx, func(tx model.UserStore) error {
+		if err := tx.DeleteAllUsers(ctx); err != nil {
+			return err
+		}
+		return audit.Log(ctx, tx, audit.Entry{Actor: audit.User(adminID), Action: audit.AccountDeleted, Target: audit.AllUsers})
+	})
+}
+
+// deleteUser deletes user on behalf of actor.
+func deleteUser(ctx context.Context, tx model.UserStore, actor string, user *model.User) error {
+	if err := tx.DeleteUser(ctx, user.ID); err != nil {
+		return err
+	}
+	err := audit.Log(ctx, tx, audit.Entry{
+		Actor:  actor,
+		Action: audit.AccountDeleted,
+		Target: audit.User(user.ID),
+		Before: map[string]any{"deleted": false, "balance": user.Balance},
+		After:  map[string]any{"deleted": true},
+	})
+	if err != nil {
+		return err
+	}
+	return addEvent(ctx, tx, "user.deleted", map[string]interface{}{
+		"type":   "UserDeleted",
+		"userID": user.ID,
+		"email":  user.Email,
+	})
+}
+
+// addUserUpdated announces the current profile of user. fields lists what
+// changed, so consumers can skip updates that do not concern them.
+func addUserUpdated(ctx context.Context, tx model.UserStore, user *model.User, fields []string, previousEmail string) error {
+	return addEvent(ctx, tx, "user.updated", map[string]interface{}{
+		"type":          "UserUpdated",
+		"userID":        user.ID,
+		"email":         user.Email,
+		"previousEmail": previousEmail,
+		"firstName":     user.FirstName,
+		"lastName":      user.LastName,
+		"emailVerified": user.EmailVerified(),
+		"fields":        fields,
+	})
+}
@@ -1,68 +1,211 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"mini-shop/platform/logger"
+	"mini-shop/platform/outbox"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/metrics"
 	"mini-shop/user-service/internal/model"
 )
 
 type BalanceService struct {
-	store model.UserStore
+	store  model.UserStore
+	outbox *outbox.Relay
+	// lowBalance is the threshold below which a withdrawal announces
+	// user.balance_low; zero disables the event.
+	lowBalance float64
 }
 
-func NewBalanceService(store model.UserStore) *BalanceService {
+func NewBalanceService(store model.UserStore, outbox *outbox.Relay, lowBalance float64) *BalanceService {
 	return &BalanceService{
-		store: store,
+		store:      store,
+		outbox:     outbox,
+		lowBalance: lowBalance,
 	}
 }
 
-func (s *BalanceService) GetBalance(userID int) (float64, error) {
-	user, err := s.store.GetUserByID(userID)
+func (s *BalanceService) GetBalance(ctx context.Context, userID int) (float64, error) {
+	user, err := s.store.GetUserByID(ctx, userID)
 	if err != nil {
-		log.Printf("Error getting user by ID %d: %v", userID, err)
-		return 0, fmt.Errorf("user not found")
+		slog.ErrorContext(ctx, "failed to get user", "user_id", userID, "error", err)
+		return 0, err
 	}
 	return user.Balance, nil
 }
 
-func (s *BalanceService) AddBalance(userID int, amount float64) (float64, error) {
-	user, err := s.store.GetUserByID(userID)
+// AddBalance credits amount to userID. A non-empty reference names the
+// payment behind the credit: repeating it returns the balance without
+// crediting again, so callers can retry.
+func (s *BalanceService) AddBalance(ctx context.Context, userID int, amount float64, reference string) (float64, error) {
+	if amount < 0 {
+		return 0, model.InvalidInput("cannot add a negative amount")
+	}
+
+	balance, _, err := s.changeBalance(ctx, model.LedgerEntry{UserID: userID, Amount: amount, Reason: model.LedgerTopUp, Reference: reference})
+	return balance, err
+}
+
+// Withdraw charges amount to userID. A non-empty reference names the payment
+// the money is taken for and makes retries safe, as in AddBalance.
+func (s *BalanceService) Withdraw(ctx context.Context, userID int, amount float64, reference string) (float64, error) {
+	balance, applied, err := s.changeBalance(ctx, model.LedgerEntry{UserID: userID, Amount: -amount, Reason: model.LedgerWithdrawal, Reference: reference})
 	if err != nil {
-		log.Printf("Error getting user by ID %d: %v", userID, err)
-		return 0, fmt.Errorf("user not found")
+		return 0, err
+	}
+
+	// Повтор уже списанного платежа деньги не двигает и не считается
+	if applied {
+		metrics.BalanceWithdrawn.Add(amount)
 	}
 
+	return balance, nil
+}
+
+// Refund returns amount taken by the payment named by reference to userID,
+// when the order is cancelled or the payment voided. Refunds are recorded
+// apart from top-ups and are safe to retry, as in AddBalance.
+func (s *BalanceService) Refund(ctx context.Context, userID int, amount float64, reference string) (float64, error) {
 	if amount < 0 {
-		return 0, fmt.Errorf("cannot add a negative amount")
+		return 0, model.InvalidInput("cannot refund a negative amount")
+	}
+	if reference == "" {
+		return 0, model.InvalidInput("a refund must name the payment it returns")
 	}
 
-	user.Balance += amount
-	err = s.store.UpdateUser(user)
-	if err != nil {
-		log.Printf("Error updating user balance for user ID %d: %v", userID, err)
-		return 0, fmt.Errorf("failed to update balance")
+	balance, _, err := s.changeBalance(ctx, model.LedgerEntry{UserID: userID, Amount: amount, Reason: model.LedgerRefund, Reference: reference})
+	return balance, err
+}
+
+// Adjust changes the balance of userID by amount on behalf of adminID, who
+// has to give the reason. The balance cannot go below zero.
+func (s *BalanceService) Adjust(ctx context.Context, adminID, userID int, amount float64, reason string) (float64, error) {
+	if amount == 0 {
+		return 0, model.InvalidInput("amount must not be zero")
 	}
 
-	return user.Balance, nil
+	balance, _, err := s.changeBalance(ctx, model.LedgerEntry{
+		UserID:  userID,
+		Amount:  amount,
+		Reason:  model.LedgerAdjustment,
+		Comment: reason,
+		ActorID: &adminID,
+	})
+	return balance, err
+}
+
+// balanceActions maps ledger reasons to audit actions.
+var balanceActions = map[string]string{
+	model.LedgerTopUp:      audit.BalanceToppedUp,
+	model.LedgerWithdrawal: audit.BalanceWithdrawn,
+	model.LedgerAdjustment: audit.BalanceAdjusted,
+	model.LedgerRefund:     audit.BalanceRefunded,
 }
 
-func (s *BalanceService) Withdraw(userID int, amount float64) (float64, error) {
-	user, err := s.store.GetUserByID(userID)
+// changeBalance applies entry.Amount to the user's balance and records entry
+// with the resulting balance in the ledger and the audit log within one
+// transaction. Changes without entry.ActorID are made for other services and
+// logged as the system's. It reports whether the change was applied; a
+// repeated entry.Reference returns the balance without changing it.
+func (s *BalanceService) changeBalance(ctx context.Context, entry model.LedgerEntry) (float64, bool, error) {
+	userID, delta := entry.UserID, entry.Amount
+	var (
+		balance float64
+		applied bool
+	)
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		if entry.Reference != "" {
+			applied, err := tx.LedgerEntryByReference(ctx, entry.Reference)
+			if err != nil {
+				return err
+			}
+			if applied != nil {
+				if applied.UserID != userID || applied.Amount != delta {
+					return model.ErrReferenceUsed
+				}
+				// Повтор того же платежа: баланс уже изменён
+				balance = user.Balance
+				return nil
+			}
+		}
+
+		if user.Balance+delta < 0 {
+			return model.ErrInsufficientFunds
+		}
+
+		crossed := user.Balance >= s.lowBalance && user.Balance+delta < s.lowBalance
+		before := map[string]any{"balance": user.Balance}
+		user.Balance += delta
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		entry.Balance = user.Balance
+		if err := tx.AddLedgerEntry(ctx, &entry); err != nil {
+			return fmt.Errorf("failed to record ledger entry: %w", err)
+		}
+
+		actor := audit.System
+		if entry.ActorID != nil {
+			actor = audit.User(*entry.ActorID)
+		}
+		after := map[string]any{"balance": user.Balance, "amount": delta, "ledgerEntryID": entry.ID}
+		if entry.Comment != "" {
+			after["reason"] = entry.Comment
+		}
+		if entry.Reference != "" {
+			after["reference"] = entry.Reference
+		}
+		err = audit.Log(ctx, tx, audit.Entry{
+			Actor:  actor,
+			Action: balanceActions[entry.Reason],
+			Target: audit.User(userID),
+			Before: before,
+			After:  after,
+		})
+		if err != nil {
+			return err
+		}
+
+		balance, applied = user.Balance, true
+		if !crossed {
+			return nil
+		}
+		return addEvent(ctx, tx, "user.balance_low", map[string]interface{}{
+			"type":      "BalanceLow",
+			"userID":    user.ID,
+			"email":     user.Email,
+			"firstName": user.FirstName,
+			"balance":   user.Balance,
+			"threshold": s.lowBalance,
+		})
+	})
 	if err != nil {
-		log.Printf("Error getting user by ID %d: %v", userID, err)
-		return 0, fmt.Errorf("user not found")
+		slog.ErrorContext(ctx, "failed to change balance", "user_id", userID, "reason", entry.Reason, "error", err)
+		return 0, false, err
 	}
+	s.outbox.Notify()
 
-	if user.Balance < amount {
-		return 0, fmt.Errorf("insufficient funds")
-	}
+	return balance, applied, nil
+}
 
-	user.Balance -= amount
-	err = s.store.UpdateUser(user)
+// addEvent writes event to the outbox of tx; the relay publishes it under
+// routingKey once the transaction commits.
+func addEvent(ctx context.Context, tx model.UserStore, routingKey string, event map[string]interface{}) error {
+	// Идентификатор хранится в outbox вместе с событием, по нему получатели
+	// отбрасывают дубликаты
+	event["eventID"] = logger.NewID()
+	body, err := json.Marshal(event)
 	if err != nil {
-		log.Printf("Error updating user balance for user ID %d: %v", userID, err)
-		return 0, fmt.Errorf("failed to update balance")
+		return fmt.Errorf("failed to marshal event: %w", err)
 	}
-
-	return user.Balance, nil
+	return tx.AddOutboxEvent(ctx, outbox.NewEvent(ctx, routingKey, body))
 }
@@ -0,0 +1,869 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mini-shop/platform/outbox"
+	"mini-shop/platform/pagination"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/messaging"
+	"mini-shop/user-service/internal/metrics"
+	"mini-shop/user-service/internal/model"
+	"mini-shop/user-service/internal/repository"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct-horse-battery"
+
+var testTokens = TokenConfig{
+	Secret:    []byte("test-secret"),
+	VerifyURL: "https://shop.test/api/v1/email/verify",
+	ResetURL:  "https://shop.test/reset-password",
+	VerifyTTL: time.Hour,
+	ResetTTL:  time.Hour,
+}
+
+func newOutbox(t *testing.T, store model.UserStore) *outbox.Relay {
+	t.Helper()
+
+	broker := messaging.NewMemoryBroker()
+	t.Cleanup(broker.Close)
+	return NewOutboxRelay(store, broker)
+}
+
+func pendingEvents(t *testing.T, store model.UserStore) []model.OutboxEvent {
+	t.Helper()
+
+	events, err := store.PendingOutboxEvents(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("PendingOutboxEvents: %v", err)
+	}
+	return events
+}
+
+func newBalanceService(t *testing.T) (*BalanceService, int) {
+	s, _, userID := newBalanceServiceWithStore(t, 0)
+	return s, userID
+}
+
+func newBalanceServiceWithStore(t *testing.T, lowBalance float64) (*BalanceService, *repository.MemoryStore, int) {
+	t.Helper()
+
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	if err := store.CreateUser(ctx, model.User{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com", Password: "hash"}); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	u, err := store.GetUserByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+
+	return NewBalanceService(store, newOutbox(t, store), lowBalance), store, u.ID
+}
+
+func TestBalanceService_AddAndWithdraw(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newBalanceService(t)
+
+	if balance, err := s.AddBalance(ctx, userID, 100, ""); err != nil || balance != 100 {
+		t.Fatalf("AddBalance = %v, %v; want 100, nil", balance, err)
+	}
+	if balance, err := s.Withdraw(ctx, userID, 30, ""); err != nil || balance != 70 {
+		t.Fatalf("Withdraw = %v, %v; want 70, nil", balance, err)
+	}
+	if balance, err := s.GetBalance(ctx, userID); err != nil || balance != 70 {
+		t.Fatalf("GetBalance = %v, %v; want 70, nil", balance, err)
+	}
+}
+
+func TestBalanceService_WithdrawInsufficientFunds(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newBalanceService(t)
+
+	if _, err := s.AddBalance(ctx, userID, 20, ""); err != nil {
+		t.Fatalf("AddBalance: %v", err)
+	}
+
+	if _, err := s.Withdraw(ctx, userID, 50, ""); !errors.Is(err, model.ErrInsufficientFunds) {
+		t.Fatalf("Withdraw error = %v, want %v", err, model.ErrInsufficientFunds)
+	}
+	if balance, _ := s.GetBalance(ctx, userID); balance != 20 {
+		t.Errorf("balance after failed withdrawal = %v, want 20", balance)
+	}
+}
+
+func TestBalanceService_RejectsNegativeTopUp(t *testing.T) {
+	s, userID := newBalanceService(t)
+
+	_, err := s.AddBalance(context.Background(), userID, -5, "")
+
+	var domainErr *model.Error
+	if !errors.As(err, &domainErr) || domainErr.Kind != model.KindInvalid {
+		t.Fatalf("AddBalance error = %v, want an invalid input error", err)
+	}
+}
+
+func TestBalanceService_AddBalanceOncePerReference(t *testing.T) {
+	ctx := context.Background()
+	s, store, userID := newBalanceServiceWithStore(t, 0)
+
+	for range 2 {
+		if balance, err := s.AddBalance(ctx, userID, 40, "topup:1"); err != nil || balance != 40 {
+			t.Fatalf("AddBalance = %v, %v; want 40, nil", balance, err)
+		}
+	}
+	if _, err := s.AddBalance(ctx, userID, 15, "topup:1"); !errors.Is(err, model.ErrReferenceUsed) {
+		t.Errorf("AddBalance with another amount: error = %v, want %v", err, model.ErrReferenceUsed)
+	}
+
+	ledger, err := store.ListLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("ListLedger: %v", err)
+	}
+	if len(ledger) != 1 || ledger[0].Reference != "topup:1" {
+		t.Errorf("ledger = %+v, want one entry for topup:1", ledger)
+	}
+}
+
+func TestBalanceService_WithdrawOncePerReference(t *testing.T) {
+	ctx := context.Background()
+	s, store, userID := newBalanceServiceWithStore(t, 0)
+
+	if _, err := s.AddBalance(ctx, userID, 100, ""); err != nil {
+		t.Fatalf("AddBalance: %v", err)
+	}
+	withdrawn := testutil.ToFloat64(metrics.BalanceWithdrawn)
+	// Повторная доставка order.created списывает деньги только один раз
+	for range 2 {
+		if balance, err := s.Withdraw(ctx, userID, 30, "order:1"); err != nil || balance != 70 {
+			t.Fatalf("Withdraw = %v, %v; want 70, nil", balance, err)
+		}
+	}
+	if got := testutil.ToFloat64(metrics.BalanceWithdrawn) - withdrawn; got != 30 {
+		t.Errorf("withdrawn metric grew by %v, want 30", got)
+	}
+	if _, err := s.Withdraw(ctx, userID, 10, "order:1"); !errors.Is(err, model.ErrReferenceUsed) {
+		t.Errorf("Withdraw with another amount: error = %v, want %v", err, model.ErrReferenceUsed)
+	}
+
+	ledger, err := store.ListLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("ListLedger: %v", err)
+	}
+	if len(ledger) != 2 || ledger[1].Reference != "order:1" {
+		t.Errorf("ledger = %+v, want a top-up and one withdrawal for order:1", ledger)
+	}
+}
+
+func TestBalanceService_UnknownUser(t *testing.T) {
+	ctx := context.Background()
+	s, userID := newBalanceService(t)
+
+	if _, err := s.GetBalance(ctx, userID+1); !errors.Is(err, model.ErrUserNotFound) {
+		t.Errorf("GetBalance error = %v, want %v", err, model.ErrUserNotFound)
+	}
+	if _, err := s.Withdraw(ctx, userID+1, 10, ""); !errors.Is(err, model.ErrUserNotFound) {
+		t.Errorf("Withdraw error = %v, want %v", err, model.ErrUserNotFound)
+	}
+}
+
+func TestBalanceService_WithdrawBelowThreshold(t *testing.T) {
+	ctx := context.Background()
+	s, store, userID := newBalanceServiceWithStore(t, 50)
+
+	if _, err := s.AddBalance(ctx, userID, 100, ""); err != nil {
+		t.Fatalf("AddBalance: %v", err)
+	}
+	if _, err := s.Withdraw(ctx, userID, 40, ""); err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	if events := pendingEvents(t, store); len(events) != 0 {
+		t.Fatalf("outbox above the threshold = %+v, want no events", events)
+	}
+
+	if _, err := s.Withdraw(ctx, userID, 20, ""); err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	// Повторное списание ниже порога не дублирует уведомление
+	if _, err := s.Withdraw(ctx, userID, 10, ""); err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+
+	events := pendingEvents(t, store)
+	if len(events) != 1 || events[0].EventType != "user.balance_low" {
+		t.Fatalf("outbox = %+v, want one user.balance_low event", events)
+	}
+
+	var event struct {
+		EventID   string  `json:"eventID"`
+		UserID    int     `json:"userID"`
+		Balance   float64 `json:"balance"`
+		Threshold float64 `json:"threshold"`
+	}
+	if err := json.Unmarshal(events[0].Payload, &event); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if event.EventID == "" || event.UserID != userID || event.Balance != 40 || event.Threshold != 50 {
+		t.Errorf("event = %+v, want an event ID, user %d, balance 40 and threshold 50", event, userID)
+	}
+}
+
+func TestUserService_Register(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if u.ID == 0 {
+		t.Errorf("registered user = %+v, want an ID", u)
+	}
+
+	events := pendingEvents(t, store)
+	if len(events) != 1 || events[0].EventType != "user.registered" {
+		t.Fatalf("outbox = %+v, want one user.registered event", events)
+	}
+
+	if _, err := s.Register(ctx, model.User{Email: "ann@example.com"}, testPassword); !errors.Is(err, model.ErrUserExists) {
+		t.Fatalf("second Register error = %v, want %v", err, model.ErrUserExists)
+	}
+	if _, err := s.Register(ctx, model.User{Email: "bob@example.com"}, "short"); !isValidationError(err) {
+		t.Fatalf("Register with a short password error = %v, want validation_failed", err)
+	}
+	if events := pendingEvents(t, store); len(events) != 1 {
+		t.Errorf("outbox after a failed registration = %+v, want only the first event", events)
+	}
+}
+
+// eventLink returns the token of the link in field of the outbox event.
+func eventLink(t *testing.T, event model.OutboxEvent, field string) (string, string) {
+	t.Helper()
+
+	var payload map[string]any
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	link, _ := payload[field].(string)
+	u, err := url.Parse(link)
+	if err != nil || u.Query().Get("token") == "" {
+		t.Fatalf("%s event %s = %q, want a link with a token", event.EventType, field, link)
+	}
+	return link, u.Query().Get("token")
+}
+
+func TestUserService_VerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if u.EmailVerified() {
+		t.Fatalf("registered user = %+v, want an unverified email", u)
+	}
+	link, first := eventLink(t, pendingEvents(t, store)[0], "verificationURL")
+	if !strings.HasPrefix(link, testTokens.VerifyURL+"?token=") {
+		t.Errorf("verification link = %q, want it to point at %s", link, testTokens.VerifyURL)
+	}
+
+	// Новая ссылка отменяет прежнюю
+	if err := s.ResendVerification(ctx, u.ID); err != nil {
+		t.Fatalf("ResendVerification: %v", err)
+	}
+	events := pendingEvents(t, store)
+	if len(events) != 2 || events[1].EventType != "user.verification_requested" {
+		t.Fatalf("outbox = %+v, want user.verification_requested", events)
+	}
+	_, second := eventLink(t, events[1], "verificationURL")
+	if _, err := s.VerifyEmail(ctx, first); !errors.Is(err, model.ErrInvalidToken) {
+		t.Errorf("VerifyEmail with the replaced link error = %v, want %v", err, model.ErrInvalidToken)
+	}
+
+	verified, err := s.VerifyEmail(ctx, second)
+	if err != nil || !verified.EmailVerified() {
+		t.Fatalf("VerifyEmail = %+v, %v; want a verified user", verified, err)
+	}
+	if _, err := s.VerifyEmail(ctx, second); !errors.Is(err, model.ErrInvalidToken) {
+		t.Errorf("second VerifyEmail error = %v, want %v", err, model.ErrInvalidToken)
+	}
+	if err := s.ResendVerification(ctx, u.ID); !errors.Is(err, model.ErrEmailVerified) {
+		t.Errorf("ResendVerification after verifying error = %v, want %v", err, model.ErrEmailVerified)
+	}
+}
+
+func TestUserService_ResetPassword(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err := s.ForgotPassword(ctx, "bob@example.com"); err != nil {
+		t.Fatalf("ForgotPassword for an unknown email: %v", err)
+	}
+	if events := pendingEvents(t, store); len(events) != 1 {
+		t.Fatalf("outbox = %+v, want no event for an unknown email", events)
+	}
+
+	if err := s.ForgotPassword(ctx, "ann@example.com"); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	events := pendingEvents(t, store)
+	if len(events) != 2 || events[1].EventType != "user.password_reset_requested" {
+		t.Fatalf("outbox = %+v, want user.password_reset_requested", events)
+	}
+	_, token := eventLink(t, events[1], "resetURL")
+
+	if err := s.ResetPassword(ctx, "forged", "new-horse-battery"); !errors.Is(err, model.ErrInvalidToken) {
+		t.Errorf("ResetPassword with a forged token error = %v, want %v", err, model.ErrInvalidToken)
+	}
+	// Отклонённый пароль не расходует ссылку
+	if err := s.ResetPassword(ctx, token, "password123"); !isValidationError(err) {
+		t.Fatalf("ResetPassword with a breached password error = %v, want validation_failed", err)
+	}
+	if err := s.ResetPassword(ctx, token, "new-horse-battery"); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+	got, err := store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if !auth.VerifyPassword(got, "new-horse-battery") || !got.EmailVerified() {
+		t.Errorf("user after reset = %+v, want the new password and a verified email", got)
+	}
+	if err := s.ResetPassword(ctx, token, "other-horse-battery"); !errors.Is(err, model.ErrInvalidToken) {
+		t.Errorf("second ResetPassword error = %v, want %v", err, model.ErrInvalidToken)
+	}
+}
+
+func TestUserService_ResetPasswordExpired(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	tokens := testTokens
+	tokens.ResetTTL = -time.Minute
+	s := NewUserService(store, newOutbox(t, store), tokens, auth.DefaultPasswordPolicy, nil)
+	if _, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err := s.ForgotPassword(ctx, "ann@example.com"); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	_, token := eventLink(t, pendingEvents(t, store)[1], "resetURL")
+	if err := s.ResetPassword(ctx, token, "new-horse-battery"); !errors.Is(err, model.ErrInvalidToken) {
+		t.Errorf("ResetPassword with an expired token error = %v, want %v", err, model.ErrInvalidToken)
+	}
+}
+
+func TestUserService_BootstrapAdmins(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+	ann, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	bob, err := s.Register(ctx, model.User{FirstName: "Bob", Email: "bob@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	_, token := eventLink(t, pendingEvents(t, store)[0], "verificationURL")
+	if _, err := s.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+
+	// Почту Ann подтвердили до того, как её назначили администратором
+	s = NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, []string{"ann@example.com", "bob@example.com", "carol@example.com"})
+	for range 2 {
+		if err := s.BootstrapAdmins(ctx); err != nil {
+			t.Fatalf("BootstrapAdmins: %v", err)
+		}
+	}
+	if u, _ := store.GetUserByID(ctx, ann.ID); !slices.Equal(u.Roles, []string{model.RoleAdmin}) {
+		t.Errorf("Ann's roles = %v, want [admin]", u.Roles)
+	}
+	if u, _ := store.GetUserByID(ctx, bob.ID); len(u.Roles) != 0 {
+		t.Errorf("Bob's roles = %v, want none until the email is verified", u.Roles)
+	}
+	records, err := store.ListAudit(ctx, model.AuditFilter{Action: audit.RolesChanged}, pagination.Params{Limit: 10})
+	if err != nil || len(records) != 1 || records[0].Actor != audit.System {
+		t.Errorf("role changes = %+v, %v; want a single grant by the system", records, err)
+	}
+}
+
+func TestUserService_UpdateProfile(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", LastName: "Smith", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := s.Register(ctx, model.User{FirstName: "Bob", Email: "bob@example.com"}, testPassword); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	_, token := eventLink(t, pendingEvents(t, store)[0], "verificationURL")
+	if _, err := s.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+
+	taken := "bob@example.com"
+	if _, err := s.UpdateProfile(ctx, u.ID, model.UpdateProfilePayload{Email: &taken}); !errors.Is(err, model.ErrUserExists) {
+		t.Fatalf("UpdateProfile to a taken email error = %v, want %v", err, model.ErrUserExists)
+	}
+
+	name, email := "Anna", "anna@example.com"
+	updated, err := s.UpdateProfile(ctx, u.ID, model.UpdateProfilePayload{FirstName: &name, Email: &email})
+	if err != nil {
+		t.Fatalf("UpdateProfile: %v", err)
+	}
+	if updated.FirstName != "Anna" || updated.LastName != "Smith" || updated.Email != email || updated.EmailVerified() {
+		t.Errorf("updated user = %+v, want a new unverified email and the last name kept", updated)
+	}
+
+	events := pendingEvents(t, store)
+	if len(events) != 4 || events[2].EventType != "user.verification_requested" || events[3].EventType != "user.updated" {
+		t.Fatalf("outbox = %+v, want user.verification_requested and user.updated", events)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal(events[3].Payload, &payload); err != nil {
+		t.Fatalf("decode event: %v", err)
+	}
+	if payload["email"] != email || payload["previousEmail"] != "ann@example.com" {
+		t.Errorf("user.updated = %v, want the new and the previous email", payload)
+	}
+
+	// Без изменений событий нет
+	if _, err := s.UpdateProfile(ctx, u.ID, model.UpdateProfilePayload{FirstName: &name}); err != nil {
+		t.Fatalf("UpdateProfile without changes: %v", err)
+	}
+	if n := len(pendingEvents(t, store)); n != 4 {
+		t.Errorf("%d events after an empty update, want 4", n)
+	}
+}
+
+func TestUserService_ChangePasswordAndDeleteAccount(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err := s.ChangePassword(ctx, u.ID, "wrong", "new-horse-battery"); !errors.Is(err, model.ErrWrongPassword) {
+		t.Fatalf("ChangePassword with a wrong password error = %v, want %v", err, model.ErrWrongPassword)
+	}
+	if err := s.ChangePassword(ctx, u.ID, testPassword, "ann@example.com1"); !isValidationError(err) {
+		t.Fatalf("ChangePassword to a password with the email error = %v, want validation_failed", err)
+	}
+	if err := s.ChangePassword(ctx, u.ID, testPassword, "new-horse-battery"); err != nil {
+		t.Fatalf("ChangePassword: %v", err)
+	}
+
+	if err := s.DeleteAccount(ctx, u.ID, testPassword); !errors.Is(err, model.ErrWrongPassword) {
+		t.Fatalf("DeleteAccount with the old password error = %v, want %v", err, model.ErrWrongPassword)
+	}
+	if err := s.DeleteAccount(ctx, u.ID, "new-horse-battery"); err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if _, err := store.GetUserByID(ctx, u.ID); !errors.Is(err, model.ErrUserNotFound) {
+		t.Errorf("GetUserByID after deletion error = %v, want %v", err, model.ErrUserNotFound)
+	}
+
+	var types []string
+	for _, event := range pendingEvents(t, store) {
+		types = append(types, event.EventType)
+	}
+	if want := []string{"user.registered", "user.updated", "user.deleted"}; !slices.Equal(types, want) {
+		t.Errorf("outbox = %v, want %v", types, want)
+	}
+}
+
+func TestEraser(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	outbox := newOutbox(t, store)
+	s := NewUserService(store, outbox, testTokens, auth.DefaultPasswordPolicy, nil)
+	u, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := s.DeleteUser(ctx, 99, u.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if _, err := s.Register(ctx, model.User{FirstName: "Ann", Email: "ann@example.com"}, testPassword); err != nil {
+		t.Fatalf("Register with the email of a deleted account: %v", err)
+	}
+
+	e := NewEraser(store, outbox, DefaultAccountRetention)
+	if n, err := e.EraseDue(ctx); n != 0 || err != nil {
+		t.Fatalf("EraseDue within retention = %d, %v; want 0, nil", n, err)
+	}
+
+	e.now = func() time.Time { return time.Now().Add(DefaultAccountRetention + time.Minute) }
+	if n, err := e.EraseDue(ctx); n != 1 || err != nil {
+		t.Fatalf("EraseDue after retention = %d, %v; want 1, nil", n, err)
+	}
+	if n, err := e.EraseDue(ctx); n != 0 || err != nil {
+		t.Errorf("second EraseDue = %d, %v; want 0, nil", n, err)
+	}
+
+	events := pendingEvents(t, store)
+	last := events[len(events)-1]
+	var payload map[string]any
+	if err := json.Unmarshal(last.Payload, &payload); err != nil {
+		t.Fatalf("unmarshal event: %v", err)
+	}
+	if last.EventType != "user.erased" || payload["userID"] != float64(u.ID) || payload["email"] != nil {
+		t.Errorf("last event = %s %v, want user.erased of user %d without an email", last.EventType, payload, u.ID)
+	}
+}
+
+func TestUserService_RehashPassword(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewUserService(store, newOutbox(t, store), testTokens, auth.DefaultPasswordPolicy, nil)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword: %v", err)
+	}
+	err = store.CreateUser(ctx, model.User{Email: "ann@example.com", Password: string(legacy), PasswordAlgorithm: model.PasswordBcrypt})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	u, err := store.GetUserByEmail(ctx, "ann@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if !auth.VerifyPassword(u, testPassword) || !auth.NeedsRehash(u) {
+		t.Fatalf("bcrypt user: verified %v, needs rehash %v; want both", auth.VerifyPassword(u, testPassword), auth.NeedsRehash(u))
+	}
+
+	if err := s.RehashPassword(ctx, u, testPassword); err != nil {
+		t.Fatalf("RehashPassword: %v", err)
+	}
+	upgraded, err := store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if upgraded.PasswordAlgorithm != model.PasswordArgon2id || !auth.VerifyPassword(upgraded, testPassword) || auth.NeedsRehash(upgraded) {
+		t.Errorf("user after rehash = %+v, want a current argon2id hash of the same password", upgraded)
+	}
+
+	// Пароль сменили, пока шёл вход: старый хеш не должен его перезаписать
+	if err := s.RehashPassword(ctx, u, testPassword); err != nil {
+		t.Fatalf("RehashPassword with a stale user: %v", err)
+	}
+	if got, _ := store.GetUserByID(ctx, u.ID); got.Password != upgraded.Password {
+		t.Error("RehashPassword with a stale user replaced the current hash")
+	}
+}
+
+func isValidationError(err error) bool {
+	var e *model.Error
+	return errors.As(err, &e) && e.Code == "validation_failed"
+}
+
+func TestTwoFactorService(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewTwoFactorService(store, testTokens.Secret, DefaultTwoFactorConfig)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	u := mustCreateUser(t, store, model.User{Email: "ann@example.com"})
+
+	if _, err := s.Enable(ctx, u.ID, "123456"); !errors.Is(err, model.ErrTwoFactorNotSetUp) {
+		t.Fatalf("Enable before Setup error = %v, want %v", err, model.ErrTwoFactorNotSetUp)
+	}
+	setup, err := s.Setup(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	if !strings.Contains(setup.ProvisioningURI, "secret="+setup.Secret) {
+		t.Errorf("provisioning URI %q, want the secret", setup.ProvisioningURI)
+	}
+	code := totpCode(t, setup.Secret, now)
+	recovery, err := s.Enable(ctx, u.ID, code)
+	if err != nil {
+		t.Fatalf("Enable: %v", err)
+	}
+	if len(recovery) != DefaultTwoFactorConfig.RecoveryCodes {
+		t.Fatalf("%d recovery codes, want %d", len(recovery), DefaultTwoFactorConfig.RecoveryCodes)
+	}
+
+	challenge, err := s.StartLogin(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if got, err := s.ChallengeUser(ctx, challenge); err != nil || got.ID != u.ID {
+		t.Fatalf("ChallengeUser = %+v, %v; want user %d", got, err, u.ID)
+	}
+	// Код, которым включали 2FA, повторно не принимается
+	if _, _, err := s.CompleteLogin(ctx, challenge, code); !errors.Is(err, model.ErrInvalidTwoFactorCode) {
+		t.Fatalf("CompleteLogin with a used code error = %v, want %v", err, model.ErrInvalidTwoFactorCode)
+	}
+	now = now.Add(30 * time.Second)
+	if _, codes, err := s.CompleteLogin(ctx, challenge, totpCode(t, setup.Secret, now)); err != nil || codes != nil {
+		t.Fatalf("CompleteLogin = %v, %v; want a login without new recovery codes", codes, err)
+	}
+	if _, _, err := s.CompleteLogin(ctx, challenge, totpCode(t, setup.Secret, now.Add(30*time.Second))); !errors.Is(err, model.ErrInvalidToken) {
+		t.Fatalf("second CompleteLogin error = %v, want %v", err, model.ErrInvalidToken)
+	}
+
+	challenge, err = s.StartLogin(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if _, _, err := s.CompleteLogin(ctx, challenge, strings.ToUpper(recovery[0])); err != nil {
+		t.Fatalf("CompleteLogin with a recovery code: %v", err)
+	}
+	challenge, err = s.StartLogin(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if _, _, err := s.CompleteLogin(ctx, challenge, recovery[0]); !errors.Is(err, model.ErrInvalidTwoFactorCode) {
+		t.Fatalf("CompleteLogin with a used recovery code error = %v, want %v", err, model.ErrInvalidTwoFactorCode)
+	}
+
+	if err := s.Disable(ctx, u.ID, "wrong", recovery[1]); !errors.Is(err, model.ErrWrongPassword) {
+		t.Fatalf("Disable with a wrong password error = %v, want %v", err, model.ErrWrongPassword)
+	}
+	if err := s.Disable(ctx, u.ID, testPassword, recovery[1]); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+	got, err := store.GetUserByID(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if got.TwoFactorEnabled() || got.TOTPSecret != "" {
+		t.Errorf("user after Disable = %+v, want two-factor authentication off", got)
+	}
+}
+
+func TestTwoFactorServiceRequiredRole(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewTwoFactorService(store, testTokens.Secret, DefaultTwoFactorConfig)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	admin := mustCreateUser(t, store, model.User{Email: "admin@example.com", Roles: []string{model.RoleAdmin}})
+
+	adminRequired, err := s.Required(ctx, admin)
+	if err != nil {
+		t.Fatalf("Required: %v", err)
+	}
+	if customerRequired, _ := s.Required(ctx, &model.User{}); !adminRequired || customerRequired {
+		t.Fatal("Required does not follow the admin role")
+	}
+
+	// Администратор настраивает 2FA по вызову из первого шага входа
+	challenge, err := s.StartLogin(ctx, admin.ID)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if _, _, err := s.CompleteLogin(ctx, challenge, "123456"); !errors.Is(err, model.ErrTwoFactorNotSetUp) {
+		t.Fatalf("CompleteLogin before setup error = %v, want %v", err, model.ErrTwoFactorNotSetUp)
+	}
+	setup, err := s.Setup(ctx, admin.ID)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	user, codes, err := s.CompleteLogin(ctx, challenge, totpCode(t, setup.Secret, now))
+	if err != nil || !user.TwoFactorEnabled() || len(codes) == 0 {
+		t.Fatalf("CompleteLogin = %+v, %v, %v; want 2FA enabled with recovery codes", user, codes, err)
+	}
+
+	if err := s.Disable(ctx, admin.ID, testPassword, codes[0]); !errors.Is(err, model.ErrTwoFactorRequired) {
+		t.Errorf("Disable for an admin error = %v, want %v", err, model.ErrTwoFactorRequired)
+	}
+}
+
+func TestTwoFactorServiceSetRequiredRoles(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	s := NewTwoFactorService(store, testTokens.Secret, DefaultTwoFactorConfig)
+	admin := mustCreateUser(t, store, model.User{Email: "admin@example.com", Roles: []string{model.RoleAdmin}})
+
+	if roles, err := s.RequiredRoles(ctx); err != nil || !slices.Equal(roles, []string{model.RoleAdmin}) {
+		t.Fatalf("RequiredRoles before any change = %v, %v; want the configured roles", roles, err)
+	}
+	if _, err := s.SetRequiredRoles(ctx, admin.ID, []string{"root"}); !errors.As(err, new(*model.Error)) {
+		t.Errorf("SetRequiredRoles with an unknown role error = %v, want a validation error", err)
+	}
+
+	// Пустой список делает 2FA необязательной для всех, настройка из env больше не действует
+	for range 2 {
+		roles, err := s.SetRequiredRoles(ctx, admin.ID, nil)
+		if err != nil || roles == nil || len(roles) != 0 {
+			t.Fatalf("SetRequiredRoles(nil) = %v, %v; want an empty list", roles, err)
+		}
+	}
+	if required, err := s.Required(ctx, admin); err != nil || required {
+		t.Errorf("Required for an admin = %v, %v; want false once no role needs 2FA", required, err)
+	}
+
+	roles, err := s.SetRequiredRoles(ctx, admin.ID, []string{model.RoleAdmin, model.RoleAdmin})
+	if err != nil || !slices.Equal(roles, []string{model.RoleAdmin}) {
+		t.Fatalf("SetRequiredRoles = %v, %v; want [admin]", roles, err)
+	}
+	if required, err := s.Required(ctx, admin); err != nil || !required {
+		t.Errorf("Required for an admin = %v, %v; want true again", required, err)
+	}
+
+	records, err := store.ListAudit(ctx, model.AuditFilter{Action: audit.TwoFactorPolicyChanged}, pagination.Params{Limit: 10})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(records) != 2 || records[0].Target != audit.Setting(model.SettingTwoFactorRoles) || records[0].Actor != audit.User(admin.ID) {
+		t.Errorf("audit = %+v, want one record by the admin per change", records)
+	}
+}
+
+// mustCreateUser stores user with testPassword.
+func mustCreateUser(t *testing.T, store model.UserStore, user model.User) *model.User {
+	t.Helper()
+
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	user.Password, user.PasswordAlgorithm = hash, auth.PasswordAlgorithm
+	if err := store.CreateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	created, err := store.GetUserByEmail(context.Background(), user.Email)
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	return created
+}
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+
+	code, err := auth.TOTPCode(secret, at)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+func TestBalanceService_Refund(t *testing.T) {
+	ctx := context.Background()
+	s, store, userID := newBalanceServiceWithStore(t, 0)
+
+	if _, err := s.AddBalance(ctx, userID, 100, ""); err != nil {
+		t.Fatalf("AddBalance: %v", err)
+	}
+	if _, err := s.Withdraw(ctx, userID, 30, "order:1"); err != nil {
+		t.Fatalf("Withdraw: %v", err)
+	}
+	// Повторная отмена возвращает деньги только один раз
+	for range 2 {
+		if balance, err := s.Refund(ctx, userID, 30, "refund:order:1"); err != nil || balance != 100 {
+			t.Fatalf("Refund = %v, %v; want 100, nil", balance, err)
+		}
+	}
+	var domainErr *model.Error
+	if _, err := s.Refund(ctx, userID, 30, ""); !errors.As(err, &domainErr) || domainErr.Kind != model.KindInvalid {
+		t.Errorf("Refund without a reference: error = %v, want an invalid input error", err)
+	}
+
+	ledger, err := store.ListLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("ListLedger: %v", err)
+	}
+	if len(ledger) != 3 || ledger[2].Reason != model.LedgerRefund {
+		t.Errorf("ledger = %+v, want the refund recorded as %q", ledger, model.LedgerRefund)
+	}
+	records, err := store.AuditChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("AuditChain: %v", err)
+	}
+	if len(records) != 3 || records[2].Action != audit.BalanceRefunded {
+		t.Errorf("audit records = %+v, want the refund logged as %s", records, audit.BalanceRefunded)
+	}
+}
+
+func TestBalanceService_Audit(t *testing.T) {
+	ctx := context.Background()
+	s, store, userID := newBalanceServiceWithStore(t, 0)
+
+	if _, err := s.AddBalance(ctx, userID, 100, ""); err != nil {
+		t.Fatalf("AddBalance: %v", err)
+	}
+	if _, err := s.Withdraw(ctx, userID, 500, ""); !errors.Is(err, model.ErrInsufficientFunds) {
+		t.Fatalf("Withdraw error = %v, want %v", err, model.ErrInsufficientFunds)
+	}
+	if _, err := s.Adjust(ctx, 7, userID, -10, "duplicate top-up"); err != nil {
+		t.Fatalf("Adjust: %v", err)
+	}
+
+	records, err := store.AuditChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("AuditChain: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("audit records = %+v, want the top-up and the adjustment only", records)
+	}
+	if r := records[0]; r.Action != audit.BalanceToppedUp || r.Actor != audit.System || r.Target != audit.User(userID) {
+		t.Errorf("top-up record = %+v, want %s by %s", r, audit.BalanceToppedUp, audit.System)
+	}
+	r := records[1]
+	if r.Action != audit.BalanceAdjusted || r.Actor != audit.User(7) || string(r.Before) != `{"balance":100}` ||
+		!strings.Contains(string(r.After), `"reason":"duplicate top-up"`) {
+		t.Errorf("adjustment record = %+v, want the admin, the old balance and the reason", r)
+	}
+}
+
+func TestPaymentAuditor(t *testing.T) {
+	ctx := context.Background()
+	store := repository.NewMemoryStore()
+	a := NewPaymentAuditor(store)
+
+	events := []string{
+		`{"eventID":"e1","type":"PaymentFailed","orderID":3,"userID":5,"email":"ann@example.com","amount":20,"reason":"insufficient_funds"}`,
+		`{"eventID":"e1","type":"PaymentFailed","orderID":3,"userID":5,"email":"ann@example.com","amount":20,"reason":"insufficient_funds"}`,
+		`{"eventID":"e2","type":"PaymentSomethingElse","orderID":3}`,
+		`{"eventID":"e3","type":"PaymentCompleted","orderID":4,"userID":5,"email":"ann@example.com","amount":15}`,
+	}
+	for _, event := range events {
+		if err := a.HandleEvent(ctx, []byte(event)); err != nil {
+			t.Fatalf("HandleEvent: %v", err)
+		}
+	}
+
+	records, err := store.AuditChain(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("AuditChain: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("audit records = %+v, want one per known event", records)
+	}
+	failed := records[0]
+	if failed.Action != audit.PaymentFailed || failed.Actor != audit.PaymentService || failed.Target != audit.Order(3) ||
+		!strings.Contains(string(failed.After), `"reason":"insufficient_funds"`) || strings.Contains(string(failed.After), "ann@") {
+		t.Errorf("failed payment record = %+v, want the decision without the email", failed)
+	}
+	if records[1].Action != audit.PaymentCompleted || records[1].EventID != "e3" {
+		t.Errorf("completed payment record = %+v", records[1])
+	}
+}
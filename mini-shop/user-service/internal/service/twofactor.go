@@ -0,0 +1,404 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mini-shop/user-service/internal/audit"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/model"
+	"slices"
+	"time"
+)
+
+// TwoFactorConfig shapes TOTP two-factor authentication.
+type TwoFactorConfig struct {
+	// Issuer names the shop in authenticator apps.
+	Issuer string
+	// RequiredRoles lists the roles that cannot log in without a second
+	// factor until an admin changes them. Users with one of them set it up
+	// while logging in.
+	RequiredRoles []string
+	// ChallengeTTL limits how long the second login step may take.
+	ChallengeTTL time.Duration
+	// RecoveryCodes is how many recovery codes a user gets at a time.
+	RecoveryCodes int
+}
+
+var DefaultTwoFactorConfig = TwoFactorConfig{
+	Issuer:        "MiniShop",
+	RequiredRoles: []string{model.RoleAdmin},
+	ChallengeTTL:  5 * time.Minute,
+	RecoveryCodes: 10,
+}
+
+// Коды восстановления действуют, пока их не заменят новыми или не отключат 2FA
+var recoveryCodeExpiry = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+type TwoFactorService struct {
+	store model.UserStore
+	// secret keys the stored hashes of challenges and recovery codes.
+	secret []byte
+	config TwoFactorConfig
+	now    func() time.Time
+}
+
+func NewTwoFactorService(store model.UserStore, secret []byte, config TwoFactorConfig) *TwoFactorService {
+	return &TwoFactorService{store: store, secret: secret, config: config, now: time.Now}
+}
+
+// Required reports whether user may not log in without a second factor.
+func (s *TwoFactorService) Required(ctx context.Context, user *model.User) (bool, error) {
+	return s.required(ctx, s.store, user)
+}
+
+func (s *TwoFactorService) required(ctx context.Context, store model.UserStore, user *model.User) (bool, error) {
+	roles, err := s.requiredRoles(ctx, store)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(user.Roles, func(role string) bool {
+		return slices.Contains(roles, role)
+	}), nil
+}
+
+// RequiredRoles returns the roles that cannot log in without a second
+// factor: the ones an admin set, or the configured ones.
+func (s *TwoFactorService) RequiredRoles(ctx context.Context) ([]string, error) {
+	return s.requiredRoles(ctx, s.store)
+}
+
+func (s *TwoFactorService) requiredRoles(ctx context.Context, store model.UserStore) ([]string, error) {
+	value, err := store.GetSetting(ctx, model.SettingTwoFactorRoles)
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return slices.Sorted(slices.Values(s.config.RequiredRoles)), nil
+	}
+	var policy model.TwoFactorPolicy
+	if err := json.Unmarshal(value, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", model.SettingTwoFactorRoles, err)
+	}
+	return policy.RequiredRoles, nil
+}
+
+// SetRequiredRoles makes roles the ones that cannot log in without a second
+// factor from the next login on and logs the change by adminID.
+func (s *TwoFactorService) SetRequiredRoles(ctx context.Context, adminID int, roles []string) ([]string, error) {
+	if err := checkRoles(roles); err != nil {
+		return nil, err
+	}
+	roles = slices.Compact(slices.Sorted(slices.Values(roles)))
+	if roles == nil {
+		roles = []string{}
+	}
+
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		before, err := s.requiredRoles(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if slices.Equal(before, roles) {
+			return nil
+		}
+
+		value, err := json.Marshal(model.TwoFactorPolicy{RequiredRoles: roles})
+		if err != nil {
+			return err
+		}
+		if err := tx.PutSetting(ctx, model.SettingTwoFactorRoles, value); err != nil {
+			return err
+		}
+		return audit.Log(ctx, tx, audit.Entry{
+			Actor:  audit.User(adminID),
+			Action: audit.TwoFactorPolicyChanged,
+			Target: audit.Setting(model.SettingTwoFactorRoles),
+			Before: map[string]any{"requiredRoles": before},
+			After:  map[string]any{"requiredRoles": roles},
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// StartLogin returns the challenge token for the second login step of a user
+// whose password has been checked. Earlier challenges stop working.
+func (s *TwoFactorService) StartLogin(ctx context.Context, userID int) (string, error) {
+	var challenge string
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		if err := tx.RevokeTokens(ctx, userID, model.TokenTwoFactorChallenge); err != nil {
+			return err
+		}
+
+		token, hash, err := auth.NewToken(s.secret)
+		if err != nil {
+			return fmt.Errorf("failed to generate token: %w", err)
+		}
+		challenge = token
+		return tx.CreateToken(ctx, &model.UserToken{
+			UserID:    userID,
+			Purpose:   model.TokenTwoFactorChallenge,
+			Hash:      hash,
+			ExpiresAt: s.now().Add(s.config.ChallengeTTL),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// ChallengeUser returns the user a valid challenge was issued to, without
+// using the challenge up.
+func (s *TwoFactorService) ChallengeUser(ctx context.Context, challenge string) (*model.User, error) {
+	stored, err := s.store.GetToken(ctx, model.TokenTwoFactorChallenge, auth.HashToken(s.secret, challenge))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || !stored.Valid(s.now()) {
+		return nil, model.ErrInvalidToken
+	}
+	user, err := s.store.GetUserByID(ctx, stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Пользователя могли заблокировать между шагами входа
+	if user.Blocked() {
+		return nil, model.ErrAccountBlocked
+	}
+	return user, nil
+}
+
+// Setup starts enrolling userID with a new secret, which replaces any earlier
+// unfinished setup. Nothing changes for logging in until Enable.
+func (s *TwoFactorService) Setup(ctx context.Context, userID int) (*model.TwoFactorSetup, error) {
+	var setup *model.TwoFactorSetup
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.TwoFactorEnabled() {
+			return model.ErrTwoFactorEnabled
+		}
+
+		secret, err := auth.NewTOTPSecret()
+		if err != nil {
+			return fmt.Errorf("failed to generate TOTP secret: %w", err)
+		}
+		user.TOTPSecret, user.TOTPLastStep = secret, 0
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+
+		setup = &model.TwoFactorSetup{
+			Secret:          secret,
+			ProvisioningURI: auth.TOTPProvisioningURI(s.config.Issuer, user.Email, secret),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return setup, nil
+}
+
+// Enable turns two-factor authentication on once code comes from the secret
+// of Setup, and returns the recovery codes. They are not stored in the clear,
+// so this is the only time the user sees them.
+func (s *TwoFactorService) Enable(ctx context.Context, userID int, code string) ([]string, error) {
+	var codes []string
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		codes, err = s.enable(ctx, tx, user, code)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteLogin checks code for the challenge and uses the challenge up. A
+// user who had to set up two-factor authentication to log in gets it enabled
+// here and receives recovery codes; otherwise codes is nil.
+func (s *TwoFactorService) CompleteLogin(ctx context.Context, challenge, code string) (user *model.User, codes []string, err error) {
+	err = s.store.InTx(ctx, func(tx model.UserStore) error {
+		stored, err := tx.GetToken(ctx, model.TokenTwoFactorChallenge, auth.HashToken(s.secret, challenge))
+		if err != nil {
+			return err
+		}
+		if stored == nil || !stored.Valid(s.now()) {
+			return model.ErrInvalidToken
+		}
+		user, err = tx.LockUserByID(ctx, stored.UserID)
+		if err != nil {
+			return err
+		}
+
+		if user.TwoFactorEnabled() {
+			err = s.checkCode(ctx, tx, user, code)
+		} else {
+			codes, err = s.enable(ctx, tx, user, code)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.UseToken(ctx, stored.ID)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, codes, nil
+}
+
+// Disable turns two-factor authentication off after checking both the
+// password and a code, unless a role of the user requires it.
+func (s *TwoFactorService) Disable(ctx context.Context, userID int, password, code string) error {
+	return s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TwoFactorEnabled() {
+			return model.ErrTwoFactorNotEnabled
+		}
+		required, err := s.required(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		if required {
+			return model.ErrTwoFactorRequired
+		}
+		if !auth.VerifyPassword(user, password) {
+			return model.ErrWrongPassword
+		}
+		if err := s.checkCode(ctx, tx, user, code); err != nil {
+			return err
+		}
+
+		user.TOTPSecret, user.TwoFactorEnabledAt, user.TOTPLastStep = "", nil, 0
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := tx.RevokeTokens(ctx, user.ID, model.TokenRecoveryCode); err != nil {
+			return err
+		}
+		return logTwoFactor(ctx, tx, user, audit.TwoFactorDisabled)
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of userID after
+// checking a code.
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	var codes []string
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.LockUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if !user.TwoFactorEnabled() {
+			return model.ErrTwoFactorNotEnabled
+		}
+		if err := s.checkCode(ctx, tx, user, code); err != nil {
+			return err
+		}
+
+		codes, err = s.issueRecoveryCodes(ctx, tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "recovery codes regenerated", "user_id", userID)
+	return codes, nil
+}
+
+// enable checks code against the secret from setup and turns two-factor
+// authentication on for user.
+func (s *TwoFactorService) enable(ctx context.Context, tx model.UserStore, user *model.User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, model.ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, model.ErrTwoFactorNotSetUp
+	}
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, s.now(), user.TOTPLastStep)
+	if !ok {
+		return nil, model.ErrInvalidTwoFactorCode
+	}
+
+	now := s.now()
+	user.TwoFactorEnabledAt, user.TOTPLastStep = &now, step
+	if err := tx.UpdateUser(ctx, user); err != nil {
+		return nil, err
+	}
+	if err := logTwoFactor(ctx, tx, user, audit.TwoFactorEnabled); err != nil {
+		return nil, err
+	}
+	return s.issueRecoveryCodes(ctx, tx, user.ID)
+}
+
+// checkCode accepts a TOTP code once or an unused recovery code of user.
+func (s *TwoFactorService) checkCode(ctx context.Context, tx model.UserStore, user *model.User, code string) error {
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, s.now(), user.TOTPLastStep); ok {
+		user.TOTPLastStep = step
+		return tx.UpdateUser(ctx, user)
+	}
+
+	stored, err := tx.GetToken(ctx, model.TokenRecoveryCode, auth.HashToken(s.secret, auth.NormalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if stored == nil || stored.UserID != user.ID || !stored.Valid(s.now()) {
+		return model.ErrInvalidTwoFactorCode
+	}
+	if err := tx.UseToken(ctx, stored.ID); err != nil {
+		return err
+	}
+	return audit.Log(ctx, tx, audit.Entry{Actor: audit.User(user.ID), Action: audit.RecoveryCodeUsed, Target: audit.User(user.ID)})
+}
+
+// issueRecoveryCodes revokes the recovery codes of userID and returns new ones.
+func (s *TwoFactorService) issueRecoveryCodes(ctx context.Context, tx model.UserStore, userID int) ([]string, error) {
+	if err := tx.RevokeTokens(ctx, userID, model.TokenRecoveryCode); err != nil {
+		return nil, err
+	}
+
+	codes, err := auth.NewRecoveryCodes(s.config.RecoveryCodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	for _, code := range codes {
+		err := tx.CreateToken(ctx, &model.UserToken{
+			UserID:    userID,
+			Purpose:   model.TokenRecoveryCode,
+			Hash:      auth.HashToken(s.secret, code),
+			ExpiresAt: recoveryCodeExpiry,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// logTwoFactor records that user turned two-factor authentication on or off.
+func logTwoFactor(ctx context.Context, tx model.UserStore, user *model.User, action string) error {
+	return audit.Log(ctx, tx, audit.Entry{
+		Actor:  audit.User(user.ID),
+		Action: action,
+		Target: audit.User(user.ID),
+		Before: map[string]any{"twoFactor": action == audit.TwoFactorDisabled},
+		After:  map[string]any{"twoFactor": user.TwoFactorEnabled()},
+	})
+}
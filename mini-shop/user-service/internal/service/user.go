@@ -0,0 +1,305 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mini-shop/platform/outbox"
+	"mini-shop/user-service/internal/auth"
+	"mini-shop/user-service/internal/model"
+	"net/url"
+	"time"
+)
+
+// TokenConfig shapes the single-use links mailed to users.
+type TokenConfig struct {
+	// Secret keys the stored token hashes.
+	Secret []byte
+	// VerifyURL is the verification endpoint, e.g.
+	// "http://localhost:8080/api/v1/email/verify". ResetURL is the shop page
+	// that asks for a new password and posts it with the token to
+	// /password/reset.
+	VerifyURL string
+	ResetURL  string
+	VerifyTTL time.Duration
+	ResetTTL  time.Duration
+}
+
+var DefaultTokenConfig = TokenConfig{VerifyTTL: 48 * time.Hour, ResetTTL: time.Hour}
+
+type UserService struct {
+	store       model.UserStore
+	outbox      *outbox.Relay
+	tokens      TokenConfig
+	passwords   *auth.PasswordPolicy
+	adminEmails []string
+}
+
+// NewUserService makes accounts whose verified email is in adminEmails
+// admins, so a new shop has someone to manage it.
+func NewUserService(store model.UserStore, outbox *outbox.Relay, tokens TokenConfig, passwords *auth.PasswordPolicy, adminEmails []string) *UserService {
+	return &UserService{
+		store:       store,
+		outbox:      outbox,
+		tokens:      tokens,
+		passwords:   passwords,
+		adminEmails: adminEmails,
+	}
+}
+
+// Register creates the user with password and announces it with
+// user.registered, carrying the email verification link, in the same
+// transaction.
+func (s *UserService) Register(ctx context.Context, user model.User, password string) (*model.User, error) {
+	if err := s.setPassword(&user, "password", password); err != nil {
+		return nil, err
+	}
+
+	var created *model.User
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		if err := tx.CreateUser(ctx, user); err != nil {
+			return err
+		}
+
+		var err error
+		created, err = tx.GetUserByEmail(ctx, user.Email)
+		if err != nil {
+			return err
+		}
+
+		link, err := s.issueToken(ctx, tx, created.ID, model.TokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return addEvent(ctx, tx, "user.registered", map[string]interface{}{
+			"type":            "UserRegistered",
+			"userID":          created.ID,
+			"email":           created.Email,
+			"firstName":       created.FirstName,
+			"verificationURL": link,
+		})
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to register user", "email", user.Email, "error", err)
+		return nil, err
+	}
+	s.outbox.Notify()
+
+	return created, nil
+}
+
+// ResendVerification mails a new verification link and invalidates the
+// earlier ones.
+func (s *UserService) ResendVerification(ctx context.Context, userID int) error {
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.GetUserByID(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if user.EmailVerified() {
+			return model.ErrEmailVerified
+		}
+
+		link, err := s.issueToken(ctx, tx, user.ID, model.TokenVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return addEvent(ctx, tx, "user.verification_requested", map[string]interface{}{
+			"type":            "VerificationRequested",
+			"userID":          user.ID,
+			"email":           user.Email,
+			"firstName":       user.FirstName,
+			"verificationURL": link,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.outbox.Notify()
+
+	return nil
+}
+
+// VerifyEmail confirms the email of the user the token was mailed to. A user
+// verifying one of the admin emails becomes an admin.
+func (s *UserService) VerifyEmail(ctx context.Context, token string) (*model.User, error) {
+	var verified *model.User
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := s.useToken(ctx, tx, model.TokenVerifyEmail, token)
+		if err != nil {
+			return err
+		}
+
+		if !user.EmailVerified() {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+			if err := tx.UpdateUser(ctx, user); err != nil {
+				return err
+			}
+		}
+		verified = user
+		return s.grantAdmin(ctx, tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "email verified", "user_id", verified.ID)
+	return verified, nil
+}
+
+// ForgotPassword mails a password reset link. Unknown emails are ignored
+// without an error, so the endpoint does not reveal who has an account.
+func (s *UserService) ForgotPassword(ctx context.Context, email string) error {
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := tx.GetUserByEmail(ctx, email)
+		if err != nil {
+			return err
+		}
+
+		link, err := s.issueToken(ctx, tx, user.ID, model.TokenResetPassword)
+		if err != nil {
+			return err
+		}
+		return addEvent(ctx, tx, "user.password_reset_requested", map[string]interface{}{
+			"type":      "PasswordResetRequested",
+			"userID":    user.ID,
+			"email":     user.Email,
+			"firstName": user.FirstName,
+			"resetURL":  link,
+		})
+	})
+	if errors.Is(err, model.ErrUserNotFound) {
+		slog.InfoContext(ctx, "password reset requested for unknown email")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.outbox.Notify()
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user the token was mailed to.
+// Following the link proves the user owns the email, so it is marked verified
+// as well. A password the policy rejects leaves the token usable.
+func (s *UserService) ResetPassword(ctx context.Context, token, password string) error {
+	var userID int
+	err := s.store.InTx(ctx, func(tx model.UserStore) error {
+		user, err := s.useToken(ctx, tx, model.TokenResetPassword, token)
+		if err != nil {
+			return err
+		}
+
+		if err := s.setPassword(user, "password", password); err != nil {
+			return err
+		}
+		if !user.EmailVerified() {
+			now := time.Now()
+			user.EmailVerifiedAt = &now
+		}
+		if err := tx.UpdateUser(ctx, user); err != nil {
+			return err
+		}
+		userID = user.ID
+		// Остальные ссылки на сброс больше не нужны
+		return tx.RevokeTokens(ctx, user.ID, model.TokenResetPassword)
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "password reset", "user_id", userID)
+	return nil
+}
+
+// RehashPassword hashes password again with the current algorithm after it
+// has been checked against user, which NeedsRehash reports as outdated. It
+// does nothing if the password has changed since user was read.
+func (s *UserService) RehashPassword(ctx context.Context, user *model.User, password string) error {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	err = s.store.InTx(ctx, func(tx model.UserStore) error {
+		current, err := tx.LockUserByID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		if current.Password != user.Password {
+			return nil
+		}
+
+		current.Password, current.PasswordAlgorithm = hash, auth.PasswordAlgorithm
+		return tx.UpdateUser(ctx, current)
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(ctx, "password rehashed", "user_id", user.ID, "from", user.PasswordAlgorithm, "to", auth.PasswordAlgorithm)
+	return nil
+}
+
+// setPassword checks password against the policy for the email of user and
+// stores its hash in user. field names the password in validation errors.
+func (s *UserService) setPassword(user *model.User, field, password string) error {
+	if err := s.passwords.Check(field, password, user.Email); err != nil {
+		return err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	user.Password, user.PasswordAlgorithm = hash, auth.PasswordAlgorithm
+	return nil
+}
+
+// issueToken revokes the earlier tokens of purpose and returns the link with
+// a new one.
+func (s *UserService) issueToken(ctx context.Context, tx model.UserStore, userID int, purpose string) (string, error) {
+	if err := tx.RevokeTokens(ctx, userID, purpose); err != nil {
+		return "", err
+	}
+
+	token, hash, err := auth.NewToken(s.tokens.Secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	base, ttl := s.tokens.VerifyURL, s.tokens.VerifyTTL
+	if purpose == model.TokenResetPassword {
+		base, ttl = s.tokens.ResetURL, s.tokens.ResetTTL
+	}
+
+	err = tx.CreateToken(ctx, &model.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return base + "?token=" + url.QueryEscape(token), nil
+}
+
+// useToken accepts a valid token of purpose once and returns its user locked
+// for update.
+func (s *UserService) useToken(ctx context.Context, tx model.UserStore, purpose, token string) (*model.User, error) {
+	stored, err := tx.GetToken(ctx, purpose, auth.HashToken(s.tokens.Secret, token))
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || !stored.Valid(time.Now()) {
+		return nil, model.ErrInvalidToken
+	}
+	if err := tx.UseToken(ctx, stored.ID); err != nil {
+		return nil, err
+	}
+
+	return tx.LockUserByID(ctx, stored.UserID)
+}
@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"context"
+	platform "mini-shop/platform/tracing"
+	"mini-shop/user-service/internal/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	ServiceName = "user-service"
+	tracerName  = "mini-shop/user-service"
+)
+
+// Init installs the tracer provider configured by OTEL_TRACES_EXPORTER.
+// The returned function flushes pending spans and must be called on shutdown.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	return platform.Init(ctx, platform.Config{
+		Service:  ServiceName,
+		Exporter: config.Envs.TracesExporter,
+		File:     config.Envs.TracesFile,
+	})
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// RecordError marks the span as failed.
+func RecordError(span trace.Span, err error) {
+	platform.RecordError(span, err)
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_users_balance;
+DROP INDEX IF EXISTS idx_users_created_at;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users (createdAt, id);
+CREATE INDEX IF NOT EXISTS idx_users_balance ON users (balance, id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS balance_ledger (
+    id SERIAL PRIMARY KEY,
+    userID INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    amount DOUBLE PRECISION NOT NULL,
+    balance DOUBLE PRECISION NOT NULL,
+    reason VARCHAR(50) NOT NULL,
+    createdAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_balance_ledger_user ON balance_ledger (userID, createdAt, id);
@@ -0,0 +1,16 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS emailVerifiedAt TIMESTAMP WITH TIME ZONE;
+
+-- Существующие аккаунты заведены до подтверждения почты и не должны потерять доступ
+UPDATE users SET emailVerifiedAt = createdAt WHERE emailVerifiedAt IS NULL;
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id SERIAL PRIMARY KEY,
+    userID INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    purpose VARCHAR(50) NOT NULL,
+    tokenHash VARCHAR(64) NOT NULL UNIQUE,
+    expiresAt TIMESTAMP WITH TIME ZONE NOT NULL,
+    usedAt TIMESTAMP WITH TIME ZONE,
+    createdAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user ON user_tokens (userID, purpose);
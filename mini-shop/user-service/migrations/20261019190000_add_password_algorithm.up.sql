@@ -0,0 +1,2 @@
+-- Существующие пароли захешированы bcrypt и переводятся на argon2id при входе
+ALTER TABLE users ADD COLUMN IF NOT EXISTS passwordAlgorithm VARCHAR(20) NOT NULL DEFAULT 'bcrypt';
@@ -0,0 +1,5 @@
+DELETE FROM user_tokens WHERE purpose IN ('two_factor_challenge', 'recovery_code');
+ALTER TABLE users DROP COLUMN IF EXISTS totpLastStep;
+ALTER TABLE users DROP COLUMN IF EXISTS twoFactorEnabledAt;
+ALTER TABLE users DROP COLUMN IF EXISTS totpSecret;
+ALTER TABLE users DROP COLUMN IF EXISTS roles;
@@ -0,0 +1,6 @@
+DELETE FROM users WHERE deletedAt IS NOT NULL;
+DROP INDEX IF EXISTS idx_users_deleted_at;
+DROP INDEX IF EXISTS idx_users_email_active;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users DROP COLUMN IF EXISTS erasedAt;
+ALTER TABLE users DROP COLUMN IF EXISTS deletedAt;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletedAt TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erasedAt TIMESTAMP WITH TIME ZONE;
+
+-- Адрес удалённого аккаунта можно зарегистрировать заново
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_active ON users (email) WHERE deletedAt IS NULL;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deletedAt) WHERE deletedAt IS NOT NULL AND erasedAt IS NULL;
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_users_last_name_prefix;
+DROP INDEX IF EXISTS idx_users_first_name_prefix;
+DROP INDEX IF EXISTS idx_users_email_prefix;
+
+ALTER TABLE balance_ledger DROP COLUMN IF EXISTS actorID;
+ALTER TABLE balance_ledger DROP COLUMN IF EXISTS comment;
+
+ALTER TABLE users DROP COLUMN IF EXISTS blockedAt;
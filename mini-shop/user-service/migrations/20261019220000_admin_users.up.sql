@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS blockedAt TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE balance_ledger ADD COLUMN IF NOT EXISTS comment TEXT NOT NULL DEFAULT '';
+ALTER TABLE balance_ledger ADD COLUMN IF NOT EXISTS actorID INTEGER;
+
+-- Поиск по началу адреса или имени без учёта регистра
+CREATE INDEX IF NOT EXISTS idx_users_email_prefix ON users (lower(email) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_first_name_prefix ON users (lower(firstName) text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_users_last_name_prefix ON users (lower(lastName) text_pattern_ops);
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    occurredAt TIMESTAMP WITH TIME ZONE NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    target VARCHAR(100) NOT NULL DEFAULT '',
+    -- JSON, а не JSONB: текст хранится как есть и хеш сходится при проверке
+    beforeState JSON,
+    afterState JSON,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    requestID VARCHAR(100) NOT NULL DEFAULT '',
+    eventID VARCHAR(100),
+    prevHash VARCHAR(64) NOT NULL,
+    hash VARCHAR(64) NOT NULL UNIQUE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_audit_log_event ON audit_log (eventID) WHERE eventID IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_audit_log_occurred ON audit_log (occurredAt, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor, occurredAt, id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target, occurredAt, id);
+
+-- Журнал только дополняется
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_log_append_only();
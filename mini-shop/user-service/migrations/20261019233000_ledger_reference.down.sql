@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_balance_ledger_reference;
+ALTER TABLE balance_ledger DROP COLUMN IF EXISTS reference;
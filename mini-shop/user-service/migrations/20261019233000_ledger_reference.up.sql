@@ -0,0 +1,4 @@
+ALTER TABLE balance_ledger ADD COLUMN IF NOT EXISTS reference VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Платёж другого сервиса зачисляется один раз
+CREATE UNIQUE INDEX IF NOT EXISTS idx_balance_ledger_reference ON balance_ledger (reference) WHERE reference <> '';
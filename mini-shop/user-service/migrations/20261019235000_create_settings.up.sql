@@ -0,0 +1,6 @@
+-- Настройки, которые администраторы меняют без перезапуска сервиса
+CREATE TABLE IF NOT EXISTS settings (
+    key VARCHAR(100) PRIMARY KEY,
+    value JSON NOT NULL,
+    updatedAt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
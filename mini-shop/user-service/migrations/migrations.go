@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL schema migrations so the service can apply
+// them regardless of its working directory.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,26 @@
+// Package userservice embeds the user service into another process, such as
+// the all-in-one local runner.
+package userservice
+
+import (
+	"context"
+	"mini-shop/user-service/internal/app"
+	"mini-shop/user-service/internal/messaging"
+)
+
+// Broker is the message bus the service publishes to.
+type Broker = messaging.Broker
+
+// Options configures the service.
+type Options = app.Options
+
+// NewMemoryBroker returns an in-process topic broker. Its method set uses only
+// built-in types, so one instance can be shared by every service in a process.
+func NewMemoryBroker() Broker {
+	return messaging.NewMemoryBroker()
+}
+
+// Run serves the user service until ctx is cancelled.
+func Run(ctx context.Context, opts Options) error {
+	return app.Run(ctx, opts)
+}